@@ -2,18 +2,24 @@ package multi
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"github.com/mingzhi/biogo/seq"
 	"github.com/mingzhi/ncbiftp/seqrecord"
 	"io"
 	"os/exec"
 	"strings"
+	"syscall"
 )
 
-type AlignFunc func(stdin io.Reader, stdout, stderr io.Writer, options ...string) error
+// AlignFunc runs an external aligner, which is killed when ctx is done.
+type AlignFunc func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, options ...string) error
 
 // Multiple sequence alignment of protein sequences
-// and back translate them to nucleotide sequences
-func AlignProt(seqRecords []seqrecord.SeqRecord, alignFunc AlignFunc, options ...string) []seqrecord.SeqRecord {
+// and back translate them to nucleotide sequences.
+// If the aligner fails, or is killed when ctx is done,
+// its output is discarded and an error is returned.
+func AlignProt(ctx context.Context, seqRecords []seqrecord.SeqRecord, alignFunc AlignFunc, options ...string) ([]seqrecord.SeqRecord, error) {
 	// prepare protein sequences in fasta format
 	stdin := new(bytes.Buffer)
 	srMap := make(map[string]seqrecord.SeqRecord)
@@ -23,13 +29,9 @@ func AlignProt(seqRecords []seqrecord.SeqRecord, alignFunc AlignFunc, options ..
 		stdin.WriteString("\n")
 		srMap[sr.Id+"|"+sr.Genome] = sr
 	}
-	stdout := new(bytes.Buffer)
-	stderr := new(bytes.Buffer)
-	alignFunc(stdin, stdout, stderr, options...)
-	fr := seq.NewFastaReader(stdout)
-	alns, err := fr.ReadAll()
+	alns, err := runAlign(ctx, alignFunc, stdin, options...)
 	if err != nil {
-		panic(string(stderr.Bytes()))
+		return nil, err
 	}
 
 	alnSeqRecords := []seqrecord.SeqRecord{}
@@ -51,13 +53,13 @@ func AlignProt(seqRecords []seqrecord.SeqRecord, alignFunc AlignFunc, options ..
 		}
 	}
 
-	return alnSeqRecords
-
+	return alnSeqRecords, nil
 }
 
-// Multiple sequence alignment of protein sequences
-// and back translate them to nucleotide sequences
-func AlignNucl(seqRecords []seqrecord.SeqRecord, alignFunc AlignFunc, options ...string) []seqrecord.SeqRecord {
+// Multiple sequence alignment of nucleotide sequences.
+// If the aligner fails, or is killed when ctx is done,
+// its output is discarded and an error is returned.
+func AlignNucl(ctx context.Context, seqRecords []seqrecord.SeqRecord, alignFunc AlignFunc, options ...string) ([]seqrecord.SeqRecord, error) {
 	// prepare protein sequences in fasta format
 	stdin := new(bytes.Buffer)
 	srMap := make(map[string]seqrecord.SeqRecord)
@@ -67,13 +69,9 @@ func AlignNucl(seqRecords []seqrecord.SeqRecord, alignFunc AlignFunc, options ..
 		stdin.WriteString("\n")
 		srMap[sr.Id+"|"+sr.Genome] = sr
 	}
-	stdout := new(bytes.Buffer)
-	stderr := new(bytes.Buffer)
-	alignFunc(stdin, stdout, stderr, options...)
-	fr := seq.NewFastaReader(stdout)
-	alns, err := fr.ReadAll()
+	alns, err := runAlign(ctx, alignFunc, stdin, options...)
 	if err != nil {
-		panic(string(stderr.Bytes()))
+		return nil, err
 	}
 
 	alnSeqRecords := []seqrecord.SeqRecord{}
@@ -94,20 +92,82 @@ func AlignNucl(seqRecords []seqrecord.SeqRecord, alignFunc AlignFunc, options ..
 		}
 	}
 
-	return alnSeqRecords
+	return alnSeqRecords, nil
+}
+
+// runAlign runs the aligner on the fasta sequences in stdin,
+// and reads the aligned sequences from its output.
+func runAlign(ctx context.Context, alignFunc AlignFunc, stdin io.Reader, options ...string) ([]*seq.Sequence, error) {
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	if err := alignFunc(ctx, stdin, stdout, stderr, options...); err != nil {
+		// the output of a killed aligner may be partial.
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%v: %s", err, stderr.Bytes())
+	}
+
+	alns, err := seq.NewFastaReader(stdout).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, stderr.Bytes())
+	}
+	return alns, nil
+}
 
+func init() {
+	Register("muscle", "muscle", Muscle)
+	Register("mafft", "mafft", Mafft)
 }
 
 // do multiple sequence alignment using muscle
-func Muscle(stdin io.Reader, stdout, stderr io.Writer, options ...string) (err error) {
+func Muscle(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, options ...string) (err error) {
 	cmd := exec.Command("muscle", options...)
 	cmd.Stdin = stdin
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
-	err = cmd.Run()
+	err = run(ctx, cmd)
+	return
+}
+
+// do multiple sequence alignment using mafft,
+// with its automatic choice of strategy.
+func Mafft(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, options ...string) (err error) {
+	args := append([]string{"--auto", "--preservecase"}, options...)
+	args = append(args, "/dev/stdin")
+	cmd := exec.Command("mafft", args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = run(ctx, cmd)
 	return
 }
 
+// run runs a command in its own process group,
+// which is killed when ctx is done.
+// Killing only the command, as exec.CommandContext does, is not enough:
+// mafft is a script, and its children would keep the output open.
+func run(ctx context.Context, cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return ctx.Err()
+	}
+}
+
 // back translate amino acid alignment to nucleotide sequences.
 func BackTranslate(aa, na []byte) []byte {
 	k := 0
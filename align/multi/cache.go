@@ -0,0 +1,76 @@
+package multi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Cache returns an AlignFunc which stores the output of alignFunc
+// in the directory dir, keyed by a hash of the aligner name,
+// its options and its input.
+// An input found in the cache is not aligned again.
+// Failed alignments are not cached.
+func Cache(dir, name string, alignFunc AlignFunc) AlignFunc {
+	return func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, options ...string) error {
+		input, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+
+		fileName := filepath.Join(dir, cacheKey(name, input, options)+".fasta")
+		if output, err := ioutil.ReadFile(fileName); err == nil {
+			_, err = stdout.Write(output)
+			return err
+		}
+
+		output := new(bytes.Buffer)
+		if err := alignFunc(ctx, bytes.NewReader(input), output, stderr, options...); err != nil {
+			return err
+		}
+		if err := writeCache(dir, fileName, output.Bytes()); err != nil {
+			return err
+		}
+		_, err = stdout.Write(output.Bytes())
+		return err
+	}
+}
+
+// cacheKey returns the hex encoded SHA-256 of the aligner name, options and input.
+func cacheKey(name string, input []byte, options []string) string {
+	h := sha256.New()
+	io.WriteString(h, name)
+	for _, o := range options {
+		io.WriteString(h, "\x00"+o)
+	}
+	io.WriteString(h, "\x00\x00")
+	h.Write(input)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// writeCache writes data to fileName in dir through a temporary file,
+// so that an interrupted run never leaves a partial alignment.
+func writeCache(dir, fileName string, data []byte) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), fileName)
+}
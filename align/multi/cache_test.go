@@ -0,0 +1,68 @@
+package multi
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mingzhi/ncbiftp/seqrecord"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aln_cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a stub aligner which returns its input, as all sequences have the same length.
+	calls := 0
+	stub := func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, options ...string) error {
+		calls++
+		_, err := io.Copy(stdout, stdin)
+		return err
+	}
+	missing := func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, options ...string) error {
+		return errors.New("aligner not found")
+	}
+
+	records := []seqrecord.SeqRecord{
+		{Id: "g1", Genome: "A", Nucl: []byte("ATGAAA")},
+		{Id: "g2", Genome: "B", Nucl: []byte("ATGAAG")},
+		{Id: "g3", Genome: "C", Nucl: []byte("ATGAGA")},
+	}
+
+	first, err := AlignNucl(context.Background(), records, Cache(dir, "stub", stub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("Expect 1 aligner call, got %d\n", calls)
+	}
+
+	// the rerun is read from the cache.
+	second, err := AlignNucl(context.Background(), records, Cache(dir, "stub", missing))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != len(first) {
+		t.Fatalf("Expect %d cached records, got %d\n", len(first), len(second))
+	}
+	for i := range first {
+		if first[i].Id != second[i].Id || string(first[i].Nucl) != string(second[i].Nucl) {
+			t.Errorf("%d, Expect %s %s, got %s %s\n", i, first[i].Id, first[i].Nucl, second[i].Id, second[i].Nucl)
+		}
+	}
+
+	// changed inputs, or another aligner, are aligned again.
+	records[0].Nucl = []byte("ATGCCC")
+	if _, err := AlignNucl(context.Background(), records, Cache(dir, "stub", missing)); err == nil {
+		t.Errorf("Expect error for a changed cluster not in the cache\n")
+	}
+	if _, err := AlignNucl(context.Background(), records[1:], Cache(dir, "other", missing)); err == nil {
+		t.Errorf("Expect error for another aligner not in the cache\n")
+	}
+}
@@ -0,0 +1,79 @@
+package multi
+
+import "github.com/mingzhi/ncbiftp/seqrecord"
+
+// isGap returns true if b is a gap character of aligners:
+// '-' (MUSCLE, MAFFT), '.' or '~'.
+func isGap(b byte) bool {
+	return b == '-' || b == '.' || b == '~'
+}
+
+// gapFractions returns the fraction of sequences
+// with a gap at each column of the aligned nucleotide sequences.
+// Columns past the end of a shorter sequence are gaps in it.
+func gapFractions(aln seqrecord.SeqRecords) []float64 {
+	length := 0
+	for _, sr := range aln {
+		if len(sr.Nucl) > length {
+			length = len(sr.Nucl)
+		}
+	}
+	fractions := make([]float64, length)
+	for _, sr := range aln {
+		for i := range fractions {
+			if i >= len(sr.Nucl) || isGap(sr.Nucl[i]) {
+				fractions[i]++
+			}
+		}
+	}
+	for i := range fractions {
+		fractions[i] /= float64(len(aln))
+	}
+	return fractions
+}
+
+// StripAllGapColumns returns a copy of the alignment (of the Nucl sequences)
+// without the columns which are gaps in every sequence.
+// Shorter sequences of a ragged alignment are padded with gaps.
+func StripAllGapColumns(aln seqrecord.SeqRecords) seqrecord.SeqRecords {
+	fractions := gapFractions(aln)
+	stripped := make(seqrecord.SeqRecords, len(aln))
+	for k, sr := range aln {
+		var s []byte
+		for i, f := range fractions {
+			if f < 1 {
+				if i < len(sr.Nucl) {
+					s = append(s, sr.Nucl[i])
+				} else {
+					s = append(s, '-')
+				}
+			}
+		}
+		sr.Nucl = s
+		stripped[k] = sr
+	}
+	return stripped
+}
+
+// MaskGapColumns returns a copy of the alignment (of the Nucl sequences)
+// with the columns having a fraction of gaps above maxGapFraction
+// replaced by gaps in every sequence, keeping the column positions.
+// Masked columns can then be removed by StripAllGapColumns.
+// Shorter sequences of a ragged alignment are padded with gaps.
+func MaskGapColumns(aln seqrecord.SeqRecords, maxGapFraction float64) seqrecord.SeqRecords {
+	fractions := gapFractions(aln)
+	masked := make(seqrecord.SeqRecords, len(aln))
+	for k, sr := range aln {
+		s := make([]byte, len(fractions))
+		for i := range s {
+			if i >= len(sr.Nucl) || fractions[i] > maxGapFraction {
+				s[i] = '-'
+			} else {
+				s[i] = sr.Nucl[i]
+			}
+		}
+		sr.Nucl = s
+		masked[k] = sr
+	}
+	return masked
+}
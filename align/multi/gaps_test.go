@@ -0,0 +1,73 @@
+package multi
+
+import (
+	"testing"
+
+	"github.com/mingzhi/ncbiftp/seqrecord"
+)
+
+func TestGapColumns(t *testing.T) {
+	// columns 1 and 3 are gaps in every sequence (with the gap characters of aligners),
+	// column 2 in two of the four sequences, and column 5 in one.
+	aln := seqrecord.SeqRecords{
+		{Id: "a", Nucl: []byte("A-C-GT")},
+		{Id: "b", Nucl: []byte("A-C.GT")},
+		{Id: "c", Nucl: []byte("A.-~G-")},
+		{Id: "d", Nucl: []byte("A--~GT")},
+	}
+	original := []string{"A-C-GT", "A-C.GT", "A.-~G-", "A--~GT"}
+
+	testCases := []struct {
+		name     string
+		got      seqrecord.SeqRecords
+		expected []string
+	}{
+		{"strip", StripAllGapColumns(aln), []string{"ACGT", "ACGT", "A-G-", "A-GT"}},
+		// masked gaps are '-'.
+		{"mask 0.5", MaskGapColumns(aln, 0.5), []string{"A-C-GT", "A-C-GT", "A---G-", "A---GT"}},
+		{"mask 0.25", MaskGapColumns(aln, 0.25), []string{"A---GT", "A---GT", "A---G-", "A---GT"}},
+		{"mask 0", MaskGapColumns(aln, 0), []string{"A---G-", "A---G-", "A---G-", "A---G-"}},
+		{"mask then strip", StripAllGapColumns(MaskGapColumns(aln, 0.25)), []string{"AGT", "AGT", "AG-", "AGT"}},
+	}
+	for _, tc := range testCases {
+		if len(tc.got) != len(tc.expected) {
+			t.Fatalf("%s, Expect %d sequences, got %d\n", tc.name, len(tc.expected), len(tc.got))
+		}
+		for i, sr := range tc.got {
+			if string(sr.Nucl) != tc.expected[i] || sr.Id != aln[i].Id {
+				t.Errorf("%s, %s, Expect %s, got %s %s\n", tc.name, aln[i].Id, tc.expected[i], sr.Id, sr.Nucl)
+			}
+		}
+	}
+
+	// the input is not modified.
+	for i, sr := range aln {
+		if string(sr.Nucl) != original[i] {
+			t.Errorf("Expect the input %s unchanged, got %s\n", original[i], sr.Nucl)
+		}
+	}
+}
+
+func TestGapColumnsRagged(t *testing.T) {
+	aln := seqrecord.SeqRecords{
+		{Id: "a", Nucl: []byte("ACG")},
+		{Id: "b", Nucl: []byte("AC")},
+		{Id: "c", Nucl: []byte("-C")},
+	}
+	testCases := []struct {
+		name     string
+		got      seqrecord.SeqRecords
+		expected []string
+	}{
+		{"strip", StripAllGapColumns(aln), []string{"ACG", "AC-", "-C-"}},
+		{"mask 0.5", MaskGapColumns(aln, 0.5), []string{"AC-", "AC-", "-C-"}},
+		{"mask then strip", StripAllGapColumns(MaskGapColumns(aln, 0.5)), []string{"AC", "AC", "-C"}},
+	}
+	for _, tc := range testCases {
+		for i, sr := range tc.got {
+			if string(sr.Nucl) != tc.expected[i] {
+				t.Errorf("%s, %s, Expect %s, got %s\n", tc.name, aln[i].Id, tc.expected[i], sr.Nucl)
+			}
+		}
+	}
+}
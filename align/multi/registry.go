@@ -0,0 +1,72 @@
+package multi
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// aligner is a registered alignment backend.
+type aligner struct {
+	executable string // checked in PATH, unless empty.
+	alignFunc  AlignFunc
+}
+
+var (
+	alignersMu sync.RWMutex
+	aligners   = make(map[string]aligner)
+)
+
+// Register makes an aligner available by name to NewAlignFunc,
+// running alignFunc, with executable, if not empty, checked in PATH.
+// Backends usually register themselves in an init function.
+// It panics if alignFunc is nil or the name is already registered.
+func Register(name, executable string, alignFunc AlignFunc) {
+	alignersMu.Lock()
+	defer alignersMu.Unlock()
+	if alignFunc == nil {
+		panic("multi: Register of a nil AlignFunc for aligner " + name)
+	}
+	if _, found := aligners[name]; found {
+		panic("multi: Register called twice for aligner " + name)
+	}
+	aligners[name] = aligner{executable: executable, alignFunc: alignFunc}
+}
+
+// unregister removes a registered aligner, e.g. one registered by a test.
+func unregister(name string) {
+	alignersMu.Lock()
+	defer alignersMu.Unlock()
+	delete(aligners, name)
+}
+
+// Aligners returns the sorted names of the registered aligners.
+func Aligners() []string {
+	alignersMu.RLock()
+	defer alignersMu.RUnlock()
+	var names []string
+	for name := range aligners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewAlignFunc returns the AlignFunc of a registered aligner, such as muscle or mafft,
+// after checking that its executable is in PATH.
+func NewAlignFunc(name string) (AlignFunc, error) {
+	alignersMu.RLock()
+	a, found := aligners[name]
+	alignersMu.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("unknown aligner %s, should be one of %s", name, strings.Join(Aligners(), ", "))
+	}
+	if a.executable != "" {
+		if _, err := exec.LookPath(a.executable); err != nil {
+			return nil, fmt.Errorf("aligner %s: %v", name, err)
+		}
+	}
+	return a.alignFunc, nil
+}
@@ -0,0 +1,59 @@
+package multi
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/mingzhi/ncbiftp/seqrecord"
+)
+
+func TestRegister(t *testing.T) {
+	// an in-memory aligner which returns its input, as all sequences have the same length.
+	calls := 0
+	fake := func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, options ...string) error {
+		calls++
+		_, err := io.Copy(stdout, stdin)
+		return err
+	}
+	Register("fake", "", fake)
+	defer unregister("fake")
+
+	registered := false
+	for _, name := range Aligners() {
+		registered = registered || name == "fake"
+	}
+	if !registered {
+		t.Errorf("Expect fake in the aligners, got %v\n", Aligners())
+	}
+
+	alignFunc, err := NewAlignFunc("fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []seqrecord.SeqRecord{
+		{Id: "g1", Genome: "A", Nucl: []byte("ATGAAA")},
+		{Id: "g2", Genome: "B", Nucl: []byte("ATGAAG")},
+	}
+	alns, err := AlignNucl(context.Background(), records, alignFunc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 || len(alns) != 2 || string(alns[1].Nucl) != "ATGAAG" {
+		t.Errorf("Expect 1 call of the fake aligner, aligning 2 records, got %d calls, %v\n", calls, alns)
+	}
+
+	// unknown aligners are listed.
+	if _, err := NewAlignFunc("clustal"); err == nil || !strings.Contains(err.Error(), "fake") {
+		t.Errorf("Expect an error listing the registered aligners, got %v\n", err)
+	}
+
+	// a name registered twice.
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expect a panic registering fake twice\n")
+		}
+	}()
+	Register("fake", "", fake)
+}
@@ -1,18 +1,24 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"github.com/mingzhi/biogo/feat/gff"
 	"github.com/mingzhi/biogo/seq"
-	"github.com/mingzhi/gomath/stat/correlation"
 	"github.com/mingzhi/gomath/stat/desc/meanvar"
+	"github.com/mingzhi/meta/genome"
 	"github.com/mingzhi/ncbiftp/genomes/profiling"
 	"github.com/mingzhi/ncbiftp/taxonomy"
 	"log"
 	"math"
+	"math/rand"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
 func main() {
@@ -23,36 +29,200 @@ func main() {
 	var maxl int
 	var pos int
 	var codonTableID string
+	var emptyBins string
+	var geneA, geneB string
+	var numBoot int
+	var minN int
+	var skipLowercase bool
+	var corr string
+	var manifestFile string
+	var ncpu int
 	// Parse arguments.
 	flag.IntVar(&maxl, "maxl", 100, "max length of correlations")
 	flag.IntVar(&pos, "pos", 4, "position")
 	flag.StringVar(&codonTableID, "codon", "11", "codon table ID")
+	flag.StringVar(&emptyBins, "empty-bins", "nan", "how to write lags without data: omit, nan or zero")
+	flag.StringVar(&geneA, "gene-a", "", "ID of the first gene for trans linkage")
+	flag.StringVar(&geneB, "gene-b", "", "ID of the second gene for trans linkage")
+	flag.IntVar(&numBoot, "boot", 1000, "number of bootstraps for trans linkage")
+	flag.IntVar(&minN, "min-n", 10, "a chunk's covariance at a lag is used only if it comes from more than min-n position pairs")
+	flag.StringVar(&corr, "corr", "pearson", "correlation of the pi values: pearson (covariance), or spearman (rank correlation, buffering the pairs of each lag in memory)")
+	flag.BoolVar(&skipLowercase, "skip-lowercase", false, "leave out the positions of soft-masked (lower case) genome bases; otherwise they are used as upper case")
+	flag.StringVar(&manifestFile, "manifest", "", "file of genomes to process, one per line: pi file, genome file, gff file and out file, separated by tabs; replaces the arguments")
+	flag.IntVar(&ncpu, "ncpu", 1, "number of chunks of the pis calculated at a time: 1 reads the pis as a stream, holding at most maxl of them, while more hold the pis of each chunk (1/1000 of a genome) in memory; or with -manifest, of genomes processed at a time, each read as a stream")
 	flag.Parse()
-	if flag.NArg() < 4 {
-		log.Fatalln("Usage: go run calc_cr.go <pi file> <genome file> <gff file> <out file>")
+	if manifestFile != "" {
+		if flag.NArg() > 0 {
+			log.Fatalln("-manifest replaces the <pi file> <genome file> <gff file> <out file> arguments")
+		}
+		if geneA != "" || geneB != "" {
+			log.Fatalln("-gene-a and -gene-b can not be used with -manifest")
+		}
+	} else if flag.NArg() < 4 {
+		log.Fatalln("Usage: go run calc_cr.go <pi file> <genome file> <gff file> <out file>, or go run calc_cr.go -manifest <manifest file>")
+	}
+	if emptyBins != "omit" && emptyBins != "nan" && emptyBins != "zero" {
+		log.Fatalf("empty-bins should be omit, nan or zero, got %s\n", emptyBins)
+	}
+	newCov, found := newCovFuncs[corr]
+	if !found {
+		log.Fatalf("corr should be pearson or spearman, got %s\n", corr)
 	}
 	piFile = flag.Arg(0)
 	genomeFile = flag.Arg(1)
 	gffFile = flag.Arg(2)
 	outFile = flag.Arg(3)
 
-	// Obtain codon table for identifying four-fold degenerate sites.
-	codonTable := taxonomy.GeneticCodes()[codonTableID]
+	opts := crOptions{
+		// Obtain codon table for identifying four-fold degenerate sites.
+		codonTable:    taxonomy.GeneticCodes()[codonTableID],
+		posType:       convertPosType(pos),
+		maxl:          maxl,
+		minN:          minN,
+		emptyBins:     emptyBins,
+		skipLowercase: skipLowercase,
+		newCov:        newCov,
+		ncpu:          ncpu,
+	}
+
+	// Genomes of a manifest.
+	if manifestFile != "" {
+		entries, err := readManifest(manifestFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		// the genomes are processed in parallel, each in one go routine.
+		opts.ncpu = 1
+		runManifest(entries, ncpu, func(e entry) { calcGenome(e, opts) })
+		return
+	}
+
+	// Trans linkage between two genes.
+	if geneA != "" || geneB != "" {
+		profiles, gffs := loadGenome(genomeFile, gffFile, opts)
+		piArr := loadPis(piFile, profiles)
+		recA, recB := findGff(gffs, geneA), findGff(gffs, geneB)
+		if recA == nil || recB == nil {
+			log.Fatalf("Can not find both genes %s and %s in %s\n", geneA, geneB, gffFile)
+		}
+		pisA := regionPis(piArr, profiles, recA.SeqName, recA.Start, recA.End)
+		pisB := regionPis(piArr, profiles, recB.SeqName, recB.Start, recB.End)
+		cov := CalcTransCr(pisA, pisB, profiles, opts.posType, newCov)
+		lo, hi := bootTransCr(pisA, pisB, profiles, opts.posType, numBoot, newCov)
+
+		w, err := os.Create(outFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer w.Close()
+		w.WriteString(fmt.Sprintf("%s\t%s\t%g\t%g\t%g\t%d\n", geneA, geneB, cov.GetResult(), lo, hi, cov.GetN()))
+		return
+	}
+
+	calcGenome(entry{Genome: genomeFile, Gff: gffFile, Pi: piFile, Out: outFile}, opts)
+}
+
+// crOptions are the options of the calculation for each genome.
+type crOptions struct {
+	codonTable    *taxonomy.GeneticCode
+	posType       byte
+	maxl, minN    int
+	emptyBins     string
+	skipLowercase bool
+	newCov        func() Covariance
+	ncpu          int // go routines calculating the chunks of the pis.
+}
+
+// loadGenome returns the profiles of the contigs of a genome,
+// profiled with the CDS in the gff file, and the CDS.
+func loadGenome(genomeFile, gffFile string, opts crOptions) (profiles contigProfiles, gffs []*gff.Record) {
 	// Profiling genome using reference sequence and protein feature data.
-	genome := readGenome(genomeFile)
-	gffs := readGff(gffFile)
-	profile := profiling.ProfileGenome(genome, gffs, codonTable)
-
-	// Read pi.
-	piArr := readPi(piFile)
-	numChunck := 1000
-	lenChunck := len(piArr) / numChunck
+	contigs := readGenome(genomeFile)
+	masked := make(map[string][][2]int)
+	for _, c := range contigs {
+		masked[c.Id] = genome.UpperCase(c.Seq)
+	}
+	gffs = readGff(gffFile)
+	regions, err := readSequenceRegions(gffFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := checkSequenceRegions(contigs, regions); err != nil {
+		log.Fatalf("%v: are %s and %s from the same assembly?\n", err, genomeFile, gffFile)
+	}
+	profiles, err = profileContigs(contigs, gffs, opts.codonTable)
+	if err != nil {
+		log.Fatalf("%v: are %s and %s from the same assembly?\n", err, genomeFile, gffFile)
+	}
+	if opts.skipLowercase {
+		for name, profile := range profiles {
+			genome.MaskProfile(profile, masked[name])
+		}
+	}
+
+	return
+}
+
+// loadPis reads all the pis of a file, in the profiles.
+func loadPis(piFile string, profiles contigProfiles) []Pi {
+	piArr, outside, zeros, missing := checkPis(readPi(piFile), profiles)
+	warnOutside(piFile, outside, zeros, missing)
+	return piArr
+}
+
+// warnOutside warns once of the pis of a file not used, see checkPis.
+func warnOutside(piFile string, outside, zeros, missing int) {
+	if outside > 0 {
+		log.Printf("Skipped %d pi records of %s with a position outside their contig, of which %d at position 0: positions should be 1-based\n", outside, piFile, zeros)
+	}
+	if missing > 0 {
+		log.Printf("Skipped %d pi records of %s on contigs not in the genome\n", missing, piFile)
+	}
+}
+
+// calcGenome calculates the covariances of the pis of a genome,
+// pooled over chunks, and writes them to its out file.
+// The pis are read as a stream, see poolPiFile.
+func calcGenome(e entry, opts crOptions) {
+	profiles, _ := loadGenome(e.Genome, e.Gff, opts)
+	covMVs, outside, zeros, missing := poolPiFile(e.Pi, profiles, opts.posType, opts.maxl, opts.minN, opts.newCov, opts.ncpu)
+	warnOutside(e.Pi, outside, zeros, missing)
+
+	w, err := os.Create(e.Out)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer w.Close()
+
+	for i := 0; i < len(covMVs); i++ {
+		c := covMVs[i]
+		m, v, n := c.Mean.GetResult(), c.Var.GetResult(), c.Mean.GetN()
+		if n == 0 {
+			switch opts.emptyBins {
+			case "omit":
+				continue
+			case "zero":
+				m, v = 0, 0
+			default:
+				m, v = math.NaN(), math.NaN()
+			}
+		}
+		w.WriteString(fmt.Sprintf("%d\t%g\t%g\t%d\n", i, m, v, n))
+	}
+}
+
+// numChunks is the number of chunks the pis of a genome are split into,
+// of equal numbers of pis; the remaining pis are not used.
+const numChunks = 1000
+
+// chunkPis splits the pis into numChunks chunks.
+func chunkPis(piArr []Pi) [][]Pi {
+	lenChunck := len(piArr) / numChunks
 	piChuncks := [][]Pi{}
-	for i := 0; i < numChunck; i++ {
+	for i := 0; i < numChunks; i++ {
 		pis := piArr[i*lenChunck : (i+1)*lenChunck]
 		piChuncks = append(piChuncks, pis)
 	}
-	posType := convertPosType(pos)
 	/*
 		genePiMap := make(map[string][]Pi)
 		for _, pi := range piArr {
@@ -61,47 +231,163 @@ func main() {
 			genePiMap[geneName] = append(genePiMap[geneName], pi)
 		}
 	*/
+	return piChuncks
+}
+
+// poolCr calculates the covariances of each chunk of pis,
+// and returns their mean and variance over chunks at each lag.
+// Covariances from minN or less position pairs are not used.
+// It holds all the pis; poolPiFile gives the same results from a pi file
+// of chunkPis chunks, holding at most maxl pis.
+// The chunks are calculated in ncpu go routines, see poolChunks.
+func poolCr(piChuncks [][]Pi, profiles contigProfiles, posType byte, maxl, minN int, newCov func() Covariance, ncpu int) []*meanvar.MeanVar {
+	chunks := make(chan []Pi)
+	go func() {
+		defer close(chunks)
+		for _, pis := range piChuncks {
+			chunks <- pis
+		}
+	}()
+	return poolChunks(chunks, profiles, posType, maxl, minN, newCov, ncpu)
+}
+
+// poolChunks is poolCr on the chunks received, calculating them
+// in ncpu go routines. Each chunk is reduced to its covariances,
+// which are pooled in the order of the chunks once all are calculated,
+// so the results do not depend on ncpu.
+func poolChunks(chunks <-chan []Pi, profiles contigProfiles, posType byte, maxl, minN int, newCov func() Covariance, ncpu int) []*meanvar.MeanVar {
+	if ncpu < 1 {
+		ncpu = 1
+	}
+	type job struct {
+		index int
+		pis   []Pi
+	}
+	// the covariances of each chunk, NaN at the lags not used.
+	chunkCovs := make(map[int][]float64)
+	var mutex sync.Mutex
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+	for i := 0; i < ncpu; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				covs := CalcCr(j.pis, profiles, posType, maxl, newCov)
+				values := make([]float64, len(covs))
+				for i := range covs {
+					n := covs[i].GetN()
+					v := covs[i].GetResult()
+					values[i] = math.NaN()
+					if n > minN && !math.IsNaN(v) {
+						values[i] = v
+					}
+				}
+				mutex.Lock()
+				chunkCovs[j.index] = values
+				mutex.Unlock()
+			}
+		}()
+	}
+	count := 0
+	for pis := range chunks {
+		jobs <- job{index: count, pis: pis}
+		count++
+	}
+	close(jobs)
+	wg.Wait()
+
 	covMVs := make([]*meanvar.MeanVar, maxl)
 	for i := range covMVs {
 		covMVs[i] = meanvar.New()
 	}
-	for _, pis := range piChuncks {
-		covs := CalcCr(pis, profile, posType, maxl)
-		for i := range covs {
-			n := covs[i].GetN()
-			v := covs[i].GetResult()
-			if n > 10 && !math.IsNaN(v) {
+	for k := 0; k < count; k++ {
+		for i, v := range chunkCovs[k] {
+			if !math.IsNaN(v) {
 				covMVs[i].Increment(v)
 			}
 		}
 	}
+	return covMVs
+}
 
-	w, err := os.Create(outFile)
+// readGenome returns the contigs in the genome file,
+// in FASTA, or in 2bit if its name ends with .2bit.
+func readGenome(filename string) []*seq.Sequence {
+	if strings.HasSuffix(filename, ".2bit") {
+		return readGenome2bit(filename)
+	}
+	ss, err := genome.ReadFastaAll(filename)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	defer w.Close()
-
-	for i := 0; i < len(covMVs); i++ {
-		c := covMVs[i]
-		w.WriteString(fmt.Sprintf("%d\t%g\t%g\t%d\n", i, c.Mean.GetResult(), c.Var.GetResult(), c.Mean.GetN()))
+	if len(ss) == 0 {
+		log.Fatalf("no sequence in %s\n", filename)
 	}
+
+	return ss
 }
 
-func readGenome(filename string) []byte {
-	f, err := os.Open(filename)
+// readGenome2bit returns the contigs in a UCSC 2bit genome file.
+func readGenome2bit(filename string) []*seq.Sequence {
+	ss, err := genome.ReadTwoBitAll(filename)
 	if err != nil {
-		panic(err)
+		log.Fatalln(err)
+	}
+	if len(ss) == 0 {
+		log.Fatalf("no sequence in %s\n", filename)
 	}
-	defer f.Close()
 
-	rd := seq.NewFastaReader(f)
-	ss, err := rd.ReadAll()
-	if err != nil {
-		panic(err)
+	return ss
+}
+
+// contigProfiles are the position profiles of the contigs of a genome.
+type contigProfiles map[string][]profiling.Pos
+
+// name returns the name of a contig in the profiles, or "" if it is not found.
+// A genome with a single contig is used for any name,
+// as its name may differ between files.
+func (p contigProfiles) name(contig string) string {
+	if _, found := p[contig]; found {
+		return contig
 	}
+	if len(p) == 1 {
+		for name := range p {
+			return name
+		}
+	}
+	return ""
+}
 
-	return ss[0].Seq
+// get returns the profile of a contig, or nil if it is not found.
+func (p contigProfiles) get(contig string) []profiling.Pos {
+	return p[p.name(contig)]
+}
+
+// profileContigs profiles each contig with its CDS.
+func profileContigs(contigs []*seq.Sequence, gffs []*gff.Record, codonTable *taxonomy.GeneticCode) (contigProfiles, error) {
+	contigGffs := make(map[string][]*gff.Record)
+	for _, rec := range gffs {
+		name := rec.SeqName
+		if len(contigs) == 1 {
+			name = contigs[0].Id
+		}
+		contigGffs[name] = append(contigGffs[name], rec)
+	}
+
+	profiles := make(contigProfiles)
+	for _, c := range contigs {
+		if err := checkGffs(c.Seq, contigGffs[c.Id]); err != nil {
+			return nil, fmt.Errorf("%s: %v", c.Id, err)
+		}
+		profiles[c.Id] = profiling.ProfileGenome(c.Seq, contigGffs[c.Id], codonTable)
+		delete(contigGffs, c.Id)
+	}
+	for name := range contigGffs {
+		return nil, fmt.Errorf("can not find contig %s of CDS", name)
+	}
+
+	return profiles, nil
 }
 
 func readGff(filename string) []*gff.Record {
@@ -129,21 +415,26 @@ func readGff(filename string) []*gff.Record {
 
 func readPi(filename string) []Pi {
 	piArr := []Pi{}
-	f, err := os.Open(filename)
+	scanPis(filename, func(pi Pi) { piArr = append(piArr, pi) })
+	return piArr
+}
+
+// scanPis calls f with each pi of the file, in order, as they are decoded.
+func scanPis(filename string, f func(pi Pi)) {
+	r, err := os.Open(filename)
 	if err != nil {
 		log.Fatalln(err)
 	}
-	defer f.Close()
-	decoder := json.NewDecoder(f)
+	defer r.Close()
+	decoder := json.NewDecoder(r)
 	var pi Pi
 	for decoder.More() {
 		err := decoder.Decode(&pi)
 		if err != nil {
 			log.Fatal(err)
 		}
-		piArr = append(piArr, pi)
+		f(pi)
 	}
-	return piArr
 }
 
 func convertPosType(pos int) byte {
@@ -185,16 +476,33 @@ type Covariance interface {
 }
 
 // Calculate covariance of rates.
-func CalcCr(pis []Pi, profile []profiling.Pos, posType byte, maxl int) (covs []Covariance) {
+// pis are sorted by position within each contig (Genome),
+// and positions on different contigs are never paired.
+// Positions are 1-based; those outside the profile of their contig are skipped.
+// newCov returns the Covariance of each lag (see newCovFuncs).
+func CalcCr(pis []Pi, profiles contigProfiles, posType byte, maxl int, newCov func() Covariance) (covs []Covariance) {
 	corrs := make([]Covariance, maxl)
 	for i := 0; i < maxl; i++ {
-		corrs[i] = correlation.NewBivariateCovariance(false)
+		corrs[i] = newCov()
 	}
 
 	for i := 0; i < len(pis); i++ {
+		profile := profiles.get(pis[i].Genome)
+		if profile == nil {
+			continue
+		}
+		if !inProfile(profile, pis[i].Position) {
+			continue
+		}
 		pos1 := profile[pis[i].Position-1]
 		if checkPosType(posType, pos1.Type) {
 			for j := i; j < len(pis); j++ {
+				if pis[j].Genome != pis[i].Genome {
+					break
+				}
+				if !inProfile(profile, pis[j].Position) {
+					continue
+				}
 				pos2 := profile[pis[j].Position-1]
 
 				distance := pis[j].Position - pis[i].Position
@@ -214,6 +522,177 @@ func CalcCr(pis []Pi, profile []profiling.Pos, posType byte, maxl int) (covs []C
 	return
 }
 
+// inProfile returns true if the 1-based position is in the profile.
+func inProfile(profile []profiling.Pos, position int) bool {
+	return position >= 1 && position <= len(profile)
+}
+
+// checkPis returns the pis whose positions are in the profile of their contig,
+// and the number of the others, outside, of which zeros are at position 0,
+// as with 0-based positions. Pis on contigs without a profile are kept,
+// and not used by CalcCr; missing is their number.
+func checkPis(pis []Pi, profiles contigProfiles) (valid []Pi, outside, zeros, missing int) {
+	for _, pi := range pis {
+		if profiles.get(pi.Genome) == nil {
+			missing++
+		}
+		if outsideProfile(pi, profiles) {
+			outside++
+			if pi.Position == 0 {
+				zeros++
+			}
+			continue
+		}
+		valid = append(valid, pi)
+	}
+	return
+}
+
+// outsideProfile returns true if the pi is outside the profile of its contig.
+func outsideProfile(pi Pi, profiles contigProfiles) bool {
+	profile := profiles.get(pi.Genome)
+	return profile != nil && !inProfile(profile, pi.Position)
+}
+
+// CalcTransCr calculates covariance of rates
+// between positions of two regions (genes).
+func CalcTransCr(pisA, pisB []Pi, profiles contigProfiles, posType byte, newCov func() Covariance) Covariance {
+	cov := newCov()
+	for i := 0; i < len(pisA); i++ {
+		profileA := profiles.get(pisA[i].Genome)
+		if inProfile(profileA, pisA[i].Position) && checkPosType(posType, profileA[pisA[i].Position-1].Type) {
+			for j := 0; j < len(pisB); j++ {
+				profileB := profiles.get(pisB[j].Genome)
+				if inProfile(profileB, pisB[j].Position) && checkPosType(posType, profileB[pisB[j].Position-1].Type) {
+					cov.Increment(pisA[i].Pi, pisB[j].Pi)
+				}
+			}
+		}
+	}
+	return cov
+}
+
+// bootTransCr resamples positions of the two regions with replacement,
+// and returns the 2.5 and 97.5 percentiles of the trans covariance.
+func bootTransCr(pisA, pisB []Pi, profiles contigProfiles, posType byte, numBoot int, newCov func() Covariance) (lo, hi float64) {
+	if numBoot <= 0 || len(pisA) == 0 || len(pisB) == 0 {
+		return math.NaN(), math.NaN()
+	}
+
+	values := []float64{}
+	for k := 0; k < numBoot; k++ {
+		sampleA := make([]Pi, len(pisA))
+		for i := range sampleA {
+			sampleA[i] = pisA[rand.Intn(len(pisA))]
+		}
+		sampleB := make([]Pi, len(pisB))
+		for i := range sampleB {
+			sampleB[i] = pisB[rand.Intn(len(pisB))]
+		}
+		v := CalcTransCr(sampleA, sampleB, profiles, posType, newCov).GetResult()
+		if !math.IsNaN(v) {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return math.NaN(), math.NaN()
+	}
+
+	sort.Float64s(values)
+	lo = values[int(0.025*float64(len(values)-1))]
+	hi = values[int(0.975*float64(len(values)-1))]
+	return
+}
+
+// checkGffs checks that no CDS lies outside of the genome,
+// or starts after its end, which ProfileGenome would leave out.
+func checkGffs(genome []byte, gffs []*gff.Record) error {
+	for _, rec := range gffs {
+		if rec.Start < 1 || rec.Start > len(genome) || rec.End < 1 || rec.End > len(genome) {
+			return fmt.Errorf("CDS %s (%d..%d) extends beyond the genome of length %d", rec.ID(), rec.Start, rec.End, len(genome))
+		}
+		if rec.Start > rec.End {
+			return fmt.Errorf("CDS %s (%d..%d) starts after its end", rec.ID(), rec.Start, rec.End)
+		}
+	}
+	return nil
+}
+
+// readSequenceRegions returns the lengths of the sequences declared
+// in the header of a gff file, by ##sequence-region seqid start end directives.
+func readSequenceRegions(filename string) (map[string]int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	regions := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "##sequence-region" {
+			continue
+		}
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("%s: bad directive %s", filename, scanner.Text())
+		}
+		start, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad directive %s", filename, scanner.Text())
+		}
+		end, err := strconv.Atoi(fields[3])
+		if err != nil || end < start {
+			return nil, fmt.Errorf("%s: bad directive %s", filename, scanner.Text())
+		}
+		regions[fields[1]] = end - start + 1
+	}
+	return regions, scanner.Err()
+}
+
+// checkSequenceRegions checks the length of each contig against that declared
+// in the gff header, if any; as in profileContigs, a single contig
+// is that of a single declared sequence, whatever their names.
+func checkSequenceRegions(contigs []*seq.Sequence, regions map[string]int) error {
+	for _, c := range contigs {
+		length, found := regions[c.Id]
+		if !found && len(contigs) == 1 && len(regions) == 1 {
+			for _, length = range regions {
+				found = true
+			}
+		}
+		if found && length != len(c.Seq) {
+			return fmt.Errorf("contig %s of length %d, but of length %d in the ##sequence-region of the gff", c.Id, len(c.Seq), length)
+		}
+	}
+	return nil
+}
+
+// findGff returns the gff record of the ID.
+func findGff(records []*gff.Record, id string) *gff.Record {
+	for _, r := range records {
+		if r.ID() == id {
+			return r
+		}
+	}
+	return nil
+}
+
+// regionPis returns pi at positions in [start, end] of a contig.
+func regionPis(piArr []Pi, profiles contigProfiles, contig string, start, end int) []Pi {
+	pis := []Pi{}
+	name := profiles.name(contig)
+	for _, pi := range piArr {
+		if name == "" || profiles.name(pi.Genome) != name {
+			continue
+		}
+		if pi.Position >= start && pi.Position <= end {
+			pis = append(pis, pi)
+		}
+	}
+	return pis
+}
+
 func checkPosType(t, t1 byte) bool {
 	isFirstPos := t1 == profiling.FirstPos
 	isSecondPos := t1 == profiling.SecondPos
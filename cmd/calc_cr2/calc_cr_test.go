@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mingzhi/biogo/feat/gff"
+	"github.com/mingzhi/biogo/seq"
+	"github.com/mingzhi/gomath/stat/correlation"
+	"github.com/mingzhi/meta/genome"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+	"github.com/mingzhi/ncbiftp/taxonomy"
+)
+
+// TestProfileStrands checks that four-fold sites are identified
+// in genes on both strands.
+func TestProfileStrands(t *testing.T) {
+	// a + strand gene (GCT AAA), a spacer,
+	// and a - strand gene coding GGA AAA.
+	genome := []byte("GCTAAA" + "TT" + "TTTTCC")
+	gffText := "chr\ttest\tCDS\t1\t6\t.\t+\t0\tID=g1\n" +
+		"chr\ttest\tCDS\t9\t14\t.\t-\t0\tID=g2\n"
+
+	dir, err := ioutil.TempDir("", "calc_cr2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gffFile := filepath.Join(dir, "genome.gff")
+	if err := ioutil.WriteFile(gffFile, []byte(gffText), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	gffs := readGff(gffFile)
+	if err := checkGffs(genome, gffs); err != nil {
+		t.Fatal(err)
+	}
+	profile := profiling.ProfileGenome(genome, gffs, taxonomy.GeneticCodes()["11"])
+
+	// the third bases of GCT and of GGA (at 12 on the - strand).
+	expected := map[int]bool{3: true, 12: true}
+	posType := convertPosType(4)
+	for i := range genome {
+		position := i + 1
+		if got := checkPosType(posType, profile[i].Type); got != expected[position] {
+			t.Errorf("position %d (type %c), Expect four-fold %v, got %v\n", position, profile[i].Type, expected[position], got)
+		}
+	}
+}
+
+// TestProfileSoftMasked checks that soft-masked (lower case) bases
+// are profiled as upper case, or left out when masked.
+func TestProfileSoftMasked(t *testing.T) {
+	// GCT AAA GCC, with a soft-masked second codon and the last base.
+	contig := &seq.Sequence{Id: "chr", Seq: []byte("GCTaaaGCc")}
+	gffs := []*gff.Record{{SeqName: "chr", Feature: "CDS", Start: 1, End: 9, Strand: gff.ForwardStrand, Frame: "0"}}
+	masked := genome.UpperCase(contig.Seq)
+	profiles, err := profileContigs([]*seq.Sequence{contig}, gffs, taxonomy.GeneticCodes()["11"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	posType := convertPosType(4)
+	profile := profiles.get("chr")
+	if !checkPosType(posType, profile[2].Type) || !checkPosType(posType, profile[8].Type) {
+		t.Errorf("Expect four-fold sites at 3 and 9, got types %c and %c\n", profile[2].Type, profile[8].Type)
+	}
+
+	genome.MaskProfile(profile, masked)
+	for i, p := range profile {
+		used := i < 3 || (i >= 6 && i < 8)
+		if got := p.Type != profiling.Undefined; got != used {
+			t.Errorf("position %d (type %c), Expect used %v, got %v\n", i+1, p.Type, used, got)
+		}
+	}
+	if checkPosType(posType, profile[8].Type) {
+		t.Errorf("Expect the masked site 9 not to be four-fold\n")
+	}
+}
+
+// TestCovarianceLargeValues checks that the covariance used by CalcCr,
+// which updates the co-moment with Welford's algorithm,
+// stays accurate for values with a large offset,
+// where summing products would lose all precision.
+func TestCovarianceLargeValues(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	offset := 1e9
+	n := 100000
+	xs := make([]float64, n)
+	ys := make([]float64, n)
+	for i := range xs {
+		xs[i] = rng.Float64()
+		ys[i] = xs[i] + rng.Float64()
+	}
+
+	// two-pass covariance of the values without the offset.
+	var meanX, meanY float64
+	for i := range xs {
+		meanX += xs[i]
+		meanY += ys[i]
+	}
+	meanX /= float64(n)
+	meanY /= float64(n)
+	expected := 0.0
+	for i := range xs {
+		expected += (xs[i] - meanX) * (ys[i] - meanY)
+	}
+	expected /= float64(n)
+
+	var cov Covariance = correlation.NewBivariateCovariance(false)
+	for i := range xs {
+		cov.Increment(xs[i]+offset, ys[i]+offset)
+	}
+	if got := cov.GetResult(); math.Abs(got-expected) > 1e-6*math.Abs(expected) {
+		t.Errorf("Expect %g, got %g\n", expected, got)
+	}
+	if cov.GetN() != n {
+		t.Errorf("Expect n %d, got %d\n", n, cov.GetN())
+	}
+}
+
+// TestCalcCrContigs checks that positions on different contigs are not paired.
+func TestCalcCrContigs(t *testing.T) {
+	contigs := []*seq.Sequence{
+		{Id: "contig1", Seq: []byte("GCTGCTGCT")},
+		{Id: "contig2", Seq: []byte("GCTGCTGCT")},
+	}
+	gffs := []*gff.Record{
+		{SeqName: "contig1", Feature: "CDS", Start: 1, End: 9, Strand: gff.ForwardStrand},
+		{SeqName: "contig2", Feature: "CDS", Start: 1, End: 9, Strand: gff.ForwardStrand},
+	}
+	profiles, err := profileContigs(contigs, gffs, taxonomy.GeneticCodes()["11"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// four-fold sites at 3, 6 and 9 of each contig.
+	var pis []Pi
+	for _, c := range contigs {
+		for _, position := range []int{3, 6, 9} {
+			pis = append(pis, Pi{Genome: c.Id, Position: position, Pi: float64(position) / 10})
+		}
+	}
+
+	maxl := 9
+	covs := CalcCr(pis, profiles, convertPosType(4), maxl, newCovFuncs["pearson"])
+	// each contig has 3 pairs at distance 0, 2 at 3 and 1 at 6.
+	expected := map[int]int{0: 6, 3: 4, 6: 2}
+	for l := 0; l < maxl; l++ {
+		if covs[l].GetN() != expected[l] {
+			t.Errorf("distance %d, Expect %d pairs, got %d\n", l, expected[l], covs[l].GetN())
+		}
+	}
+
+	// a CDS on an unknown contig is an error.
+	gffs = append(gffs, &gff.Record{SeqName: "contig3", Feature: "CDS", Start: 1, End: 3})
+	if _, err := profileContigs(contigs, gffs, taxonomy.GeneticCodes()["11"]); err == nil {
+		t.Errorf("Expect error for a CDS on an unknown contig\n")
+	}
+}
+
+// TestCalcCrOutside checks that positions outside the profile are skipped.
+func TestCalcCrOutside(t *testing.T) {
+	contigs := []*seq.Sequence{{Id: "contig1", Seq: []byte("GCTGCTGCT")}}
+	gffs := []*gff.Record{{SeqName: "contig1", Feature: "CDS", Start: 1, End: 9, Strand: gff.ForwardStrand}}
+	profiles, err := profileContigs(contigs, gffs, taxonomy.GeneticCodes()["11"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// four-fold sites at 3, 6 and 9, with a 0-based position 0,
+	// and a position after the end of the contig.
+	pis := []Pi{
+		{Genome: "contig1", Position: 0, Pi: 0.5},
+		{Genome: "contig1", Position: 3, Pi: 0.1},
+		{Genome: "contig1", Position: 6, Pi: 0.2},
+		{Genome: "contig1", Position: 9, Pi: 0.3},
+		{Genome: "contig1", Position: 12, Pi: 0.4},
+	}
+	maxl := 9
+	covs := CalcCr(pis, profiles, convertPosType(4), maxl, newCovFuncs["pearson"])
+	expected := map[int]int{0: 3, 3: 2, 6: 1}
+	for l := 0; l < maxl; l++ {
+		if covs[l].GetN() != expected[l] {
+			t.Errorf("distance %d, Expect %d pairs, got %d\n", l, expected[l], covs[l].GetN())
+		}
+	}
+	cov := CalcTransCr(pis[:2], pis[3:], profiles, convertPosType(4), newCovFuncs["pearson"])
+	if cov.GetN() != 1 {
+		t.Errorf("trans, Expect 1 pair, got %d\n", cov.GetN())
+	}
+
+	// a pi on an unknown contig is kept, and counted as missing.
+	valid, outside, zeros, missing := checkPis(append(pis, Pi{Genome: "contig2", Position: 100}), map[string][]profiling.Pos{"contig1": profiles["contig1"], "contig2": nil})
+	if len(valid) != 4 || outside != 2 || zeros != 1 || missing != 1 {
+		t.Errorf("Expect 4 valid pis, 2 outside, 1 at 0 and 1 missing, got %d, %d, %d and %d\n", len(valid), outside, zeros, missing)
+	}
+}
+
+func TestPoolCrMinN(t *testing.T) {
+	profile := make([]profiling.Pos, 20)
+	for i := range profile {
+		profile[i].Type = profiling.FourFold
+	}
+	profiles := contigProfiles{"contig1": profile}
+
+	// two chunks of 20 consecutive sites (1-based), with 20 - l pairs at lag l.
+	rng := rand.New(rand.NewSource(1))
+	var piChuncks [][]Pi
+	for k := 0; k < 2; k++ {
+		var pis []Pi
+		for i := range profile {
+			pis = append(pis, Pi{Genome: "contig1", Position: i + 1, Pi: rng.Float64()})
+		}
+		piChuncks = append(piChuncks, pis)
+	}
+
+	maxl, minN := 15, 10
+	covMVs := poolCr(piChuncks, profiles, convertPosType(4), maxl, minN, newCovFuncs["pearson"], 1)
+	for l := 0; l < maxl; l++ {
+		expected := 0
+		if 20-l > minN {
+			expected = 2
+		}
+		if n := covMVs[l].Mean.GetN(); n != expected {
+			t.Errorf("lag %d, Expect %d chunks, got %d\n", l, expected, n)
+		}
+	}
+}
+
+// TestSpearman checks the Spearman correlation against known values.
+func TestSpearman(t *testing.T) {
+	testCases := []struct {
+		name     string
+		xs, ys   []float64
+		expected float64
+	}{
+		// 1 - 6 * sum(d^2) / (n * (n^2 - 1)), without ties.
+		{"no ties", []float64{1, 2, 3, 4, 5}, []float64{2, 1, 4, 3, 5}, 0.8},
+		// ranks of y are 1, 2, 3.5, 5, 3.5.
+		{"ties", []float64{1, 2, 3, 4, 5}, []float64{5, 6, 7, 8, 7}, 0.8207826816681233},
+		{"monotone", []float64{0.1, 0.2, 0.3, 0.4}, []float64{0.001, 0.008, 0.027, 0.064}, 1},
+		{"reversed", []float64{0.3, 0.1, 0.2}, []float64{1, 3, 2}, -1},
+		{"too few", []float64{1}, []float64{1}, math.NaN()},
+		{"all tied", []float64{1, 2, 3}, []float64{1, 1, 1}, math.NaN()},
+	}
+	for _, tc := range testCases {
+		cov := newCovFuncs["spearman"]()
+		for i := range tc.xs {
+			cov.Increment(tc.xs[i], tc.ys[i])
+		}
+		got := cov.GetResult()
+		if math.IsNaN(tc.expected) != math.IsNaN(got) || math.Abs(got-tc.expected) > 1e-12 {
+			t.Errorf("%s, Expect %g, got %g\n", tc.name, tc.expected, got)
+		}
+		if cov.GetN() != len(tc.xs) {
+			t.Errorf("%s, Expect n %d, got %d\n", tc.name, len(tc.xs), cov.GetN())
+		}
+	}
+
+	// with CalcCr, at lag 1: pairs (1, 2), (2, 4), (4, 3) and (3, 5).
+	profile := make([]profiling.Pos, 5)
+	for i := range profile {
+		profile[i].Type = profiling.FourFold
+	}
+	profiles := contigProfiles{"contig1": profile}
+	var pis []Pi
+	for i, pi := range []float64{1, 2, 4, 3, 5} {
+		pis = append(pis, Pi{Genome: "contig1", Position: i + 1, Pi: pi})
+	}
+	covs := CalcCr(pis, profiles, convertPosType(4), 2, newCovFuncs["spearman"])
+	if got := covs[1].GetResult(); math.Abs(got-0.4) > 1e-12 {
+		t.Errorf("CalcCr, Expect 0.4 at lag 1, got %g\n", got)
+	}
+}
+
+// TestManifest checks that the genomes of a manifest, processed at a time,
+// give the outputs of processing each of them alone.
+func TestManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc_cr2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// two genomes of a gene of GCT codons, with pis at their four-fold sites,
+	// for 1000 chunks of 30 sites.
+	rng := rand.New(rand.NewSource(1))
+	numCodons := 30000
+	var manifest string
+	var entries []entry
+	for _, name := range []string{"g1", "g2"} {
+		genomeFile := write(name+".fna", ">"+name+"\n"+strings.Repeat("GCT", numCodons)+"\n")
+		gffFile := write(name+".gff", fmt.Sprintf("%s\ttest\tCDS\t1\t%d\t.\t+\t0\tID=%s_1\n", name, 3*numCodons, name))
+		var pis bytes.Buffer
+		for i := 0; i < numCodons; i++ {
+			fmt.Fprintf(&pis, "{\"Genome\":\"%s\",\"Position\":%d,\"Pi\":%g}\n", name, 3*i+3, rng.Float64())
+		}
+		piFile := write(name+".json", pis.String())
+		e := entry{Pi: piFile, Genome: genomeFile, Gff: gffFile, Out: filepath.Join(dir, name+".cr.txt")}
+		manifest += strings.Join([]string{e.Pi, e.Genome, e.Gff, e.Out}, "\t") + "\n"
+		entries = append(entries, e)
+	}
+	manifestFile := write("manifest.txt", "# pi\tgenome\tgff\tout\n"+manifest+"\n")
+
+	opts := crOptions{
+		codonTable: taxonomy.GeneticCodes()["11"],
+		posType:    convertPosType(4),
+		maxl:       10,
+		minN:       10,
+		emptyBins:  "nan",
+		newCov:     newCovFuncs["pearson"],
+	}
+	got, err := readManifest(manifestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(entries) || got[0] != entries[0] || got[1] != entries[1] {
+		t.Fatalf("Expect entries %v, got %v\n", entries, got)
+	}
+	runManifest(got, 2, func(e entry) { calcGenome(e, opts) })
+
+	var outputs []string
+	for _, e := range entries {
+		data, err := ioutil.ReadFile(e.Out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		alone := e
+		alone.Out += ".alone"
+		calcGenome(alone, opts)
+		expected, err := ioutil.ReadFile(alone.Out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != string(expected) {
+			t.Errorf("%s, Expect %s, got %s\n", e.Genome, expected, data)
+		}
+		// lags 0, 3, 6 and 9 have pairs.
+		if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != opts.maxl || strings.Contains(lines[3], "NaN") {
+			t.Errorf("%s, Expect %d lags with a value at lag 3, got %s\n", e.Genome, opts.maxl, data)
+		}
+		outputs = append(outputs, string(data))
+	}
+	if outputs[0] == outputs[1] {
+		t.Errorf("Expect different outputs for the two genomes, got %s\n", outputs[0])
+	}
+
+	if _, err := readManifest(write("bad.txt", "a.json\tg.fna\tg.gff\n")); err == nil {
+		t.Errorf("Expect an error for a line of 3 files\n")
+	}
+}
+
+// TestPoolPiFile checks that the pis read as a stream give the results
+// of the pis loaded in memory.
+func TestPoolPiFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc_cr2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// two contigs of every other site four-fold, with pis at most sites,
+	// pis outside the contigs, and pis on a contig without profile.
+	rng := rand.New(rand.NewSource(1))
+	profiles := make(contigProfiles)
+	var pis bytes.Buffer
+	for _, name := range []string{"contig1", "contig2"} {
+		profile := make([]profiling.Pos, 20000)
+		for i := range profile {
+			if i%2 == 0 {
+				profile[i].Type = profiling.FourFold
+			} else {
+				profile[i].Type = profiling.FirstPos
+			}
+		}
+		profiles[name] = profile
+		for position := 0; position <= len(profile)+1; position++ {
+			if rng.Float64() < 0.2 {
+				continue
+			}
+			fmt.Fprintf(&pis, "{\"Genome\":\"%s\",\"Position\":%d,\"Pi\":%g}\n", name, position, rng.Float64())
+		}
+		fmt.Fprintf(&pis, "{\"Genome\":\"plasmid\",\"Position\":%d,\"Pi\":0.5}\n", rng.Intn(100))
+	}
+	piFile := filepath.Join(dir, "pi.json")
+	if err := ioutil.WriteFile(piFile, pis.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, corr := range []string{"pearson", "spearman"} {
+		maxl, minN := 30, 5
+		posType := convertPosType(4)
+		piArr, outside, zeros, missing := checkPis(readPi(piFile), profiles)
+		if missing != 2 {
+			t.Errorf("%s, Expect 2 pis on a contig without profile, got %d\n", corr, missing)
+		}
+		expected := poolCr(chunkPis(piArr), profiles, posType, maxl, minN, newCovFuncs[corr], 1)
+		// streamed, and in go routines.
+		for _, ncpu := range []int{1, 4} {
+			got, gotOutside, gotZeros, gotMissing := poolPiFile(piFile, profiles, posType, maxl, minN, newCovFuncs[corr], ncpu)
+			if gotOutside != outside || gotZeros != zeros || gotMissing != missing {
+				t.Errorf("%s, ncpu %d, Expect %d outside, %d at 0 and %d missing, got %d, %d and %d\n", corr, ncpu, outside, zeros, missing, gotOutside, gotZeros, gotMissing)
+			}
+			for l := 0; l < maxl; l++ {
+				e, g := expected[l], got[l]
+				if e.Mean.GetN() != g.Mean.GetN() || fmt.Sprint(e.Mean.GetResult(), e.Var.GetResult()) != fmt.Sprint(g.Mean.GetResult(), g.Var.GetResult()) {
+					t.Errorf("%s, ncpu %d, lag %d, Expect %g, %g (n %d), got %g, %g (n %d)\n", corr, ncpu, l,
+						e.Mean.GetResult(), e.Var.GetResult(), e.Mean.GetN(), g.Mean.GetResult(), g.Var.GetResult(), g.Mean.GetN())
+				}
+			}
+		}
+		// lags of four-fold sites with pairs in the chunks of about 32 pis.
+		if n := expected[2].Mean.GetN(); n == 0 || expected[1].Mean.GetN() != 0 {
+			t.Errorf("%s, Expect chunks at lag 2 and none at lag 1, got %d and %d\n", corr, n, expected[1].Mean.GetN())
+		}
+	}
+}
+
+// randomChunks returns a profile of four-fold sites, and numChunks chunks
+// of consecutive pis on it.
+func randomChunks(numChunks, lenChunck int) (contigProfiles, [][]Pi) {
+	profile := make([]profiling.Pos, numChunks*lenChunck)
+	for i := range profile {
+		profile[i].Type = profiling.FourFold
+	}
+	rng := rand.New(rand.NewSource(1))
+	var pis []Pi
+	for i := range profile {
+		if rng.Float64() < 0.8 {
+			pis = append(pis, Pi{Genome: "contig1", Position: i + 1, Pi: rng.Float64()})
+		}
+	}
+	var piChuncks [][]Pi
+	for i := 0; i < numChunks; i++ {
+		piChuncks = append(piChuncks, pis[i*len(pis)/numChunks:(i+1)*len(pis)/numChunks])
+	}
+	return contigProfiles{"contig1": profile}, piChuncks
+}
+
+// TestPoolCrParallel checks that the chunks calculated in go routines
+// give the results of the chunks calculated one after another.
+func TestPoolCrParallel(t *testing.T) {
+	profiles, piChuncks := randomChunks(50, 200)
+	maxl, minN := 30, 10
+	for _, corr := range []string{"pearson", "spearman"} {
+		expected := poolCr(piChuncks, profiles, convertPosType(4), maxl, minN, newCovFuncs[corr], 1)
+		for _, ncpu := range []int{2, 8} {
+			got := poolCr(piChuncks, profiles, convertPosType(4), maxl, minN, newCovFuncs[corr], ncpu)
+			for l := 0; l < maxl; l++ {
+				e, g := expected[l], got[l]
+				if e.Mean.GetN() != g.Mean.GetN() || fmt.Sprint(e.Mean.GetResult(), e.Var.GetResult()) != fmt.Sprint(g.Mean.GetResult(), g.Var.GetResult()) {
+					t.Errorf("%s, ncpu %d, lag %d, Expect %g, %g (n %d), got %g, %g (n %d)\n", corr, ncpu, l,
+						e.Mean.GetResult(), e.Var.GetResult(), e.Mean.GetN(), g.Mean.GetResult(), g.Var.GetResult(), g.Mean.GetN())
+				}
+			}
+		}
+		if n := expected[1].Mean.GetN(); n != 50 {
+			t.Errorf("%s, Expect 50 chunks at lag 1, got %d\n", corr, n)
+		}
+	}
+}
+
+func BenchmarkPoolCr(b *testing.B) {
+	profiles, piChuncks := randomChunks(100, 2000)
+	for _, ncpu := range []int{1, 2, 4} {
+		b.Run(fmt.Sprintf("ncpu=%d", ncpu), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				poolCr(piChuncks, profiles, convertPosType(4), 100, 10, newCovFuncs["pearson"], ncpu)
+			}
+		})
+	}
+}
+
+func TestCheckGffs(t *testing.T) {
+	genome := []byte("ATGAAATAG")
+	tests := []struct {
+		start, end int
+		valid      bool
+	}{
+		{1, 9, true},
+		{4, 4, true},
+		{0, 9, false},
+		{1, 10, false},
+		{10, 12, false},
+		{7, 3, false},
+	}
+	for _, test := range tests {
+		gffs := []*gff.Record{{SeqName: "chr", Feature: "CDS", Start: test.start, End: test.end, Strand: gff.ForwardStrand, Frame: "0"}}
+		if err := checkGffs(genome, gffs); (err == nil) != test.valid {
+			t.Errorf("CDS %d..%d, Expect valid %v, got error %v\n", test.start, test.end, test.valid, err)
+		}
+	}
+}
+
+func TestCheckSequenceRegions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc_cr2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		header  string
+		contigs []*seq.Sequence
+		valid   bool
+	}{
+		{"", []*seq.Sequence{{Id: "chr", Seq: []byte("ATGAAATAG")}}, true},
+		{"##sequence-region chr 1 9\n", []*seq.Sequence{{Id: "chr", Seq: []byte("ATGAAATAG")}}, true},
+		{"##sequence-region chr 1 12\n", []*seq.Sequence{{Id: "chr", Seq: []byte("ATGAAATAG")}}, false},
+		{"##sequence-region NC_1 1 12\n", []*seq.Sequence{{Id: "chr", Seq: []byte("ATGAAATAG")}}, false},
+		{"##sequence-region chr 1 9\n##sequence-region plasmid 1 4\n", []*seq.Sequence{{Id: "chr", Seq: []byte("ATGAAATAG")}, {Id: "plasmid", Seq: []byte("ATGC")}}, true},
+		{"##sequence-region chr 1 9\n##sequence-region plasmid 1 5\n", []*seq.Sequence{{Id: "chr", Seq: []byte("ATGAAATAG")}, {Id: "plasmid", Seq: []byte("ATGC")}}, false},
+		{"##sequence-region chr 1 9\n##sequence-region other 1 5\n", []*seq.Sequence{{Id: "chr", Seq: []byte("ATGAAATAG")}}, true},
+	}
+	for i, test := range tests {
+		gffFile := filepath.Join(dir, fmt.Sprintf("%d.gff", i))
+		text := "##gff-version 3\n" + test.header + "chr\tRefSeq\tCDS\t1\t9\t.\t+\t0\tID=cds0\n"
+		if err := ioutil.WriteFile(gffFile, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		regions, err := readSequenceRegions(gffFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkSequenceRegions(test.contigs, regions); (err == nil) != test.valid {
+			t.Errorf("header %q, Expect valid %v, got error %v\n", test.header, test.valid, err)
+		}
+	}
+
+	for i, header := range []string{"##sequence-region chr 1\n", "##sequence-region chr a 9\n", "##sequence-region chr 9 1\n"} {
+		gffFile := filepath.Join(dir, fmt.Sprintf("bad%d.gff", i))
+		if err := ioutil.WriteFile(gffFile, []byte("##gff-version 3\n"+header), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readSequenceRegions(gffFile); err == nil {
+			t.Errorf("header %q, Expect an error, got none\n", header)
+		}
+	}
+}
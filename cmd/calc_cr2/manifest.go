@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// entry is a genome to process: its pi, genome and gff files,
+// and the file the covariances are written to.
+type entry struct {
+	Pi, Genome, Gff, Out string
+}
+
+// readManifest reads the entries of a manifest file,
+// one per line, with the pi, genome, gff and out files separated by tabs,
+// as the arguments of a single genome. Empty lines and lines starting
+// with # are skipped.
+func readManifest(filename string) (entries []entry, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	outs := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("%s, line %d: expect 4 tab-separated files (pi, genome, gff and out), got %d", filename, lineNum, len(fields))
+		}
+		e := entry{Pi: fields[0], Genome: fields[1], Gff: fields[2], Out: fields[3]}
+		if prev, found := outs[e.Out]; found {
+			return nil, fmt.Errorf("%s, line %d: out file %s is also on line %d", filename, lineNum, e.Out, prev)
+		}
+		outs[e.Out] = lineNum
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no entry in %s", filename)
+	}
+	return entries, nil
+}
+
+// runManifest calls calc on each entry, in ncpu go routines.
+func runManifest(entries []entry, ncpu int, calc func(e entry)) {
+	if ncpu < 1 {
+		ncpu = 1
+	}
+	entryChan := make(chan entry)
+	var wg sync.WaitGroup
+	for i := 0; i < ncpu; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range entryChan {
+				calc(e)
+			}
+		}()
+	}
+	for _, e := range entries {
+		entryChan <- e
+	}
+	close(entryChan)
+	wg.Wait()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"math"
+
+	"github.com/mingzhi/gomath/stat/desc/meanvar"
+)
+
+// crStream calculates the covariances of CalcCr, with the pis given one
+// at a time to Add, in the same order. It holds the pis that can still be
+// paired with the next ones: those of the contig of the last pi,
+// less than maxl from it, so at most maxl pis of sorted positions.
+type crStream struct {
+	profiles contigProfiles
+	posType  byte
+	maxl     int
+	corrs    []Covariance
+	pis      []Pi // pis to pair with the next ones.
+}
+
+func newCrStream(profiles contigProfiles, posType byte, maxl int, newCov func() Covariance) *crStream {
+	s := &crStream{profiles: profiles, posType: posType, maxl: maxl}
+	s.corrs = make([]Covariance, maxl)
+	for i := 0; i < maxl; i++ {
+		s.corrs[i] = newCov()
+	}
+	return s
+}
+
+// Add pairs the pi with the previous pis,
+// as CalcCr does for each of them, in the order of the previous pis,
+// so that the pairs at a lag are added in the order of CalcCr.
+func (s *crStream) Add(pi Pi) {
+	profile := s.profiles.get(pi.Genome)
+	kept := s.pis[:0]
+	for _, prev := range s.pis {
+		if pi.Genome != prev.Genome {
+			// as CalcCr, prev is not paired with the pis of another contig.
+			continue
+		}
+		if !inProfile(profile, pi.Position) {
+			kept = append(kept, prev)
+			continue
+		}
+		distance := pi.Position - prev.Position
+		if distance >= s.maxl {
+			continue
+		}
+		if checkPosType(s.posType, profile[pi.Position-1].Type) {
+			s.corrs[distance].Increment(prev.Pi, pi.Pi)
+		}
+		kept = append(kept, prev)
+	}
+	s.pis = kept
+
+	// the pi is paired with itself, and kept if it is of posType.
+	if profile == nil || !inProfile(profile, pi.Position) || !checkPosType(s.posType, profile[pi.Position-1].Type) {
+		return
+	}
+	s.corrs[0].Increment(pi.Pi, pi.Pi)
+	s.pis = append(s.pis, pi)
+}
+
+// Covs returns the covariances at each lag.
+func (s *crStream) Covs() []Covariance {
+	return s.corrs
+}
+
+// poolPiFile is poolCr on the chunkPis chunks of the pis of a file
+// in the profiles, but reads the file as a stream, holding at most maxl pis
+// (if they are sorted by position within each contig).
+// The file is read twice, first to count the pis in the profiles,
+// and the others, outside, of which zeros are at position 0,
+// and missing, on contigs without a profile (see checkPis).
+// With ncpu > 1, the chunks are calculated in ncpu go routines (see poolChunks),
+// holding the pis of the chunks being calculated instead.
+func poolPiFile(filename string, profiles contigProfiles, posType byte, maxl, minN int, newCov func() Covariance, ncpu int) (covMVs []*meanvar.MeanVar, outside, zeros, missing int) {
+	numPis := 0
+	scanPis(filename, func(pi Pi) {
+		if profiles.get(pi.Genome) == nil {
+			missing++
+		}
+		if outsideProfile(pi, profiles) {
+			outside++
+			if pi.Position == 0 {
+				zeros++
+			}
+			return
+		}
+		numPis++
+	})
+
+	covMVs = make([]*meanvar.MeanVar, maxl)
+	for i := range covMVs {
+		covMVs[i] = meanvar.New()
+	}
+	lenChunck := numPis / numChunks
+	if lenChunck == 0 {
+		return
+	}
+	if ncpu > 1 {
+		covMVs = poolChunks(scanChunks(filename, profiles, lenChunck), profiles, posType, maxl, minN, newCov, ncpu)
+		return
+	}
+	pool := func(s *crStream) {
+		for i, cov := range s.Covs() {
+			n := cov.GetN()
+			v := cov.GetResult()
+			if n > minN && !math.IsNaN(v) {
+				covMVs[i].Increment(v)
+			}
+		}
+	}
+
+	k := 0 // index of the pi in the profiles.
+	var s *crStream
+	scanPis(filename, func(pi Pi) {
+		if outsideProfile(pi, profiles) || k >= numChunks*lenChunck {
+			return
+		}
+		if k%lenChunck == 0 {
+			if s != nil {
+				pool(s)
+			}
+			s = newCrStream(profiles, posType, maxl, newCov)
+		}
+		s.Add(pi)
+		k++
+	})
+	pool(s)
+	return
+}
+
+// scanChunks reads the pis of a file in the profiles, and sends them
+// in numChunks chunks of lenChunck pis; the remaining pis are not used.
+func scanChunks(filename string, profiles contigProfiles, lenChunck int) <-chan []Pi {
+	chunks := make(chan []Pi)
+	go func() {
+		defer close(chunks)
+		var pis []Pi
+		k := 0 // number of chunks sent.
+		scanPis(filename, func(pi Pi) {
+			if outsideProfile(pi, profiles) || k >= numChunks {
+				return
+			}
+			pis = append(pis, pi)
+			if len(pis) == lenChunck {
+				chunks <- pis
+				pis = nil
+				k++
+			}
+		})
+	}()
+	return chunks
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/mingzhi/gomath/stat/correlation"
+)
+
+// newCovFuncs are the constructors of the Covariance of each -corr option:
+// pearson for the covariance of the values, and spearman for the (Spearman)
+// correlation of their ranks.
+var newCovFuncs = map[string]func() Covariance{
+	"pearson":  func() Covariance { return correlation.NewBivariateCovariance(false) },
+	"spearman": func() Covariance { return &spearman{} },
+}
+
+// spearman is a Covariance whose result is the Spearman correlation,
+// the correlation of the ranks of x and y, more robust than the covariance
+// to skewed values. Ranks are only known once all values are seen,
+// so the pairs are buffered, and ranked at each GetResult.
+// Tied values have their average rank.
+type spearman struct {
+	xs, ys []float64
+}
+
+func (s *spearman) Increment(x, y float64) {
+	s.xs = append(s.xs, x)
+	s.ys = append(s.ys, y)
+}
+
+func (s *spearman) GetN() int {
+	return len(s.xs)
+}
+
+// MeanX returns the mean of the x values (not of their ranks).
+func (s *spearman) MeanX() float64 {
+	return mean(s.xs)
+}
+
+// MeanY returns the mean of the y values (not of their ranks).
+func (s *spearman) MeanY() float64 {
+	return mean(s.ys)
+}
+
+// GetResult returns the Spearman correlation,
+// NaN with less than 2 pairs, or if all values of x or y are tied.
+func (s *spearman) GetResult() float64 {
+	if len(s.xs) < 2 {
+		return math.NaN()
+	}
+	rx, ry := ranks(s.xs), ranks(s.ys)
+	mx, my := mean(rx), mean(ry)
+	var sxy, sxx, syy float64
+	for i := range rx {
+		dx, dy := rx[i]-mx, ry[i]-my
+		sxy += dx * dy
+		sxx += dx * dx
+		syy += dy * dy
+	}
+	if sxx == 0 || syy == 0 {
+		return math.NaN()
+	}
+	return sxy / math.Sqrt(sxx*syy)
+}
+
+// ranks returns the ranks (from 1) of the values, the average rank for ties.
+func ranks(values []float64) []float64 {
+	indices := make([]int, len(values))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.Slice(indices, func(a, b int) bool { return values[indices[a]] < values[indices[b]] })
+
+	r := make([]float64, len(values))
+	for i := 0; i < len(indices); {
+		j := i + 1
+		for j < len(indices) && values[indices[j]] == values[indices[i]] {
+			j++
+		}
+		// positions i to j-1 are tied, with ranks i+1 to j.
+		rank := float64(i+1+j) / 2
+		for k := i; k < j; k++ {
+			r[indices[k]] = rank
+		}
+		i = j
+	}
+	return r
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -2,62 +2,120 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
-	"github.com/biogo/hts/bam"
 	"github.com/biogo/hts/sam"
 	"github.com/mingzhi/biogo/feat/gff"
 	"github.com/mingzhi/biogo/seq"
-	"github.com/mingzhi/gomath/stat/correlation"
 	"github.com/mingzhi/gomath/stat/desc/meanvar"
+	"github.com/mingzhi/meta"
+	"github.com/mingzhi/meta/genome"
+	"github.com/mingzhi/meta/p2"
+	"github.com/mingzhi/meta/reads"
 	"github.com/mingzhi/ncbiftp/genomes/profiling"
 	"github.com/mingzhi/ncbiftp/taxonomy"
 	"io"
 	"log"
 	"math"
 	"os"
+	"os/exec"
+	"os/signal"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
-// MappedRead contains the section of a read mapped to a reference genome.
-type MappedRead struct {
-	Pos  int
-	Seq  []byte
-	Qual []byte
-}
-
-type SubProfile struct {
-	Pos     int
-	Profile []float64
-}
-
-func (m MappedRead) Len() int {
-	return len(m.Seq)
-}
-
-var MINBQ int
-var MINMQ int
-var SAMPLES int
-
 func main() {
 	// Command variables.
-	var bamFile string      // bam or sam file
-	var genomeFile string   // genome file
-	var gffFile string      // gff file
-	var outFile string      // output file
-	var maxl int            // max length of correlation
-	var pos int             // position for calculation
-	var codonTableID string // codon table ID
-	var ncpu int            // number of CPUs
+	var bamFile string       // bam or sam file
+	var genomeFile string    // genome file
+	var gffFile string       // gff file
+	var outFile string       // output file
+	var maxl int             // max length of correlation
+	var outMaxl int          // max length of correlation written to the output
+	var emptyBins string     // how to write lags without data
+	var minPairs int         // min count (n) of a written lag
+	var overlapFile string   // file for dumping read overlaps
+	var componentFile string // file for dumping covariance components
+	var mapq255 string       // how to handle MapQ 255
+	var reference string     // reference fasta file for cram
+	var excludeBed string    // bed file of excluded regions
+	var readGroups string    // read groups of the used reads
+	var sample string        // sample of the used reads
+	var bamFile2 string      // bam file of a second sample
+	var pos int              // position for calculation
+	var codonTableID string  // codon table ID
+	var classify string      // substitution classes
+	var level string         // level of substitutions
+	var compare string       // comparator of bases
+	var ncpu int             // number of CPUs
+	var perReference bool    // keep the results of each reference separate
+	var stream bool          // write each reference once it is done
+	var assumeSorted bool    // skip checking the sort order in the header
+	var autoMaxl bool        // set maxl to the longest read
+	var skipLowercase bool   // leave out soft-masked genome positions
+	var logLevel string      // log level
+	var quiet bool           // only log errors
+	var insertBins string    // bins of insert sizes
+	var opts p2.Options      // options of the calculation
 	// Parse command arguments.
 	flag.IntVar(&maxl, "maxl", 100, "max length of correlations")
+	flag.BoolVar(&autoMaxl, "auto-maxl", false, "set maxl to the longest reference span of the first reads, capped by -maxl if it is given")
+	flag.IntVar(&outMaxl, "output-maxl", 0, "max length of correlations written to the output file (0 for maxl)")
+	flag.IntVar(&opts.LagStep, "lag-step", 1, "use and write only the lags 0, lag-step, 2*lag-step, ...")
+	flag.BoolVar(&opts.LogLags, "log-lags", false, "pool the lags in geometric bins 0, 1, [2, 4), [4, 8), ..., written at their first lag")
 	flag.IntVar(&pos, "pos", 4, "position")
 	flag.StringVar(&codonTableID, "codon", "11", "codon table ID")
+	flag.StringVar(&classify, "classify", "all", "substitutions to correlate: all, syn, nonsyn, or both (written with a type column)")
+	flag.StringVar(&compare, "compare", "bases", "substitutions between bases: bases (all), or transitions (only, leaving out transversions)")
+	flag.BoolVar(&opts.Stranded, "stranded", false, "compare only reads on the same strand, for stranded protocols, with a profile for each (written with a type column, e.g. all_forward and all_reverse)")
+	flag.BoolVar(&opts.TsTv, "ts-tv", false, "correlate transitions and transversions separately (written with a ts or tv type column; requires -classify all, -compare bases and -level nuc)")
+	flag.StringVar(&level, "level", "nuc", "level of substitutions: nuc, or aa for amino acids with lags in codons (ignores -pos, and requires -classify all)")
 	flag.IntVar(&ncpu, "ncpu", runtime.NumCPU(), "number of CPU for using")
-	flag.IntVar(&MINBQ, "min-bq", 13, "min base quality")
-	flag.IntVar(&MINMQ, "min-mq", 0, "min map quality")
-	flag.IntVar(&SAMPLES, "samples", 100, "number of samples")
+	flag.BoolVar(&perReference, "per-reference", false, "calculate each reference separately, using the genome sequence of the same name, and write it in a first (reference) column")
+	flag.BoolVar(&stream, "stream", false, "with -per-reference, write each reference as soon as its reads are done, in the order of the bam file, rather than holding the results of all references (sorted by name) until the end; -max-pairs is not supported")
+	flag.StringVar(&emptyBins, "empty-bins", "nan", "how to write lags without data: omit, nan or zero")
+	flag.IntVar(&minPairs, "min-pairs", 1, "min count of a lag (the n column, samples of read pairs with data); lags with less are written as -empty-bins")
+	flag.StringVar(&overlapFile, "dump-overlaps", "", "file for dumping reads and compared read pairs")
+	flag.StringVar(&componentFile, "dump-components", "", "json file for dumping the n, means and co-moment of the covariance at each lag of each sample (and reference)")
+	flag.StringVar(&mapq255, "mapq255", "exclude", "how to handle MapQ 255 (not available): exclude, include, or a MapQ value to treat it as")
+	flag.IntVar(&opts.MinBQ, "min-bq", 13, "min base quality")
+	flag.IntVar(&opts.QualOffset, "qual-offset", 0, "offset subtracted from base qualities before checking min-bq, e.g. 33 if they keep the ASCII offset")
+	flag.IntVar(&opts.DefaultQual, "default-qual", 0, "quality (Phred score) of the bases of reads without base qualities (QUAL *); 0 discards those reads")
+	flag.IntVar(&opts.MinReadLen, "min-readlen", 0, "min mapped length of a read, without deletions")
+	flag.IntVar(&opts.MaxSoftClip, "max-softclip", -1, "max number of soft-clipped bases of a read (-1 for no limit, 0 for none)")
+	flag.BoolVar(&opts.KeepSecondary, "keep-secondary", false, "use secondary and supplementary alignments (by their SAM flags), which are otherwise excluded with unmapped reads")
+	flag.BoolVar(&opts.KeepDuplicates, "keep-duplicates", false, "use reads flagged as duplicates")
+	flag.IntVar(&opts.MinMQ, "min-mq", 0, "min map quality; reads with MapQ > min-mq and <= max-mq are used")
+	flag.IntVar(&opts.MaxMQ, "max-mq", 60, "max map quality (0 for no limit); MapQ 255 is handled by -mapq255")
+	flag.IntVar(&opts.Samples, "samples", 100, "number of samples")
+	flag.BoolVar(&opts.Paired, "paired", false, "merge overlapping mates of read pairs")
+	flag.BoolVar(&opts.DedupeMates, "dedupe-mates", false, "do not compare the mates of read pairs (reads with the same name)")
+	flag.IntVar(&opts.MinOverlap, "min-overlap", 0, "min number of reference positions covered by both reads of a compared pair")
+	flag.StringVar(&insertBins, "insert-bins", "", "comma-separated bins of insert sizes (|TLEN|), e.g. 0-200,200-400, comparing only the reads of fragments in the same bin, with a profile for each (written with a type and a bin column); reads in none of the bins are not used")
+	flag.StringVar(&reference, "reference", "", "reference fasta file for decoding a cram file")
+	flag.StringVar(&bamFile2, "bam2", "", "bam file of a second sample, for the correlation of substitutions between the two samples (requires -classify all and -level nuc)")
+	flag.BoolVar(&skipLowercase, "skip-lowercase", false, "leave out the positions of soft-masked (lower case) genome bases; otherwise they are used as upper case")
+	flag.StringVar(&readGroups, "read-group", "", "comma-separated read groups (RG tag) whose reads are used; reads without an RG tag are then not used")
+	flag.StringVar(&sample, "sample", "", "sample (SM of the @RG header lines) whose read groups are used, with those of -read-group")
+	flag.StringVar(&excludeBed, "exclude-bed", "", "bed file of regions (e.g. repeats) whose positions are excluded")
+	flag.BoolVar(&assumeSorted, "assume-sorted", false, "assume the reads are sorted by coordinate, even if the header does not say so")
+	flag.Int64Var(&opts.MaxPairs, "max-pairs", 0, "stop after comparing this many read pairs (0 for no limit)")
+	flag.IntVar(&opts.MaxPileup, "max-pileup", 0, "max number of overlapping reads held in memory and compared with a read, reservoir sampled (reproducibly) in regions of higher coverage (0 for no limit, otherwise at least 2)")
+	flag.IntVar(&opts.Window, "window", 0, "keep the reads starting within this many bases of a read in its window, even if they do not overlap it (0 for the read length); pairs without a common position are skipped, so lags from the read length on stay empty")
+	flag.IntVar(&opts.MDWindow, "md-window", 0, "mask mismatches within this many bases of another mismatch, using the MD tag (0 for no masking)")
+	flag.StringVar(&logLevel, "log-level", "info", "log level: debug, info, warn or error")
+	flag.BoolVar(&quiet, "quiet", false, "only log errors")
 	flag.Parse()
+	minLevel, err := meta.ParseLevel(logLevel)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	meta.SetLevel(minLevel)
+	meta.SetQuiet(quiet)
 	// Print usage if the number of arguments is not satisfied.
 	if flag.NArg() < 4 {
 		log.Fatalln("Usage: go run calc_cr.go <pi file> <genome file> <gff file> <out file>")
@@ -66,238 +124,417 @@ func main() {
 	genomeFile = flag.Arg(1)
 	gffFile = flag.Arg(2)
 	outFile = flag.Arg(3)
+	if outMaxl == 0 {
+		outMaxl = maxl
+	}
+	if outMaxl < 0 || outMaxl > maxl {
+		log.Fatalf("output-maxl (%d) should be between 0 and maxl (%d)\n", outMaxl, maxl)
+	}
+	if strings.HasSuffix(bamFile, ".cram") && reference == "" {
+		log.Fatalf("a reference fasta file (-reference) is required for reading cram file %s\n", bamFile)
+	}
+	if opts.MaxMQ > 0 && opts.MaxMQ <= opts.MinMQ {
+		log.Fatalf("max-mq (%d) should be greater than min-mq (%d)\n", opts.MaxMQ, opts.MinMQ)
+	}
+	if classify != p2.All && classify != p2.Syn && classify != p2.NonSyn && classify != "both" {
+		log.Fatalf("classify should be all, syn, nonsyn or both, got %s\n", classify)
+	}
+	opts.Classify = classify
+	if level != "nuc" && level != p2.AminoAcid {
+		log.Fatalf("level should be nuc or aa, got %s\n", level)
+	}
+	if level == p2.AminoAcid && classify != p2.All {
+		log.Fatalf("classify should be all at the aa level, got %s\n", classify)
+	}
+	opts.Level = level
+	comparator, found := p2.Comparators[compare]
+	if !found {
+		log.Fatalf("compare should be bases or transitions, got %s\n", compare)
+	}
+	opts.Compare = comparator
+	if opts.TsTv && (classify != p2.All || compare != "bases" || level != "nuc") {
+		log.Fatalf("ts-tv requires -classify all, -compare bases and -level nuc, got %s, %s and %s\n", classify, compare, level)
+	}
+	if insertBins != "" {
+		bins, err := p2.ParseInsertBins(insertBins)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		opts.InsertBins = bins
+	}
+	if bamFile2 != "" && (classify != p2.All || level != "nuc" || perReference || autoMaxl || opts.TsTv || opts.Stranded || len(opts.InsertBins) > 0) {
+		log.Fatalf("bam2 does not support -classify %s, -level %s, -per-reference, -auto-maxl, -ts-tv, -stranded or -insert-bins\n", classify, level)
+	}
+	if stream && (!perReference || opts.MaxPairs > 0) {
+		log.Fatalln("stream requires -per-reference, and does not support -max-pairs")
+	}
+	if emptyBins != "omit" && emptyBins != "nan" && emptyBins != "zero" {
+		log.Fatalf("empty-bins should be omit, nan or zero, got %s\n", emptyBins)
+	}
+	if opts.DefaultQual < 0 || opts.DefaultQual > 93 {
+		log.Fatalf("default-qual should be in [0, 93], got %d\n", opts.DefaultQual)
+	}
+	if opts.MaxPileup == 1 || opts.MaxPileup < 0 {
+		log.Fatalf("max-pileup should be 0 or at least 2, got %d\n", opts.MaxPileup)
+	}
+	if opts.Window < 0 {
+		log.Fatalf("window should be at least 0, got %d\n", opts.Window)
+	}
+	if opts.LagStep < 1 {
+		log.Fatalf("lag-step should be at least 1, got %d\n", opts.LagStep)
+	}
+	if opts.LogLags && opts.LagStep > 1 {
+		log.Fatalln("log-lags and lag-step can not be used together")
+	}
+	if opts.MinOverlap < 0 {
+		log.Fatalf("min-overlap should not be negative, got %d\n", opts.MinOverlap)
+	}
+	if minPairs < 1 {
+		log.Fatalf("min-pairs should be at least 1, got %d\n", minPairs)
+	}
+	opts.MapQ255 = mapq255
+	if mapq255 != "exclude" && mapq255 != "include" {
+		v, err := strconv.Atoi(mapq255)
+		if err != nil || v < 0 || v > 254 {
+			log.Fatalf("mapq255 should be exclude, include or a MapQ value in [0, 254], got %s\n", mapq255)
+		}
+		opts.MapQ255As = v
+	}
+	if excludeBed != "" {
+		regions, err := p2.ReadBedFile(excludeBed)
+		if err != nil {
+			log.Fatalf("reading %s: %v\n", excludeBed, err)
+		}
+		opts.Exclude = regions
+	}
 	runtime.GOMAXPROCS(ncpu)
 
+	if overlapFile != "" {
+		f, err := os.Create(overlapFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		opts.Overlaps = f
+	}
+	if componentFile != "" {
+		f, err := os.Create(componentFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer f.Close()
+		opts.Components = f
+	}
+
 	// Profile genome.
 	// We need:
 	// 1. genome file;
 	// 2. gene features;
 	// 3. condon table to identify four-fold degenerate sites.
-	genome := readGenome(genomeFile)
+	contigs := readGenome(genomeFile)
+	masked := make(map[string][][2]int)
+	for _, contig := range contigs {
+		masked[contig.Id] = genome.UpperCase(contig.Seq)
+	}
+	if !skipLowercase {
+		masked = nil
+	}
 	gffs := readGff(gffFile)
 	codonTable := taxonomy.GeneticCodes()[codonTableID]
-	profile := profiling.ProfileGenome(genome, gffs, codonTable)
+	opts.GeneticCode = codonTable
 
-	// Read sequence reads.
-	_, readChan := readBamFile(bamFile)
-	subProfileChan := slideReads(readChan)
-	posType := convertPosType(pos)
-	covsChan := calc(subProfileChan, profile, posType, maxl)
-	meanVars := collect(covsChan, maxl)
-	write(meanVars, outFile)
-}
-
-// slideReads
-func slideReads(readChan chan *sam.Record) chan SubProfile {
-	subProfileChan := make(chan SubProfile)
-
-	mappedReadArrChan := make(chan []MappedRead)
+	// Stop the calculation on SIGINT.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
 	go func() {
-		defer close(mappedReadArrChan)
-
-		totalDiscards := 0
-		totalUsed := 0
-		mappedReadArr := []MappedRead{}
-		for r := range readChan {
-			if int(r.MapQ) > MINMQ && int(r.MapQ) < 51 {
-				current := MappedRead{}
-				current.Pos = r.Pos
-				current.Seq, current.Qual = Map2Ref(r)
-				mappedReadArr = append(mappedReadArr, current)
-				if len(mappedReadArr) > 0 {
-					a := mappedReadArr[0]
-					if a.Pos+a.Len() < current.Pos {
-						mappedReadArrChan <- mappedReadArr
-						mappedReadArr = mappedReadArr[1:]
-					}
-				}
-				totalUsed++
-			} else {
-				totalDiscards++
-			}
+		select {
+		case <-sigChan:
+			meta.WARN.Println("Interrupted, stopping...")
+			cancel()
+		case <-ctx.Done():
 		}
-		log.Printf("Total discard reads: %d\n", totalDiscards)
-		log.Printf("Total used reads: %d\n", totalUsed)
 	}()
 
-	ncpu := runtime.GOMAXPROCS(0)
-	done := make(chan bool)
-	for i := 0; i < ncpu; i++ {
-		go func() {
-			for mappedReadArr := range mappedReadArrChan {
-				a := mappedReadArr[0]
-				mappedReadArr = mappedReadArr[1:]
-				for _, b := range mappedReadArr {
-					if b.Pos > a.Len()+a.Pos {
-						break
-					}
-					subProfile := compareMappedReads(a, b)
-					subProfileChan <- subProfile
-				}
+	// Read sequence reads.
+	header, readChan, errc, err := readBamFile(ctx, bamFile, reference)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	readErrs := []chan error{errc}
+	// checkReads stops reading, and exits if a file was not read completely,
+	// as the results of files read partially are not written.
+	checkReads := func(status string) {
+		cancel()
+		for _, errc := range readErrs {
+			if err := <-errc; err != nil {
+				log.Fatalf("%v, %s %s\n", err, outFile, status)
 			}
-			done <- true
-		}()
+		}
 	}
-
-	go func() {
-		defer close(subProfileChan)
-		for i := 0; i < ncpu; i++ {
-			<-done
+	if err := checkSortOrder(header, assumeSorted); err != nil {
+		log.Fatalf("%s: %v\n", bamFile, err)
+	}
+	meta.INFO.Printf("Number of references: %d\n", len(header.Refs()))
+	if readGroups != "" || sample != "" {
+		opts.ReadGroups = make(map[string]bool)
+		for _, id := range strings.Split(readGroups, ",") {
+			if id != "" {
+				opts.ReadGroups[id] = true
+			}
 		}
-	}()
-
-	return subProfileChan
-}
-
-// compareMappedReads compares two MappedReads in their overlapped part,
-// and return a subsitution profile.
-func compareMappedReads(a, b MappedRead) SubProfile {
-	var subs []float64
-	lag := b.Pos - a.Pos
-	for j := 0; j < a.Len()-lag && j < b.Len(); j++ {
-		i := j + lag
-		d := math.NaN()
-		if isATGC(a.Seq[i]) && isATGC(b.Seq[j]) {
-			if int(a.Qual[i]) > MINBQ && int(b.Qual[j]) > MINBQ {
-				if a.Seq[i] != b.Seq[j] {
-					d = 1.0
-				} else {
-					d = 0.0
-				}
+		if sample != "" {
+			ids := p2.SampleReadGroups(header, sample)
+			if len(ids) == 0 {
+				log.Fatalf("%s: no read group of sample %s in the header\n", bamFile, sample)
+			}
+			for _, id := range ids {
+				opts.ReadGroups[id] = true
 			}
 		}
-		subs = append(subs, d)
 	}
-	return SubProfile{Pos: b.Pos, Profile: subs}
-}
-
-func isATGC(b byte) bool {
-	if b == 'A' {
-		return true
-	} else if b == 'T' {
-		return true
-	} else if b == 'C' {
-		return true
-	} else if b == 'G' {
-		return true
+	if autoMaxl {
+		var longest int
+		longest, readChan = sampleMaxl(ctx, readChan, autoMaxlReads)
+		maxlSet := false
+		flag.Visit(func(f *flag.Flag) { maxlSet = maxlSet || f.Name == "maxl" })
+		if longest > 0 && (!maxlSet || longest < maxl) {
+			maxl = longest
+		}
+		if outMaxl > maxl {
+			outMaxl = maxl
+		}
+		meta.INFO.Printf("maxl: %d\n", maxl)
+	}
+	posType := p2.ConvertPosType(pos)
+	var results map[string]map[string][]*meanvar.MeanVar
+	if bamFile2 != "" {
+		// the records are piled up, they do not need to be sorted.
+		_, readChan2, errc2, err := readBamFile(ctx, bamFile2, reference)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		readErrs = append(readErrs, errc2)
+		profiles := profileContigs(contigs, gffs, codonTable)
+		maskProfiles(profiles, masked)
+		results = map[string]map[string][]*meanvar.MeanVar{"": p2.CalcCross(ctx, readChan, readChan2, profiles, posType, maxl, opts)}
+	} else if perReference && stream {
+		profiles := profileContigs(contigs, gffs, codonTable)
+		maskProfiles(profiles, masked)
+		w, err := os.Create(outFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer w.Close()
+		p2.CalcByRefStream(ctx, readChan, profiles, posType, maxl, opts, newStreamWriter(w, p2.Lags(outMaxl, opts), emptyBins, minPairs, opts.InsertBins))
+		if ctx.Err() != nil {
+			log.Fatalf("the calculation was interrupted, %s is incomplete\n", outFile)
+		}
+		checkReads("is incomplete")
+		return
+	} else if perReference {
+		profiles := profileContigs(contigs, gffs, codonTable)
+		maskProfiles(profiles, masked)
+		results = p2.CalcByRef(ctx, readChan, profiles, posType, maxl, opts)
+	} else {
+		profile := profiling.ProfileGenome(contigs[0].Seq, gffs, codonTable)
+		genome.MaskProfile(profile, masked[contigs[0].Id])
+		results = map[string]map[string][]*meanvar.MeanVar{"": p2.Calc(ctx, readChan, profile, posType, maxl, opts)}
+	}
+	if ctx.Err() != nil {
+		log.Fatalf("the calculation was interrupted, %s is not written\n", outFile)
+	}
+	checkReads("is not written")
+	if from := emptyLongLags(results, p2.Lags(outMaxl, opts)); from >= 0 {
+		meta.WARN.Printf("no read pairs at lags from %d on: a pair of reads gives lags shorter than their overlap, at most the read length; see -auto-maxl (-window does not populate them)\n", from)
 	}
 
-	return false
+	w, err := os.Create(outFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer w.Close()
+	var refs []string
+	for ref := range results {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		// only the lags below outMaxl are written,
+		// the calculation still uses the full maxl.
+		write(w, ref, results[ref], p2.Lags(outMaxl, opts), emptyBins, minPairs, opts.InsertBins)
+	}
 }
 
-// calc
-func calc(subProfileChan chan SubProfile, profile []profiling.Pos, posType byte, maxl int) (covsChan chan []*correlation.BivariateCovariance) {
-	covsChan = make(chan []*correlation.BivariateCovariance)
-	done := make(chan bool)
-	for i := 0; i < SAMPLES; i++ {
-		go func() {
-			covs := []*correlation.BivariateCovariance{}
-			for i := 0; i < maxl; i++ {
-				covs = append(covs, correlation.NewBivariateCovariance(false))
-			}
-
-			for subProfile := range subProfileChan {
-				for i := 0; i < len(subProfile.Profile); i++ {
-					pos1 := subProfile.Pos + i
-					x := subProfile.Profile[i]
-					if checkPosType(posType, profile[pos1].Type) && !math.IsNaN(x) {
-						for j := i; j < len(subProfile.Profile); j++ {
-							pos2 := subProfile.Pos + j
-							l := pos2 - pos1
-							if l >= len(covs) {
-								break
-							} else {
-								y := subProfile.Profile[j]
-								if checkPosType(posType, profile[pos2].Type) && !math.IsNaN(y) {
-									covs[l].Increment(x, y)
-								}
-							}
-
-						}
-					}
+// profileContigs profiles each contig with its CDS features,
+// and returns the profiles keyed by the contig name.
+func profileContigs(contigs []*seq.Sequence, gffs []*gff.Record, codonTable *taxonomy.GeneticCode) map[string][]profiling.Pos {
+	contigGffs := make(map[string][]*gff.Record)
+	for _, rec := range gffs {
+		contigGffs[rec.SeqName] = append(contigGffs[rec.SeqName], rec)
+	}
+	profiles := make(map[string][]profiling.Pos)
+	for _, contig := range contigs {
+		profiles[contig.Id] = profiling.ProfileGenome(contig.Seq, contigGffs[contig.Id], codonTable)
+	}
+	return profiles
+}
 
-				}
-			}
-			covsChan <- covs
-			done <- true
-		}()
+// maskProfiles leaves out the masked positions of each profile, see genome.MaskProfile.
+func maskProfiles(profiles map[string][]profiling.Pos, masked map[string][][2]int) {
+	for name, profile := range profiles {
+		genome.MaskProfile(profile, masked[name])
 	}
+}
 
-	go func() {
-		defer close(covsChan)
-		for i := 0; i < SAMPLES; i++ {
-			<-done
+// emptyLongLags returns the first of the written lags (the first lags of the bins,
+// with -lag-step or -log-lags) after the last lag with read pairs in any of the results,
+// or -1 if the last written lag has pairs, or no lag has.
+func emptyLongLags(results map[string]map[string][]*meanvar.MeanVar, lags []int) int {
+	populated := 0
+	for _, refResults := range results {
+		if n := p2.PopulatedLags(refResults); n > populated {
+			populated = n
 		}
-	}()
-
-	return
+	}
+	if populated == 0 {
+		return -1
+	}
+	for _, l := range lags {
+		if l >= populated {
+			return l
+		}
+	}
+	return -1
 }
 
-// collect
-func collect(covsChan chan []*correlation.BivariateCovariance, maxl int) (meanVars []*meanvar.MeanVar) {
-	meanVars = []*meanvar.MeanVar{}
-	for i := 0; i < maxl; i++ {
-		meanVars = append(meanVars, meanvar.New())
+// write writes mean and variance at each lag of lags.
+// Lags with a count n less than minPairs are omitted,
+// or written as NaN or zero, according to emptyBins.
+// Synonymous and non-synonymous results are tagged in a last (type) column,
+// syn before nonsyn, as are those of each strand, forward before reverse.
+// With insertBins, the results of each bin, in order, are tagged
+// with their type (all for All) and the label of the bin in a last column.
+// Rows start with the reference name ref, if it is not empty.
+func write(w io.Writer, ref string, results map[string][]*meanvar.MeanVar, lags []int, emptyBins string, minPairs int, insertBins []p2.InsertBin) {
+	labels := []string{""}
+	if len(insertBins) > 0 {
+		labels = nil
+		for _, b := range insertBins {
+			labels = append(labels, b.Label())
+		}
 	}
-
-	for covs := range covsChan {
-		for i := range covs {
-			c := covs[i]
-			v := c.GetResult()
-			if !math.IsNaN(v) {
-				meanVars[i].Increment(v)
+	for _, label := range labels {
+		// writeType writes the results of the type t, tagged with tag.
+		writeType := func(t, tag string) {
+			if label != "" {
+				t = p2.InsertClass(t, label)
+				tag += "\t" + label
+			}
+			if meanVars, found := results[t]; found {
+				writeMeanVars(w, meanVars, lags, ref, tag, emptyBins, minPairs)
+			}
+		}
+		if label == "" {
+			writeType(p2.All, "")
+		} else {
+			writeType(p2.All, p2.All)
+		}
+		for _, t := range []string{p2.Syn, p2.NonSyn, p2.Ts, p2.Tv} {
+			writeType(t, t)
+		}
+		for _, c := range []string{p2.All, p2.Syn, p2.NonSyn, p2.Ts, p2.Tv} {
+			for _, strand := range []string{p2.Forward, p2.Reverse} {
+				t := p2.StrandClass(c, strand)
+				writeType(t, t)
 			}
 		}
 	}
-
-	return
 }
 
-// write
-func write(meanVars []*meanvar.MeanVar, filename string) {
-	w, err := os.Create(filename)
-	if err != nil {
-		log.Fatal(err)
+// newStreamWriter returns a function writing the results of a reference to w, as write,
+// for p2.CalcByRefStream. The rows of a reference are written at once,
+// so that they are not interleaved with those of another reference.
+func newStreamWriter(w io.Writer, lags []int, emptyBins string, minPairs int, insertBins []p2.InsertBin) func(ref string, results map[string][]*meanvar.MeanVar) {
+	var mu sync.Mutex
+	var buf bytes.Buffer
+	return func(ref string, results map[string][]*meanvar.MeanVar) {
+		mu.Lock()
+		defer mu.Unlock()
+		buf.Reset()
+		write(&buf, ref, results, lags, emptyBins, minPairs, insertBins)
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			log.Fatalln(err)
+		}
 	}
-	defer w.Close()
+}
 
-	for i := 0; i < len(meanVars); i++ {
+// writeMeanVars writes the results of a substitution class at lags,
+// every lag if nil, tagged by t if it is not empty, on the reference ref if it is not empty.
+func writeMeanVars(w io.Writer, meanVars []*meanvar.MeanVar, lags []int, ref, t string, emptyBins string, minPairs int) {
+	if lags == nil {
+		for i := range meanVars {
+			lags = append(lags, i)
+		}
+	}
+	for _, i := range lags {
+		if i >= len(meanVars) {
+			break
+		}
 		m := meanVars[i].Mean.GetResult()
 		v := meanVars[i].Var.GetResult()
 		n := meanVars[i].Mean.GetN()
-		w.WriteString(fmt.Sprintf("%d\t%g\t%g\t%d\n", i, m, v, n))
+		if n < minPairs {
+			switch emptyBins {
+			case "omit":
+				continue
+			case "zero":
+				m, v = 0, 0
+			default:
+				m, v = math.NaN(), math.NaN()
+			}
+		}
+		if ref != "" {
+			fmt.Fprintf(w, "%s\t", ref)
+		}
+		if t == "" {
+			fmt.Fprintf(w, "%d\t%g\t%g\t%d\n", i, m, v, n)
+		} else {
+			fmt.Fprintf(w, "%d\t%g\t%g\t%d\t%s\n", i, m, v, n, t)
+		}
 	}
 }
 
 // readGenome read the genome file
-// and return a genomic sequence.
-func readGenome(filename string) []byte {
+// and return its sequences.
+func readGenome(filename string) []*seq.Sequence {
 	f, err := os.Open(filename)
 	if err != nil {
-		panic(err)
+		log.Fatalln(err)
 	}
 	defer f.Close()
 
 	rd := seq.NewFastaReader(f)
 	ss, err := rd.ReadAll()
 	if err != nil {
-		panic(err)
+		log.Fatalln(err)
 	}
 
-	return ss[0].Seq
+	return ss
 }
 
 func readGff(filename string) []*gff.Record {
 	f, err := os.Open(filename)
 	if err != nil {
-		panic(err)
+		log.Fatalln(err)
 	}
 	defer f.Close()
 
 	rd := gff.NewReader(f)
 	ss, err := rd.ReadAll()
 	if err != nil {
-		panic(err)
+		log.Fatalln(err)
 	}
 
 	records := []*gff.Record{}
@@ -310,134 +547,158 @@ func readGff(filename string) []*gff.Record {
 	return records
 }
 
-type SamReader interface {
-	Header() *sam.Header
-	Read() (*sam.Record, error)
-}
+// autoMaxlReads is the number of reads sampled by -auto-maxl.
+const autoMaxlReads = 10000
+
+// sampleMaxl receives at most n records from readChan,
+// and returns the longest reference span of them,
+// with a channel sending the received records and then the rest of readChan.
+// A pair of reads overlapping for the whole span has lags in [0, maxl) between them.
+func sampleMaxl(ctx context.Context, readChan chan *sam.Record, n int) (maxl int, c chan *sam.Record) {
+	var records []*sam.Record
+	for rec := range readChan {
+		records = append(records, rec)
+		if span := rec.End() - rec.Pos; span > maxl {
+			maxl = span
+		}
+		if len(records) >= n {
+			break
+		}
+	}
 
-// ReadBamFile reads bam file, and return the header and a channel of sam records.
-func readBamFile(fileName string) (h *sam.Header, c chan *sam.Record) {
-	// Initialize the channel of sam records.
 	c = make(chan *sam.Record)
-
-	// Create a new go routine to read the records.
 	go func() {
-		// Close the record channel when finished.
 		defer close(c)
+		for _, rec := range records {
+			select {
+			case c <- rec:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for rec := range readChan {
+			select {
+			case c <- rec:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return
+}
 
-		// Open file stream, and close it when finished.
-		f, err := os.Open(fileName)
+// checkSortOrder returns an error if the header does not declare
+// the records sorted by coordinate (SO:coordinate in the @HD line),
+// as overlapping reads are found by sliding along the reference,
+// unless assumeSorted.
+func checkSortOrder(h *sam.Header, assumeSorted bool) error {
+	if assumeSorted || h.SortOrder == sam.Coordinate {
+		return nil
+	}
+	return fmt.Errorf("the sort order is %s, not coordinate; sort it with samtools sort, or use -assume-sorted if it is sorted", h.SortOrder)
+}
+
+// ReadBamFile reads bam file, and return the header and a channel of sam records.
+// The header is read before returning, and errors in opening the file
+// or reading the header are returned; the records are read in a go routine,
+// which sends an error in reading them on errc before closing c.
+// errc is closed after.
+// A cram file is decoded by samtools, using the reference fasta file,
+// and a gzip-compressed sam file is decompressed;
+// samtools failing, e.g. without the reference, is an error.
+// Reading stops when ctx is done, killing samtools if it is used.
+func readBamFile(ctx context.Context, fileName, reference string) (h *sam.Header, c chan *sam.Record, errc chan error, err error) {
+	var f io.ReadCloser
+	var samtools *exec.Cmd
+	if strings.HasSuffix(fileName, ".cram") {
+		// biogo/hts can not decode cram records yet.
+		samtools = exec.Command("samtools", "view", "-h", "-T", reference, fileName)
+		samtools.Stderr = os.Stderr
+		stdout, err := samtools.StdoutPipe()
 		if err != nil {
-			panic(err)
+			return nil, nil, nil, err
 		}
-		defer f.Close()
+		if err := samtools.Start(); err != nil {
+			return nil, nil, nil, fmt.Errorf("samtools view %s: %v", fileName, err)
+		}
+		f = stdout
+	} else {
+		// Open file stream, which is closed when all records are read.
+		file, err := os.Open(fileName)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		f = file
+	}
 
-		var reader SamReader
-		if fileName[len(fileName)-3:] == "bam" {
-			bamReader, err := bam.NewReader(f, 0)
-			if err != nil {
-				panic(err)
-			}
-			defer bamReader.Close()
-			reader = bamReader
-		} else {
-			reader, err = sam.NewReader(f)
-			if err != nil {
-				panic(err)
+	format := "sam"
+	if strings.HasSuffix(fileName, "bam") {
+		format = "bam"
+	}
+	reader, err := reads.NewRecordReader(f, format)
+	if err != nil {
+		if samtools != nil {
+			// the error of samtools, such as a missing reference, explains the missing header.
+			if werr := samtools.Wait(); werr != nil {
+				return nil, nil, nil, fmt.Errorf("samtools view %s: %v", fileName, werr)
 			}
+			return nil, nil, nil, fmt.Errorf("samtools view %s: %v", fileName, err)
 		}
+		f.Close()
+		return nil, nil, nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+
+	// Read and assign header.
+	h = reader.Header()
+
+	// Initialize the channel of sam records.
+	c = make(chan *sam.Record)
+	errc = make(chan error, 1)
 
-		// Read and assign header.
-		h = reader.Header()
+	// Create a new go routine to read the records.
+	go func() {
+		// Close the record channel and the file when finished.
+		defer close(errc)
+		defer close(c)
+		if samtools == nil {
+			defer f.Close()
+		}
+		defer reader.Close()
 
 		// Read sam records and send them to the channel,
-		// until it hit an error, which raises a panic
+		// until it hit an error, which is sent to errc
 		// if it is not a IO EOF.
 		for {
 			rec, err := reader.Read()
 			if err != nil {
 				if err != io.EOF {
-					panic(err)
+					if samtools != nil {
+						samtools.Process.Kill()
+						samtools.Wait()
+					}
+					errc <- fmt.Errorf("%s: %v", fileName, err)
+					return
 				}
 				break
 			}
-			c <- rec
+			select {
+			case c <- rec:
+			case <-ctx.Done():
+				if samtools != nil {
+					samtools.Process.Kill()
+					samtools.Wait()
+				}
+				return
+			}
 		}
-		log.Println("Finished reading bam file!")
-	}()
-
-	return
-}
-
-// Map2Ref Obtains a read mapping to the reference genome.
-func Map2Ref(r *sam.Record) (s []byte, q []byte) {
-	p := 0                 // position in the read sequence.
-	read := r.Seq.Expand() // read sequence.
-	qual := r.Qual
-	for _, c := range r.Cigar {
-		switch c.Type() {
-		case sam.CigarMatch, sam.CigarMismatch, sam.CigarEqual:
-			s = append(s, read[p:p+c.Len()]...)
-			q = append(q, qual[p:p+c.Len()]...)
-			p += c.Len()
-		case sam.CigarInsertion, sam.CigarSoftClipped, sam.CigarHardClipped:
-			p += c.Len()
-		case sam.CigarDeletion, sam.CigarSkipped:
-			for i := 0; i < c.Len(); i++ {
-				s = append(s, '*')
-				q = append(q, 0)
+		if samtools != nil {
+			if err := samtools.Wait(); err != nil {
+				errc <- fmt.Errorf("samtools view %s: %v", fileName, err)
+				return
 			}
 		}
-	}
-
-	s = bytes.ToUpper(s)
+		meta.INFO.Println("Finished reading bam file!")
+	}()
 
 	return
 }
-
-func checkPosType(posType, t1 byte) bool {
-	isFirstPos := t1 == profiling.FirstPos
-	isSecondPos := t1 == profiling.SecondPos
-	isThirdPos := t1 == profiling.ThirdPos
-	isFourFold := t1 == profiling.FourFold
-
-	if posType == profiling.Coding {
-		if isFirstPos || isSecondPos || isThirdPos || isFourFold {
-			return true
-		}
-		return false
-	}
-
-	if posType == profiling.ThirdPos {
-		if isThirdPos || isFourFold {
-			return true
-		}
-		return false
-	}
-
-	return posType == t1
-}
-
-func convertPosType(pos int) byte {
-	var p byte
-	switch pos {
-	case 0:
-		p = profiling.NonCoding
-	case 1:
-		p = profiling.FirstPos
-		break
-	case 2:
-		p = profiling.SecondPos
-		break
-	case 3:
-		p = profiling.ThirdPos
-		break
-	case 4:
-		p = profiling.FourFold
-		break
-	default:
-		p = profiling.Coding
-	}
-
-	return p
-}
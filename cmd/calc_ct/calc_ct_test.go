@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/gomath/stat/desc/meanvar"
+	"github.com/mingzhi/meta/p2"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+// testRecords returns a header with two references and three records on the first.
+func testRecords(t *testing.T) (*sam.Header, []*sam.Record) {
+	var refs []*sam.Reference
+	for _, name := range []string{"NC_000001", "NC_000002"} {
+		ref, err := sam.NewReference(name, "", "", 1000, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, ref)
+	}
+	h, err := sam.NewHeader(nil, refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}
+	var records []*sam.Record
+	for i, pos := range []int{10, 20, 30} {
+		r, err := sam.NewRecord("read"+string(rune('a'+i)), refs[0], nil, pos, -1, 0, 60, cigar, []byte("ACGT"), []byte{30, 30, 30, 30}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	return h, records
+}
+
+// writeBam writes a small BAM file and returns its name.
+func writeBam(t *testing.T, dir string) string {
+	h, records := testRecords(t)
+	fileName := filepath.Join(dir, "reads.bam")
+	f, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := bam.NewWriter(f, h, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return fileName
+}
+
+func TestReadBamFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc_ct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := writeBam(t, dir)
+
+	h, c, _, err := readBamFile(context.Background(), fileName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h == nil {
+		t.Fatal("Expect a header, got nil")
+	}
+	if len(h.Refs()) != 2 {
+		t.Errorf("Expect 2 references, got %d\n", len(h.Refs()))
+	}
+
+	n := 0
+	for range c {
+		n++
+	}
+	if n != 3 {
+		t.Errorf("Expect 3 records, got %d\n", n)
+	}
+}
+
+func TestReadBamFileCancel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc_ct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := writeBam(t, dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, c, _, err := readBamFile(ctx, fileName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-c
+	cancel()
+	// let the reader, blocked in sending the next record, see the cancellation.
+	time.Sleep(50 * time.Millisecond)
+
+	// the channel is closed without sending the other records.
+	n := 1
+	for range c {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("Expect 1 record before cancelling, got %d\n", n)
+	}
+}
+
+func TestReadGzipSamFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc_ct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h, records := testRecords(t)
+	var buf bytes.Buffer
+	w, err := sam.NewWriter(&buf, h, sam.FlagDecimal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var gzBuf bytes.Buffer
+	gz := gzip.NewWriter(&gzBuf)
+	if _, err := gz.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	samFile := filepath.Join(dir, "reads.sam")
+	gzFile := filepath.Join(dir, "reads.sam.gz")
+	if err := ioutil.WriteFile(samFile, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(gzFile, gzBuf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(fileName string) (lines []string) {
+		h, c, _, err := readBamFile(context.Background(), fileName, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(h.Refs()) != 2 {
+			t.Errorf("%s, Expect 2 references, got %d\n", fileName, len(h.Refs()))
+		}
+		for r := range c {
+			lines = append(lines, r.String())
+		}
+		return
+	}
+	expected := read(samFile)
+	got := read(gzFile)
+	if len(expected) != len(records) || fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Expect records\n%v\ngot\n%v\n", expected, got)
+	}
+}
+
+func TestWriteMeanVarsMinPairs(t *testing.T) {
+	// lags with counts 0, 2 and 5.
+	var meanVars []*meanvar.MeanVar
+	for _, n := range []int{0, 2, 5} {
+		mv := meanvar.New()
+		for i := 0; i < n; i++ {
+			mv.Increment(float64(i))
+		}
+		meanVars = append(meanVars, mv)
+	}
+
+	testCases := []struct {
+		minPairs  int
+		emptyBins string
+		expected  string
+	}{
+		{1, "omit", "1\t0.5\t0.25\t2\n2\t2\t2\t5\n"},
+		{3, "omit", "2\t2\t2\t5\n"},
+		{3, "nan", "0\tNaN\tNaN\t0\n1\tNaN\tNaN\t2\n2\t2\t2\t5\n"},
+	}
+	for _, tc := range testCases {
+		var buf bytes.Buffer
+		writeMeanVars(&buf, meanVars, nil, "", "", tc.emptyBins, tc.minPairs)
+		if buf.String() != tc.expected {
+			t.Errorf("min-pairs %d, %s, Expect\n%s\ngot\n%s\n", tc.minPairs, tc.emptyBins, tc.expected, buf.String())
+		}
+	}
+}
+
+func TestWriteInsertBins(t *testing.T) {
+	meanVar := func(v float64) []*meanvar.MeanVar {
+		mv := meanvar.New()
+		mv.Increment(v)
+		return []*meanvar.MeanVar{mv}
+	}
+	bins := []p2.InsertBin{{Min: 0, Max: 200}, {Min: 200, Max: 400}}
+	results := map[string][]*meanvar.MeanVar{
+		p2.InsertClass(p2.All, "200-400"):                           meanVar(0.2),
+		p2.InsertClass(p2.All, "0-200"):                             meanVar(0.1),
+		p2.InsertClass(p2.StrandClass(p2.All, p2.Reverse), "0-200"): meanVar(0.3),
+	}
+	var buf bytes.Buffer
+	write(&buf, "", results, []int{0}, "nan", 1, bins)
+	expected := "0\t0.1\t0\t1\tall\t0-200\n0\t0.3\t0\t1\tall_reverse\t0-200\n0\t0.2\t0\t1\tall\t200-400\n"
+	if buf.String() != expected {
+		t.Errorf("Expect\n%s\ngot\n%s\n", expected, buf.String())
+	}
+}
+
+func TestCalcByRef(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc_ct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var refs []*sam.Reference
+	for _, name := range []string{"NC_000001", "NC_000002"} {
+		ref, err := sam.NewReference(name, "", "", 20, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, ref)
+	}
+	h, err := sam.NewHeader(nil, refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// overlapping reads with substitutions on the first reference,
+	// and without on the second.
+	reads := [][]string{
+		{"ACGTACGTAC", "GTTCGTACGT", "ACGAACGTAC"},
+		{"ACGTACGTAC", "GTACGTACGT", "ACGTACGTAC"},
+	}
+	fileName := filepath.Join(dir, "reads.bam")
+	f, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bw, err := bam.NewWriter(f, h, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, ref := range refs {
+		for i, s := range reads[k] {
+			cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, len(s))}
+			qual := bytes.Repeat([]byte{30}, len(s))
+			r, err := sam.NewRecord(fmt.Sprintf("read%d", i), ref, nil, i*2, -1, 0, 40, cigar, []byte(s), qual, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := bw.Write(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// all positions are four-fold degenerate sites.
+	profiles := make(map[string][]profiling.Pos)
+	for _, ref := range refs {
+		profile := make([]profiling.Pos, ref.Len())
+		for i := range profile {
+			profile[i].Type = profiling.FourFold
+		}
+		profiles[ref.Name()] = profile
+	}
+
+	_, c, _, err := readBamFile(context.Background(), fileName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := p2.Options{MinBQ: 13, MapQ255: "exclude", Samples: 1}
+	results := p2.CalcByRef(context.Background(), c, profiles, p2.ConvertPosType(4), 3, opts)
+	if len(results) != 2 {
+		t.Fatalf("Expect 2 references, got %d\n", len(results))
+	}
+	expected := map[string]float64{"NC_000001": 0.1488, "NC_000002": 0}
+	for name, value := range expected {
+		got := results[name][p2.All][0].Mean.GetResult()
+		if math.Abs(got-value) > 1e-4 {
+			t.Errorf("%s, Expect P2 %g at lag 0, got %g\n", name, value, got)
+		}
+	}
+
+	var buf bytes.Buffer
+	write(&buf, "NC_000002", results["NC_000002"], []int{0}, "nan", 1, nil)
+	if s := buf.String(); s != "NC_000002\t0\t0\t0\t1\n" {
+		t.Errorf("Expect a row of NC_000002, got %s\n", s)
+	}
+
+	// streaming writes the same rows, in the order of the references in the bam file.
+	var batched bytes.Buffer
+	for _, ref := range refs {
+		write(&batched, ref.Name(), results[ref.Name()], []int{0, 1, 2}, "nan", 1, nil)
+	}
+	var streamed bytes.Buffer
+	_, c, _, err = readBamFile(context.Background(), fileName, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2.CalcByRefStream(context.Background(), c, profiles, p2.ConvertPosType(4), 3, opts, newStreamWriter(&streamed, []int{0, 1, 2}, "nan", 1, nil))
+	if !equalRows(streamed.String(), batched.String()) {
+		t.Errorf("Expect streamed rows\n%s, got\n%s\n", batched.String(), streamed.String())
+	}
+}
+
+// equalRows returns true if the rows a and b have the same fields,
+// up to the last bits of the numbers: the read pairs are compared in parallel,
+// so the covariances are summed in varying orders.
+func equalRows(a, b string) bool {
+	rowsA, rowsB := strings.Split(a, "\n"), strings.Split(b, "\n")
+	if len(rowsA) != len(rowsB) {
+		return false
+	}
+	for i := range rowsA {
+		fieldsA, fieldsB := strings.Split(rowsA[i], "\t"), strings.Split(rowsB[i], "\t")
+		if len(fieldsA) != len(fieldsB) {
+			return false
+		}
+		for j := range fieldsA {
+			if fieldsA[j] == fieldsB[j] {
+				continue
+			}
+			x, errA := strconv.ParseFloat(fieldsA[j], 64)
+			y, errB := strconv.ParseFloat(fieldsB[j], 64)
+			if errA != nil || errB != nil || math.Abs(x-y) > 1e-12 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func TestCheckSortOrder(t *testing.T) {
+	testCases := []struct {
+		order        sam.SortOrder
+		assumeSorted bool
+		ok           bool
+	}{
+		{sam.Coordinate, false, true},
+		{sam.Unsorted, false, false},
+		{sam.QueryName, false, false},
+		{sam.UnknownOrder, false, false},
+		{sam.Unsorted, true, true},
+		{sam.UnknownOrder, true, true},
+	}
+	for _, tc := range testCases {
+		h, _ := testRecords(t)
+		h.SortOrder = tc.order
+		err := checkSortOrder(h, tc.assumeSorted)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s, assume-sorted %v, Expect ok %v, got %v\n", tc.order, tc.assumeSorted, tc.ok, err)
+		}
+	}
+}
+
+func TestSampleMaxl(t *testing.T) {
+	ref, err := sam.NewReference("NC_000001", "", "", 1000, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	newRecord := func(pos int, cigar ...sam.CigarOp) *sam.Record {
+		n := 0
+		for _, c := range cigar {
+			if c.Type() == sam.CigarMatch {
+				n += c.Len()
+			}
+		}
+		s := bytes.Repeat([]byte("A"), n)
+		r, err := sam.NewRecord("read", ref, nil, pos, -1, 0, 60, cigar, s, bytes.Repeat([]byte{30}, n), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	records := []*sam.Record{
+		newRecord(0, sam.NewCigarOp(sam.CigarMatch, 50)),
+		// a deletion is in the span, but not an insertion.
+		newRecord(10, sam.NewCigarOp(sam.CigarMatch, 40), sam.NewCigarOp(sam.CigarDeletion, 5), sam.NewCigarOp(sam.CigarMatch, 30)),
+		newRecord(20, sam.NewCigarOp(sam.CigarMatch, 40), sam.NewCigarOp(sam.CigarInsertion, 30), sam.NewCigarOp(sam.CigarMatch, 40)),
+		// after the sampled reads.
+		newRecord(30, sam.NewCigarOp(sam.CigarMatch, 100)),
+	}
+	readChan := make(chan *sam.Record)
+	go func() {
+		defer close(readChan)
+		for _, r := range records {
+			readChan <- r
+		}
+	}()
+
+	maxl, c := sampleMaxl(context.Background(), readChan, 3)
+	if maxl != 80 {
+		t.Errorf("Expect maxl 80, got %d\n", maxl)
+	}
+	i := 0
+	for r := range c {
+		if i < len(records) && r != records[i] {
+			t.Errorf("record %d, Expect %v, got %v\n", i, records[i], r)
+		}
+		i++
+	}
+	if i != len(records) {
+		t.Errorf("Expect %d records, got %d\n", len(records), i)
+	}
+}
+
+func TestEmptyLongLags(t *testing.T) {
+	// results of 100 lags, with pairs at the lags of bins up to last.
+	results := func(lags []int, last int) map[string]map[string][]*meanvar.MeanVar {
+		meanVars := make([]*meanvar.MeanVar, 100)
+		for l := range meanVars {
+			meanVars[l] = meanvar.New()
+		}
+		for _, l := range lags {
+			if l <= last {
+				meanVars[l].Increment(0.5)
+			}
+		}
+		return map[string]map[string][]*meanvar.MeanVar{"NC_000001": {"all": meanVars}}
+	}
+
+	testCases := []struct {
+		name     string
+		opts     p2.Options
+		last     int
+		expected int
+	}{
+		{"every lag", p2.Options{}, 99, -1},
+		{"every lag, short reads", p2.Options{}, 49, 50},
+		{"log lags", p2.Options{LogLags: true}, 64, -1},
+		{"log lags, short reads", p2.Options{LogLags: true}, 16, 32},
+		{"lag step", p2.Options{LagStep: 10}, 90, -1},
+		{"lag step, short reads", p2.Options{LagStep: 10}, 40, 50},
+		{"no pairs", p2.Options{}, -1, -1},
+	}
+	for _, tc := range testCases {
+		lags := p2.Lags(100, tc.opts)
+		if got := emptyLongLags(results(lags, tc.last), lags); got != tc.expected {
+			t.Errorf("%s, Expect %d, got %d\n", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestReadCramFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calc_ct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cramFile := filepath.Join(dir, "reads.cram")
+	reference := filepath.Join(dir, "ref.fa")
+
+	// fakeSamtools puts first in PATH a samtools running script.
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	fakeSamtools := func(script string) {
+		bin := filepath.Join(dir, "bin")
+		if err := os.MkdirAll(bin, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(bin, "samtools"), []byte("#!/bin/sh\n"+script), 0777); err != nil {
+			t.Fatal(err)
+		}
+		os.Setenv("PATH", bin)
+	}
+
+	// samtools failing before the header, e.g. without the reference.
+	fakeSamtools("echo '[E::cram_decode] missing reference' >&2\nexit 1\n")
+	if _, _, _, err := readBamFile(context.Background(), cramFile, reference); err == nil || !strings.Contains(err.Error(), "exit status 1") {
+		t.Errorf("Expect the exit status of samtools, got %v\n", err)
+	}
+
+	// samtools failing after the records.
+	fakeSamtools("printf '@SQ\\tSN:NC_000001\\tLN:1000\\nreada\\t0\\tNC_000001\\t11\\t60\\t4M\\t*\\t0\\t0\\tACGT\\t????\\n'\nexit 2\n")
+	_, c, errc, err := readBamFile(context.Background(), cramFile, reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for range c {
+		n++
+	}
+	if err := <-errc; n != 1 || err == nil || !strings.Contains(err.Error(), "exit status 2") {
+		t.Errorf("Expect 1 record and the exit status of samtools, got %d and %v\n", n, err)
+	}
+
+	// samtools not in PATH.
+	os.Setenv("PATH", filepath.Join(dir, "none"))
+	if _, _, _, err := readBamFile(context.Background(), cramFile, reference); err == nil {
+		t.Errorf("Expect an error without samtools\n")
+	}
+}
+
+func TestReadCramFileSamtools(t *testing.T) {
+	if _, err := exec.LookPath("samtools"); err != nil {
+		t.Skip("samtools is not in PATH")
+	}
+	dir, err := ioutil.TempDir("", "calc_ct")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := writeBam(t, dir)
+	reference := filepath.Join(dir, "ref.fa")
+	fasta := ">NC_000001\n" + strings.Repeat("ACGT", 250) + "\n>NC_000002\n" + strings.Repeat("ACGT", 250) + "\n"
+	if err := ioutil.WriteFile(reference, []byte(fasta), 0666); err != nil {
+		t.Fatal(err)
+	}
+	cramFile := filepath.Join(dir, "reads.cram")
+	if out, err := exec.Command("samtools", "view", "-C", "-T", reference, "-o", cramFile, fileName).CombinedOutput(); err != nil {
+		t.Fatalf("samtools view -C: %v\n%s", err, out)
+	}
+
+	h, c, errc, err := readBamFile(context.Background(), cramFile, reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Refs()) != 2 {
+		t.Errorf("Expect 2 references, got %d\n", len(h.Refs()))
+	}
+	n := 0
+	for range c {
+		n++
+	}
+	if err := <-errc; err != nil || n != 3 {
+		t.Errorf("Expect 3 records, got %d and %v\n", n, err)
+	}
+
+	// a missing reference is an error, not a crash,
+	// before the header or after the records, as samtools fails.
+	_, c, errc, err = readBamFile(context.Background(), cramFile, filepath.Join(dir, "missing.fa"))
+	if err == nil {
+		for range c {
+		}
+		err = <-errc
+	}
+	if err == nil {
+		t.Errorf("Expect an error with a missing reference\n")
+	}
+}
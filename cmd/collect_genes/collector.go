@@ -1,5 +1,7 @@
 package main
 
+import "math"
+
 // CorrResult contains a correlation result.
 type CorrResult struct {
 	Lag      int
@@ -79,10 +81,21 @@ func (c *Collector) CorrTypes() (corrTypes []string) {
 	return
 }
 
-// Results get results
+// Ks returns the mean and the variance of Ks, the P2 at lag 0,
+// and the number of its values; the mean is NaN without values.
+func (c *Collector) Ks() (mean, variance float64, n int) {
+	mvs := c.MeanVars("P2")
+	if len(mvs) == 0 || mvs[0].N == 0 {
+		return math.NaN(), math.NaN(), 0
+	}
+	return mvs[0].Mean(), mvs[0].Variance(), mvs[0].N
+}
+
+// Results get results, the P2 at lag 0 as Ks,
+// and the others normalized by Ks.
 func (c *Collector) Results() (results []CorrResult) {
 	corrTypes := c.CorrTypes()
-	ks := 0.0
+	ks, _, _ := c.Ks()
 	for _, ctype := range corrTypes {
 		means := c.Means(ctype)
 		vars := c.Vars(ctype)
@@ -97,7 +110,6 @@ func (c *Collector) Results() (results []CorrResult) {
 				res.Variance = vars[i]
 				if ctype == "P2" && i == 0 {
 					res.Type = "Ks"
-					ks = res.Value
 				} else {
 					res.Value /= ks
 					res.Variance /= (ks * ks)
@@ -7,6 +7,7 @@ import (
 	"io"
 	"log"
 	"os"
+	"runtime"
 	"sort"
 	"strings"
 
@@ -20,6 +21,9 @@ func main() {
 	var geneFile string
 	var sampleFile string
 	var byGene bool
+	var appendix string
+	var ksFile string
+	var ncpu int
 	app := kingpin.New("collect_genes", "Calculate correlation across multiple samples")
 	app.Version("v0.1")
 
@@ -28,12 +32,22 @@ func main() {
 	geneFileFlag := app.Flag("gene-file", "gene file").Default("").String()
 	sampleFileFlag := app.Flag("sample-file", "sample file").Default("").String()
 	byGeneFlag := app.Flag("by-gene", "by gene").Default("false").Bool()
+	ncpuFlag := app.Flag("ncpu", "number of sample files decoded concurrently").Default("0").Int()
+	ksFileFlag := app.Flag("ks-file", "output file of the Ks of each gene (and of all genes), with columns g,ks,v,n").Default("").String()
+	appendixFlag := app.Flag("appendix", "appendix of corr results files, appended to each sample in the sample file (e.g. _corr.json)").Default("").String()
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 	corrFile = *corrFileArg
 	outfile = *outFileArg
 	geneFile = *geneFileFlag
 	sampleFile = *sampleFileFlag
 	byGene = *byGeneFlag
+	appendix = *appendixFlag
+	ksFile = *ksFileFlag
+	if *ncpuFlag == 0 {
+		ncpu = runtime.NumCPU()
+	} else {
+		ncpu = *ncpuFlag
+	}
 
 	var geneSet map[string]bool
 	if geneFile != "" {
@@ -44,43 +58,23 @@ func main() {
 			geneSet[gene] = true
 		}
 	}
-	var samples []string
+	var corrFiles []string
 	if sampleFile != "" {
 		lines := readLines(sampleFile)
+		var samples []string
 		for _, line := range lines {
 			samples = append(samples, strings.TrimSpace(line))
 		}
+		for _, sample := range checkFiles(samples, appendix) {
+			corrFiles = append(corrFiles, sample+appendix)
+		}
 	} else {
-		samples = append(samples, corrFile)
+		corrFiles = append(corrFiles, corrFile)
 	}
 
-	collectorMap := make(map[string]*Collector)
-	if byGene {
-		if geneFile != "" {
-			for geneID := range geneSet {
-				collectorMap[geneID] = NewCollector()
-			}
-		}
-	}
-	collectorMap["all"] = NewCollector()
-	pbar := pb.StartNew(len(samples))
-	defer pbar.Finish()
-	for _, sampleFile := range samples {
-		corrChan := readCorrResults(sampleFile)
-		for corrResults := range corrChan {
-			geneID := corrResults.GeneID
-			if geneFile != "" {
-				if !geneSet[geneID] {
-					continue
-				}
-			}
-			if byGene {
-				collectorMap[geneID].Add(corrResults)
-			}
-			collectorMap["all"].Add(corrResults)
-		}
-		pbar.Increment()
-	}
+	pbar := pb.StartNew(len(corrFiles))
+	collectorMap := collectSamples(corrFiles, ncpu, geneSet, byGene, func() { pbar.Increment() })
+	pbar.Finish()
 
 	w, err := os.Create(outfile)
 	if err != nil {
@@ -104,6 +98,126 @@ func main() {
 				res.Lag, res.Value, res.Variance, res.Count, res.Type, geneID))
 		}
 	}
+
+	if ksFile != "" {
+		f, err := os.Create(ksFile)
+		if err != nil {
+			log.Panic(err)
+		}
+		defer f.Close()
+		if err := writeKs(f, geneIDs, collectorMap); err != nil {
+			log.Panic(err)
+		}
+	}
+}
+
+// writeKs writes the Ks of each gene, in the order of geneIDs,
+// with columns g,ks,v,n: the gene, the mean and the variance of its Ks,
+// and the number of values.
+func writeKs(w io.Writer, geneIDs []string, collectorMap map[string]*Collector) error {
+	if _, err := fmt.Fprintln(w, "g,ks,v,n"); err != nil {
+		return err
+	}
+	for _, geneID := range geneIDs {
+		ks, v, n := collectorMap[geneID].Ks()
+		if _, err := fmt.Fprintf(w, "%s,%g,%g,%d\n", geneID, ks, v, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectSamples pools the corr results in the files, decoded by ncpu workers,
+// into the collector of all genes ("all") and, if byGene, the collector of each gene.
+// The results are added in the order of the files, as by one worker,
+// so that the means and variances do not depend on the scheduling of the workers.
+// If geneSet is not nil, only the genes in it are collected.
+// progress is called after each file is decoded.
+func collectSamples(corrFiles []string, ncpu int, geneSet map[string]bool, byGene bool, progress func()) map[string]*Collector {
+	collectorMap := make(map[string]*Collector)
+	if byGene {
+		for geneID := range geneSet {
+			collectorMap[geneID] = NewCollector()
+		}
+	}
+	collectorMap["all"] = NewCollector()
+
+	// at most 2*ncpu files are decoded and not yet added,
+	// waiting for the files before them.
+	slots := make(chan bool, 2*ncpu)
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for i := range corrFiles {
+			slots <- true
+			jobs <- i
+		}
+	}()
+
+	// the decoded files of all workers are funneled into this goroutine,
+	// which is the only one adding them to the collectors.
+	type decodedFile struct {
+		index   int
+		results []CorrResults
+	}
+	decodedChan := make(chan decodedFile)
+	done := make(chan bool)
+	for i := 0; i < ncpu; i++ {
+		go func() {
+			for index := range jobs {
+				var results []CorrResults
+				for corrResults := range readCorrResults(corrFiles[index]) {
+					results = append(results, corrResults)
+				}
+				decodedChan <- decodedFile{index: index, results: results}
+				progress()
+			}
+			done <- true
+		}()
+	}
+	go func() {
+		defer close(decodedChan)
+		for i := 0; i < ncpu; i++ {
+			<-done
+		}
+	}()
+
+	add := func(corrResults CorrResults) {
+		geneID := corrResults.GeneID
+		if geneSet != nil {
+			if !geneSet[geneID] {
+				return
+			}
+		}
+		if byGene {
+			collector, found := collectorMap[geneID]
+			if !found {
+				collector = NewCollector()
+				collectorMap[geneID] = collector
+			}
+			collector.Add(corrResults)
+		}
+		collectorMap["all"].Add(corrResults)
+	}
+	pending := make(map[int][]CorrResults)
+	next := 0 // index of the next file to add.
+	for decoded := range decodedChan {
+		pending[decoded.index] = decoded.results
+		for {
+			results, found := pending[next]
+			if !found {
+				break
+			}
+			delete(pending, next)
+			for _, corrResults := range results {
+				add(corrResults)
+			}
+			<-slots
+			next++
+		}
+	}
+
+	return collectorMap
 }
 
 func readSamples(filename string) []string {
@@ -152,12 +266,16 @@ func readCorrResults(filename string) chan CorrResults {
 	return c
 }
 
+// checkFiles returns the samples whose files (sample + appendix) exist,
+// warning about the missing ones.
 func checkFiles(samples []string, appendix string) []string {
 	var results []string
 	for _, sample := range samples {
 		filename := sample + appendix
 		if _, err := os.Stat(filename); !os.IsNotExist(err) {
 			results = append(results, sample)
+		} else {
+			log.Printf("Skip sample %s: can not find %s\n", sample, filename)
 		}
 	}
 	return results
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSamples writes synthetic corr results files of numSamples samples,
+// each with numGenes genes, and returns the file names.
+func writeSamples(dir string, numSamples, numGenes int) ([]string, error) {
+	var corrFiles []string
+	for i := 0; i < numSamples; i++ {
+		fileName := filepath.Join(dir, fmt.Sprintf("sample%d_corr.json", i))
+		f, err := os.Create(fileName)
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(f)
+		for j := 0; j < numGenes; j++ {
+			corrResults := CorrResults{GeneID: fmt.Sprintf("gene%d", j)}
+			for l := 0; l < 100; l++ {
+				corrResults.Results = append(corrResults.Results, CorrResult{Lag: l, Type: "P2", Value: float64(i+j+l) / 1000, Count: 10})
+			}
+			if err := encoder.Encode(corrResults); err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+		corrFiles = append(corrFiles, fileName)
+	}
+	return corrFiles, nil
+}
+
+func TestCollectSamples(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collect_genes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	corrFiles, err := writeSamples(dir, 10, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := collectSamples(corrFiles, 1, nil, true, func() {})
+	got := collectSamples(corrFiles, 4, nil, true, func() {})
+	if len(got) != 6 || len(expected) != 6 {
+		t.Fatalf("Expect 6 collectors (all and 5 genes), got %d and %d\n", len(expected), len(got))
+	}
+	// the results are the same, to the bit, as with one worker.
+	for geneID, c := range expected {
+		ns, means, vars := c.Ns("P2"), c.Means("P2"), c.Vars("P2")
+		gotNs, gotMeans, gotVars := got[geneID].Ns("P2"), got[geneID].Means("P2"), got[geneID].Vars("P2")
+		if len(ns) != len(gotNs) {
+			t.Fatalf("%s, Expect %d lags, got %d\n", geneID, len(ns), len(gotNs))
+		}
+		for l := range ns {
+			if ns[l] != gotNs[l] {
+				t.Errorf("%s at lag %d, Expect %d values, got %d\n", geneID, l, ns[l], gotNs[l])
+			}
+			if math.Float64bits(means[l]) != math.Float64bits(gotMeans[l]) {
+				t.Errorf("%s at lag %d, Expect mean %v, got %v\n", geneID, l, means[l], gotMeans[l])
+			}
+			if math.Float64bits(vars[l]) != math.Float64bits(gotVars[l]) {
+				t.Errorf("%s at lag %d, Expect variance %v, got %v\n", geneID, l, vars[l], gotVars[l])
+			}
+		}
+	}
+}
+
+func TestGeneKs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collect_genes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the mean P2 at lag 0 of gene1 is 0.125 in sample 0 and 0.375 in sample 1,
+	// and that of gene2 is 0.5 in both.
+	ks := map[string][]float64{"gene1": {0.125, 0.375}, "gene2": {0.5, 0.5}}
+	var corrFiles []string
+	for i := 0; i < 2; i++ {
+		fileName := filepath.Join(dir, fmt.Sprintf("sample%d_corr.json", i))
+		f, err := os.Create(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoder := json.NewEncoder(f)
+		for _, geneID := range []string{"gene1", "gene2"} {
+			corrResults := CorrResults{GeneID: geneID, Results: []CorrResult{
+				{Lag: 0, Type: "P2", Value: ks[geneID][i] * 4, Count: 4},
+				{Lag: 1, Type: "P2", Value: ks[geneID][i] * 2, Count: 4},
+			}}
+			if err := encoder.Encode(corrResults); err != nil {
+				t.Fatal(err)
+			}
+		}
+		f.Close()
+		corrFiles = append(corrFiles, fileName)
+	}
+
+	collectorMap := collectSamples(corrFiles, 1, nil, true, func() {})
+	var buf bytes.Buffer
+	if err := writeKs(&buf, []string{"all", "gene1", "gene2"}, collectorMap); err != nil {
+		t.Fatal(err)
+	}
+	expected := "g,ks,v,n\n" +
+		"all,0.375,0.0234375,4\n" +
+		"gene1,0.25,0.015625,2\n" +
+		"gene2,0.5,0,2\n"
+	if buf.String() != expected {
+		t.Errorf("Expect\n%s, got\n%s", expected, buf.String())
+	}
+
+	// the P2 of each gene is normalized by its own Ks.
+	for _, res := range collectorMap["gene1"].Results() {
+		if res.Type == "P2" && math.Abs(res.Value-0.5) > 1e-12 {
+			t.Errorf("gene1 at lag %d, Expect 0.5, got %g\n", res.Lag, res.Value)
+		}
+	}
+}
+
+func BenchmarkCollectSamples(b *testing.B) {
+	dir, err := ioutil.TempDir("", "collect_genes")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	corrFiles, err := writeSamples(dir, 50, 100)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for _, ncpu := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("ncpu=%d", ncpu), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				collectSamples(corrFiles, ncpu, nil, false, func() {})
+			}
+		})
+	}
+}
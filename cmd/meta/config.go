@@ -154,19 +154,19 @@ func (cmd *cmdConfig) ReadSpeciesFile() map[string][]string {
 	filePath := filepath.Join(*cmd.workspace, cmd.speciesFile)
 	f, err := os.Open(filePath)
 	if err != nil {
-		ERROR.Panicln("Cannot open %s: %v\n", filePath, err)
+		ERROR.Panicf("Cannot open %s: %v\n", filePath, err)
 	}
 	defer f.Close()
 
 	data, err := ioutil.ReadAll(f)
 	if err != nil {
-		ERROR.Panicln("Cannot read file %s: %v\n", filePath, err)
+		ERROR.Panicf("Cannot read file %s: %v\n", filePath, err)
 	}
 
 	m := make(map[string][]string)
 	err = yaml.Unmarshal(data, &m)
 	if err != nil {
-		ERROR.Panicln("Cannot unmarshal %s: %v\n", filePath, err)
+		ERROR.Panicf("Cannot unmarshal %s: %v\n", filePath, err)
 	}
 
 	return m
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"compress/zlib"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// This command converts the csv results of meta_p2 into cov results (json),
+// which can be fitted like those of cov_genomes.
+type cmdConvert struct {
+	out *string // output file.
+	zip *bool   // compress the output with zlib.
+}
+
+func (cmd *cmdConvert) Flags(fs *flag.FlagSet) *flag.FlagSet {
+	cmd.out = fs.String("o", "", "output json file (default: the standard output)")
+	cmd.zip = fs.Bool("zip", false, "compress the output with zlib, as the _boot.json.zip files read by fit_genomes")
+	return fs
+}
+
+func (cmd *cmdConvert) Run(args []string) {
+	if len(args) != 1 {
+		ERROR.Fatalln("convert needs a csv result file of meta_p2")
+	}
+
+	in, err := os.Open(args[0])
+	if err != nil {
+		ERROR.Fatalln(err)
+	}
+	defer in.Close()
+	results, err := readP2Results(in)
+	if err != nil {
+		ERROR.Fatalf("Cannot read %s: %v\n", args[0], err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *cmd.out != "" {
+		f, err := os.Create(*cmd.out)
+		if err != nil {
+			ERROR.Fatalln(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if *cmd.zip {
+		zw := zlib.NewWriter(w)
+		defer zw.Close()
+		w = zw
+	}
+
+	e := json.NewEncoder(w)
+	for _, res := range results {
+		if err := e.Encode(res); err != nil {
+			ERROR.Fatalln(err)
+		}
+	}
+	INFO.Printf("Converted %d groups\n", len(results))
+}
+
+// readP2Results reads the csv results of meta_p2, with columns l,m,v,n,t,b,
+// and returns a CovResult for each group (the b column), in their order in the file.
+// The fields are mapped as:
+//
+//	t = Ks: Ks = m, VarKs = v, N = n;
+//	t = P2: Ct = m (already normalized by Ks in meta_p2), CtN = n,
+//	        and CtIndices = l / 3, the lag in codons as in cov_genomes.
+//
+// Other types (P4), and columns (se, lo, hi), are not used.
+func readP2Results(r io.Reader) (results []CovResult, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[name] = i
+	}
+	for _, name := range []string{"l", "m", "v", "n", "t", "b"} {
+		if _, found := columns[name]; !found {
+			return nil, fmt.Errorf("missing column %s", name)
+		}
+	}
+
+	groups := make(map[string]int)
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) < len(header) {
+			return nil, fmt.Errorf("line %d: expect %d columns, got %d", line, len(header), len(rec))
+		}
+
+		lag, err := strconv.Atoi(rec[columns["l"]])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		m, err := strconv.ParseFloat(rec[columns["m"]], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		v, err := strconv.ParseFloat(rec[columns["v"]], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		n, err := strconv.Atoi(rec[columns["n"]])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		group := rec[columns["b"]]
+		i, found := groups[group]
+		if !found {
+			i = len(results)
+			groups[group] = i
+			results = append(results, CovResult{SchemaVersion: schemaVersion})
+		}
+		res := &results[i]
+		switch rec[columns["t"]] {
+		case "Ks":
+			res.Ks, res.VarKs, res.N = m, v, n
+		case "P2":
+			if math.IsNaN(m) {
+				continue
+			}
+			res.CtIndices = append(res.CtIndices, lag/3)
+			res.Ct = append(res.Ct, m)
+			res.CtN = append(res.CtN, n)
+		}
+	}
+	return results, nil
+}
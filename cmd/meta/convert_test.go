@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/mingzhi/meta/fit"
+)
+
+func TestReadP2Results(t *testing.T) {
+	// correlations decaying as fit.Exp, at codon lags 1 to 30, in two groups.
+	par := []float64{1, 2, 5}
+	var buf bytes.Buffer
+	buf.WriteString("l,m,v,n,t,b\n")
+	for _, group := range []string{"g1", "g2"} {
+		fmt.Fprintf(&buf, "0,0.01,0.0001,100,Ks,%s\n", group)
+		buf.WriteString("3,NaN,NaN,1,P2," + group + "\n")
+		for l := 1; l <= 30; l++ {
+			fmt.Fprintf(&buf, "%d,%g,0,100,P2,%s\n", 3*l, fit.Exp(float64(l), par), group)
+		}
+		fmt.Fprintf(&buf, "3,0.5,0,100,P4,%s\n", group)
+	}
+
+	results, err := readP2Results(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expect 2 results, got %d\n", len(results))
+	}
+
+	// round trip through json, as read by fit_genomes.
+	var js bytes.Buffer
+	e := json.NewEncoder(&js)
+	for _, res := range results {
+		if err := e.Encode(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resChan := make(chan CovResult)
+	go func() {
+		defer close(resChan)
+		d := json.NewDecoder(&js)
+		for {
+			var res CovResult
+			if err := d.Decode(&res); err != nil {
+				break
+			}
+			if res.Ks != 0.01 || res.VarKs != 0.0001 || res.N != 100 {
+				t.Errorf("Expect Ks 0.01, VarKs 0.0001 and N 100, got %g, %g and %d\n", res.Ks, res.VarKs, res.N)
+			}
+			if len(res.Ct) != 30 || res.CtIndices[0] != 1 || res.CtIndices[29] != 30 {
+				t.Errorf("Expect Ct at codon lags 1 to 30, got %v\n", res.CtIndices)
+			}
+			resChan <- res
+		}
+	}()
+
+	n := 0
+	for res := range doFit(fitExp, resChan, 0, 100, 0, 1, fitFilter{minR2: 0.99, minPoints: 3}) {
+		n++
+		for i, b := range []float64{res.B0, res.B1, res.B2} {
+			if math.Abs(b-par[i]) > 1e-3*par[i] {
+				t.Errorf("Expect parameter %d %g, got %g\n", i, par[i], b)
+			}
+		}
+	}
+	if n != 2 {
+		t.Errorf("Expect 2 fit results, got %d\n", n)
+	}
+}
+
+func TestReadP2ResultsErrors(t *testing.T) {
+	for _, bad := range []string{
+		"l,m,v,n,b\n0,0.01,0,1,g\n",
+		"l,m,v,n,t,b\nx,0.01,0,1,Ks,g\n",
+		"l,m,v,n,t,b\n0,0.01,0\n",
+	} {
+		if _, err := readP2Results(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q, Expect an error\n", bad)
+		}
+	}
+}
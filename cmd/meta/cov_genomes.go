@@ -300,6 +300,7 @@ func createCovResult(cc []*cov.Calculators, maxl, pos int) (res CovResult) {
 	}
 
 	// Process and return a cov result.
+	res.SchemaVersion = schemaVersion
 	res.Ks = c.Ks.Mean.GetResult()
 	res.VarKs = c.Ks.Var.GetResult()
 	res.N = c.Ks.Mean.GetN()
@@ -73,7 +73,7 @@ func (cmd *cmdCovReads) Run(args []string) {
 								switch funcName {
 								case "Cov_Reads_vs_Reads":
 									cmd.covFunc = cov.ReadsVsReads
-									sort.Sort(reads.ByRightCoordinatePairedEndReads{matedReads})
+									sort.Sort(reads.ByRightCoordinatePairedEndReads{PairedEndReads: matedReads})
 								case "Cov_Reads_vs_Genome":
 									cmd.covFunc = cov.ReadsVsGenome
 								default:
@@ -116,6 +116,7 @@ func (cmd *cmdCovReads) Cov(records reads.PairedEndReads,
 	kc, cc := cmd.covFunc(records, g, cmd.maxl, pos)
 
 	// Process and return a cov result.
+	res.SchemaVersion = schemaVersion
 	res.Ks = kc.Mean.GetResult()
 	res.VarKs = kc.Var.GetResult()
 	res.N = kc.Mean.GetN()
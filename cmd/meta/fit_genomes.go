@@ -3,22 +3,56 @@ package main
 import (
 	"compress/zlib"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/mingzhi/meta/fit"
 	"github.com/mingzhi/meta/strain"
 	"io"
-	"log"
 	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync/atomic"
 )
 
 type cmdFitGenomes struct {
+	model        *string  // model of the correlation decay.
+	fitBootstrap *int     // number of bootstrap refits of each result.
+	seed         *int64   // seed of the bootstrap random number generator.
+	minR2        *float64 // minimum R2 of the fits written.
+	minPoints    *int     // minimum number of points to fit.
+	validate     *bool    // only check the inputs.
 	cmdConfig
 }
 
+func (cmd *cmdFitGenomes) Flags(fs *flag.FlagSet) *flag.FlagSet {
+	cmd.cmdConfig.Flags(fs)
+	cmd.model = fs.String("model", "exp", "model of the correlation decay fitted in the fit.exp range: exp, power or linear")
+	cmd.fitBootstrap = fs.Int("fit-bootstrap", 0, "number of bootstrap refits for the 95% intervals of the parameters (0 for none)")
+	cmd.seed = fs.Int64("seed", 1, "seed of the random number generator for bootstrap refits")
+	cmd.minR2 = fs.Float64("min-r2", math.Inf(-1), "minimum R2 of the fits written; genomes of worse fits, such as flat profiles, are excluded")
+	cmd.minPoints = fs.Int("min-points", 3, "minimum number of points in the fit range to fit a result")
+	cmd.validate = fs.Bool("validate", false, "only check the config, species map and input cov files, without fitting")
+	return fs
+}
+
+// fitModels maps a model name to its fitting function.
+var fitModels = map[string]fitFunc{
+	"exp":    fitExp,
+	"power":  fitPower,
+	"linear": fitLinear,
+}
+
 func (cmd *cmdFitGenomes) Init() {
+	// Check the model before doing any work.
+	if _, found := fitModels[*cmd.model]; !found {
+		ERROR.Fatalf("unknown model %s, should be exp, power or linear", *cmd.model)
+	}
+	if *cmd.minPoints < 1 {
+		ERROR.Fatalf("-min-points should be at least 1, got %d", *cmd.minPoints)
+	}
+
 	// Parse config and settings.
 	cmd.ParseConfig()
 	// Load species map.
@@ -34,6 +68,12 @@ func (cmd *cmdFitGenomes) Init() {
 }
 
 func (cmd *cmdFitGenomes) Run(args []string) {
+	if *cmd.validate {
+		v := &validation{}
+		cmd.Validate(v)
+		v.report("fit_genomes")
+		return
+	}
 	cmd.Init()
 	type job struct {
 		strains []strain.Strain
@@ -91,20 +131,29 @@ func (cmd *cmdFitGenomes) RunOne(strains []strain.Strain, pos int, name string,
 
 	ncpu := runtime.GOMAXPROCS(0)
 	done := make(chan bool)
+	var numGenomes, skipped int64
 	for i := 0; i < ncpu; i++ {
 		go func() {
 			for s := range jobs {
 				MakeDir(filepath.Join(*cmd.workspace, cmd.fitOutBase, s.Path))
 				for _, g := range s.Genomes {
+					atomic.AddInt64(&numGenomes, 1)
 					filePrefix := fmt.Sprintf("%s_%s_%s_pos%d", g.RefAcc(), funcType, name, pos)
-					filePath := filepath.Join(*cmd.workspace, cmd.covOutBase, s.Path, filePrefix+"_boot.json.zip")
+					filePath := cmd.covFilePath(s, filePrefix)
+					results, err := fromJson(filePath)
+					if err != nil {
+						WARN.Printf("Skip %s: %v\n", filePath, err)
+						atomic.AddInt64(&skipped, 1)
+						continue
+					}
 					var f fitFunc
 					for _, fitCon := range cmd.fitControls {
 						if fitCon.end-fitCon.start > 0 {
 							name := fitCon.name
 							switch name {
 							case "exp":
-								f = fitExp
+								name = *cmd.model
+								f = fitModels[name]
 							case "hyper":
 								f = fitHyper
 							default:
@@ -112,8 +161,9 @@ func (cmd *cmdFitGenomes) RunOne(strains []strain.Strain, pos int, name string,
 							}
 
 							if f != nil {
-								resChan := fromJson(filePath)
-								fitResChan := doFit(f, resChan, fitCon.start, fitCon.end)
+								resChan := covResultChan(results)
+								filter := fitFilter{minR2: *cmd.minR2, minPoints: *cmd.minPoints}
+								fitResChan := doFit(f, resChan, fitCon.start, fitCon.end, *cmd.fitBootstrap, *cmd.seed, filter)
 								fitFileOutPath := filepath.Join(*cmd.workspace, cmd.fitOutBase, s.Path, filePrefix+"_"+name+"_boot.json")
 								toJson(fitFileOutPath, fitResChan)
 							}
@@ -128,22 +178,119 @@ func (cmd *cmdFitGenomes) RunOne(strains []strain.Strain, pos int, name string,
 	for i := 0; i < ncpu; i++ {
 		<-done
 	}
+	if skipped > 0 {
+		WARN.Printf("%s %s pos%d: skipped %d of %d genomes with unreadable cov results\n", funcType, name, pos, skipped, numGenomes)
+	}
+}
+
+// covFilePath returns the path of the cov results of a strain, with the file prefix of a genome.
+func (cmd *cmdFitGenomes) covFilePath(s strain.Strain, filePrefix string) string {
+	return filepath.Join(*cmd.workspace, cmd.covOutBase, s.Path, filePrefix+"_boot.json.zip")
+}
+
+// Validate checks the model, the config, the species map, and the cov files read by Run,
+// adding the problems to v.
+func (cmd *cmdFitGenomes) Validate(v *validation) {
+	if _, found := fitModels[*cmd.model]; !found {
+		v.addf("unknown model %s, should be exp, power or linear", *cmd.model)
+	}
+	if *cmd.minPoints < 1 {
+		v.addf("-min-points should be at least 1, got %d", *cmd.minPoints)
+	}
+	if !cmd.validateConfig(v) {
+		return
+	}
+	fitted := false
+	for _, fitCon := range cmd.fitControls {
+		fitted = fitted || fitCon.end-fitCon.start > 0
+	}
+	if !fitted {
+		v.addf("no fit range in the config (fit.exp or fit.hyper), nothing to fit")
+		return
+	}
+	positions := cmd.positions
+	if len(positions) == 0 {
+		positions = []int{4}
+	}
+	for _, strains := range cmd.speciesMap {
+		for _, s := range strains {
+			for _, g := range s.Genomes {
+				for _, pos := range positions {
+					for _, name := range []string{"core", "disp", "pan"} {
+						for _, funcType := range []string{"Cov_Genomes_vs_Genome", "Cov_Genomes_vs_Genomes"} {
+							filePrefix := fmt.Sprintf("%s_%s_%s_pos%d", g.RefAcc(), funcType, name, pos)
+							v.checkFile(cmd.covFilePath(s, filePrefix))
+						}
+					}
+				}
+			}
+		}
+	}
 }
 
 type FitResult struct {
+	SchemaVersion int // see schemaVersion.
+
+	Model      string // name of the fitted model.
 	Ks         float64
 	B0, B1, B2 float64
+	RSS        float64 // residual sum of squares over the fitted range.
+	R2         float64 // coefficient of determination over the fitted range.
+
+	// bootstrap 95% intervals of the parameters, NaN if every refit failed.
+	// NaN values are null in json.
+	B0Lo, B0Hi float64
+	B1Lo, B1Hi float64
+	B2Lo, B2Hi float64
 }
 
 type fitFunc func(xdata, ydata []float64) FitResult
 
-func doFit(f fitFunc, resChan chan CovResult, fitStart, fitEnd int) (fitResChan chan FitResult) {
+// fitFilter excludes the results too short to fit,
+// and the fits too poor for their parameters to mean anything.
+type fitFilter struct {
+	minR2     float64 // minimum R2 of a fit; -Inf for none.
+	minPoints int     // minimum number of points in the fit range.
+}
+
+// enough returns whether there are enough points to fit.
+func (ff fitFilter) enough(ydata []float64) bool {
+	return len(ydata) >= ff.minPoints && len(ydata) > 0
+}
+
+// good returns whether the fit is good enough to be written;
+// with a minimum R2, fits of undefined R2, such as of flat profiles, are not.
+func (ff fitFilter) good(res FitResult) bool {
+	return math.IsInf(ff.minR2, -1) || res.R2 >= ff.minR2
+}
+
+// doFit fits each result in the range [fitStart, fitEnd),
+// skipping those excluded by the filter.
+// If numBoot > 0, the intervals of the parameters are estimated
+// from numBoot bootstrap refits, by a generator seeded with seed plus the index
+// of the result in resChan, so that they do not depend on the scheduling of the workers.
+func doFit(f fitFunc, resChan chan CovResult, fitStart, fitEnd int, numBoot int, seed int64, filter fitFilter) (fitResChan chan FitResult) {
+	type job struct {
+		index int
+		r     CovResult
+	}
+	jobs := make(chan job)
+	go func() {
+		defer close(jobs)
+		index := 0
+		for r := range resChan {
+			jobs <- job{index: index, r: r}
+			index++
+		}
+	}()
+
 	ncpu := runtime.GOMAXPROCS(0)
 	done := make(chan bool)
 	fitResChan = make(chan FitResult)
 	for i := 0; i < ncpu; i++ {
 		go func() {
-			for r := range resChan {
+			for j := range jobs {
+				r := j.r
 				xdata := []float64{}
 				ydata := []float64{}
 				for i := 0; i < len(r.CtIndices) && r.CtIndices[i] < fitEnd; i++ {
@@ -152,8 +299,19 @@ func doFit(f fitFunc, resChan chan CovResult, fitStart, fitEnd int) (fitResChan
 						ydata = append(ydata, r.Ct[i])
 					}
 				}
+				if !filter.enough(ydata) {
+					continue
+				}
 				res := f(xdata, ydata)
+				res.SchemaVersion = schemaVersion
 				res.Ks = r.Ks
+				if !filter.good(res) {
+					continue
+				}
+				if numBoot > 0 {
+					rng := rand.New(rand.NewSource(seed + int64(j.index)))
+					bootFit(&res, f, xdata, ydata, numBoot, rng)
+				}
 				if !isNaN(res) {
 					fitResChan <- res
 				}
@@ -172,8 +330,30 @@ func doFit(f fitFunc, resChan chan CovResult, fitStart, fitEnd int) (fitResChan
 	return
 }
 
+// bootFit sets the bootstrap intervals of the parameters of res,
+// or NaNs if every refit failed.
+func bootFit(res *FitResult, f fitFunc, xdata, ydata []float64, numBoot int, rng *rand.Rand) {
+	params := func(xdata, ydata []float64) []float64 {
+		r := f(xdata, ydata)
+		return []float64{r.B0, r.B1, r.B2}
+	}
+	lo, hi := fit.Bootstrap(xdata, ydata, params, numBoot, rng)
+	if lo == nil {
+		nan := math.NaN()
+		res.B0Lo, res.B0Hi = nan, nan
+		res.B1Lo, res.B1Hi = nan, nan
+		res.B2Lo, res.B2Hi = nan, nan
+		return
+	}
+	res.B0Lo, res.B0Hi = lo[0], hi[0]
+	res.B1Lo, res.B1Hi = lo[1], hi[1]
+	res.B2Lo, res.B2Hi = lo[2], hi[2]
+}
+
 func fitHyper(xdata, ydata []float64) (res FitResult) {
 	par := fit.FitHyper(xdata, ydata)
+	res.Model = "hyper"
+	res.RSS, res.R2 = fit.GoodnessOfFit(xdata, ydata, fit.Hyper, par)
 	res.B0 = par[0]
 	res.B1 = par[1]
 	return
@@ -181,12 +361,32 @@ func fitHyper(xdata, ydata []float64) (res FitResult) {
 
 func fitExp(xdata, ydata []float64) (res FitResult) {
 	par := fit.FitExp(xdata, ydata)
+	res.Model = "exp"
+	res.RSS, res.R2 = fit.GoodnessOfFit(xdata, ydata, fit.Exp, par)
 	res.B0 = par[0]
 	res.B1 = par[1]
 	res.B2 = par[2]
 	return
 }
 
+func fitPower(xdata, ydata []float64) (res FitResult) {
+	par := fit.FitPower(xdata, ydata)
+	res.Model = "power"
+	res.RSS, res.R2 = fit.GoodnessOfFit(xdata, ydata, fit.Power, par)
+	res.B0 = par[0]
+	res.B1 = par[1]
+	return
+}
+
+func fitLinear(xdata, ydata []float64) (res FitResult) {
+	par := fit.FitLinear(xdata, ydata)
+	res.Model = "linear"
+	res.RSS, res.R2 = fit.GoodnessOfFit(xdata, ydata, fit.Linear, par)
+	res.B0 = par[0]
+	res.B1 = par[1]
+	return
+}
+
 func isNaN(res FitResult) bool {
 	floats := []float64{}
 	floats = append(floats, res.Ks)
@@ -203,38 +403,55 @@ func isNaN(res FitResult) bool {
 	return false
 }
 
-func fromJson(filePath string) (resChan chan CovResult) {
+// fromJson reads the cov results of a zlib compressed file.
+// It returns an error if the file can not be read or decoded,
+// such as a file partially written by an interrupted run,
+// which also fails the zlib checksum, or if a result is inconsistent.
+func fromJson(filePath string) ([]CovResult, error) {
 	f, err := os.Open(filePath)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	defer f.Close()
 
 	r, err := zlib.NewReader(f)
 	if err != nil {
-		log.Panicln(err)
+		return nil, err
 	}
+	defer r.Close()
+
+	return readCovResults(r)
+}
 
-	d := json.NewDecoder(r)
+// readCovResults decodes all the cov results in r,
+// checking that each result has a Ct at each of its CtIndices.
+func readCovResults(r io.Reader) (results []CovResult, err error) {
+	d := newCovResultDecoder(r)
+	for {
+		res, err := d.Decode()
+		if err != nil {
+			if err == io.EOF {
+				return results, nil
+			}
+			return nil, err
+		}
+		if len(res.Ct) != len(res.CtIndices) {
+			return nil, fmt.Errorf("result %d has %d Ct for %d CtIndices", len(results)+1, len(res.Ct), len(res.CtIndices))
+		}
+		results = append(results, res)
+	}
+}
 
-	resChan = make(chan CovResult)
+// covResultChan returns a channel of the results, closed after the last one.
+func covResultChan(results []CovResult) chan CovResult {
+	resChan := make(chan CovResult)
 	go func() {
 		defer close(resChan)
-		for {
-			res := CovResult{}
-			if err := d.Decode(&res); err != nil {
-				if err != io.EOF {
-					log.Println(err)
-				}
-				break
-			} else {
-				resChan <- res
-			}
+		for _, res := range results {
+			resChan <- res
 		}
-		r.Close()
-		f.Close()
 	}()
-
-	return
+	return resChan
 }
 
 func toJson(filePath string, fitResChan chan FitResult) {
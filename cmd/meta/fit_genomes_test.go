@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/mingzhi/meta/fit"
+)
+
+func TestDoFitFilter(t *testing.T) {
+	// a decaying profile, a flat noisy profile, and a profile of 2 points.
+	par := []float64{1, 2, 5}
+	decay := CovResult{Ks: 0.01}
+	flat := CovResult{Ks: 0.02}
+	short := CovResult{Ks: 0.03}
+	for l := 1; l <= 30; l++ {
+		decay.CtIndices = append(decay.CtIndices, l)
+		decay.Ct = append(decay.Ct, fit.Exp(float64(l), par))
+		flat.CtIndices = append(flat.CtIndices, l)
+		flat.Ct = append(flat.Ct, 0.5+0.01*math.Pow(-1, float64(l)))
+	}
+	short.CtIndices = []int{1, 2}
+	short.Ct = decay.Ct[:2]
+
+	testCases := []struct {
+		filter fitFilter
+		ks     []float64 // of the results fitted.
+	}{
+		{fitFilter{minR2: 0.5, minPoints: 3}, []float64{0.01}},
+		{fitFilter{minR2: math.Inf(-1), minPoints: 3}, []float64{0.01, 0.02}},
+	}
+	for _, tc := range testCases {
+		resChan := make(chan CovResult)
+		go func() {
+			defer close(resChan)
+			for _, r := range []CovResult{decay, flat, short} {
+				resChan <- r
+			}
+		}()
+		fitted := make(map[float64]FitResult)
+		for res := range doFit(fitExp, resChan, 0, 100, 0, 1, tc.filter) {
+			fitted[res.Ks] = res
+		}
+		if len(fitted) != len(tc.ks) {
+			t.Errorf("filter %+v, Expect %d results, got %v\n", tc.filter, len(tc.ks), fitted)
+		}
+		for _, ks := range tc.ks {
+			if _, found := fitted[ks]; !found {
+				t.Errorf("filter %+v, Expect the result of Ks %g, got %v\n", tc.filter, ks, fitted)
+			}
+		}
+		if res, found := fitted[0.01]; found && res.R2 < 0.99 {
+			t.Errorf("Expect R2 of the decaying profile close to 1, got %g\n", res.R2)
+		}
+	}
+}
+
+func TestFromJson(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fit_genomes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// compressed writes the results, compressed as written by cov_genomes.
+	compressed := func(results ...CovResult) []byte {
+		var buf bytes.Buffer
+		w := zlib.NewWriter(&buf)
+		e := json.NewEncoder(w)
+		for _, res := range results {
+			if err := e.Encode(res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	good := CovResult{SchemaVersion: schemaVersion, Ks: 0.01, CtIndices: []int{1, 2, 3}, Ct: []float64{0.3, 0.2, 0.1}}
+	mismatched := CovResult{SchemaVersion: schemaVersion, Ks: 0.02, CtIndices: []int{1, 2, 3}, Ct: []float64{0.3, 0.2}}
+	data := compressed(good, good, good)
+
+	testCases := []struct {
+		name   string
+		data   []byte
+		n      int
+		hasErr bool
+	}{
+		{"good", data, 3, false},
+		{"truncated", data[:len(data)-6], 0, true},
+		{"length mismatch", compressed(good, mismatched), 0, true},
+		{"not compressed", []byte("{}"), 0, true},
+	}
+	for _, tc := range testCases {
+		filePath := filepath.Join(dir, tc.name+"_boot.json.zip")
+		if err := ioutil.WriteFile(filePath, tc.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		results, err := fromJson(filePath)
+		if (err != nil) != tc.hasErr {
+			t.Errorf("%s, Expect error %v, got %v\n", tc.name, tc.hasErr, err)
+		}
+		if len(results) != tc.n {
+			t.Errorf("%s, Expect %d results, got %d\n", tc.name, tc.n, len(results))
+		}
+	}
+
+	if _, err := fromJson(filepath.Join(dir, "missing_boot.json.zip")); err == nil {
+		t.Errorf("Expect an error for a missing file\n")
+	}
+}
+
+func TestDoFitBootstrapSeed(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+
+	// decaying profiles with some noise, of different Ks.
+	rng := rand.New(rand.NewSource(1))
+	var results []CovResult
+	for k := 0; k < 20; k++ {
+		r := CovResult{Ks: 0.01 * float64(k+1)}
+		for l := 1; l <= 30; l++ {
+			r.CtIndices = append(r.CtIndices, l)
+			r.Ct = append(r.Ct, fit.Exp(float64(l), []float64{1, 2, 5})*(1+0.1*rng.NormFloat64()))
+		}
+		results = append(results, r)
+	}
+	run := func() map[float64]FitResult {
+		fitted := make(map[float64]FitResult)
+		for res := range doFit(fitExp, covResultChan(results), 0, 100, 20, 7, fitFilter{minR2: math.Inf(-1), minPoints: 3}) {
+			fitted[res.Ks] = res
+		}
+		return fitted
+	}
+
+	// the bootstrap of each result is the same, whichever worker fits it.
+	expected := run()
+	for i := 0; i < 5; i++ {
+		for ks, res := range run() {
+			e := expected[ks]
+			if res.B0Lo != e.B0Lo || res.B0Hi != e.B0Hi || res.B1Lo != e.B1Lo || res.B1Hi != e.B1Hi {
+				t.Fatalf("Ks %g, Expect the same bootstrap intervals, got %+v and %+v\n", ks, e, res)
+			}
+		}
+	}
+}
+
+func TestBootFitFailed(t *testing.T) {
+	// a fit whose refits all fail.
+	calls := 0
+	f := func(xdata, ydata []float64) FitResult {
+		calls++
+		if calls == 1 {
+			return FitResult{Ks: 0.01, B0: 1, B1: 2, B2: 5}
+		}
+		return FitResult{B0: math.NaN(), B1: math.NaN(), B2: math.NaN()}
+	}
+	xdata, ydata := []float64{1, 2, 3}, []float64{0.3, 0.2, 0.1}
+	res := f(xdata, ydata)
+	bootFit(&res, f, xdata, ydata, 10, rand.New(rand.NewSource(1)))
+	for _, v := range []float64{res.B0Lo, res.B0Hi, res.B1Lo, res.B1Hi, res.B2Lo, res.B2Hi} {
+		if !math.IsNaN(v) {
+			t.Errorf("Expect NaN bootstrap intervals, got %+v\n", res)
+			break
+		}
+	}
+
+	// NaN values are written as null, and read back as NaN.
+	b, err := json.Marshal([]FitResult{res})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"B1Lo":null`)) {
+		t.Errorf("Expect B1Lo null, got %s\n", b)
+	}
+	var decoded []FitResult
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if d := decoded[0]; d.B1 != 2 || !math.IsNaN(d.B1Lo) || !math.IsNaN(d.B2Hi) {
+		t.Errorf("Expect B1 2 and NaN intervals, got %+v\n", d)
+	}
+
+	// values missing in older files are 0.
+	decoded = nil
+	if err := json.Unmarshal([]byte(`[{"Ks":0.01,"B1":3}]`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if d := decoded[0]; d.B1 != 3 || d.B1Lo != 0 || d.R2 != 0 {
+		t.Errorf("Expect B1 3 and 0 intervals, got %+v\n", d)
+	}
+}
@@ -1,28 +1,29 @@
 package main
 
 import (
-	"github.com/rakyll/command"
+	"flag"
 	"log"
-	"os"
+
+	"github.com/mingzhi/meta"
+	"github.com/rakyll/command"
 )
 
+// Loggers, those of the meta package.
 var (
-	INFO  *log.Logger
-	WARN  *log.Logger
-	ERROR *log.Logger
+	INFO  = meta.INFO
+	WARN  = meta.WARN
+	ERROR = meta.ERROR
 )
 
 func main() {
-	// Register loggers.
-	INFO = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-	WARN = log.New(os.Stdout, "WARN: ", log.Ldate|log.Ltime|log.Lshortfile)
-	ERROR = log.New(os.Stderr, "ERROR:", log.Ldate|log.Ltime|log.Lshortfile)
+	logLevel := flag.String("log-level", "info", "log level: debug, info, warn or error")
+	quiet := flag.Bool("quiet", false, "only log errors")
 
 	// Register commands.
 	args := []string{}
 	command.On("init", "generate strain information", &cmdInit{}, args)
 	command.On("ortho_mcl", "find orthologs using OrthoMCL", &cmdOrthoMCL{}, args)
-	command.On("ortho_aln", "align orthologs using MUSCLE", &cmdOrthoAln{}, args)
+	command.On("ortho_aln", "align orthologs using MUSCLE or MAFFT", &cmdOrthoAln{}, args)
 	command.On("cov_reads", "calculate correlation of subsitutions in reads", &cmdCovReads{}, args)
 	command.On("cov_genomes", "calculate correlation of subsitutions in genomes", &cmdCovGenomes{}, args)
 	command.On("bowtie2_index", "build bowtie2 index", &cmdIndex{}, []string{})
@@ -30,7 +31,15 @@ func main() {
 	command.On("scaffold_merge", "merge scaffolds", &cmdScaffoldMerge{}, args)
 	command.On("genome_profile", "genome position profiling", &cmdGenomeProfile{}, args)
 	command.On("fit_genomes", "fit genome cov results", &cmdFitGenomes{}, args)
+	command.On("convert", "convert csv results of meta_p2 to cov results in json", &cmdConvert{}, args)
 
 	// Parse and run commands.
-	command.ParseAndRun()
+	command.Parse()
+	level, err := meta.ParseLevel(*logLevel)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	meta.SetLevel(level)
+	meta.SetQuiet(*quiet)
+	command.Run()
 }
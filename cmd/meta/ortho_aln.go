@@ -1,26 +1,80 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/cheggaaa/pb"
 	"github.com/mingzhi/biogo/seq"
 	"github.com/mingzhi/meta/align/multi"
 	"github.com/mingzhi/meta/genome"
 	"github.com/mingzhi/meta/strain"
 	"github.com/mingzhi/ncbiftp/seqrecord"
+	"io"
 	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 )
 
 // Command to align orthologs.
 type cmdOrthoAln struct {
-	cmdConfig // embed cmdConfig.
+	aligner    *string        // multiple sequence alignment backend.
+	alnTimeout *time.Duration // timeout of aligning a cluster.
+	progress   *bool          // show a progress bar of the alignments.
+	cacheDir   *string        // directory of cached alignments.
+	outFormat  *string        // format of the aligned orthologs.
+	minCluster *int           // min number of sequences of a cluster aligned.
+	validate   *bool          // only check the inputs.
+	cmdConfig                 // embed cmdConfig.
+}
+
+func (cmd *cmdOrthoAln) Flags(fs *flag.FlagSet) *flag.FlagSet {
+	cmd.cmdConfig.Flags(fs)
+	cmd.aligner = fs.String("aligner", "muscle", "multiple sequence aligner: "+strings.Join(multi.Aligners(), ", "))
+	cmd.alnTimeout = fs.Duration("aln-timeout", 0, "timeout of aligning a cluster, which is then skipped, e.g. 10m (0 for no timeout)")
+	cmd.cacheDir = fs.String("cache-dir", "", "directory caching alignments, so that unchanged clusters are not aligned again on rerun")
+	cmd.outFormat = fs.String("output-format", "json", "format of the aligned orthologs: json, or fasta for a directory of one FASTA file per cluster")
+	cmd.minCluster = fs.Int("min-cluster-size", 3, "min number of sequences of a cluster to align it")
+	cmd.progress = fs.Bool("progress", false, "show a progress bar of the alignments")
+	cmd.validate = fs.Bool("validate", false, "only check the config, species map, input files and aligner, without aligning")
+	return fs
 }
 
 // Run command.
 func (cmd *cmdOrthoAln) Run(args []string) {
+	if *cmd.validate {
+		v := &validation{}
+		cmd.Validate(v)
+		v.report("ortho_aln")
+		return
+	}
+	if *cmd.outFormat != "json" && *cmd.outFormat != "fasta" {
+		ERROR.Fatalf("unknown output format %s, should be json or fasta\n", *cmd.outFormat)
+	}
+	if *cmd.minCluster < 2 {
+		ERROR.Fatalf("-min-cluster-size should be at least 2, got %d\n", *cmd.minCluster)
+	}
+	// Check the aligner before doing any work.
+	// With a cache, it is only needed for uncached clusters.
+	alignFunc, err := multi.NewAlignFunc(*cmd.aligner)
+	if err != nil {
+		if *cmd.cacheDir == "" {
+			ERROR.Fatalln(err)
+		}
+		WARN.Printf("%v, only cached alignments are available\n", err)
+		lookErr := err
+		alignFunc = func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, options ...string) error {
+			return lookErr
+		}
+	}
+	if *cmd.cacheDir != "" {
+		alignFunc = multi.Cache(*cmd.cacheDir, *cmd.aligner, alignFunc)
+	}
+
 	// Parse config and settings.
 	cmd.ParseConfig()
 	cmd.LoadSpeciesMap()
@@ -28,23 +82,36 @@ func (cmd *cmdOrthoAln) Run(args []string) {
 
 	for prefix, strains := range cmd.speciesMap {
 		// Read ortholog protein clusters.
-		rawClusters := cmd.ReadOrhtologs(prefix)
+		rawClusters, err := cmd.ReadOrhtologs(prefix)
+		if err != nil {
+			ERROR.Printf("Skip %s: %v\n", prefix, err)
+			continue
+		}
 		// filter outliers based on their lengths.
 		clusters := []seqrecord.SeqRecords{}
+		small := 0
 		for i := 0; i < len(rawClusters); i++ {
 			records := rawClusters[i]
-			if len(records) >= 3 {
-				cls := filter(rawClusters[i])
-				if len(cls) == len(records) {
-					clusters = append(clusters, cls)
-				}
+			if len(records) < *cmd.minCluster {
+				small++
+				continue
+			}
+			if err := checkCluster(records); err != nil {
+				WARN.Printf("Skip the cluster of %s: %v\n", records[0].Id, err)
+				continue
+			}
+			cls := filter(records)
+			if len(cls) == len(records) {
+				clusters = append(clusters, cls)
 			}
-
+		}
+		if small > 0 {
+			INFO.Printf("%s: skip %d clusters of fewer than %d sequences\n", prefix, small, *cmd.minCluster)
 		}
 
 		if len(clusters) > 0 {
 			// align coding regions (protein clusters).
-			alns := align(clusters, multi.AlignProt, *cmd.ncpu)
+			alns := cmd.align(prefix, clusters, multi.AlignProt, alignFunc)
 			cmd.SaveAlignments(prefix, alns)
 
 			// expand gene to include its adjacent non-coding regions.
@@ -57,7 +124,7 @@ func (cmd *cmdOrthoAln) Run(args []string) {
 					expandedClusters = append(expandedClusters, filter(expandedRecords))
 				}
 			}
-			expandedAlns := align(expandedClusters, multi.AlignNucl, *cmd.ncpu)
+			expandedAlns := cmd.align(prefix+" "+appendix, expandedClusters, multi.AlignNucl, alignFunc)
 			cmd.SaveAlignments(prefix, expandedAlns, appendix)
 		} else {
 			WARN.Printf("%s has zero orthologous cluster\n", prefix)
@@ -65,15 +132,87 @@ func (cmd *cmdOrthoAln) Run(args []string) {
 	}
 }
 
-type multiAlignFunc func(seqRecords []seqrecord.SeqRecord, alignFunc multi.AlignFunc, options ...string) []seqrecord.SeqRecord
+// Validate checks the options, the aligner, the config, the species map,
+// and the ortholog and genome files read by Run, adding the problems to v.
+func (cmd *cmdOrthoAln) Validate(v *validation) {
+	if *cmd.outFormat != "json" && *cmd.outFormat != "fasta" {
+		v.addf("unknown output format %s, should be json or fasta", *cmd.outFormat)
+	}
+	if *cmd.minCluster < 2 {
+		v.addf("-min-cluster-size should be at least 2, got %d", *cmd.minCluster)
+	}
+	if _, err := multi.NewAlignFunc(*cmd.aligner); err != nil {
+		if *cmd.cacheDir == "" {
+			v.addf("%v", err)
+		} else {
+			WARN.Printf("%v, only cached alignments are available\n", err)
+		}
+	}
+	if !cmd.validateConfig(v) {
+		return
+	}
+	for prefix, strains := range cmd.speciesMap {
+		v.checkFile(cmd.orthologsPath(prefix))
+		for _, s := range strains {
+			for _, g := range s.Genomes {
+				v.checkFile(filepath.Join(cmd.refBase, s.Path, g.RefAcc()+".pos"))
+			}
+		}
+	}
+}
+
+// align aligns clusters, showing a progress bar labelled with name if requested.
+func (cmd *cmdOrthoAln) align(name string, clusters []seqrecord.SeqRecords, multiAlign multiAlignFunc, alignFunc multi.AlignFunc) []seqrecord.SeqRecords {
+	progress := func() {}
+	if *cmd.progress {
+		total := 0
+		for _, cluster := range clusters {
+			if len(cluster) >= *cmd.minCluster {
+				total++
+			}
+		}
+		bar := pb.New(total).Prefix(name + " ")
+		bar.Output = os.Stderr
+		// Start log messages on a new line, not in the middle of the bar.
+		warnOut, errorOut := WARN.Writer(), ERROR.Writer()
+		WARN.SetOutput(barBreaker{bar: bar.Output, w: warnOut})
+		ERROR.SetOutput(barBreaker{bar: bar.Output, w: errorOut})
+		defer func() {
+			bar.Finish()
+			WARN.SetOutput(warnOut)
+			ERROR.SetOutput(errorOut)
+		}()
+		bar.Start()
+		progress = func() { bar.Increment() }
+	}
+	return align(context.Background(), clusters, multiAlign, alignFunc, *cmd.minCluster, *cmd.alnTimeout, *cmd.ncpu, progress)
+}
+
+// barBreaker writes a newline to the progress bar output
+// before each write to w.
+type barBreaker struct {
+	bar io.Writer
+	w   io.Writer
+}
+
+func (b barBreaker) Write(p []byte) (int, error) {
+	fmt.Fprintln(b.bar)
+	return b.w.Write(p)
+}
 
-func align(clusters []seqrecord.SeqRecords, alignFunc multiAlignFunc, ncpu int) (alns []seqrecord.SeqRecords) {
+type multiAlignFunc func(ctx context.Context, seqRecords []seqrecord.SeqRecord, alignFunc multi.AlignFunc, options ...string) ([]seqrecord.SeqRecord, error)
+
+// align aligns the clusters of at least minSize sequences in parallel.
+// If timeout > 0, the alignment of a cluster taking longer is killed,
+// and the cluster is skipped with a warning, as are failed alignments.
+// progress is called after each cluster is done.
+func align(ctx context.Context, clusters []seqrecord.SeqRecords, multiAlign multiAlignFunc, alignFunc multi.AlignFunc, minSize int, timeout time.Duration, ncpu int, progress func()) (alns []seqrecord.SeqRecords) {
 	// Create a job for each sequence records.
 	jobs := make(chan seqrecord.SeqRecords)
 	go func() {
 		defer close(jobs)
 		for _, cluster := range clusters {
-			if len(cluster) >= 3 {
+			if len(cluster) >= minSize {
 				jobs <- cluster
 			}
 		}
@@ -89,7 +228,12 @@ func align(clusters []seqrecord.SeqRecords, alignFunc multiAlignFunc, ncpu int)
 	for i := 0; i < numWorker; i++ {
 		go func() {
 			for cluster := range jobs {
-				aln := alignFunc(cluster, multi.Muscle)
+				aln, err := alignCluster(ctx, cluster, multiAlign, alignFunc, timeout)
+				progress()
+				if err != nil {
+					WARN.Printf("Skip the cluster of %s: %v\n", cluster[0].Id, err)
+					continue
+				}
 				results <- aln
 			}
 			done <- true
@@ -112,6 +256,16 @@ func align(clusters []seqrecord.SeqRecords, alignFunc multiAlignFunc, ncpu int)
 	return
 }
 
+// alignCluster aligns a cluster, killing the aligner after timeout if timeout > 0.
+func alignCluster(ctx context.Context, cluster seqrecord.SeqRecords, multiAlign multiAlignFunc, alignFunc multi.AlignFunc, timeout time.Duration) ([]seqrecord.SeqRecord, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return multiAlign(ctx, cluster, alignFunc)
+}
+
 // return a map[string]genome.Genome
 func getGenomeMap(strains []strain.Strain, refBase string) (genomeMap map[string]genome.Genome) {
 	genomeMap = make(map[string]genome.Genome)
@@ -252,24 +406,57 @@ func filter(records seqrecord.SeqRecords) seqrecord.SeqRecords {
 	return news
 }
 
-func (cmd *cmdOrthoAln) ReadOrhtologs(prefix string) (groups []seqrecord.SeqRecords) {
-	fileName := prefix + "_orthologs.json"
-	filePath := filepath.Join(*cmd.workspace, cmd.orthoOutBase,
-		fileName)
+// orthologsPath returns the path of the ortholog clusters of a species.
+func (cmd *cmdOrthoAln) orthologsPath(prefix string) string {
+	return filepath.Join(*cmd.workspace, cmd.orthoOutBase, prefix+"_orthologs.json")
+}
+
+// ReadOrhtologs reads the ortholog clusters of a species.
+// A missing file is warned about, and has no clusters;
+// an empty file has no clusters either.
+func (cmd *cmdOrthoAln) ReadOrhtologs(prefix string) (groups []seqrecord.SeqRecords, err error) {
+	filePath := cmd.orthologsPath(prefix)
 	r, err := os.Open(filePath)
 	if err != nil {
 		WARN.Println(err)
-		return
+		return nil, nil
 	}
 	defer r.Close()
 
-	decoder := json.NewDecoder(r)
-	err = decoder.Decode(&groups)
+	groups, err = readOrthologs(r)
 	if err != nil {
-		ERROR.Fatalln(err)
+		return nil, fmt.Errorf("%s: %v", filePath, err)
 	}
+	return groups, nil
+}
 
-	return
+// readOrthologs decodes the JSON array of ortholog clusters in r.
+func readOrthologs(r io.Reader) (groups []seqrecord.SeqRecords, err error) {
+	if err := json.NewDecoder(r).Decode(&groups); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("malformed ortholog clusters: %v", err)
+	}
+	return groups, nil
+}
+
+// checkCluster returns an error if a cluster can not be aligned:
+// if it has an empty sequence, or two records of the same id and genome,
+// which would have the same name in the input of the aligner.
+func checkCluster(records seqrecord.SeqRecords) error {
+	seen := make(map[string]bool)
+	for _, sr := range records {
+		if len(sr.Prot) == 0 || len(sr.Nucl) == 0 {
+			return fmt.Errorf("empty sequence of %s", sr.Id)
+		}
+		name := sr.Id + "|" + sr.Genome
+		if seen[name] {
+			return fmt.Errorf("duplicate sequence %s", name)
+		}
+		seen[name] = true
+	}
+	return nil
 }
 
 func (cmd *cmdOrthoAln) SaveAlignments(prefix string, alns []seqrecord.SeqRecords, appendix ...string) {
@@ -278,18 +465,58 @@ func (cmd *cmdOrthoAln) SaveAlignments(prefix string, alns []seqrecord.SeqRecord
 		prefixTerms = append(prefixTerms, appendix...)
 	}
 
-	fileName := strings.Join(prefixTerms, "_") + "_orthologs_aligned.json"
+	fileName := strings.Join(prefixTerms, "_") + "_orthologs_aligned"
 	filePath := filepath.Join(*cmd.workspace, cmd.orthoOutBase,
 		fileName)
-	w, err := os.Create(filePath)
-	if err != nil {
+	if err := writeAlignments(filePath, alns, *cmd.outFormat); err != nil {
 		ERROR.Fatalln(err)
 	}
-	defer w.Close()
+}
 
-	encoder := json.NewEncoder(w)
-	err = encoder.Encode(alns)
-	if err != nil {
-		ERROR.Fatalln(err)
+// writeAlignments writes the alignments to filePath + ".json",
+// or, in the fasta format, to the directory filePath,
+// with a FASTA file of aligned nucleotide sequences per cluster.
+func writeAlignments(filePath string, alns []seqrecord.SeqRecords, format string) error {
+	switch format {
+	case "json":
+		w, err := os.Create(filePath + ".json")
+		if err != nil {
+			return err
+		}
+		if err := json.NewEncoder(w).Encode(alns); err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	case "fasta":
+		if err := os.MkdirAll(filePath, 0777); err != nil {
+			return err
+		}
+		for i, aln := range alns {
+			w, err := os.Create(filepath.Join(filePath, fmt.Sprintf("cluster_%05d.fasta", i+1)))
+			if err != nil {
+				return err
+			}
+			if err := writeFasta(w, aln); err != nil {
+				w.Close()
+				return err
+			}
+			if err := w.Close(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown output format %s", format)
+}
+
+// writeFasta writes the aligned nucleotide sequences of a cluster,
+// with their ids as headers, followed by their genomes.
+func writeFasta(w io.Writer, aln seqrecord.SeqRecords) error {
+	for _, sr := range aln {
+		if _, err := fmt.Fprintf(w, ">%s %s\n%s\n", sr.Id, sr.Genome, sr.Nucl); err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mingzhi/meta/align/multi"
+	"github.com/mingzhi/ncbiftp/seqrecord"
+)
+
+func TestReadOrthologs(t *testing.T) {
+	testCases := []struct {
+		name   string
+		data   string
+		n      int
+		hasErr bool
+	}{
+		{"empty file", "", 0, false},
+		{"no clusters", "[]", 0, false},
+		{"clusters", `[[{"Id": "g1", "Genome": "A"}], [{"Id": "g2"}, {"Id": "g3"}]]`, 2, false},
+		{"malformed", `[[{"Id": "g1"`, 0, true},
+		{"not clusters", `{"Id": "g1"}`, 0, true},
+	}
+	for _, tc := range testCases {
+		groups, err := readOrthologs(strings.NewReader(tc.data))
+		if (err != nil) != tc.hasErr {
+			t.Errorf("%s, Expect error %v, got %v\n", tc.name, tc.hasErr, err)
+		}
+		if len(groups) != tc.n {
+			t.Errorf("%s, Expect %d clusters, got %d\n", tc.name, tc.n, len(groups))
+		}
+	}
+}
+
+func TestCheckCluster(t *testing.T) {
+	record := func(id, genome, nucl string) seqrecord.SeqRecord {
+		return seqrecord.SeqRecord{Id: id, Genome: genome, Nucl: []byte(nucl), Prot: []byte("MK")}
+	}
+	testCases := []struct {
+		name    string
+		records seqrecord.SeqRecords
+		hasErr  bool
+	}{
+		{"good", seqrecord.SeqRecords{record("g1", "A", "ATGAAA"), record("g2", "B", "ATGAAG"), record("g1", "C", "ATGAAA")}, false},
+		{"duplicate ids", seqrecord.SeqRecords{record("g1", "A", "ATGAAA"), record("g2", "B", "ATGAAG"), record("g1", "A", "ATGAAA")}, true},
+		{"empty sequence", seqrecord.SeqRecords{record("g1", "A", "ATGAAA"), record("g2", "B", ""), record("g3", "C", "ATGAAA")}, true},
+		{"empty protein", seqrecord.SeqRecords{record("g1", "A", "ATGAAA"), {Id: "g2", Genome: "B", Nucl: []byte("ATGAAG")}}, true},
+	}
+	for _, tc := range testCases {
+		if err := checkCluster(tc.records); (err != nil) != tc.hasErr {
+			t.Errorf("%s, Expect error %v, got %v\n", tc.name, tc.hasErr, err)
+		}
+	}
+}
+
+func TestAlignMinSize(t *testing.T) {
+	// a fake alignment returning its input.
+	multiAlign := func(ctx context.Context, seqRecords []seqrecord.SeqRecord, alignFunc multi.AlignFunc, options ...string) ([]seqrecord.SeqRecord, error) {
+		return seqRecords, nil
+	}
+	var clusters []seqrecord.SeqRecords
+	for size := 0; size <= 4; size++ {
+		cluster := seqrecord.SeqRecords{}
+		for i := 0; i < size; i++ {
+			cluster = append(cluster, seqrecord.SeqRecord{Id: "g"})
+		}
+		clusters = append(clusters, cluster)
+	}
+	for _, minSize := range []int{2, 3} {
+		alns := align(context.Background(), clusters, multiAlign, nil, minSize, 0, 2, func() {})
+		if len(alns) != 5-minSize {
+			t.Errorf("min size %d, Expect %d clusters aligned, got %d\n", minSize, 5-minSize, len(alns))
+		}
+		for _, aln := range alns {
+			if len(aln) < minSize {
+				t.Errorf("min size %d, Expect no cluster of %d sequences\n", minSize, len(aln))
+			}
+		}
+	}
+}
+
+func TestWriteAlignments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ortho_aln")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	alns := []seqrecord.SeqRecords{
+		{{Id: "g1_1", Genome: "A", Nucl: []byte("ATG-AA")}, {Id: "g1_2", Genome: "B", Nucl: []byte("ATGCAA")}},
+		{{Id: "g2_1", Genome: "A", Nucl: []byte("TTT")}, {Id: "g2_2", Genome: "C", Nucl: []byte("TT-")}},
+	}
+	check := func(format string, got []seqrecord.SeqRecords) {
+		if len(got) != len(alns) {
+			t.Fatalf("%s, Expect %d alignments, got %d\n", format, len(alns), len(got))
+		}
+		for i, aln := range alns {
+			if len(got[i]) != len(aln) {
+				t.Fatalf("%s, cluster %d, Expect %d sequences, got %d\n", format, i, len(aln), len(got[i]))
+			}
+			for k, sr := range aln {
+				g := got[i][k]
+				if g.Id != sr.Id || g.Genome != sr.Genome || string(g.Nucl) != string(sr.Nucl) {
+					t.Errorf("%s, cluster %d, Expect %s %s %s, got %s %s %s\n", format, i, sr.Id, sr.Genome, sr.Nucl, g.Id, g.Genome, g.Nucl)
+				}
+			}
+		}
+	}
+
+	filePath := filepath.Join(dir, "alns")
+	if err := writeAlignments(filePath, alns, "json"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(filePath + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []seqrecord.SeqRecords
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	check("json", got)
+
+	// a FASTA file per cluster, of headers ">id genome".
+	if err := writeAlignments(filePath, alns, "fasta"); err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for i := range alns {
+		b, err := ioutil.ReadFile(filepath.Join(filePath, fmt.Sprintf("cluster_%05d.fasta", i+1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var aln seqrecord.SeqRecords
+		for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+			if strings.HasPrefix(line, ">") {
+				fields := strings.Fields(line[1:])
+				aln = append(aln, seqrecord.SeqRecord{Id: fields[0], Genome: fields[1]})
+			} else {
+				aln[len(aln)-1].Nucl = append(aln[len(aln)-1].Nucl, line...)
+			}
+		}
+		got = append(got, aln)
+	}
+	check("fasta", got)
+
+	if err := writeAlignments(filePath, alns, "xml"); err == nil {
+		t.Errorf("Expect an error for an unknown format\n")
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// schemaVersion is the version of the json CovResult and FitResult written by the commands.
+// It is increased when a change of the fields would make older readers misread them;
+// files without a version (written before versions) are version 0.
+const schemaVersion = 1
+
+// checkSchemaVersion checks the schema version v of a decoded result, of the type name.
+// Older versions can be migrated, newer ones are not understood, and are an error.
+func checkSchemaVersion(name string, v int) error {
+	if v > schemaVersion {
+		return fmt.Errorf("%s schema version %d is newer than the supported version %d", name, v, schemaVersion)
+	}
+	return nil
+}
+
+// warnMigration warns, once, that results of the type name are migrated from version v.
+func warnMigration(once *sync.Once, name string, v int) {
+	once.Do(func() {
+		WARN.Printf("%s schema version %d, migrating to version %d\n", name, v, schemaVersion)
+	})
+}
+
+// migrateCovResult migrates a CovResult of an older schema version to the current one.
+// Version 0 has the same fields, only without the version.
+func migrateCovResult(res *CovResult) {
+	res.SchemaVersion = schemaVersion
+}
+
+// MarshalJSON writes a FitResult with null for the NaN values
+// of R2 and of the bootstrap intervals, which json can not encode.
+func (res FitResult) MarshalJSON() ([]byte, error) {
+	type plain FitResult
+	nullable := func(v float64) *float64 {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+		return &v
+	}
+	return json.Marshal(struct {
+		plain
+		R2                                 *float64
+		B0Lo, B0Hi, B1Lo, B1Hi, B2Lo, B2Hi *float64
+	}{plain(res), nullable(res.R2),
+		nullable(res.B0Lo), nullable(res.B0Hi), nullable(res.B1Lo), nullable(res.B1Hi), nullable(res.B2Lo), nullable(res.B2Hi)})
+}
+
+// UnmarshalJSON reads a FitResult written by MarshalJSON, null values as NaN;
+// missing values, of files written without them, are 0.
+func (res *FitResult) UnmarshalJSON(b []byte) error {
+	type plain FitResult
+	var nullable struct {
+		*plain
+		R2                                 json.RawMessage
+		B0Lo, B0Hi, B1Lo, B1Hi, B2Lo, B2Hi json.RawMessage
+	}
+	nullable.plain = (*plain)(res)
+	if err := json.Unmarshal(b, &nullable); err != nil {
+		return err
+	}
+	for _, field := range []struct {
+		raw json.RawMessage
+		v   *float64
+	}{
+		{nullable.R2, &res.R2},
+		{nullable.B0Lo, &res.B0Lo}, {nullable.B0Hi, &res.B0Hi},
+		{nullable.B1Lo, &res.B1Lo}, {nullable.B1Hi, &res.B1Hi},
+		{nullable.B2Lo, &res.B2Lo}, {nullable.B2Hi, &res.B2Hi},
+	} {
+		switch {
+		case len(field.raw) == 0:
+		case string(field.raw) == "null":
+			*field.v = math.NaN()
+		default:
+			if err := json.Unmarshal(field.raw, field.v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// covResultDecoder decodes CovResults, checking and migrating their schema version,
+// with a warning at the first migrated result.
+type covResultDecoder struct {
+	d    *json.Decoder
+	once sync.Once
+}
+
+func newCovResultDecoder(r io.Reader) *covResultDecoder {
+	return &covResultDecoder{d: json.NewDecoder(r)}
+}
+
+// Decode decodes the next CovResult. It returns io.EOF after the last result.
+func (cd *covResultDecoder) Decode() (res CovResult, err error) {
+	if err := cd.d.Decode(&res); err != nil {
+		return res, err
+	}
+	if err := checkSchemaVersion("CovResult", res.SchemaVersion); err != nil {
+		return res, err
+	}
+	if res.SchemaVersion < schemaVersion {
+		warnMigration(&cd.once, "CovResult", res.SchemaVersion)
+		migrateCovResult(&res)
+	}
+	return res, nil
+}
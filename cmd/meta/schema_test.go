@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCovResultDecoder(t *testing.T) {
+	// a versioned result, and one written before versions.
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(CovResult{SchemaVersion: schemaVersion, Ks: 0.01, CtIndices: []int{1}, Ct: []float64{0.5}}); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString(`{"Ks":0.02,"VarKs":0.001,"Ct":[0.25],"CtIndices":[2],"CtN":[10],"N":5}` + "\n")
+
+	d := newCovResultDecoder(&buf)
+	expected := []CovResult{
+		{Ks: 0.01, CtIndices: []int{1}, Ct: []float64{0.5}},
+		{Ks: 0.02, VarKs: 0.001, CtIndices: []int{2}, Ct: []float64{0.25}, CtN: []int{10}, N: 5},
+	}
+	for i, e := range expected {
+		res, err := d.Decode()
+		if err != nil {
+			t.Fatalf("result %d: %v", i, err)
+		}
+		if res.SchemaVersion != schemaVersion {
+			t.Errorf("result %d, Expect version %d, got %d\n", i, schemaVersion, res.SchemaVersion)
+		}
+		if res.Ks != e.Ks || res.VarKs != e.VarKs || res.N != e.N || res.CtIndices[0] != e.CtIndices[0] || res.Ct[0] != e.Ct[0] {
+			t.Errorf("result %d, Expect %v, got %v\n", i, e, res)
+		}
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("Expect io.EOF after the last result, got %v\n", err)
+	}
+
+	// a newer version is not decoded.
+	d = newCovResultDecoder(strings.NewReader(`{"SchemaVersion":2,"Ks":0.01}`))
+	if _, err := d.Decode(); err == nil {
+		t.Errorf("Expect an error for version 2\n")
+	}
+}
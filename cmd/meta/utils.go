@@ -6,6 +6,8 @@ import (
 )
 
 type CovResult struct {
+	SchemaVersion int // see schemaVersion.
+
 	Ks, VarKs float64
 	Ct        []float64
 	MeanXY    []float64
@@ -69,14 +71,13 @@ func save2Jsons(cr []CovResult, fileName string) {
 func readCovResult(fileName string) (cr CovResult) {
 	r, err := os.Open(fileName)
 	if err != nil {
-		ERROR.Fatalln("Cannot open file: %s, %v", fileName, err)
+		ERROR.Fatalf("Cannot open file: %s, %v", fileName, err)
 	}
 	defer r.Close()
 
-	decoder := json.NewDecoder(r)
-	err = decoder.Decode(&cr)
+	cr, err = newCovResultDecoder(r).Decode()
 	if err != nil {
-		ERROR.Fatalln("Cannot decode file: %s, with CovResult: %v", fileName, err)
+		ERROR.Fatalf("Cannot decode file: %s, with CovResult: %v", fileName, err)
 	}
 	return
 }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mingzhi/meta/strain"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// validation collects the problems found when checking the inputs of a command
+// (with --validate), without processing any data.
+type validation struct {
+	problems []string
+}
+
+func (v *validation) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// checkFile checks that a file exists and is readable,
+// and returns false otherwise.
+func (v *validation) checkFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		v.addf("%v", err)
+		return false
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil {
+		v.addf("%v", err)
+		return false
+	} else if fi.IsDir() {
+		v.addf("%s is a directory", path)
+		return false
+	}
+	return true
+}
+
+// report logs the problems of the command name,
+// and exits with a non-zero status if there are any.
+func (v *validation) report(name string) {
+	if len(v.problems) == 0 {
+		INFO.Printf("%s: all inputs are valid\n", name)
+		return
+	}
+	for _, p := range v.problems {
+		ERROR.Println(p)
+	}
+	ERROR.Fatalf("%s: %d problems found\n", name, len(v.problems))
+}
+
+// validateConfig parses the configure files and loads the species map, as
+// ParseConfig and LoadSpeciesMap do, but adds the missing files to v,
+// rather than stopping at the first one.
+// Strains with a missing genome (.fna) file are left out of the species map.
+// It returns false if the species map cannot be loaded.
+func (cmd *cmdConfig) validateConfig(v *validation) bool {
+	ok := true
+	for _, p := range strings.Split(*cmd.config, ",") {
+		ok = v.checkFile(filepath.Join(*cmd.workspace, p)) && ok
+	}
+	if !ok {
+		return false
+	}
+	cmd.ParseConfig()
+
+	strainsFile := filepath.Join(*cmd.workspace, "reference_strains.json")
+	prokaryotesFile := filepath.Join(cmd.repBase, "prokaryotes.txt")
+	ok = v.checkFile(strainsFile)
+	ok = v.checkFile(prokaryotesFile) && ok
+	ok = v.checkFile(filepath.Join(*cmd.workspace, cmd.speciesFile)) && ok
+	if !ok {
+		return false
+	}
+	if !isReferenceStrainsExists(*cmd.workspace, cmd.repBase) {
+		v.addf("%s is older than %s, please run meta init first", strainsFile, prokaryotesFile)
+		return false
+	}
+
+	strainMap := make(map[string]strain.Strain)
+	for _, s := range cmd.ReadReferenceStrains() {
+		strainMap[s.Path] = s
+	}
+	cmd.speciesMap = make(map[string][]strain.Strain)
+	for prefix, strainPaths := range cmd.ReadSpeciesFile() {
+		for _, strainPath := range strainPaths {
+			s, found := strainMap[strainPath]
+			if !found {
+				v.addf("species %s: cannot find strain %s in %s", prefix, strainPath, strainsFile)
+				continue
+			}
+			complete := true
+			for _, g := range s.Genomes {
+				complete = v.checkFile(filepath.Join(cmd.refBase, s.Path, g.RefAcc()+".fna")) && complete
+			}
+			if complete {
+				cmd.speciesMap[prefix] = append(cmd.speciesMap[prefix], s)
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mingzhi/meta/genome"
+	"github.com/mingzhi/meta/strain"
+)
+
+// writeWorkspace writes a workspace in dir with a species of one strain,
+// whose genome has its sequence, profile, ortholog clusters and cov files.
+func writeWorkspace(t *testing.T, dir string) {
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := `genome:
+ reference: "%s"
+ reports: "%s"
+species:
+ file: "species.yaml"
+out:
+ cov: "cov_output"
+ ortho: "ortho_output"
+ fit: "fit_output"
+cov:
+ positions:
+  - 4
+fit:
+ exp:
+  start: 1
+  end: 100
+`
+	write("config.yaml", fmt.Sprintf(config, filepath.Join(dir, "ref"), filepath.Join(dir, "rep")))
+	write("species.yaml", "Ecoli:\n - Escherichia_coli_K_12\n")
+	write("rep/prokaryotes.txt", "")
+	// reference_strains.json should be newer than prokaryotes.txt.
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "rep", "prokaryotes.txt"), past, past); err != nil {
+		t.Fatal(err)
+	}
+	strains := []strain.Strain{{Path: "Escherichia_coli_K_12", Genomes: []genome.Genome{{Accession: "NC_000913.3"}}}}
+	data, err := json.Marshal(strains)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("reference_strains.json", string(data))
+
+	write("ref/Escherichia_coli_K_12/NC_000913.fna", ">NC_000913\nACGT\n")
+	write("ref/Escherichia_coli_K_12/NC_000913.pos", "\x01\x02\x04\x08")
+	write("ortho_output/Ecoli_orthologs.json", "[]")
+	for _, name := range []string{"core", "disp", "pan"} {
+		for _, funcType := range []string{"Cov_Genomes_vs_Genome", "Cov_Genomes_vs_Genomes"} {
+			write(fmt.Sprintf("cov_output/Escherichia_coli_K_12/NC_000913_%s_%s_pos4_boot.json.zip", funcType, name), "")
+		}
+	}
+
+	// an aligner in PATH.
+	write("bin/muscle", "#!/bin/sh\n")
+	if err := os.Chmod(filepath.Join(dir, "bin", "muscle"), 0777); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meta_validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeWorkspace(t, dir)
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", filepath.Join(dir, "bin"))
+
+	orthoAln := func() []string {
+		cmd := &cmdOrthoAln{}
+		if err := cmd.Flags(flag.NewFlagSet("ortho_aln", flag.ContinueOnError)).Parse([]string{"-w", dir}); err != nil {
+			t.Fatal(err)
+		}
+		v := &validation{}
+		cmd.Validate(v)
+		return v.problems
+	}
+	fitGenomes := func() []string {
+		cmd := &cmdFitGenomes{}
+		if err := cmd.Flags(flag.NewFlagSet("fit_genomes", flag.ContinueOnError)).Parse([]string{"-w", dir}); err != nil {
+			t.Fatal(err)
+		}
+		v := &validation{}
+		cmd.Validate(v)
+		return v.problems
+	}
+
+	if problems := orthoAln(); len(problems) != 0 {
+		t.Errorf("ortho_aln, Expect no problems, got %v\n", problems)
+	}
+	if problems := fitGenomes(); len(problems) != 0 {
+		t.Errorf("fit_genomes, Expect no problems, got %v\n", problems)
+	}
+
+	// a missing ortholog file, and a missing aligner.
+	if err := os.Remove(filepath.Join(dir, "ortho_output", "Ecoli_orthologs.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "bin", "muscle")); err != nil {
+		t.Fatal(err)
+	}
+	problems := orthoAln()
+	if len(problems) != 2 || !strings.Contains(problems[0], "muscle") || !strings.Contains(problems[1], "Ecoli_orthologs.json") {
+		t.Errorf("ortho_aln, Expect the missing aligner and ortholog file, got %v\n", problems)
+	}
+
+	// a missing genome sequence.
+	if err := os.Remove(filepath.Join(dir, "ref", "Escherichia_coli_K_12", "NC_000913.fna")); err != nil {
+		t.Fatal(err)
+	}
+	problems = fitGenomes()
+	if len(problems) != 1 || !strings.Contains(problems[0], "NC_000913.fna") {
+		t.Errorf("fit_genomes, Expect the missing genome, got %v\n", problems)
+	}
+}
@@ -3,8 +3,8 @@ package main
 import (
 	"github.com/biogo/hts/bam"
 	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/meta"
 	"io"
-	"log"
 	"os"
 )
 
@@ -61,7 +61,7 @@ func ReadBamFile(fileName string) (h *sam.Header, c chan *sam.Record) {
 			}
 			c <- rec
 		}
-		log.Println("Finished reading bam file!")
+		meta.INFO.Println("Finished reading bam file!")
 	}()
 
 	return
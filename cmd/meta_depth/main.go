@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/meta/p2"
+)
+
+func main() {
+	var mapq255 string    // how to handle MapQ 255
+	var excludeBed string // bed file of excluded regions
+	var opts p2.Options   // filters of reads and bases
+	flag.StringVar(&mapq255, "mapq255", "exclude", "how to handle MapQ 255 (not available): exclude, include, or a MapQ value to treat it as")
+	flag.IntVar(&opts.MinBQ, "min-bq", 13, "min base quality")
+	flag.IntVar(&opts.QualOffset, "qual-offset", 0, "offset subtracted from base qualities before checking min-bq, e.g. 33 if they keep the ASCII offset")
+	flag.IntVar(&opts.MinMQ, "min-mq", 0, "min map quality; reads with MapQ > min-mq and <= max-mq are used")
+	flag.IntVar(&opts.MaxMQ, "max-mq", 60, "max map quality (0 for no limit); MapQ 255 is handled by -mapq255")
+	flag.IntVar(&opts.MinReadLen, "min-readlen", 0, "min mapped length of a read, without deletions")
+	flag.IntVar(&opts.MaxSoftClip, "max-softclip", -1, "max number of soft-clipped bases of a read (-1 for no limit, 0 for none)")
+	flag.IntVar(&opts.MDWindow, "md-window", 0, "mask mismatches within this many bases of another mismatch, using the MD tag (0 for no masking)")
+	flag.StringVar(&excludeBed, "exclude-bed", "", "bed file of regions (e.g. repeats) whose positions are not counted")
+	flag.Parse()
+	if flag.NArg() < 2 {
+		// the depth counts the bases used by calc_ct with the same filters.
+		log.Fatalln("Usage: meta_depth <bam file> <out file>")
+	}
+	bamFile := flag.Arg(0)
+	outFile := flag.Arg(1)
+
+	if opts.MaxMQ > 0 && opts.MaxMQ <= opts.MinMQ {
+		log.Fatalf("max-mq (%d) should be greater than min-mq (%d)\n", opts.MaxMQ, opts.MinMQ)
+	}
+	opts.MapQ255 = mapq255
+	if mapq255 != "exclude" && mapq255 != "include" {
+		v, err := strconv.Atoi(mapq255)
+		if err != nil || v < 0 || v > 254 {
+			log.Fatalf("mapq255 should be exclude, include or a MapQ value in [0, 254], got %s\n", mapq255)
+		}
+		opts.MapQ255As = v
+	}
+	if excludeBed != "" {
+		regions, err := p2.ReadBedFile(excludeBed)
+		if err != nil {
+			log.Fatalf("reading %s: %v\n", excludeBed, err)
+		}
+		opts.Exclude = regions
+	}
+
+	bins, err := depthHistogram(bamFile, opts)
+	if err != nil {
+		log.Fatalf("%s: %v\n", bamFile, err)
+	}
+
+	w, err := os.Create(outFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer w.Close()
+	writeHistogram(w, bins)
+}
+
+// depthHistogram streams the records of a bam file,
+// and returns the depth histogram of its references.
+func depthHistogram(fileName string, opts p2.Options) ([]p2.DepthBin, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := bam.NewReader(f, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	readChan := make(chan *sam.Record)
+	errChan := make(chan error, 1)
+	go func() {
+		defer close(readChan)
+		for {
+			rec, err := r.Read()
+			if err != nil {
+				if err != io.EOF {
+					errChan <- err
+				}
+				return
+			}
+			select {
+			case readChan <- rec:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	bins := p2.DepthHistogram(ctx, readChan, r.Header().Refs(), opts)
+	select {
+	case err := <-errChan:
+		return nil, err
+	default:
+	}
+	return bins, nil
+}
+
+// writeHistogram writes the depth histogram as CSV.
+func writeHistogram(w io.Writer, bins []p2.DepthBin) {
+	fmt.Fprintln(w, "depth,positions")
+	for _, b := range bins {
+		fmt.Fprintf(w, "%d,%d\n", b.Depth, b.Positions)
+	}
+}
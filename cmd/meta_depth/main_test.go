@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/meta/p2"
+)
+
+func TestDepthHistogram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meta_depth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ref, err := sam.NewReference("NC_000001", "", "", 10, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := sam.NewHeader(nil, []*sam.Reference{ref})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pileup of the 10 positions:
+	//	0123456789
+	//	ACGT        read1
+	//	  GTAC      read2, with a low quality base at 3
+	//	  GT*A      read3, with a deletion at 4
+	//	ACGT        read4, with a low MapQ
+	// depths 1 1 3 2 1 2 0 0 0 0.
+	type read struct {
+		pos   int
+		mapQ  byte
+		seq   string
+		qual  []byte
+		cigar []sam.CigarOp
+	}
+	reads := []read{
+		{0, 60, "ACGT", []byte{30, 30, 30, 30}, []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}},
+		{2, 60, "GTAC", []byte{30, 5, 30, 30}, []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}},
+		{2, 60, "GTA", []byte{30, 30, 30}, []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 2), sam.NewCigarOp(sam.CigarDeletion, 1), sam.NewCigarOp(sam.CigarMatch, 1)}},
+		{0, 0, "ACGT", []byte{30, 30, 30, 30}, []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}},
+	}
+	fileName := filepath.Join(dir, "reads.bam")
+	f, err := os.Create(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := bam.NewWriter(f, h, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range reads {
+		rec, err := sam.NewRecord("read", ref, nil, r.pos, -1, 0, r.mapQ, r.cigar, []byte(r.seq), r.qual, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	opts := p2.Options{MinBQ: 13, MinMQ: 0, MaxMQ: 60, MapQ255: "exclude"}
+	bins, err := depthHistogram(fileName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	writeHistogram(&buf, bins)
+	expected := "depth,positions\n0,4\n1,3\n2,2\n3,1\n"
+	if buf.String() != expected {
+		t.Errorf("Expect %q, got %q\n", expected, buf.String())
+	}
+
+	// excluded positions are not counted.
+	opts.Exclude = p2.Regions{"NC_000001": {{Start: 6, End: 10}}}
+	bins, err = depthHistogram(fileName, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bins) == 0 || bins[0] != (p2.DepthBin{Depth: 1, Positions: 3}) {
+		t.Errorf("Expect no positions at depth 0, got %v\n", bins)
+	}
+}
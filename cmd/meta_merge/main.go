@@ -0,0 +1,219 @@
+// Merge the results of meta_p2 runs on shards of reads.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func main() {
+	app := kingpin.New("meta_merge", "Merge the json results (--format json) of meta_p2 runs on shards of reads")
+	app.Version("v20170405")
+	outFileArg := app.Arg("outfile", "out file").Required().String()
+	inFilesArg := app.Arg("infiles", "json result files of meta_p2").Required().Strings()
+	formatFlag := app.Flag("format", "output format").Default("csv").Enum("csv", "json")
+	kingpin.MustParse(app.Parse(os.Args[1:]))
+
+	var groups []jsonGroup
+	for _, fileName := range *inFilesArg {
+		f, err := os.Open(fileName)
+		if err != nil {
+			app.Fatalf("%v", err)
+		}
+		fileGroups, err := readGroups(f)
+		f.Close()
+		if err != nil {
+			app.Fatalf("%s: %v", fileName, err)
+		}
+		groups = append(groups, fileGroups...)
+	}
+
+	w, err := os.Create(*outFileArg)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer w.Close()
+
+	if err := writeGroups(w, mergeGroups(groups), *formatFlag); err != nil {
+		log.Panic(err)
+	}
+}
+
+// jsonResult is a result in the json output of meta_p2.
+type jsonResult struct {
+	Lag      int      `json:"lag"`
+	Mean     *float64 `json:"mean"`
+	Variance *float64 `json:"variance"`
+	N        int64    `json:"n"`
+	Type     string   `json:"type"`
+}
+
+// jsonGroup is a group in the json output of meta_p2.
+type jsonGroup struct {
+	Group   string       `json:"group"`
+	Ks      *float64     `json:"ks"`
+	Results []jsonResult `json:"results"`
+}
+
+// resultKey identifies a result by its type and lag.
+type resultKey struct {
+	Type string
+	Lag  int
+}
+
+// readGroups reads the groups in a json result file,
+// a json array of groups as written by meta_p2.
+// Files of one group per document, of older versions, are also read.
+func readGroups(r io.Reader) (groups []jsonGroup, err error) {
+	decoder := json.NewDecoder(r)
+	for {
+		var doc json.RawMessage
+		if err := decoder.Decode(&doc); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		if trimmed := bytes.TrimSpace(doc); len(trimmed) > 0 && trimmed[0] == '[' {
+			var gs []jsonGroup
+			if err := json.Unmarshal(doc, &gs); err != nil {
+				return nil, err
+			}
+			groups = append(groups, gs...)
+			continue
+		}
+		var g jsonGroup
+		if err := json.Unmarshal(doc, &g); err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	return
+}
+
+// mergeGroups pools the results of groups with the same name.
+// The results of meta_p2 are normalized by Ks,
+// so they are scaled back before pooling, and normalized again by the pooled Ks.
+func mergeGroups(groups []jsonGroup) []jsonGroup {
+	pooled := make(map[string]map[resultKey]*MeanVar)
+	for _, g := range groups {
+		scale := 1.0
+		if g.Ks != nil && *g.Ks != 0 {
+			scale = *g.Ks
+		}
+		if pooled[g.Group] == nil {
+			pooled[g.Group] = make(map[resultKey]*MeanVar)
+		}
+		for _, res := range g.Results {
+			if res.N == 0 || res.Mean == nil {
+				continue
+			}
+			s := scale
+			if res.Type == "Ks" {
+				s = 1
+			}
+			mv := &MeanVar{N: int(res.N), M1: *res.Mean * s}
+			if res.Variance != nil {
+				mv.M2 = *res.Variance * s * s * float64(res.N)
+			}
+
+			key := resultKey{Type: res.Type, Lag: res.Lag}
+			if pooled[g.Group][key] == nil {
+				pooled[g.Group][key] = NewMeanVar()
+			}
+			pooled[g.Group][key].Append(mv)
+		}
+	}
+
+	var names []string
+	for name := range pooled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var merged []jsonGroup
+	for _, name := range names {
+		mvs := pooled[name]
+		ks := math.NaN()
+		if mv, found := mvs[resultKey{Type: "Ks", Lag: 0}]; found {
+			ks = mv.Mean()
+		}
+
+		var keys []resultKey
+		for key := range mvs {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].Type != keys[j].Type {
+				if keys[i].Type == "Ks" || keys[j].Type == "Ks" {
+					return keys[i].Type == "Ks"
+				}
+				return keys[i].Type < keys[j].Type
+			}
+			return keys[i].Lag < keys[j].Lag
+		})
+
+		g := jsonGroup{Group: name, Ks: jsonFloat(ks), Results: []jsonResult{}}
+		for _, key := range keys {
+			mv := mvs[key]
+			m, v := mv.Mean(), mv.Variance()
+			if key.Type != "Ks" && ks != 0 && !math.IsNaN(ks) {
+				m /= ks
+				v /= ks * ks
+			}
+			g.Results = append(g.Results, jsonResult{Lag: key.Lag, Mean: jsonFloat(m), Variance: jsonFloat(v), N: int64(mv.N), Type: key.Type})
+		}
+		merged = append(merged, g)
+	}
+	return merged
+}
+
+// writeGroups writes the merged groups in the output formats of meta_p2:
+// json is a single array of the groups.
+func writeGroups(w io.Writer, groups []jsonGroup, format string) error {
+	switch format {
+	case "json":
+		if groups == nil {
+			groups = []jsonGroup{}
+		}
+		return json.NewEncoder(w).Encode(groups)
+	case "csv":
+		if _, err := fmt.Fprintln(w, "l,m,v,n,t,b"); err != nil {
+			return err
+		}
+		for _, g := range groups {
+			for _, res := range g.Results {
+				if _, err := fmt.Fprintf(w, "%d,%g,%g,%d,%s,%s\n",
+					res.Lag, value(res.Mean), value(res.Variance), res.N, res.Type, g.Group); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown output format %s", format)
+}
+
+// jsonFloat returns nil for NaN or infinite values, which json can not encode.
+func jsonFloat(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
+
+// value returns the value of a json float, or NaN if it is null.
+func value(v *float64) float64 {
+	if v == nil {
+		return math.NaN()
+	}
+	return *v
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+// resultsGroup returns a group of results from raw values,
+// normalized by Ks as meta_p2 does.
+func resultsGroup(name string, values map[resultKey][]float64) jsonGroup {
+	mvs := make(map[resultKey]*MeanVar)
+	for key, vs := range values {
+		mvs[key] = NewMeanVar()
+		for _, v := range vs {
+			mvs[key].Add(v)
+		}
+	}
+
+	ks := mvs[resultKey{Type: "Ks", Lag: 0}].Mean()
+	g := jsonGroup{Group: name, Ks: jsonFloat(ks)}
+	for key, mv := range mvs {
+		m, v := mv.Mean(), mv.Variance()
+		if key.Type != "Ks" {
+			m /= ks
+			v /= ks * ks
+		}
+		g.Results = append(g.Results, jsonResult{Lag: key.Lag, Mean: jsonFloat(m), Variance: jsonFloat(v), N: int64(mv.N), Type: key.Type})
+	}
+	return g
+}
+
+func TestMergeGroups(t *testing.T) {
+	shard1 := map[resultKey][]float64{
+		{Type: "Ks", Lag: 0}: {0.01, 0.02, 0.015},
+		{Type: "P2", Lag: 3}: {0.005, 0.008},
+		{Type: "P4", Lag: 3}: {0.0001},
+	}
+	shard2 := map[resultKey][]float64{
+		{Type: "Ks", Lag: 0}: {0.03, 0.012},
+		{Type: "P2", Lag: 3}: {0.002, 0.009, 0.004},
+		{Type: "P2", Lag: 6}: {0.001},
+	}
+	all := make(map[resultKey][]float64)
+	for _, shard := range []map[resultKey][]float64{shard1, shard2} {
+		for key, vs := range shard {
+			all[key] = append(all[key], vs...)
+		}
+	}
+
+	// write and read the shards as meta_p2 json output.
+	var buf bytes.Buffer
+	if err := writeGroups(&buf, []jsonGroup{resultsGroup("all", shard1), resultsGroup("all", shard2)}, "json"); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := readGroups(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merged := mergeGroups(groups)
+	if len(merged) != 1 {
+		t.Fatalf("Expect 1 group, got %d\n", len(merged))
+	}
+
+	expected := make(map[resultKey]jsonResult)
+	for _, res := range resultsGroup("all", all).Results {
+		expected[resultKey{Type: res.Type, Lag: res.Lag}] = res
+	}
+	if len(merged[0].Results) != len(expected) {
+		t.Fatalf("Expect %d results, got %d\n", len(expected), len(merged[0].Results))
+	}
+	if merged[0].Results[0].Type != "Ks" {
+		t.Errorf("Expect Ks first, got %s\n", merged[0].Results[0].Type)
+	}
+	for _, res := range merged[0].Results {
+		key := resultKey{Type: res.Type, Lag: res.Lag}
+		e := expected[key]
+		if res.N != e.N {
+			t.Errorf("%v, Expect n %d, got %d\n", key, e.N, res.N)
+		}
+		for _, c := range []struct {
+			name          string
+			expected, got float64
+		}{
+			{"mean", value(e.Mean), value(res.Mean)},
+			{"variance", value(e.Variance), value(res.Variance)},
+		} {
+			if math.IsNaN(c.expected) != math.IsNaN(c.got) || math.Abs(c.expected-c.got) > 1e-9*math.Abs(c.expected) {
+				t.Errorf("%v, Expect %s %g, got %g\n", key, c.name, c.expected, c.got)
+			}
+		}
+	}
+}
+
+// TestReadGroups reads the json output of meta_p2,
+// written by its tests in testdata/meta_p2.json.
+func TestReadGroups(t *testing.T) {
+	f, err := os.Open("testdata/meta_p2.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	groups, err := readGroups(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[0].Group != "all" || groups[1].Group != "other" {
+		t.Fatalf("Expect groups all and other, got %+v\n", groups)
+	}
+	if r := groups[0].Results[2]; r.Lag != 6 || value(r.Mean) != 0.4 || r.Variance != nil || r.N != 1 || r.Type != "P2" {
+		t.Errorf("Expect {6 0.4 null 1 P2}, got %+v\n", r)
+	}
+
+	// two shards of the same results: the means are the same, with twice the counts.
+	merged := mergeGroups(append(groups, groups...))
+	if len(merged) != 2 {
+		t.Fatalf("Expect 2 groups, got %d\n", len(merged))
+	}
+	for i, g := range merged {
+		if len(g.Results) != len(groups[i].Results) {
+			t.Fatalf("group %s, Expect %d results, got %d\n", g.Group, len(groups[i].Results), len(g.Results))
+		}
+		for k, res := range g.Results {
+			e := groups[i].Results[k]
+			if res.Type != e.Type || res.Lag != e.Lag || res.N != 2*e.N || math.Abs(value(res.Mean)-value(e.Mean)) > 1e-9 {
+				t.Errorf("group %s, Expect %v %d mean %g n %d, got %v %d mean %g n %d\n", g.Group,
+					e.Type, e.Lag, value(e.Mean), 2*e.N, res.Type, res.Lag, value(res.Mean), res.N)
+			}
+		}
+	}
+
+	// the merged groups are written in the same format.
+	var buf bytes.Buffer
+	if err := writeGroups(&buf, merged, "json"); err != nil {
+		t.Fatal(err)
+	}
+	if b := bytes.TrimSpace(buf.Bytes()); len(b) == 0 || b[0] != '[' {
+		t.Errorf("Expect a json array, got %s\n", buf.String())
+	}
+	again, err := readGroups(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 2 {
+		t.Errorf("Expect 2 groups, got %d\n", len(again))
+	}
+
+	// older files have a document for each group.
+	old := `{"group":"all","ks":0.01,"results":[]}
+{"group":"other","ks":0.02,"results":[]}
+`
+	groups, err = readGroups(strings.NewReader(old))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || groups[1].Group != "other" {
+		t.Errorf("Expect groups all and other, got %+v\n", groups)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// checkpoint is the state of the collection of results of a run,
+// saved with --checkpoint-file, from which an interrupted run is resumed.
+type checkpoint struct {
+	Args          []string                         // command arguments of the run.
+	Done          map[string]bool                  // genes (ref and ID) whose results are collected.
+	Collectors    map[string]*Collector            // results of each group.
+	RefCollectors map[string]map[string]*Collector // results of each reference of a group.
+	CorrResOffset int64                            // size of the corr result file.
+}
+
+func newCheckpoint(args []string) *checkpoint {
+	return &checkpoint{
+		Args:          args,
+		Done:          make(map[string]bool),
+		Collectors:    make(map[string]*Collector),
+		RefCollectors: make(map[string]map[string]*Collector),
+	}
+}
+
+// doneKey returns the key in Done of a gene.
+func doneKey(ref, geneID string) string {
+	return ref + "\t" + geneID
+}
+
+// Add adds the results of a gene to the collector of its group,
+// and, with perRef, to the collector of its reference in the group.
+func (c *checkpoint) Add(corrResults CorrResults, perRef bool) {
+	collector, found := c.Collectors[corrResults.Group]
+	if !found {
+		collector = NewCollector()
+		c.Collectors[corrResults.Group] = collector
+		c.RefCollectors[corrResults.Group] = make(map[string]*Collector)
+	}
+	collector.Add(corrResults)
+	if perRef {
+		refCollector, found := c.RefCollectors[corrResults.Group][corrResults.Ref]
+		if !found {
+			refCollector = NewCollector()
+			c.RefCollectors[corrResults.Group][corrResults.Ref] = refCollector
+		}
+		refCollector.Add(corrResults)
+	}
+	c.Done[doneKey(corrResults.Ref, corrResults.GeneID)] = true
+}
+
+// Save writes the checkpoint to the file,
+// through a temporary file, so that an interrupted save keeps the last one.
+func (c *checkpoint) Save(fileName string) error {
+	tmpFile := fileName + ".tmp"
+	f, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(c); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, fileName)
+}
+
+// readCheckpoint reads the checkpoint file of a run with the arguments,
+// and returns nil if it does not exist.
+func readCheckpoint(fileName string, args []string) (*checkpoint, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	c := newCheckpoint(nil)
+	if err := json.NewDecoder(f).Decode(c); err != nil {
+		return nil, fmt.Errorf("%s: %v", fileName, err)
+	}
+	if strings.Join(c.Args, " ") != strings.Join(args, " ") {
+		return nil, fmt.Errorf("%s is from a run with other arguments (%s), remove it to start again", fileName, strings.Join(c.Args, " "))
+	}
+	return c, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meta_p2_checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "checkpoint.json")
+	args := []string{"in.bam", "out.csv", "--jackknife"}
+
+	// results of genes on three references, in two groups.
+	rng := rand.New(rand.NewSource(1))
+	var genes []CorrResults
+	for i := 0; i < 30; i++ {
+		var results []CorrResult
+		for l := 0; l < 5; l++ {
+			results = append(results, CorrResult{Type: "P2", Lag: l, Value: rng.Float64() * 10, Count: int64(rng.Intn(10) + 1)})
+		}
+		genes = append(genes, CorrResults{
+			GeneID:  fmt.Sprintf("gene%d", i),
+			Ref:     fmt.Sprintf("NC_00000%d", i%3),
+			Group:   []string{"+", "-"}[i%2],
+			Results: results,
+		})
+	}
+	summary := func(c *checkpoint) string {
+		s := ""
+		for _, group := range []string{"+", "-"} {
+			s += fmt.Sprintf("%s %v %v\n", group, c.Collectors[group].Results(), jackknife(c.RefCollectors[group]))
+		}
+		return s
+	}
+
+	uninterrupted := newCheckpoint(args)
+	for _, g := range genes {
+		uninterrupted.Add(g, true)
+	}
+
+	// a run stopped after a checkpoint at 12 genes, with 3 more collected,
+	// and restarted.
+	stopped := newCheckpoint(args)
+	for i, g := range genes[:15] {
+		stopped.Add(g, true)
+		if i == 11 {
+			if err := stopped.Save(fileName); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	resumed, err := readCheckpoint(fileName, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed == nil || len(resumed.Done) != 12 {
+		t.Fatalf("Expect a checkpoint of 12 genes, got %v\n", resumed)
+	}
+	for _, g := range genes {
+		if !resumed.Done[doneKey(g.Ref, g.GeneID)] {
+			resumed.Add(g, true)
+		}
+	}
+	if got, expected := summary(resumed), summary(uninterrupted); got != expected {
+		t.Errorf("Expect %s, got %s\n", expected, got)
+	}
+
+	// no checkpoint, and a checkpoint of another run.
+	if c, err := readCheckpoint(filepath.Join(dir, "none.json"), args); c != nil || err != nil {
+		t.Errorf("Expect no checkpoint, got %v, %v\n", c, err)
+	}
+	if _, err := readCheckpoint(fileName, []string{"other.bam", "out.csv"}); err == nil {
+		t.Errorf("Expect an error for a checkpoint of other arguments\n")
+	}
+}
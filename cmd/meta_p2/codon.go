@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/mingzhi/meta/p2"
 	"github.com/mingzhi/ncbiftp/taxonomy"
 )
 
@@ -123,7 +124,7 @@ func SynoumousSplitCodonPairs(codonPairs []CodonPair, codeTable *taxonomy.Geneti
 		hasGap := false
 		for _, codon := range []Codon{codonPair.A, codonPair.B} {
 			for _, b := range codon.Seq {
-				if !isATGC(byte(b)) {
+				if !p2.IsATGC(byte(b)) {
 					hasGap = true
 					break
 				}
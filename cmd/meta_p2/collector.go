@@ -1,5 +1,11 @@
 package main
 
+import (
+	"encoding/json"
+	"math"
+	"sort"
+)
+
 // CorrResult contains a correlation result.
 type CorrResult struct {
 	Lag      int
@@ -12,6 +18,8 @@ type CorrResult struct {
 // CorrResults is a list of CorrResult.
 type CorrResults struct {
 	GeneID  string
+	Group   string
+	Ref     string
 	Results []CorrResult
 	ReadNum int
 	GeneLen int
@@ -29,6 +37,22 @@ func NewCollector() *Collector {
 	return &c
 }
 
+// MarshalJSON implements json.Marshaler,
+// writing the MeanVars of each type, e.g. to save a checkpoint.
+func (c *Collector) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.m)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (c *Collector) UnmarshalJSON(data []byte) error {
+	m := make(map[string][]*MeanVar)
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	c.m = m
+	return nil
+}
+
 // Add add an array of CorrResult.
 func (c *Collector) Add(results CorrResults) {
 	for _, res := range results.Results {
@@ -70,44 +94,80 @@ func (c *Collector) MeanVars(corrType string) (values []*MeanVar) {
 	return c.m[corrType]
 }
 
-// CorrTypes return all corr types.
+// CorrTypes return all corr types, P2 first and the others sorted.
 func (c *Collector) CorrTypes() (corrTypes []string) {
-	corrTypes = append(corrTypes, "P2")
 	for key := range c.m {
 		if key != "P2" {
 			corrTypes = append(corrTypes, key)
 		}
 	}
-	return
+	sort.Strings(corrTypes)
+	return append([]string{"P2"}, corrTypes...)
+}
+
+// ks returns the Ks of the types of each position tag (see positionTag),
+// the lag 0 of their P2, or 0 if it has no pairs.
+func (c *Collector) ks() map[string]float64 {
+	ks := make(map[string]float64)
+	for ctype, mvs := range c.m {
+		if ctype == "P2"+typeTag(ctype) && len(mvs) > 0 && mvs[0].N > 0 {
+			ks[typeTag(ctype)] = mvs[0].Mean()
+		}
+	}
+	return ks
 }
 
 // Results get results
 func (c *Collector) Results() (results []CorrResult) {
+	return c.results(0)
+}
+
+// AllResults returns the results at each lag below maxl,
+// those without pairs with a count of 0 and NaN values.
+func (c *Collector) AllResults(maxl int) (results []CorrResult) {
+	return c.results(maxl)
+}
+
+// results returns the results of lags with pairs,
+// and the empty lags below maxl.
+// The lag 0 of each P2 is its Ks, by which the results of its position tag are normalized.
+func (c *Collector) results(maxl int) (results []CorrResult) {
 	corrTypes := c.CorrTypes()
-	ks := 0.0
+	ksOf := c.ks()
 	for _, ctype := range corrTypes {
+		tag := typeTag(ctype)
+		ks := ksOf[tag]
 		means := c.Means(ctype)
 		vars := c.Vars(ctype)
 		ns := c.Ns(ctype)
-		for i := 0; i < len(means); i++ {
-			if ns[i] > 0 {
-				res := CorrResult{}
-				res.Lag = i * 3
+		numLags := len(means)
+		if numLags < maxl {
+			numLags = maxl
+		}
+		for i := 0; i < numLags; i++ {
+			res := CorrResult{}
+			res.Lag = i * 3
+			res.Type = ctype
+			if i < len(ns) && ns[i] > 0 {
 				res.Count = int64(ns[i])
-				res.Type = ctype
 				res.Value = means[i]
 				res.Variance = vars[i]
-				if ctype == "P2" && i == 0 {
-					res.Type = "Ks"
-					ks = res.Value
-				} else {
-					if ks != 0 {
-						res.Value /= ks
-						res.Variance /= (ks * ks)
-					}
+			} else if i < maxl {
+				res.Value = math.NaN()
+				res.Variance = math.NaN()
+			} else {
+				continue
+			}
+			if ctype == "P2"+tag && i == 0 {
+				// an empty lag 0 leaves the results not normalized.
+				res.Type = "Ks" + tag
+			} else {
+				if ks != 0 {
+					res.Value /= ks
+					res.Variance /= (ks * ks)
 				}
-				results = append(results, res)
 			}
+			results = append(results, res)
 		}
 	}
 
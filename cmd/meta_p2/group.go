@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// groupKeyTypes are the stratifications supported by the group key.
+var groupKeyTypes = []string{"ref", "gene", "strand"}
+
+// parseGroupBy parses a comma-separated list of stratifications,
+// such as "ref,strand". The keys are returned in the order of groupKeyTypes,
+// so that "strand,ref" gives the same groups as "ref,strand".
+func parseGroupBy(s string) (keys []string, err error) {
+	given := make(map[string]bool)
+	for _, key := range strings.Split(s, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		valid := false
+		for _, t := range groupKeyTypes {
+			if key == t {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("unknown group key %s, should be one of %s", key, strings.Join(groupKeyTypes, ","))
+		}
+		if given[key] {
+			return nil, fmt.Errorf("duplicate group key %s", key)
+		}
+		given[key] = true
+	}
+
+	for _, t := range groupKeyTypes {
+		if given[t] {
+			keys = append(keys, t)
+		}
+	}
+	return
+}
+
+// groupKey returns the group of a gene as "key=value" pairs joined by ';',
+// for example "ref=NC_000913;strand=+", or "all" if there is no stratification.
+func groupKey(geneRecords GeneSamRecords, keys []string) string {
+	if len(keys) == 0 {
+		return "all"
+	}
+
+	var terms []string
+	for _, key := range keys {
+		var value string
+		switch key {
+		case "ref":
+			value = geneRecords.Ref
+		case "gene":
+			value = geneRecords.ID
+		case "strand":
+			value = "+"
+			if geneRecords.Strand == -1 {
+				value = "-"
+			}
+		}
+		terms = append(terms, key+"="+value)
+	}
+	return strings.Join(terms, ";")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGroupBy(t *testing.T) {
+	testCases := []struct {
+		s     string
+		keys  []string
+		valid bool
+	}{
+		{"", nil, true},
+		{"ref", []string{"ref"}, true},
+		{"ref,strand", []string{"ref", "strand"}, true},
+		{"strand,ref", []string{"ref", "strand"}, true},
+		{" strand , gene,ref ", []string{"ref", "gene", "strand"}, true},
+		{"ref,,strand,", []string{"ref", "strand"}, true},
+		{"contig", nil, false},
+		{"ref,Strand", nil, false},
+		{"ref,ref", nil, false},
+		{"strand,ref,strand", nil, false},
+	}
+	for _, tc := range testCases {
+		keys, err := parseGroupBy(tc.s)
+		if (err == nil) != tc.valid {
+			t.Errorf("parseGroupBy(%q), Expect valid %v, got error %v\n", tc.s, tc.valid, err)
+			continue
+		}
+		if !reflect.DeepEqual(keys, tc.keys) {
+			t.Errorf("parseGroupBy(%q), Expect %v, got %v\n", tc.s, tc.keys, keys)
+		}
+	}
+}
+
+func TestGroupKey(t *testing.T) {
+	forward := GeneSamRecords{ID: "gene1", Ref: "NC_000913", Strand: 1}
+	reverse := GeneSamRecords{ID: "gene2", Ref: "NC_000913", Strand: -1}
+	testCases := []struct {
+		geneRecords GeneSamRecords
+		groupBy     string
+		expected    string
+	}{
+		{forward, "", "all"},
+		{forward, "ref", "ref=NC_000913"},
+		{forward, "gene", "gene=gene1"},
+		{forward, "strand", "strand=+"},
+		{reverse, "strand", "strand=-"},
+		{reverse, "ref,strand", "ref=NC_000913;strand=-"},
+		{reverse, "strand,ref", "ref=NC_000913;strand=-"},
+		{forward, "strand,gene,ref", "ref=NC_000913;gene=gene1;strand=+"},
+	}
+	for _, tc := range testCases {
+		keys, err := parseGroupBy(tc.groupBy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := groupKey(tc.geneRecords, keys); got != tc.expected {
+			t.Errorf("groupKey of %s by %q, Expect %s, got %s\n", tc.geneRecords.ID, tc.groupBy, tc.expected, got)
+		}
+	}
+}
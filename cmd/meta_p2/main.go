@@ -5,32 +5,32 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"io"
-	"log"
 	"math/rand"
 	"os"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 
 	"github.com/biogo/hts/sam"
 	"github.com/mingzhi/biogo/seq"
+	"github.com/mingzhi/meta"
+	"github.com/mingzhi/meta/p2"
 	"github.com/mingzhi/ncbiftp/taxonomy"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
-// SubProfile Substitution/mutation profile.
-type SubProfile struct {
-	Pos     int
-	Profile []float64
-}
-
 // ShowProgress show progress.
 var ShowProgress bool
 
 // MinBaseQuality min base quality
 var MinBaseQuality int
 
+// QualOffset is subtracted from base qualities before checking MinBaseQuality.
+var QualOffset int
+
 // MinMapQuality min map quality
 var MinMapQuality int
 
@@ -40,69 +40,220 @@ var MinAlleleDepth int
 // MinReadLength minimal read length
 var MinReadLength int
 
+// MaxPileup, if > 0, caps the reads overlapping a position of a gene.
+var MaxPileup int
+
+// Exclude are the regions whose codons are not used.
+var Exclude p2.Regions
+
 func main() {
 	// Command variables.
-	var bamFile string      // bam or sam file
+	var bamFiles []string   // bam or sam files
 	var outFile string      // output file
 	var maxl int            // max length of correlation
 	var ncpu int            // number of CPUs
+	var maxInflight int     // max number of references processed at a time
 	var minDepth int        // min depth
 	var minCoverage float64 // min coveage
 	var gffFile string      // gff file
 	var corrResFile string  // corr result file.
 	var geneFile string     // gene file.
 	var maxDepth float64    // max depth
+	var groupBy []string    // stratifications of the output
+	var positions []int     // codon positions of the profiles
+	var useJackknife bool   // output jackknife standard errors
+	var outFormat string    // output format
+	var numBoot int         // number of bootstraps
 
 	// Parse command arguments.
 	app := kingpin.New("meta_p2", "Calculate mutation correlation from bacterial metagenomic sequence data")
 	app.Version("v20170405")
-	bamFileArg := app.Arg("bamfile", "bam file").Required().String()
-	outFileArg := app.Arg("outfile", "out file").Required().String()
+	filesArg := app.Arg("files", "bam files, sorted by coordinate with the same references, whose records are merged (or - for the standard input, given after --, e.g. meta_p2 -- - out.csv), followed by the out file").Required().Strings()
 	maxlFlag := app.Flag("maxl", "max len of correlations").Default("100").Int()
 	ncpuFlag := app.Flag("ncpu", "number of CPUs").Default("0").Int()
+	maxInflightFlag := app.Flag("max-inflight", "max number of references (or genes, with --gff-file) processed at a time, each holding its reads and codon pileup in memory; 0 for ncpu").Default("0").Int()
 	minDepthFlag := app.Flag("min-depth", "min depth").Default("5").Int()
 	minCoverageFlag := app.Flag("min-coverage", "min coverage").Default("0.5").Float64()
 	progressFlag := app.Flag("progress", "show progress").Default("false").Bool()
-	gffFileFlag := app.Flag("gff-file", "gff file").Default("").String()
+	gffFileFlag := app.Flag("gff-file", "gff file; only codons inside CDS features are used, in their reading frames").Default("").String()
 	minBaseQFlag := app.Flag("min-base-qual", "min base quality").Default("30").Int()
+	qualOffsetFlag := app.Flag("qual-offset", "offset subtracted from base qualities before checking min-base-qual, e.g. 33 if they keep the ASCII offset").Default("0").Int()
 	minMapQFlag := app.Flag("min-map-qual", "min mapping quality").Default("30").Int()
 	corrResFileFlag := app.Flag("corr-res-file", "corr result file").Default("").String()
 	geneFileFlag := app.Flag("gene-file", "gene file").Default("").String()
 	minAlleleDepthFlag := app.Flag("min-allele-depth", "min allele depth").Default("0").Int()
 	maxDepthFlag := app.Flag("max-depth", "max coverage depth for each gene").Default("0").Float64()
 	minReadLenFlag := app.Flag("min-read-length", "minimal read length").Default("60").Int()
+	maxPileupFlag := app.Flag("max-pileup", "max number of reads overlapping a position of a gene, reservoir sampled (reproducibly) in regions of higher coverage; 0 for no limit").Default("0").Int()
+	jackknifeFlag := app.Flag("jackknife", "output delete-one-reference jackknife standard errors").Default("false").Bool()
+	bootstrapFlag := app.Flag("bootstrap", "number of bootstraps over references for confidence intervals (0 for none)").Default("0").Int()
+	seedFlag := app.Flag("seed", "random seed for bootstrap").Default("1").Int64()
+	formatFlag := app.Flag("format", "output format").Default("csv").Enum("csv", "json")
+	codonFlag := app.Flag("codon", "genetic code table ID").Default("11").String()
+	inputFormatFlag := app.Flag("input-format", "format of the standard input (bamfile -)").Default("bam").Enum("bam", "sam")
+	regionFlag := app.Flag("region", "only read records in a region (ref:start-end, 1-based), using the bam index").Default("").String()
+	excludeBedFlag := app.Flag("exclude-bed", "bed file of regions (e.g. repeats); codons with a base inside them are excluded").Default("").String()
+	checkpointFileFlag := app.Flag("checkpoint-file", "file of the collected results, saved every --checkpoint-every genes, from which a run with the same arguments is resumed; removed at the end of the run").Default("").String()
+	checkpointEveryFlag := app.Flag("checkpoint-every", "number of genes (or references) between checkpoints").Default("100").Int()
+	statsFileFlag := app.Flag("stats-file", "file of the numbers of reads passing the filters, piled up, and discarded (by reason), in JSON").Default("").String()
+	naStringFlag := app.Flag("na-string", "string of NaN values (e.g. of lags without pairs) in the csv output").Default("NaN").String()
+	skipEmptyFlag := app.Flag("skip-empty", "omit lags without pairs; with --no-skip-empty, every lag below maxl is written").Default("true").Bool()
+	positionsFlag := app.Flag("positions", "comma-separated codon positions of the profiles: 1, 2, 3, and 4 for the third positions of four-fold degenerate codons (requires --gff-file); the types of the profiles other than of 3 are tagged with the position, e.g. P2_1").Default("3").String()
+	groupByFlag := app.Flag("group-by", "comma-separated stratifications of the output b column: ref, gene, strand").Default("").String()
+	logLevelFlag := app.Flag("log-level", "log level").Default("info").Enum("debug", "info", "warn", "error")
+	quietFlag := app.Flag("quiet", "only log errors").Default("false").Bool()
 	kingpin.MustParse(app.Parse(os.Args[1:]))
+	minLevel, err := meta.ParseLevel(*logLevelFlag)
+	if err != nil {
+		app.Fatalf("%v", err)
+	}
+	meta.SetLevel(minLevel)
+	meta.SetQuiet(*quietFlag)
 
-	bamFile = *bamFileArg
-	outFile = *outFileArg
+	if len(*filesArg) < 2 {
+		app.Fatalf("expect bam files followed by the out file, got %s", strings.Join(*filesArg, " "))
+	}
+	bamFiles = (*filesArg)[:len(*filesArg)-1]
+	outFile = (*filesArg)[len(*filesArg)-1]
+	stdin := 0
+	for _, bamFile := range bamFiles {
+		if bamFile == "-" {
+			stdin++
+		}
+	}
+	if stdin > 1 {
+		app.Fatalf("the standard input (-) can only be read once")
+	}
 	maxl = *maxlFlag
 	if *ncpuFlag == 0 {
 		ncpu = runtime.NumCPU()
 	} else {
 		ncpu = *ncpuFlag
 	}
+	maxInflight = *maxInflightFlag
+	if maxInflight <= 0 {
+		maxInflight = ncpu
+	}
 	ShowProgress = *progressFlag
 	minDepth = *minDepthFlag
 	minCoverage = *minCoverageFlag
 	gffFile = *gffFileFlag
 	MinBaseQuality = *minBaseQFlag
+	QualOffset = *qualOffsetFlag
 	MinMapQuality = *minMapQFlag
 	corrResFile = *corrResFileFlag
 	geneFile = *geneFileFlag
 	MinAlleleDepth = *minAlleleDepthFlag
 	maxDepth = *maxDepthFlag
 	MinReadLength = *minReadLenFlag
+	MaxPileup = *maxPileupFlag
+	if MaxPileup < 0 {
+		app.Fatalf("--max-pileup should be 0 (no limit) or positive, got %d", MaxPileup)
+	}
+	groupBy, err = parseGroupBy(*groupByFlag)
+	if err != nil {
+		app.Fatalf("%v", err)
+	}
+	positions, err = parsePositions(*positionsFlag)
+	if err != nil {
+		app.Fatalf("%v", err)
+	}
+	for _, pos := range positions {
+		if pos == fourFold && gffFile == "" {
+			app.Fatalf("--positions 4 (four-fold sites) requires --gff-file")
+		}
+	}
+	if *excludeBedFlag != "" {
+		Exclude, err = p2.ReadBedFile(*excludeBedFlag)
+		if err != nil {
+			app.Fatalf("%v", err)
+		}
+	}
+	useJackknife = *jackknifeFlag
+	outFormat = *formatFlag
+	numBoot = *bootstrapFlag
+	geneticCodes := taxonomy.GeneticCodes()
+	codeTable, found := geneticCodes[*codonFlag]
+	if !found {
+		var ids []string
+		for id := range geneticCodes {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			a, _ := strconv.Atoi(ids[i])
+			b, _ := strconv.Atoi(ids[j])
+			return a < b
+		})
+		app.Fatalf("unknown genetic code table %s, valid IDs: %s", *codonFlag, strings.Join(ids, ", "))
+	}
+
+	if *checkpointEveryFlag < 1 {
+		app.Fatalf("--checkpoint-every should be at least 1, got %d", *checkpointEveryFlag)
+	}
+	state := newCheckpoint(os.Args[1:])
+	if *checkpointFileFlag != "" {
+		c, err := readCheckpoint(*checkpointFileFlag, os.Args[1:])
+		if err != nil {
+			app.Fatalf("%v", err)
+		}
+		if c != nil {
+			meta.INFO.Printf("resuming from %s, with the results of %d genes\n", *checkpointFileFlag, len(c.Done))
+			state = c
+		}
+	}
+	// genes done before the run, not used by the workers reading state.Done.
+	resumed := make(map[string]bool)
+	for key := range state.Done {
+		resumed[key] = true
+	}
 
 	runtime.GOMAXPROCS(ncpu)
 
-	// Read sequence reads.
+	// Read sequence reads, merging the records of the bam files.
+	var headerChans []chan *sam.Header
+	var samRecChans []chan *sam.Record
+	var errChans []chan error // errors in reading the records of each file.
+	for _, bamFile := range bamFiles {
+		var headerChan chan *sam.Header
+		var samRecChan chan *sam.Record
+		var errChan chan error
+		if *regionFlag != "" {
+			if bamFile == "-" {
+				app.Fatalf("--region needs an indexed bam file, not the standard input")
+			}
+			ref, start, end, err := parseRegion(*regionFlag)
+			if err != nil {
+				app.Fatalf("%v", err)
+			}
+			headerChan, samRecChan, errChan, err = readBamRegion(bamFile, ref, start, end)
+			if err != nil {
+				app.Fatalf("%v", err)
+			}
+		} else {
+			headerChan, samRecChan, errChan, err = readSamRecords(bamFile, *inputFormatFlag)
+			if err != nil {
+				app.Fatalf("%v", err)
+			}
+		}
+		headerChans = append(headerChans, headerChan)
+		samRecChans = append(samRecChans, samRecChan)
+		errChans = append(errChans, errChan)
+	}
+	headerChan, samRecChan, err := mergeSamRecords(bamFiles, headerChans, samRecChans)
+	if err != nil {
+		app.Fatalf("%v", err)
+	}
+	stats := newReadStats()
+	samRecChan = countReads(samRecChan, stats)
+
 	var header *sam.Header
 	var recordsChan chan GeneSamRecords
 	if gffFile != "" {
 		gffRecMap := readGffs(gffFile)
-		header, recordsChan = readStrainBamFile(bamFile, gffRecMap)
+		header, recordsChan = readStrainBamFile(headerChan, samRecChan, gffRecMap)
 	} else {
-		header, recordsChan = readPanGenomeBamFile(bamFile)
+		meta.WARN.Println("no --gff-file: each reference is taken as a gene on the forward strand, with its first codon at the first base")
+		header, recordsChan = readPanGenomeBamFile(headerChan, samRecChan)
 	}
 
 	var geneSet map[string]bool
@@ -115,74 +266,141 @@ func main() {
 		}
 	}
 
-	codeTable := taxonomy.GeneticCodes()["11"]
-
-	done := make(chan bool)
+	// process at most maxInflight references (or genes) at a time.
+	var droppedReads int64 // reads dropped at the pileup cap.
 	p2Chan := make(chan CorrResults)
-	for i := 0; i < ncpu; i++ {
-		go func() {
-			for geneRecords := range recordsChan {
-				if geneFile != "" {
-					if !geneSet[geneRecords.ID] {
-						continue
-					}
+	go func() {
+		defer close(p2Chan)
+		workers := newPool(maxInflight)
+		for geneRecords := range recordsChan {
+			if geneFile != "" {
+				if !geneSet[geneRecords.ID] {
+					continue
 				}
+			}
+			if resumed[doneKey(geneRecords.Ref, geneRecords.ID)] {
+				continue
+			}
+			geneRecords := geneRecords
+			workers.Go(func() {
 				if maxDepth > 0 {
 					geneRecords = subsample(geneRecords, maxDepth)
 				}
+				if MaxPileup > 0 {
+					var dropped int
+					geneRecords.Records, dropped = capPileup(geneRecords.Records, MaxPileup)
+					atomic.AddInt64(&droppedReads, int64(dropped))
+				}
 				geneLen := geneRecords.End - geneRecords.Start
+				stats.CountPileup(geneRecords.Records)
 				gene := pileupCodons(geneRecords)
 				ok := checkCoverage(gene, geneLen, minDepth, minCoverage)
 				if ok {
-					p2 := calcP2(gene, maxl, minDepth, codeTable)
-					p4 := calcP4(gene, maxl, minDepth, codeTable)
-					p2 = append(p2, p4...)
-					p2Chan <- CorrResults{Results: p2, GeneID: geneRecords.ID, GeneLen: geneLen, ReadNum: len(geneRecords.Records)}
+					var p2 []CorrResult
+					for _, pos := range positions {
+						p2 = append(p2, calcP2(gene, maxl, minDepth, pos, codeTable)...)
+						p2 = append(p2, calcP4(gene, maxl, minDepth, pos, codeTable)...)
+					}
+					p2Chan <- CorrResults{Results: p2, GeneID: geneRecords.ID, Group: groupKey(geneRecords, groupBy), Ref: geneRecords.Ref, GeneLen: geneLen, ReadNum: len(geneRecords.Records)}
 				}
-			}
-			done <- true
-		}()
-	}
-
-	go func() {
-		defer close(p2Chan)
-		for i := 0; i < ncpu; i++ {
-			<-done
+			})
 		}
+		workers.Wait()
 	}()
 
 	var corrResEncoder *json.Encoder
+	var corrResWriter *os.File
 	if corrResFile != "" {
-		f, err := os.Create(corrResFile)
+		// when resuming, results after the checkpoint are written again.
+		f, err := os.OpenFile(corrResFile, os.O_WRONLY|os.O_CREATE, 0666)
 		if err != nil {
-			log.Panic(err)
+			meta.ERROR.Panic(err)
 		}
 		defer f.Close()
+		if err := f.Truncate(state.CorrResOffset); err != nil {
+			meta.ERROR.Panic(err)
+		}
+		if _, err := f.Seek(state.CorrResOffset, io.SeekStart); err != nil {
+			meta.ERROR.Panic(err)
+		}
 		corrResEncoder = json.NewEncoder(f)
+		corrResWriter = f
 	}
-	collector := NewCollector()
+	// pool results of each group separately,
+	// and of each reference in a group, for jackknife and bootstrap.
+	numCollected := 0
 	for corrResults := range p2Chan {
-		collector.Add(corrResults)
+		state.Add(corrResults, useJackknife || numBoot > 0)
 		if corrResFile != "" {
 			if err := corrResEncoder.Encode(corrResults); err != nil {
-				log.Panic(err)
+				meta.ERROR.Panic(err)
+			}
+		}
+		numCollected++
+		if *checkpointFileFlag != "" && numCollected%*checkpointEveryFlag == 0 {
+			if corrResFile != "" {
+				if state.CorrResOffset, err = corrResWriter.Seek(0, io.SeekCurrent); err != nil {
+					meta.ERROR.Panic(err)
+				}
+			}
+			if err := state.Save(*checkpointFileFlag); err != nil {
+				meta.ERROR.Panic(err)
 			}
 		}
 	}
+	// all records are read: results are not written from a file read partially.
+	for _, errChan := range errChans {
+		if err := <-errChan; err != nil {
+			app.Fatalf("%v", err)
+		}
+	}
+	collectors, refCollectors := state.Collectors, state.RefCollectors
 
 	numJob := len(header.Refs())
-	log.Printf("Number of references: %d\n", numJob)
+	meta.INFO.Printf("Number of references: %d\n", numJob)
+	stats.Report()
+	if droppedReads > 0 {
+		meta.WARN.Printf("Dropped %d reads in pileups of more than %d reads\n", droppedReads, MaxPileup)
+	}
+	if *statsFileFlag != "" {
+		if err := stats.Write(*statsFileFlag); err != nil {
+			meta.ERROR.Panic(err)
+		}
+	}
 	w, err := os.Create(outFile)
 	if err != nil {
 		panic(err)
 	}
 	defer w.Close()
 
-	w.WriteString("l,m,v,n,t,b\n")
-	results := collector.Results()
-	for _, res := range results {
-		w.WriteString(fmt.Sprintf("%d,%g,%g,%d,%s,all\n",
-			res.Lag, res.Value, res.Variance, res.Count, res.Type))
+	var groups []string
+	for group := range collectors {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	rng := rand.New(rand.NewSource(*seedFlag))
+	var groupResults []GroupResults
+	for _, group := range groups {
+		g := GroupResults{Group: group, Results: collectors[group].Results()}
+		if !*skipEmptyFlag {
+			g.Results = collectors[group].AllResults(maxl)
+		}
+		if useJackknife {
+			g.SEs = jackknife(refCollectors[group])
+		}
+		if numBoot > 0 {
+			g.CIs = bootstrap(refCollectors[group], numBoot, rng)
+		}
+		groupResults = append(groupResults, g)
+	}
+	if err := writeResults(w, groupResults, outFormat, useJackknife, numBoot > 0, *naStringFlag); err != nil {
+		meta.ERROR.Panic(err)
+	}
+	if *checkpointFileFlag != "" {
+		if err := os.Remove(*checkpointFileFlag); err != nil && !os.IsNotExist(err) {
+			meta.ERROR.Panic(err)
+		}
 	}
 }
 
@@ -191,7 +409,7 @@ func pileupCodons(geneRecords GeneSamRecords) (codonGene *CodonGene) {
 	codonGene = NewCodonGene()
 	for _, read := range geneRecords.Records {
 		if checkReadQuality(read) {
-			codonArray := getCodons(read, geneRecords.Start, geneRecords.Strand)
+			codonArray := getCodons(read, geneRecords)
 			for _, codon := range codonArray {
 				if !codon.ContainsGap() {
 					codonGene.AddCodon(codon)
@@ -205,7 +423,7 @@ func pileupCodons(geneRecords GeneSamRecords) (codonGene *CodonGene) {
 
 // checkReadQuality return false if the read fails quality check.
 func checkReadQuality(read *sam.Record) bool {
-	if int(read.MapQ) < MinMapQuality || read.Len() < MinReadLength {
+	if discardReason(read) != "" {
 		return false
 	}
 
@@ -218,21 +436,32 @@ func checkReadQuality(read *sam.Record) bool {
     return true
 }
 
-// getCodons split a read into a list of Codon.
-func getCodons(read *sam.Record, offset, strand int) (codonArray []Codon) {
+// getCodons split a read into a list of Codon,
+// in the reading frame given by the strand and the phase of the gene.
+// Only complete codons inside the gene are returned,
+// and GenePos counts codons from the start codon.
+func getCodons(read *sam.Record, gene GeneSamRecords) (codonArray []Codon) {
 	// get the mapped sequence of the read onto the reference.
 	mappedSeq, _ := Map2Ref(read)
-	for i := 2; i < len(mappedSeq); {
-		if (read.Pos+i-offset+1)%3 == 0 {
-			codonSeq := mappedSeq[i-2 : i+1]
-			genePos := (read.Pos+i-offset+1)/3 - 1
-			if genePos >= 0 {
-				if strand == -1 {
-					codonSeq = seq.Reverse(seq.Complement(codonSeq))
-				}
-				codon := Codon{ReadID: read.Name, Seq: string(codonSeq), GenePos: genePos}
-				codonArray = append(codonArray, codon)
+	for i := 0; i+3 <= len(mappedSeq); {
+		start := read.Pos + i // start of the codon in the reference.
+		var offset int        // distance from the first codon.
+		if gene.Strand == -1 {
+			offset = gene.End - gene.Phase - (start + 3)
+		} else {
+			offset = start - (gene.Start + gene.Phase)
+		}
+		if offset >= 0 && offset%3 == 0 && start >= gene.Start && start+3 <= gene.End {
+			if excluded(gene.Ref, start) {
+				i += 3
+				continue
+			}
+			codonSeq := mappedSeq[i : i+3]
+			if gene.Strand == -1 {
+				codonSeq = seq.Reverse(seq.Complement(codonSeq))
 			}
+			codon := Codon{ReadID: read.Name, Seq: string(codonSeq), GenePos: offset / 3}
+			codonArray = append(codonArray, codon)
 			i += 3
 		} else {
 			i++
@@ -242,17 +471,14 @@ func getCodons(read *sam.Record, offset, strand int) (codonArray []Codon) {
 	return
 }
 
-func isATGC(b byte) bool {
-	if b == 'A' {
-		return true
-	} else if b == 'T' {
-		return true
-	} else if b == 'C' {
-		return true
-	} else if b == 'G' {
-		return true
+// excluded returns true if a base of the codon starting at
+// the position start of the reference ref is in the Exclude regions.
+func excluded(ref string, start int) bool {
+	for k := 0; k < 3; k++ {
+		if Exclude.Contains(ref, start+k) {
+			return true
+		}
 	}
-
 	return false
 }
 
@@ -262,20 +488,20 @@ type P2 struct {
 	Count int
 }
 
-// doubleCount count codon pairs.
-func doubleCount(nc *NuclCov, codonPairArray []CodonPair) {
+// doubleCount count codon pairs, by their bases at index.
+func doubleCount(nc *NuclCov, codonPairArray []CodonPair, index int) {
 	for _, cp := range codonPairArray {
-		a := cp.A.Seq[2]
-		b := cp.B.Seq[2]
+		a := cp.A.Seq[index]
+		b := cp.B.Seq[index]
 		nc.Add(a, b)
 	}
 }
 
-func calcP2(gene *CodonGene, maxl, minDepth int, codeTable *taxonomy.GeneticCode) (p2Res []CorrResult) {
+func calcP2(gene *CodonGene, maxl, minDepth, pos int, codeTable *taxonomy.GeneticCode) (p2Res []CorrResult) {
 	alphabet := []byte{'A', 'T', 'G', 'C'}
 	for i := 0; i < gene.Len(); i++ {
 		for j := i; j < gene.Len(); j++ {
-			codonPairRaw := gene.PairCodonAt(i, j)
+			codonPairRaw := positionPairs(gene.PairCodonAt(i, j), pos, codeTable)
 			if len(codonPairRaw) < 2 {
 				continue
 			}
@@ -291,10 +517,10 @@ func calcP2(gene *CodonGene, maxl, minDepth int, codeTable *taxonomy.GeneticCode
 			for _, synPairs := range splittedCodonPairs {
 				if len(synPairs) > minDepth {
 					nc := NewNuclCov(alphabet)
-					doubleCount(nc, synPairs)
+					doubleCount(nc, synPairs, codonIndex(pos))
 
 					for len(p2Res) <= lag {
-						p2Res = append(p2Res, CorrResult{Type: "P2", Lag: len(p2Res)})
+						p2Res = append(p2Res, CorrResult{Type: "P2" + positionTag(pos), Lag: len(p2Res)})
 					}
 					xy, _, _, n := nc.Cov11(MinAlleleDepth)
 					p2Res[lag].Count += int64(n)
@@ -307,12 +533,12 @@ func calcP2(gene *CodonGene, maxl, minDepth int, codeTable *taxonomy.GeneticCode
 	return
 }
 
-func calcP4(gene *CodonGene, maxl, minDepth int, codeTable *taxonomy.GeneticCode) (p4Res []CorrResult) {
+func calcP4(gene *CodonGene, maxl, minDepth, pos int, codeTable *taxonomy.GeneticCode) (p4Res []CorrResult) {
 	var valueArray []float64
 	var countArray []int
 	var posArray []int
 	for i := 0; i < gene.Len(); i++ {
-		value, count := autoCov(gene, i, minDepth, codeTable)
+		value, count := autoCov(gene, i, minDepth, pos, codeTable)
 		if count > 0 {
 			pos := gene.CodonPiles[i].GenePos()
 			valueArray = append(valueArray, value)
@@ -336,7 +562,7 @@ func calcP4(gene *CodonGene, maxl, minDepth int, codeTable *taxonomy.GeneticCode
 				break
 			}
 			for len(p4Res) <= lag {
-				p4Res = append(p4Res, CorrResult{Type: "P4", Lag: len(p4Res)})
+				p4Res = append(p4Res, CorrResult{Type: "P4" + positionTag(pos), Lag: len(p4Res)})
 			}
 			p4Res[lag].Value += xbar * ybar
 			p4Res[lag].Count++
@@ -346,9 +572,9 @@ func calcP4(gene *CodonGene, maxl, minDepth int, codeTable *taxonomy.GeneticCode
 	return
 }
 
-func autoCov(gene *CodonGene, i, minDepth int, codeTable *taxonomy.GeneticCode) (value float64, count int) {
+func autoCov(gene *CodonGene, i, minDepth, pos int, codeTable *taxonomy.GeneticCode) (value float64, count int) {
 	alphabet := []byte{'A', 'T', 'G', 'C'}
-	codonPairRaw := gene.PairCodonAt(i, i)
+	codonPairRaw := positionPairs(gene.PairCodonAt(i, i), pos, codeTable)
 	if len(codonPairRaw) < 2 {
 		return
 	}
@@ -361,7 +587,7 @@ func autoCov(gene *CodonGene, i, minDepth int, codeTable *taxonomy.GeneticCode)
 	for _, synPairs := range splittedCodonPairs {
 		if len(synPairs) > minDepth {
 			nc := NewNuclCov(alphabet)
-			doubleCount(nc, synPairs)
+			doubleCount(nc, synPairs, codonIndex(pos))
 
 			xy, _, _, n := nc.Cov11(MinAlleleDepth)
 			value += xy
@@ -372,6 +598,8 @@ func autoCov(gene *CodonGene, i, minDepth int, codeTable *taxonomy.GeneticCode)
 }
 
 // Map2Ref Obtains a read mapping to the reference genome.
+// Unlike p2.Map2Ref, deleted bases and bases with a quality below MinBaseQuality are '-',
+// and it returns nothing if the CIGAR does not agree with the read length.
 func Map2Ref(r *sam.Record) (s []byte, q []byte) {
 	p := 0                 // position in the read sequence.
 	read := r.Seq.Expand() // read sequence.
@@ -406,7 +634,7 @@ func Map2Ref(r *sam.Record) (s []byte, q []byte) {
 	s = bytes.ToUpper(s)
 
 	for i, a := range q {
-		if int(a) < MinBaseQuality {
+		if p2.Phred(a, QualOffset) < MinBaseQuality {
 			s[i] = '-'
 		}
 	}
@@ -430,7 +658,7 @@ func checkCoverage(gene *CodonGene, geneLen, minDepth int, minCoverage float64)
 func readLines(filename string) []string {
 	f, err := os.Open(filename)
 	if err != nil {
-		log.Panic(err)
+		meta.ERROR.Panic(err)
 	}
 	defer f.Close()
 
@@ -440,7 +668,7 @@ func readLines(filename string) []string {
 		line, err := rd.ReadString('\n')
 		if err != nil {
 			if err != io.EOF {
-				log.Panic(err)
+				meta.ERROR.Panic(err)
 			}
 			break
 		}
@@ -470,3 +698,51 @@ func subsample(geneRecords GeneSamRecords, maxDepth float64) GeneSamRecords {
 
 	return geneRecords
 }
+
+// capPileup returns the reads of a gene, sorted by position, with at most maxPileup
+// (of those used) overlapping each position, and the number of dropped reads.
+// Beyond the cap, the reads are reservoir sampled into the maxPileup slots of
+// the reads overlapping the current one, by a generator with a fixed seed
+// so that the sample of a gene does not depend on the order of the genes.
+func capPileup(reads []*sam.Record, maxPileup int) (kept []*sam.Record, dropped int) {
+	rng := rand.New(rand.NewSource(1))
+	keep := make([]bool, len(reads))
+	var window []int // indices of the sampled reads overlapping the current read.
+	offered := 0     // reads offered to the window since it is full.
+	for i, read := range reads {
+		keep[i] = true
+		if !checkReadQuality(read) {
+			// not piled up, nor counted in the window.
+			continue
+		}
+		n := 0
+		for _, k := range window {
+			if reads[k].End() > read.Pos {
+				window[n] = k
+				n++
+			}
+		}
+		window = window[:n]
+		if len(window) < maxPileup {
+			offered = 0
+			window = append(window, i)
+			continue
+		}
+		// read is the offered-th read beyond the cap,
+		// sampled with a probability maxPileup/(maxPileup+offered).
+		offered++
+		dropped++
+		if j := rng.Intn(maxPileup + offered); j < maxPileup {
+			keep[window[j]] = false
+			window[j] = i
+		} else {
+			keep[i] = false
+		}
+	}
+	for i, read := range reads {
+		if keep[i] {
+			kept = append(kept, read)
+		}
+	}
+	return
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/meta/p2"
+)
+
+func TestGetCodons(t *testing.T) {
+	ref, err := sam.NewReference("NC_000000", "", "", 100, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	s := []byte("AACGTACGTACG")
+	qual := make([]byte, len(s))
+	for i := range qual {
+		qual[i] = 30
+	}
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, len(s))}
+	read, err := sam.NewRecord("read", ref, nil, 0, -1, 0, 60, cigar, s, qual, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		gene     GeneSamRecords
+		expected []Codon
+	}{
+		// the first codon starts after the phase, and the incomplete last codon is dropped.
+		{
+			GeneSamRecords{Start: 2, End: 11, Phase: 1},
+			[]Codon{{ReadID: "read", Seq: "GTA", GenePos: 0}, {ReadID: "read", Seq: "CGT", GenePos: 1}},
+		},
+		// codons of a reverse-strand gene are counted from its end.
+		{
+			GeneSamRecords{Start: 2, End: 11, Strand: -1},
+			[]Codon{{ReadID: "read", Seq: "ACG", GenePos: 2}, {ReadID: "read", Seq: "CGT", GenePos: 1}, {ReadID: "read", Seq: "GTA", GenePos: 0}},
+		},
+		// the phase of a reverse-strand gene is counted from its end.
+		{
+			GeneSamRecords{Start: 1, End: 12, Strand: -1, Phase: 2},
+			[]Codon{{ReadID: "read", Seq: "CGT", GenePos: 2}, {ReadID: "read", Seq: "GTA", GenePos: 1}, {ReadID: "read", Seq: "TAC", GenePos: 0}},
+		},
+		// a reference of the pan-genome is a gene from its first base.
+		{
+			GeneSamRecords{Start: 0, End: 12},
+			[]Codon{{ReadID: "read", Seq: "AAC", GenePos: 0}, {ReadID: "read", Seq: "GTA", GenePos: 1}, {ReadID: "read", Seq: "CGT", GenePos: 2}, {ReadID: "read", Seq: "ACG", GenePos: 3}},
+		},
+	}
+
+	for _, tc := range testCases {
+		codons := getCodons(read, tc.gene)
+		if len(codons) != len(tc.expected) {
+			t.Errorf("%+v, Expect %v, got %v\n", tc.gene, tc.expected, codons)
+			continue
+		}
+		for i, c := range codons {
+			if c != tc.expected[i] {
+				t.Errorf("%+v, Expect %v, got %v\n", tc.gene, tc.expected[i], c)
+			}
+		}
+	}
+}
+
+func TestMap2RefQualOffset(t *testing.T) {
+	ref, err := sam.NewReference("NC_000000", "", "", 100, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}
+
+	defer func(minBQ, offset int) { MinBaseQuality, QualOffset = minBQ, offset }(MinBaseQuality, QualOffset)
+	MinBaseQuality = 30
+
+	testCases := []struct {
+		offset   int
+		qual     []byte
+		expected string
+	}{
+		// raw Phred scores 30, 40, 10 and 29.
+		{0, []byte{30, 40, 10, 29}, "AC--"},
+		// the same scores with the ASCII offset.
+		{33, []byte{63, 73, 43, 62}, "AC--"},
+	}
+	for _, tc := range testCases {
+		QualOffset = tc.offset
+		read, err := sam.NewRecord("read", ref, nil, 0, -1, 0, 60, cigar, []byte("ACGT"), tc.qual, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s, _ := Map2Ref(read); string(s) != tc.expected {
+			t.Errorf("offset %d, Expect %s, got %s\n", tc.offset, tc.expected, s)
+		}
+	}
+}
+
+func TestGetCodonsExclude(t *testing.T) {
+	ref, err := sam.NewReference("NC_000000", "", "", 100, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	s := []byte("AACGTACGTACG")
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, len(s))}
+	read, err := sam.NewRecord("read", ref, nil, 0, -1, 0, 60, cigar, s, bytes.Repeat([]byte{30}, len(s)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(regions p2.Regions) { Exclude = regions }(Exclude)
+	// the last base of the first codon (GTA, at 3-5) is excluded,
+	// but not the next codon (CGT, at 6-8).
+	Exclude = p2.Regions{"NC_000000": {{Start: 5, End: 6}}}
+	gene := GeneSamRecords{Ref: "NC_000000", Start: 2, End: 11, Phase: 1}
+	expected := []Codon{{ReadID: "read", Seq: "CGT", GenePos: 1}}
+	codons := getCodons(read, gene)
+	if len(codons) != len(expected) || codons[0] != expected[0] {
+		t.Errorf("Expect %v, got %v\n", expected, codons)
+	}
+}
+
+func TestCapPileup(t *testing.T) {
+	defer func(mapQ, readLen int) { MinMapQuality, MinReadLength = mapQ, readLen }(MinMapQuality, MinReadLength)
+	MinMapQuality, MinReadLength = 30, 0
+
+	ref, err := sam.NewReference("NC_000000", "", "", 1000, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	s := bytes.Repeat([]byte{'A'}, 50)
+	qual := bytes.Repeat([]byte{30}, 50)
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, len(s))}
+	// a pileup of 100 reads at 0-9, a read of a low mapping quality in it,
+	// and 3 reads at 500.
+	var reads []*sam.Record
+	for i := 0; i < 104; i++ {
+		pos, mapQ := i/10, byte(60)
+		if i == 50 {
+			mapQ = 0
+		}
+		if i >= 101 {
+			pos = 500
+		}
+		r, err := sam.NewRecord(fmt.Sprintf("read%d", i), ref, nil, pos, -1, 0, mapQ, cigar, s, qual, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reads = append(reads, r)
+	}
+
+	kept, dropped := capPileup(reads, 5)
+	if dropped != 95 || len(kept) != len(reads)-dropped {
+		t.Fatalf("Expect 95 dropped reads and %d kept, got %d and %d\n", len(reads)-95, dropped, len(kept))
+	}
+	for pos := 0; pos < 1000; pos++ {
+		depth := 0
+		for _, r := range kept {
+			if checkReadQuality(r) && r.Pos <= pos && pos < r.End() {
+				depth++
+			}
+		}
+		if depth > 5 {
+			t.Errorf("position %d, Expect at most 5 reads, got %d\n", pos, depth)
+		}
+	}
+	var names []string
+	lowQual := false
+	for i, r := range kept {
+		if i > 0 && r.Pos < kept[i-1].Pos {
+			t.Errorf("Expect the reads sorted by position, got %s after %s\n", r.Name, kept[i-1].Name)
+		}
+		lowQual = lowQual || r.Name == "read50"
+		names = append(names, r.Name)
+	}
+	if !lowQual {
+		t.Errorf("Expect the read of low mapping quality left to the pileup, got %v\n", names)
+	}
+
+	// the sample is reproducible.
+	again, _ := capPileup(reads, 5)
+	for i := range kept {
+		if again[i] != kept[i] {
+			t.Fatalf("Expect the same reads in both runs, got %s and %s\n", kept[i].Name, again[i].Name)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+)
+
+// GroupResults contains the pooled results of a group.
+type GroupResults struct {
+	Group   string
+	Results []CorrResult
+	SEs     map[resultKey]float64    // jackknife standard errors, nil if not calculated.
+	CIs     map[resultKey][2]float64 // bootstrap confidence intervals, nil if not calculated.
+}
+
+// writeResults writes the results of groups in csv or json format.
+// CSV has columns l,m,v,n,t,b, followed by se with jackknife, and lo,hi with bootstrap,
+// with naString for NaN values;
+// JSON is an array of an object for each group, with its Ks and results.
+func writeResults(w io.Writer, groups []GroupResults, format string, withSE, withCI bool, naString string) error {
+	switch format {
+	case "csv":
+		return writeCSV(w, groups, withSE, withCI, naString)
+	case "json":
+		return writeJSON(w, groups, withSE, withCI)
+	}
+	return fmt.Errorf("unknown output format %s", format)
+}
+
+func writeCSV(w io.Writer, groups []GroupResults, withSE, withCI bool, naString string) error {
+	header := "l,m,v,n,t,b"
+	if withSE {
+		header += ",se"
+	}
+	if withCI {
+		header += ",lo,hi"
+	}
+	if _, err := fmt.Fprintln(w, header); err != nil {
+		return err
+	}
+
+	for _, g := range groups {
+		for _, res := range g.Results {
+			line := fmt.Sprintf("%d,%s,%s,%d,%s,%s",
+				res.Lag, csvFloat(res.Value, naString), csvFloat(res.Variance, naString), res.Count, res.Type, g.Group)
+			if withSE {
+				line += "," + csvFloat(g.se(res), naString)
+			}
+			if withCI {
+				ci := g.ci(res)
+				line += "," + csvFloat(ci[0], naString) + "," + csvFloat(ci[1], naString)
+			}
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// csvFloat formats a value in csv, naString for NaN.
+func csvFloat(v float64, naString string) string {
+	if math.IsNaN(v) {
+		return naString
+	}
+	return fmt.Sprintf("%g", v)
+}
+
+// jsonResult is a CorrResult in json; NaN values are written as null.
+type jsonResult struct {
+	Lag      int      `json:"lag"`
+	Mean     *float64 `json:"mean"`
+	Variance *float64 `json:"variance"`
+	N        int64    `json:"n"`
+	Type     string   `json:"type"`
+	SE       *float64 `json:"se,omitempty"`
+	Lo       *float64 `json:"lo,omitempty"`
+	Hi       *float64 `json:"hi,omitempty"`
+}
+
+type jsonGroup struct {
+	Group   string       `json:"group"`
+	Ks      *float64     `json:"ks"`
+	Results []jsonResult `json:"results"`
+}
+
+// writeJSON writes the groups as a single json array, so that it is one document.
+func writeJSON(w io.Writer, groups []GroupResults, withSE, withCI bool) error {
+	jgs := []jsonGroup{}
+	for _, g := range groups {
+		jg := jsonGroup{Group: g.Group, Ks: jsonFloat(math.NaN()), Results: []jsonResult{}}
+		for _, res := range g.Results {
+			if res.Type == "Ks" {
+				jg.Ks = jsonFloat(res.Value)
+			}
+			jr := jsonResult{
+				Lag:      res.Lag,
+				Mean:     jsonFloat(res.Value),
+				Variance: jsonFloat(res.Variance),
+				N:        res.Count,
+				Type:     res.Type,
+			}
+			if withSE {
+				jr.SE = jsonFloat(g.se(res))
+			}
+			if withCI {
+				ci := g.ci(res)
+				jr.Lo, jr.Hi = jsonFloat(ci[0]), jsonFloat(ci[1])
+			}
+			jg.Results = append(jg.Results, jr)
+		}
+		jgs = append(jgs, jg)
+	}
+	return json.NewEncoder(w).Encode(jgs)
+}
+
+// se returns the jackknife standard error of a result, or NaN if not available.
+func (g GroupResults) se(res CorrResult) float64 {
+	se, found := g.SEs[resultKey{Type: res.Type, Lag: res.Lag}]
+	if !found {
+		return math.NaN()
+	}
+	return se
+}
+
+// ci returns the bootstrap confidence interval of a result, or NaNs if not available.
+func (g GroupResults) ci(res CorrResult) [2]float64 {
+	ci, found := g.CIs[resultKey{Type: res.Type, Lag: res.Lag}]
+	if !found {
+		return [2]float64{math.NaN(), math.NaN()}
+	}
+	return ci
+}
+
+// jsonFloat returns nil for NaN or infinite values, which json can not encode.
+func jsonFloat(v float64) *float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	return &v
+}
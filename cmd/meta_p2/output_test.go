@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"math"
+	"testing"
+)
+
+func TestWriteResults(t *testing.T) {
+	groups := []GroupResults{
+		{
+			Group: "all",
+			Results: []CorrResult{
+				{Lag: 0, Value: 0.01, Variance: 0.001, Count: 10, Type: "Ks"},
+				{Lag: 3, Value: 0.5, Variance: math.NaN(), Count: 1, Type: "P2"},
+			},
+		},
+		{
+			Group: "other",
+			Results: []CorrResult{
+				{Lag: 0, Value: 0.02, Variance: 0.002, Count: 5, Type: "Ks"},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeResults(&buf, groups, "csv", false, false, "NaN"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "l,m,v,n,t,b\n0,0.01,0.001,10,Ks,all\n3,0.5,NaN,1,P2,all\n0,0.02,0.002,5,Ks,other\n"
+	if buf.String() != expected {
+		t.Errorf("Expect csv\n%s\ngot\n%s\n", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := writeResults(&buf, groups, "json", false, false, "NaN"); err != nil {
+		t.Fatal(err)
+	}
+	// the groups are a single document.
+	var gs []struct {
+		Group   string
+		Ks      float64
+		Results []struct {
+			Lag      int
+			Mean     float64
+			Variance *float64
+			N        int64
+			Type     string
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &gs); err != nil {
+		t.Fatal(err)
+	}
+	if len(gs) != 2 {
+		t.Fatalf("Expect 2 groups, got %+v\n", gs)
+	}
+	if g := gs[1]; g.Group != "other" || g.Ks != 0.02 || len(g.Results) != 1 {
+		t.Errorf("Expect group other with Ks 0.02 and 1 result, got %+v\n", g)
+	}
+	g := gs[0]
+	if g.Group != "all" || g.Ks != 0.01 || len(g.Results) != 2 {
+		t.Fatalf("Expect group all with Ks 0.01 and 2 results, got %+v\n", g)
+	}
+	if r := g.Results[1]; r.Lag != 3 || r.Mean != 0.5 || r.Variance != nil || r.N != 1 || r.Type != "P2" {
+		t.Errorf("Expect {3 0.5 null 1 P2}, got %+v\n", r)
+	}
+
+	if err := writeResults(&buf, groups, "xml", false, false, "NaN"); err == nil {
+		t.Errorf("Expect an error for an unknown format\n")
+	}
+}
+
+func TestWriteResultsEmptyLags(t *testing.T) {
+	c := NewCollector()
+	c.Add(CorrResults{Results: []CorrResult{
+		{Type: "P2", Lag: 0, Value: 0.02, Count: 2},
+		{Type: "P2", Lag: 2, Value: 0.03, Count: 3},
+	}})
+	// without pairs at lag 0, the results are not normalized.
+	noKs := NewCollector()
+	noKs.Add(CorrResults{Results: []CorrResult{
+		{Type: "P2", Lag: 0},
+		{Type: "P2", Lag: 1, Value: 0.5, Count: 1},
+	}})
+
+	testCases := []struct {
+		results  []CorrResult
+		naString string
+		expected string
+	}{
+		{c.Results(), "NaN", "0,0.01,NaN,1,Ks,all\n6,1,NaN,1,P2,all\n"},
+		{c.AllResults(4), "NA", "0,0.01,NA,1,Ks,all\n3,NA,NA,0,P2,all\n6,1,NA,1,P2,all\n9,NA,NA,0,P2,all\n"},
+		{noKs.Results(), "NaN", "3,0.5,NaN,1,P2,all\n"},
+		{noKs.AllResults(2), "", "0,,,0,Ks,all\n3,0.5,,1,P2,all\n"},
+	}
+	for _, tc := range testCases {
+		var buf bytes.Buffer
+		groups := []GroupResults{{Group: "all", Results: tc.results}}
+		if err := writeResults(&buf, groups, "csv", false, false, tc.naString); err != nil {
+			t.Fatal(err)
+		}
+		expected := "l,m,v,n,t,b\n" + tc.expected
+		if buf.String() != expected {
+			t.Errorf("Expect csv\n%s\ngot\n%s\n", expected, buf.String())
+		}
+	}
+}
+
+// mergeInput is the json output read by meta_merge in its tests.
+const mergeInput = "../meta_merge/testdata/meta_p2.json"
+
+var update = flag.Bool("update", false, "update "+mergeInput)
+
+// TestWriteJSONMergeInput checks that the json output of writeJSON
+// is that read by meta_merge.
+func TestWriteJSONMergeInput(t *testing.T) {
+	groups := []GroupResults{
+		{
+			Group: "all",
+			Results: []CorrResult{
+				{Lag: 0, Value: 0.01, Variance: 0.0001, Count: 10, Type: "Ks"},
+				{Lag: 3, Value: 0.5, Variance: 0.04, Count: 8, Type: "P2"},
+				{Lag: 6, Value: 0.4, Variance: math.NaN(), Count: 1, Type: "P2"},
+			},
+			SEs: map[resultKey]float64{{Type: "P2", Lag: 3}: 0.1},
+		},
+		{
+			Group: "other",
+			Results: []CorrResult{
+				{Lag: 0, Value: 0.02, Variance: 0.0002, Count: 5, Type: "Ks"},
+				{Lag: 3, Value: 0.25, Variance: 0.01, Count: 4, Type: "P2"},
+			},
+		},
+	}
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, groups, true, false); err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if err := ioutil.WriteFile(mergeInput, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(mergeInput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Expect json\n%s\ngot\n%s\nrun go test -update if meta_merge reads the new format\n", expected, buf.Bytes())
+	}
+}
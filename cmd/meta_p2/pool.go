@@ -0,0 +1,35 @@
+package main
+
+import "sync"
+
+// pool runs jobs in go routines, at most n at a time.
+type pool struct {
+	slots chan bool
+	wg    sync.WaitGroup
+}
+
+func newPool(n int) *pool {
+	if n < 1 {
+		n = 1
+	}
+	return &pool{slots: make(chan bool, n)}
+}
+
+// Go runs the job f in a go routine,
+// waiting for one of the running jobs to finish if n of them are running.
+func (p *pool) Go(f func()) {
+	p.slots <- true
+	p.wg.Add(1)
+	go func() {
+		defer func() {
+			<-p.slots
+			p.wg.Done()
+		}()
+		f()
+	}()
+}
+
+// Wait waits for all the jobs to finish.
+func (p *pool) Wait() {
+	p.wg.Wait()
+}
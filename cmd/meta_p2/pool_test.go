@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPool(t *testing.T) {
+	for _, n := range []int{1, 3} {
+		var running, maxRunning int32
+		var mu sync.Mutex
+		var order []int
+		workers := newPool(n)
+		for i := 0; i < 10; i++ {
+			i := i
+			workers.Go(func() {
+				r := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&maxRunning)
+					if r <= m || atomic.CompareAndSwapInt32(&maxRunning, m, r) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				order = append(order, i)
+				mu.Unlock()
+				atomic.AddInt32(&running, -1)
+			})
+		}
+		workers.Wait()
+
+		if len(order) != 10 {
+			t.Errorf("n = %d, Expect 10 jobs, got %d\n", n, len(order))
+		}
+		if maxRunning > int32(n) {
+			t.Errorf("n = %d, Expect at most %d running jobs, got %d\n", n, n, maxRunning)
+		}
+		if n == 1 {
+			// serialized in the order of submission.
+			for i, j := range order {
+				if i != j {
+					t.Errorf("n = 1, Expect job %d at %d, got %d\n", i, i, j)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mingzhi/ncbiftp/taxonomy"
+)
+
+// Codon positions of the profiles (--positions):
+// the first, second and third positions of codons,
+// and fourFold, the third positions of four-fold degenerate codons.
+const (
+	firstPos  = 1
+	secondPos = 2
+	thirdPos  = 3
+	fourFold  = 4
+)
+
+// parsePositions parses a comma-separated list of codon positions,
+// such as "1,2,3".
+func parsePositions(s string) (positions []int, err error) {
+	seen := make(map[int]bool)
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		pos, err := strconv.Atoi(field)
+		if err != nil || pos < firstPos || pos > fourFold {
+			return nil, fmt.Errorf("codon position should be 1, 2, 3 or 4, got %s", field)
+		}
+		if !seen[pos] {
+			seen[pos] = true
+			positions = append(positions, pos)
+		}
+	}
+	if len(positions) == 0 {
+		return nil, fmt.Errorf("no codon position in %q", s)
+	}
+	return
+}
+
+// positionTag returns the tag of the types (P2, P4 and Ks) of the profiles
+// of a codon position, such as "_1" for P2_1: none for the third positions,
+// the profiles without --positions.
+func positionTag(pos int) string {
+	if pos == thirdPos {
+		return ""
+	}
+	return "_" + strconv.Itoa(pos)
+}
+
+// typeTag returns the position tag of a type.
+func typeTag(ctype string) string {
+	if i := strings.Index(ctype, "_"); i >= 0 {
+		return ctype[i:]
+	}
+	return ""
+}
+
+// codonIndex returns the index in a codon of the base of a codon position.
+func codonIndex(pos int) int {
+	if pos == fourFold {
+		return 2
+	}
+	return pos - 1
+}
+
+// positionPairs returns the codon pairs whose bases are of the codon position:
+// for fourFold, the pairs of four-fold degenerate codons, and otherwise all.
+func positionPairs(pairs []CodonPair, pos int, codeTable *taxonomy.GeneticCode) []CodonPair {
+	if pos != fourFold {
+		return pairs
+	}
+	var kept []CodonPair
+	for _, pair := range pairs {
+		if codeTable.IsFourFold(pair.A.Seq) && codeTable.IsFourFold(pair.B.Seq) {
+			kept = append(kept, pair)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mingzhi/ncbiftp/taxonomy"
+)
+
+// TestPositions checks that a synonymous substitution at each codon position
+// is in the profiles of its position only.
+func TestPositions(t *testing.T) {
+	codeTable := taxonomy.GeneticCodes()["11"]
+	testCases := []struct {
+		a, b     string
+		expected map[int]bool // positions with a substitution.
+	}{
+		// Leu, four-fold.
+		{"CTG", "CTA", map[int]bool{thirdPos: true, fourFold: true}},
+		// Leu, with a codon of a two-fold family.
+		{"CTG", "TTG", map[int]bool{firstPos: true}},
+		// stop codons.
+		{"TAA", "TGA", map[int]bool{secondPos: true}},
+	}
+	for _, tc := range testCases {
+		// half of the reads with each codon at 0, and the same codon at 1.
+		gene := NewCodonGene()
+		for i := 0; i < 10; i++ {
+			codon := tc.a
+			if i%2 == 1 {
+				codon = tc.b
+			}
+			readID := fmt.Sprintf("read%d", i)
+			gene.AddCodon(Codon{Seq: codon, ReadID: readID, GenePos: 0})
+			gene.AddCodon(Codon{Seq: "CTG", ReadID: readID, GenePos: 1})
+		}
+		for pos := firstPos; pos <= fourFold; pos++ {
+			results := calcP2(gene, 3, 2, pos, codeTable)
+			got := len(results) > 0 && results[0].Value > 0
+			if got != tc.expected[pos] {
+				t.Errorf("%s/%s, position %d, Expect a substitution %v, got %v\n", tc.a, tc.b, pos, tc.expected[pos], results)
+			}
+			for _, res := range results {
+				if res.Type != "P2"+positionTag(pos) {
+					t.Errorf("%s/%s, position %d, Expect type %s, got %s\n", tc.a, tc.b, pos, "P2"+positionTag(pos), res.Type)
+				}
+			}
+		}
+	}
+
+	// the profiles of each position are normalized by their own Ks.
+	c := NewCollector()
+	c.Add(CorrResults{Results: []CorrResult{
+		{Type: "P2", Lag: 0, Value: 0.02, Count: 1},
+		{Type: "P2", Lag: 1, Value: 0.01, Count: 1},
+		{Type: "P2_1", Lag: 0, Value: 0.04, Count: 1},
+		{Type: "P2_1", Lag: 1, Value: 0.01, Count: 1},
+		{Type: "P4_1", Lag: 1, Value: 0.02, Count: 1},
+	}})
+	expected := "[{0 0.02 1 Ks NaN} {3 0.5 1 P2 NaN} {0 0.04 1 Ks_1 NaN} {3 0.25 1 P2_1 NaN} {3 0.5 1 P4_1 NaN}]"
+	if got := fmt.Sprint(c.Results()); got != expected {
+		t.Errorf("Expect results %s, got %s\n", expected, got)
+	}
+
+	if _, err := parsePositions("1,5"); err == nil {
+		t.Errorf("Expect an error for codon position 5\n")
+	}
+	if positions, err := parsePositions("3, 1,3"); err != nil || fmt.Sprint(positions) != "[3 1]" {
+		t.Errorf("Expect positions [3 1], got %v (%v)\n", positions, err)
+	}
+}
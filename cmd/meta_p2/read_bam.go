@@ -1,61 +1,69 @@
 package main
 
 import (
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/biogo/hts/bam"
 	"github.com/biogo/hts/sam"
 	"github.com/mingzhi/biogo/feat/gff"
+	"github.com/mingzhi/meta/reads"
 )
 
-// SamReader is an interface for sam or bam reader.
-type SamReader interface {
-	Header() *sam.Header
-	Read() (*sam.Record, error)
-}
+// readSamRecords reads a sam or bam file, and return channels of the header and the records.
+// If fileName is "-", it reads from the standard input in the format (bam or sam);
+// otherwise the format is decided by the file extension.
+// Errors in opening the file and reading the header are returned;
+// the records are read in a go routine, and an error in reading them,
+// such as of a truncated file, is sent on errChan before samRecChan is closed.
+// errChan is closed after.
+func readSamRecords(fileName, format string) (headerChan chan *sam.Header, samRecChan chan *sam.Record, errChan chan error, err error) {
+	// Open file stream, which is closed when all records are read.
+	var f io.ReadCloser
+	if fileName == "-" {
+		f = ioutil.NopCloser(os.Stdin)
+	} else {
+		f, err = os.Open(fileName)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		format = "sam"
+		if strings.HasSuffix(fileName, "bam") {
+			format = "bam"
+		}
+	}
+
+	reader, err := reads.NewRecordReader(f, format)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	header := reader.Header()
 
-func readSamRecords(fileName string) (headerChan chan *sam.Header, samRecChan chan *sam.Record) {
 	headerChan = make(chan *sam.Header)
 	samRecChan = make(chan *sam.Record)
+	errChan = make(chan error, 1)
 	go func() {
+		defer close(errChan)
 		defer close(headerChan)
 		defer close(samRecChan)
-
-		// Open file stream, and close it when finished.
-		f, err := os.Open(fileName)
-		if err != nil {
-			panic(err)
-		}
 		defer f.Close()
+		defer reader.Close()
 
-		// Decide if it is a .sam or .bam file.
-		var reader SamReader
-		if fileName[len(fileName)-3:] == "bam" {
-			bamReader, err := bam.NewReader(f, 0)
-			if err != nil {
-				panic(err)
-			}
-			defer bamReader.Close()
-			reader = bamReader
-		} else {
-			reader, err = sam.NewReader(f)
-			if err != nil {
-				panic(err)
-			}
-		}
-
-		header := reader.Header()
 		headerChan <- header
 
 		// Read sam records and send them to the channel,
-		// until it hit an error, which raises a panic
+		// until it hit an error, which is sent to errChan
 		// if it is not a IO EOF.
 		for {
 			rec, err := reader.Read()
 			if err != nil {
 				if err != io.EOF {
-					panic(err)
+					errChan <- fmt.Errorf("reading %s: %v", fileName, err)
 				}
 				break
 			}
@@ -65,18 +73,195 @@ func readSamRecords(fileName string) (headerChan chan *sam.Header, samRecChan ch
 	return
 }
 
+// readBamRegion reads records overlapping a region of a reference
+// from a bam file, using its index (fileName + ".bai").
+// start and end are 1-based and inclusive.
+// Errors in reading the records are sent on errChan, as by readSamRecords.
+func readBamRegion(fileName, ref string, start, end int) (headerChan chan *sam.Header, samRecChan chan *sam.Record, errChan chan error, err error) {
+	idxFile, err := os.Open(fileName + ".bai")
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("can not find the index of %s, please index it using samtools index: %v", fileName, err)
+	}
+	defer idxFile.Close()
+	idx, err := bam.ReadIndex(idxFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	bamReader, err := bam.NewReader(f, 0)
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	header := bamReader.Header()
+
+	var reference *sam.Reference
+	for _, r := range header.Refs() {
+		if r.Name() == ref {
+			reference = r
+			break
+		}
+	}
+	if reference == nil {
+		bamReader.Close()
+		f.Close()
+		return nil, nil, nil, fmt.Errorf("can not find reference %s in %s", ref, fileName)
+	}
+
+	// the index uses 0-based half-open intervals.
+	beg := start - 1
+	chunks, err := idx.Chunks(reference, beg, end)
+	if err != nil {
+		bamReader.Close()
+		f.Close()
+		return nil, nil, nil, fmt.Errorf("region %s:%d-%d: %v", ref, start, end, err)
+	}
+	it, err := bam.NewIterator(bamReader, chunks)
+	if err != nil {
+		bamReader.Close()
+		f.Close()
+		return nil, nil, nil, err
+	}
+
+	headerChan = make(chan *sam.Header)
+	samRecChan = make(chan *sam.Record)
+	errChan = make(chan error, 1)
+	go func() {
+		defer close(errChan)
+		defer close(headerChan)
+		defer close(samRecChan)
+		defer f.Close()
+		defer bamReader.Close()
+		defer it.Close()
+
+		headerChan <- header
+
+		for it.Next() {
+			rec := it.Record()
+			if rec.Ref == reference && rec.Pos < end && rec.End() > beg {
+				samRecChan <- rec
+			}
+		}
+		if err := it.Error(); err != nil {
+			errChan <- fmt.Errorf("reading %s: %v", fileName, err)
+		}
+	}()
+
+	return
+}
+
+// mergeSamRecords merges the records of several sam or bam files,
+// each sorted by coordinate, into one channel in coordinate order,
+// so that the records of a reference from all files come together.
+// The files must have the same references, in the same order,
+// which is checked on their headers; the header of the first file is sent,
+// and the merged records are given its references.
+// Unmapped records come last.
+func mergeSamRecords(fileNames []string, headerChans []chan *sam.Header, samRecChans []chan *sam.Record) (headerChan chan *sam.Header, samRecChan chan *sam.Record, err error) {
+	if len(samRecChans) == 1 {
+		return headerChans[0], samRecChans[0], nil
+	}
+
+	headers := make([]*sam.Header, len(headerChans))
+	for i := range headerChans {
+		headers[i] = <-headerChans[i]
+	}
+	refs := headers[0].Refs()
+	for i := 1; i < len(headers); i++ {
+		others := headers[i].Refs()
+		if len(others) != len(refs) {
+			return nil, nil, fmt.Errorf("%s has %d references, but %s has %d", fileNames[i], len(others), fileNames[0], len(refs))
+		}
+		for j := range refs {
+			if others[j].Name() != refs[j].Name() || others[j].Len() != refs[j].Len() {
+				return nil, nil, fmt.Errorf("reference %d of %s is %s (length %d), but %s (length %d) in %s",
+					j, fileNames[i], others[j].Name(), others[j].Len(), refs[j].Name(), refs[j].Len(), fileNames[0])
+			}
+		}
+	}
+
+	headerChan = make(chan *sam.Header)
+	samRecChan = make(chan *sam.Record)
+	go func() {
+		defer close(headerChan)
+		defer close(samRecChan)
+
+		headerChan <- headers[0]
+
+		// the next record of each file, nil once it is read through.
+		heads := make([]*sam.Record, len(samRecChans))
+		for i := range samRecChans {
+			heads[i] = <-samRecChans[i]
+		}
+		for {
+			k := -1
+			for i, rec := range heads {
+				if rec != nil && (k < 0 || coordLess(rec, heads[k])) {
+					k = i
+				}
+			}
+			if k < 0 {
+				break
+			}
+			rec := heads[k]
+			if id := rec.Ref.ID(); id >= 0 {
+				rec.Ref = refs[id]
+			}
+			samRecChan <- rec
+			heads[k] = <-samRecChans[k]
+		}
+	}()
+	return
+}
+
+// coordLess returns true if record a comes before b in coordinate order,
+// with unmapped records last.
+func coordLess(a, b *sam.Record) bool {
+	refA, refB := a.Ref.ID(), b.Ref.ID()
+	if refA != refB {
+		return refB < 0 || (refA >= 0 && refA < refB)
+	}
+	return a.Pos < b.Pos
+}
+
+// parseRegion parses a region in the form of ref:start-end.
+func parseRegion(region string) (ref string, start, end int, err error) {
+	i := strings.LastIndex(region, ":")
+	j := strings.LastIndex(region, "-")
+	if i <= 0 || j < i {
+		err = fmt.Errorf("region should be ref:start-end, got %s", region)
+		return
+	}
+	ref = region[:i]
+	if start, err = strconv.Atoi(region[i+1 : j]); err != nil {
+		return
+	}
+	if end, err = strconv.Atoi(region[j+1:]); err != nil {
+		return
+	}
+	if start < 1 || end < start {
+		err = fmt.Errorf("bad region %s", region)
+	}
+	return
+}
+
 // GeneSamRecords stores Sam Records.
 type GeneSamRecords struct {
 	ID      string
+	Ref     string
 	Start   int
 	End     int
 	Strand  int
+	Phase   int // bases before the first codon, from the start (or the end, on the reverse strand).
 	Records []*sam.Record
 }
 
-// readPanGenomeBamFile reads bam file, and return the header and a channel of sam records.
-func readPanGenomeBamFile(fileName string) (header *sam.Header, recordsChan chan GeneSamRecords) {
-	headerChan, samRecChan := readSamRecords(fileName)
+// readPanGenomeBamFile groups sam records by references, and return the header and a channel of them.
+func readPanGenomeBamFile(headerChan chan *sam.Header, samRecChan chan *sam.Record) (header *sam.Header, recordsChan chan GeneSamRecords) {
 	header = <-headerChan
 	recordsChan = make(chan GeneSamRecords)
 	go func() {
@@ -89,7 +274,7 @@ func readPanGenomeBamFile(fileName string) (header *sam.Header, recordsChan chan
 			}
 			if rec.Ref.Name() != currentRefID {
 				if len(records) > 0 {
-					recordsChan <- GeneSamRecords{Start: 0, Records: records, End: records[0].Ref.Len(), ID: currentRefID}
+					recordsChan <- GeneSamRecords{Start: 0, Records: records, End: records[0].Ref.Len(), ID: currentRefID, Ref: currentRefID}
 					records = []*sam.Record{}
 				}
 				currentRefID = rec.Ref.Name()
@@ -97,16 +282,16 @@ func readPanGenomeBamFile(fileName string) (header *sam.Header, recordsChan chan
 			records = append(records, rec)
 		}
 		if len(records) > 0 {
-			recordsChan <- GeneSamRecords{Start: 0, Records: records, End: records[0].Ref.Len()}
+			recordsChan <- GeneSamRecords{Start: 0, Records: records, End: records[0].Ref.Len(), ID: currentRefID, Ref: currentRefID}
 		}
 	}()
 
 	return
 }
 
-//readStrainBamFile read []sam.Record from a bam file of mapping reads to a strain genome file.
-func readStrainBamFile(fileName string, gffMap map[string][]*gff.Record) (header *sam.Header, recordsChan chan GeneSamRecords) {
-	headerChan, samRecChan := readSamRecords(fileName)
+// readStrainBamFile groups sam records of reads mapped to a strain genome by genes,
+// and return the header and a channel of them.
+func readStrainBamFile(headerChan chan *sam.Header, samRecChan chan *sam.Record, gffMap map[string][]*gff.Record) (header *sam.Header, recordsChan chan GeneSamRecords) {
 	header = <-headerChan
 	recordsChan = make(chan GeneSamRecords)
 	go func() {
@@ -126,9 +311,13 @@ func readStrainBamFile(fileName string, gffMap map[string][]*gff.Record) (header
 					genes[i].Start = gffRecords[i].Start - 1
 					genes[i].End = gffRecords[i].End
 					genes[i].ID = gffRecords[i].ID()
+					genes[i].Ref = currentReference
 					if gffRecords[i].Strand == gff.ReverseStrand {
 						genes[i].Strand = -1
 					}
+					if phase, err := strconv.Atoi(gffRecords[i].Frame); err == nil {
+						genes[i].Phase = phase
+					}
 				}
 			}
 
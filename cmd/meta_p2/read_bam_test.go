@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/ncbiftp/taxonomy"
+)
+
+// writeBam writes the records to a bam file in dir, with the header.
+func writeBam(t *testing.T, dir, name string, header *sam.Header, records []*sam.Record) string {
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w, err := bam.NewWriter(f, header, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range records {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestMergeSamRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meta_p2_merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var refs []*sam.Reference
+	for _, name := range []string{"NC_000001", "NC_000002"} {
+		ref, err := sam.NewReference(name, "", "", 300, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, ref)
+	}
+	header, err := sam.NewHeader(nil, refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reads of two sequences, sorted by coordinate.
+	rng := rand.New(rand.NewSource(1))
+	genomes := make([][]byte, 2)
+	for i := range genomes {
+		genomes[i] = make([]byte, 300)
+		for j := range genomes[i] {
+			genomes[i][j] = "ACGT"[rng.Intn(4)]
+		}
+	}
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 60)}
+	var records []*sam.Record
+	for _, ref := range refs {
+		for pos := 0; pos+60 <= 300; pos += 3 {
+			s := append([]byte{}, genomes[rng.Intn(2)][pos:pos+60]...)
+			qual := make([]byte, len(s))
+			for i := range qual {
+				qual[i] = 40
+			}
+			r, err := sam.NewRecord(fmt.Sprintf("%s_%d", ref.Name(), pos), ref, nil, pos, -1, 0, 60, cigar, s, qual, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, r)
+		}
+	}
+
+	// records split into two files, e.g. per lane, and all of them in one.
+	var lane1, lane2 []*sam.Record
+	for i, r := range records {
+		if rng.Intn(2) == 0 || i == 0 {
+			lane1 = append(lane1, r)
+		} else {
+			lane2 = append(lane2, r)
+		}
+	}
+	fileNames := []string{writeBam(t, dir, "lane1.bam", header, lane1), writeBam(t, dir, "lane2.bam", header, lane2)}
+	merged := writeBam(t, dir, "merged.bam", header, records)
+
+	read := func(fileNames []string) (results []string) {
+		var headerChans []chan *sam.Header
+		var samRecChans []chan *sam.Record
+		for _, fileName := range fileNames {
+			headerChan, samRecChan, _, err := readSamRecords(fileName, "bam")
+			if err != nil {
+				t.Fatal(err)
+			}
+			headerChans = append(headerChans, headerChan)
+			samRecChans = append(samRecChans, samRecChan)
+		}
+		headerChan, samRecChan, err := mergeSamRecords(fileNames, headerChans, samRecChans)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, recordsChan := readPanGenomeBamFile(headerChan, samRecChan)
+		codeTable := taxonomy.GeneticCodes()["11"]
+		for geneRecords := range recordsChan {
+			var names []string
+			for _, r := range geneRecords.Records {
+				names = append(names, r.Name)
+			}
+			p2 := calcP2(pileupCodons(geneRecords), 30, 2, thirdPos, codeTable)
+			results = append(results, fmt.Sprintf("%s %d %v %v", geneRecords.ID, geneRecords.End, names, p2))
+		}
+		return
+	}
+
+	expected := read([]string{merged})
+	if len(expected) != len(refs) {
+		t.Fatalf("Expect %d references, got %d\n", len(refs), len(expected))
+	}
+	got := read(fileNames)
+	if len(got) != len(expected) {
+		t.Fatalf("Expect %d references, got %d\n", len(expected), len(got))
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("reference %d, Expect %s, got %s\n", i, expected[i], got[i])
+		}
+	}
+
+	// references in a different order are rejected.
+	other, err := sam.NewHeader(nil, []*sam.Reference{refs[1].Clone(), refs[0].Clone()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	swapped := writeBam(t, dir, "swapped.bam", other, nil)
+	var headerChans []chan *sam.Header
+	var samRecChans []chan *sam.Record
+	for _, fileName := range []string{merged, swapped} {
+		headerChan, samRecChan, _, err := readSamRecords(fileName, "bam")
+		if err != nil {
+			t.Fatal(err)
+		}
+		headerChans = append(headerChans, headerChan)
+		samRecChans = append(samRecChans, samRecChan)
+	}
+	if _, _, err := mergeSamRecords([]string{merged, swapped}, headerChans, samRecChans); err == nil {
+		t.Errorf("Expect an error for references in a different order\n")
+	}
+}
+
+// indexBam writes the index of a sorted bam file to path + ".bai".
+func indexBam(t *testing.T, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	br, err := bam.NewReader(f, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer br.Close()
+	var idx bam.Index
+	for {
+		r, err := br.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := idx.Add(r, br.LastChunk()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w, err := os.Create(path + ".bai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := bam.WriteIndex(w, &idx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadBamRegion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "meta_p2_region")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var refs []*sam.Reference
+	for _, name := range []string{"NC_000001", "NC_000002"} {
+		ref, err := sam.NewReference(name, "", "", 100, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, ref)
+	}
+	header, err := sam.NewHeader(nil, refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.SortOrder = sam.Coordinate
+
+	// reads of 10 bases at 1-10, 11-20, ..., 91-100 on both references.
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 10)}
+	s := []byte("ACGTACGTAC")
+	qual := []byte{40, 40, 40, 40, 40, 40, 40, 40, 40, 40}
+	var records []*sam.Record
+	for _, ref := range refs {
+		for pos := 0; pos < 100; pos += 10 {
+			r, err := sam.NewRecord(fmt.Sprintf("%s_%d", ref.Name(), pos+1), ref, nil, pos, -1, 0, 60, cigar, s, qual, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, r)
+		}
+	}
+	path := writeBam(t, dir, "sorted.bam", header, records)
+
+	// no index yet.
+	if _, _, _, err := readBamRegion(path, "NC_000001", 1, 10); err == nil {
+		t.Errorf("Expect an error for a bam file without index\n")
+	}
+
+	indexBam(t, path)
+	tests := []struct {
+		ref        string
+		start, end int
+		expect     []string
+	}{
+		{"NC_000001", 11, 20, []string{"NC_000001_11"}},
+		{"NC_000001", 10, 21, []string{"NC_000001_1", "NC_000001_11", "NC_000001_21"}},
+		{"NC_000001", 20, 20, []string{"NC_000001_11"}},
+		{"NC_000002", 95, 100, []string{"NC_000002_91"}},
+		{"NC_000002", 1, 1, []string{"NC_000002_1"}},
+	}
+	for _, test := range tests {
+		headerChan, samRecChan, errChan, err := readBamRegion(path, test.ref, test.start, test.end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-headerChan
+		var names []string
+		for r := range samRecChan {
+			names = append(names, r.Name)
+		}
+		if err := <-errChan; err != nil {
+			t.Errorf("%s:%d-%d: %v\n", test.ref, test.start, test.end, err)
+		}
+		if !reflect.DeepEqual(names, test.expect) {
+			t.Errorf("%s:%d-%d: Expect %v, got %v\n", test.ref, test.start, test.end, test.expect, names)
+		}
+	}
+
+	if _, _, _, err := readBamRegion(path, "NC_000003", 1, 10); err == nil {
+		t.Errorf("Expect an error for an unknown reference\n")
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// resultKey identifies a result by its type and lag.
+type resultKey struct {
+	Type string
+	Lag  int
+}
+
+// sum is the sum and the number of values.
+type sum struct {
+	total float64
+	n     int
+}
+
+// sums are the sums of values of each type and lag.
+type sums map[string][]sum
+
+// collectorSums returns the sums of the values in a collector.
+func collectorSums(c *Collector) sums {
+	s := make(sums)
+	for ctype, mvs := range c.m {
+		for _, mv := range mvs {
+			s[ctype] = append(s[ctype], sum{total: mv.Mean() * float64(mv.N), n: mv.N})
+		}
+	}
+	return s
+}
+
+// add adds another sums, multiplied by sign.
+func (s sums) add(s2 sums, sign int) {
+	for ctype, values := range s2 {
+		for len(s[ctype]) < len(values) {
+			s[ctype] = append(s[ctype], sum{})
+		}
+		for i, v := range values {
+			s[ctype][i].total += float64(sign) * v.total
+			s[ctype][i].n += sign * v.n
+		}
+	}
+}
+
+// values returns the pooled means, normalized by Ks as in Collector.Results.
+func (s sums) values() map[resultKey]float64 {
+	ksOf := make(map[string]float64)
+	for ctype, sums := range s {
+		if ctype == "P2"+typeTag(ctype) && len(sums) > 0 && sums[0].n > 0 {
+			ksOf[typeTag(ctype)] = sums[0].total / float64(sums[0].n)
+		}
+	}
+
+	values := make(map[resultKey]float64)
+	for ctype, sums := range s {
+		tag := typeTag(ctype)
+		ks := ksOf[tag]
+		for i, v := range sums {
+			if v.n <= 0 {
+				continue
+			}
+			mean := v.total / float64(v.n)
+			key := resultKey{Type: ctype, Lag: i * 3}
+			if ctype == "P2"+tag && i == 0 {
+				key.Type = "Ks" + tag
+			} else if ks != 0 {
+				mean /= ks
+			}
+			values[key] = mean
+		}
+	}
+	return values
+}
+
+// jackknife computes delete-one-reference jackknife standard errors
+// of the pooled results, from the collectors of each reference.
+func jackknife(refCollectors map[string]*Collector) map[resultKey]float64 {
+	// sort references, so that sums do not depend on the map order.
+	var refs []string
+	for ref := range refCollectors {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	refSums := make([]sums, len(refs))
+	totals := make(sums)
+	for i, ref := range refs {
+		refSums[i] = collectorSums(refCollectors[ref])
+		totals.add(refSums[i], 1)
+	}
+
+	replicates := make(map[resultKey][]float64)
+	for _, rs := range refSums {
+		s := make(sums)
+		s.add(totals, 1)
+		s.add(rs, -1)
+		for key, v := range s.values() {
+			replicates[key] = append(replicates[key], v)
+		}
+	}
+
+	ses := make(map[resultKey]float64)
+	for key, values := range replicates {
+		g := float64(len(values))
+		if len(values) < 2 {
+			ses[key] = math.NaN()
+			continue
+		}
+		mean := 0.0
+		for _, v := range values {
+			mean += v
+		}
+		mean /= g
+		ss := 0.0
+		for _, v := range values {
+			ss += (v - mean) * (v - mean)
+		}
+		ses[key] = math.Sqrt((g - 1) / g * ss)
+	}
+
+	return ses
+}
+
+// bootstrap computes 95% confidence intervals (2.5 and 97.5 percentiles)
+// of the pooled results, by resampling references with replacement numBoot times.
+func bootstrap(refCollectors map[string]*Collector, numBoot int, rng *rand.Rand) map[resultKey][2]float64 {
+	// sort references, so that the result only depends on rng.
+	var refs []string
+	for ref := range refCollectors {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	refSums := make([]sums, len(refs))
+	for i, ref := range refs {
+		refSums[i] = collectorSums(refCollectors[ref])
+	}
+
+	replicates := make(map[resultKey][]float64)
+	for b := 0; b < numBoot && len(refs) > 0; b++ {
+		s := make(sums)
+		for range refs {
+			s.add(refSums[rng.Intn(len(refs))], 1)
+		}
+		for key, v := range s.values() {
+			replicates[key] = append(replicates[key], v)
+		}
+	}
+
+	cis := make(map[resultKey][2]float64)
+	for key, values := range replicates {
+		sort.Float64s(values)
+		cis[key] = [2]float64{percentile(values, 0.025), percentile(values, 0.975)}
+	}
+	return cis
+}
+
+// percentile returns the p percentile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	i := int(p * float64(len(sorted)))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
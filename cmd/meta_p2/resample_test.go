@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func TestBootstrap(t *testing.T) {
+	refCollectors := make(map[string]*Collector)
+	pooled := NewCollector()
+	for i, ref := range []string{"r1", "r2", "r3", "r4", "r5"} {
+		results := CorrResults{Ref: ref, Results: []CorrResult{
+			{Lag: 0, Type: "P2", Value: 0.01 * float64(i+1), Count: 1},
+			{Lag: 1, Type: "P2", Value: 0.005 * float64(i+1) * float64(i+1), Count: 1},
+		}}
+		refCollectors[ref] = NewCollector()
+		refCollectors[ref].Add(results)
+		pooled.Add(results)
+	}
+
+	cis := bootstrap(refCollectors, 200, rand.New(rand.NewSource(1)))
+	again := bootstrap(refCollectors, 200, rand.New(rand.NewSource(1)))
+	if !reflect.DeepEqual(cis, again) {
+		t.Errorf("Expect the same intervals with the same seed, got %v and %v\n", cis, again)
+	}
+
+	for _, res := range pooled.Results() {
+		ci, found := cis[resultKey{Type: res.Type, Lag: res.Lag}]
+		if !found {
+			t.Errorf("Expect an interval of %s at %d\n", res.Type, res.Lag)
+			continue
+		}
+		if ci[0] > res.Value || ci[1] < res.Value || ci[0] == ci[1] {
+			t.Errorf("%s at %d, Expect %g in (%g, %g)\n", res.Type, res.Lag, res.Value, ci[0], ci[1])
+		}
+	}
+}
+
+func TestJackknife(t *testing.T) {
+	// P4 at lag 1 (3 bases) of 1, 2 and 4 in three references,
+	// whose delete-one means are 3, 2.5 and 1.5, of mean 7/3:
+	// the standard error is sqrt(2/3 * 7/6) = sqrt(7)/3.
+	refCollectors := make(map[string]*Collector)
+	for i, ref := range []string{"r1", "r2", "r3"} {
+		refCollectors[ref] = NewCollector()
+		refCollectors[ref].Add(CorrResults{Ref: ref, Results: []CorrResult{
+			{Lag: 1, Type: "P4", Value: math.Pow(2, float64(i)), Count: 1},
+		}})
+	}
+
+	ses := jackknife(refCollectors)
+	if len(ses) != 1 {
+		t.Errorf("Expect 1 standard error, got %v\n", ses)
+	}
+	expected := math.Sqrt(7) / 3
+	if se, found := ses[resultKey{Type: "P4", Lag: 3}]; !found || math.Abs(se-expected) > 1e-12 {
+		t.Errorf("Expect standard error %g, got %v\n", expected, ses)
+	}
+
+	// the references are summed in the same order in every run.
+	for i := 0; i < 20; i++ {
+		if again := jackknife(refCollectors); !reflect.DeepEqual(again, ses) {
+			t.Fatalf("Expect the same standard errors in every run, got %v and %v\n", ses, again)
+		}
+	}
+
+	// a single reference has no standard error.
+	if ses := jackknife(map[string]*Collector{"r1": refCollectors["r1"]}); len(ses) != 0 {
+		t.Errorf("Expect no standard error of a single reference, got %v\n", ses)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/meta"
+)
+
+// Reasons for discarding a read.
+const (
+	discardMapQ   = "map_qual"    // mapping quality below --min-map-qual.
+	discardLength = "read_length" // read shorter than --min-read-length.
+)
+
+// discardReason returns why a read is not used, or "" if it is used.
+func discardReason(read *sam.Record) string {
+	if int(read.MapQ) < MinMapQuality {
+		return discardMapQ
+	}
+	if read.Len() < MinReadLength {
+		return discardLength
+	}
+	return ""
+}
+
+// readStats counts the reads of a run, passing the filters or discarded by reason,
+// and the reads piled up: those passing the filters, of the genes calculated,
+// after --max-depth and --max-pileup; a read in two genes is piled up in each.
+type readStats struct {
+	mu        sync.Mutex
+	Total     int            `json:"total"`
+	Passed    int            `json:"passed"`
+	PiledUp   int            `json:"piled_up"`
+	Discarded map[string]int `json:"discarded"`
+}
+
+func newReadStats() *readStats {
+	return &readStats{Discarded: map[string]int{discardMapQ: 0, discardLength: 0}}
+}
+
+// Count counts a read.
+func (s *readStats) Count(read *sam.Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Total++
+	if reason := discardReason(read); reason != "" {
+		s.Discarded[reason]++
+	} else {
+		s.Passed++
+	}
+}
+
+// CountPileup counts the reads piled up of a gene, as pileupCodons does.
+func (s *readStats) CountPileup(reads []*sam.Record) {
+	n := 0
+	for _, read := range reads {
+		if checkReadQuality(read) {
+			n++
+		}
+	}
+	s.mu.Lock()
+	s.PiledUp += n
+	s.mu.Unlock()
+}
+
+// countReads counts the reads of samRecChan, each once,
+// and returns a channel of them.
+func countReads(samRecChan chan *sam.Record, stats *readStats) chan *sam.Record {
+	c := make(chan *sam.Record)
+	go func() {
+		defer close(c)
+		for read := range samRecChan {
+			stats.Count(read)
+			c <- read
+		}
+	}()
+	return c
+}
+
+// Report logs the counts.
+func (s *readStats) Report() {
+	meta.INFO.Printf("Reads: %d, passing the filters: %d, piled up: %d, discarded by mapping quality: %d, discarded by read length: %d\n",
+		s.Total, s.Passed, s.PiledUp, s.Discarded[discardMapQ], s.Discarded[discardLength])
+}
+
+// Write writes the counts to a file in JSON.
+func (s *readStats) Write(fileName string) error {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
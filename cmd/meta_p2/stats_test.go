@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestReadStats(t *testing.T) {
+	ref, err := sam.NewReference("NC_000000", "", "", 1000, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	defer func(mapQ, readLen int) { MinMapQuality, MinReadLength = mapQ, readLen }(MinMapQuality, MinReadLength)
+	MinMapQuality, MinReadLength = 30, 60
+
+	testCases := []struct {
+		mapQ   byte
+		length int
+		reason string
+	}{
+		{60, 100, ""},
+		{30, 60, ""},
+		{29, 100, discardMapQ},
+		{0, 30, discardMapQ}, // both, counted once by mapping quality.
+		{60, 59, discardLength},
+		{40, 20, discardLength},
+		{255, 100, ""},
+	}
+	samRecChan := make(chan *sam.Record)
+	go func() {
+		defer close(samRecChan)
+		for i, tc := range testCases {
+			cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, tc.length)}
+			s := bytes.Repeat([]byte("A"), tc.length)
+			r, err := sam.NewRecord("read", ref, nil, i, -1, 0, tc.mapQ, cigar, s, bytes.Repeat([]byte{30}, tc.length), nil)
+			if err != nil {
+				panic(err)
+			}
+			if reason := discardReason(r); reason != tc.reason {
+				t.Errorf("map qual %d, length %d, Expect discard reason %q, got %q\n", tc.mapQ, tc.length, tc.reason, reason)
+			}
+			samRecChan <- r
+		}
+	}()
+
+	stats := newReadStats()
+	var reads []*sam.Record
+	for read := range countReads(samRecChan, stats) {
+		reads = append(reads, read)
+	}
+	if len(reads) != len(testCases) {
+		t.Errorf("Expect %d reads passed on, got %d\n", len(testCases), len(reads))
+	}
+	if stats.Total != 7 || stats.Passed != 3 || stats.PiledUp != 0 || len(stats.Discarded) != 2 ||
+		stats.Discarded[discardMapQ] != 2 || stats.Discarded[discardLength] != 2 {
+		t.Errorf("Expect 7 reads, 3 passed, none piled up, and 2 discarded by each reason, got %d, %d, %d and %v\n",
+			stats.Total, stats.Passed, stats.PiledUp, stats.Discarded)
+	}
+
+	// only the reads of the genes piled up, passing the filters,
+	// once in each gene.
+	stats.CountPileup(reads[:3])
+	stats.CountPileup(reads[1:2])
+	if stats.PiledUp != 3 {
+		t.Errorf("Expect 3 reads piled up, got %d\n", stats.PiledUp)
+	}
+
+	dir, err := ioutil.TempDir("", "meta_p2_stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "stats.json")
+	if err := stats.Write(fileName); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got readStats
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Total != 7 || got.Passed != 3 || got.PiledUp != 3 || got.Discarded[discardLength] != 2 {
+		t.Errorf("Expect 7 reads, 3 passed, 3 piled up and 2 discarded by read length in the stats file, got %s\n", data)
+	}
+}
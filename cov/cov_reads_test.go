@@ -0,0 +1,125 @@
+package cov
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/gomath/stat/regression"
+	"github.com/mingzhi/meta/genome"
+	"github.com/mingzhi/meta/reads"
+)
+
+// simulation parameters of a two-ancestor mosaic population.
+type simParams struct {
+	genomeLen int     // length of the genome.
+	readLen   int     // length of each mate; mates are adjacent.
+	step      int     // distance between the starts of successive fragments.
+	diverge   float64 // fraction of sites differing between the two ancestors.
+	switchP   float64 // probability of switching ancestor at each base.
+}
+
+// simGenome generates a random reference genome whose positions are all four-fold sites,
+// and a second ancestor differing from it at a fraction of sites.
+func simGenome(rng *rand.Rand, p simParams) (g genome.Genome, ancestors [][]byte) {
+	const alphabet = "ATGC"
+	a := make([]byte, p.genomeLen)
+	b := make([]byte, p.genomeLen)
+	profile := make(genome.Profile, p.genomeLen)
+	for i := range a {
+		a[i] = alphabet[rng.Intn(4)]
+		b[i] = a[i]
+		if rng.Float64() < p.diverge {
+			b[i] = alphabet[(rng.Intn(3)+1+strings.IndexByte(alphabet, a[i]))%4]
+		}
+		profile[i] = genome.FourFold
+	}
+
+	g = genome.Genome{Accession: "NC_000000", Length: p.genomeLen, Seq: a, PosProfile: profile}
+	ancestors = [][]byte{a, b}
+	return
+}
+
+// simReads generates paired-end reads, one fragment every p.step bases.
+// Each fragment comes from an independent mosaic of the two ancestors,
+// switching ancestor with probability p.switchP at each base.
+func simReads(rng *rand.Rand, p simParams, ancestors [][]byte) (matedReads reads.PairedEndReads, err error) {
+	ref, err := sam.NewReference("NC_000000", "", "", p.genomeLen, nil, nil)
+	if err != nil {
+		return
+	}
+	if _, err = sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		return
+	}
+
+	fragLen := 2 * p.readLen
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, p.readLen)}
+	for start := 0; start+fragLen <= p.genomeLen; start += p.step {
+		s := make([]byte, fragLen)
+		k := rng.Intn(2)
+		for i := range s {
+			if rng.Float64() < p.switchP {
+				k = 1 - k
+			}
+			s[i] = ancestors[k][start+i]
+		}
+
+		name := "read" + strconv.Itoa(len(matedReads))
+		left, err := sam.NewRecord(name, ref, ref, start, start+p.readLen, fragLen, 60, cigar, s[:p.readLen], nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		right, err := sam.NewRecord(name, ref, ref, start+p.readLen, start, -fragLen, 60, cigar, s[p.readLen:], nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		matedReads = append(matedReads, reads.PairedEndRead{Name: name, ReadLeft: left, ReadRight: right})
+	}
+	sort.Sort(reads.ByRightCoordinatePairedEndReads{PairedEndReads: matedReads})
+
+	return
+}
+
+// TestReadsVsReadsSimulated runs the read-vs-read pipeline on simulated reads
+// and checks the recovered Ks and correlation decay against the model:
+// two reads differ at a site if they descend from different ancestors there,
+// so Ks = d/2 and Cov(l) = d^2/4 * (1-2q)^l with q = 2r(1-r), for l > 0.
+func TestReadsVsReadsSimulated(t *testing.T) {
+	p := simParams{genomeLen: 10000, readLen: 100, step: 8, diverge: 0.5, switchP: 0.01}
+	maxl := 30
+
+	rng := rand.New(rand.NewSource(1))
+	g, ancestors := simGenome(rng, p)
+	matedReads, err := simReads(rng, p, ancestors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kc, cc := ReadsVsReads(matedReads, g, maxl, 4)
+
+	expectedKs := p.diverge / 2
+	ks := kc.Mean.GetResult()
+	if math.Abs(ks-expectedKs) > 0.1*expectedKs {
+		t.Errorf("Ks, Expect %f, got %f\n", expectedKs, ks)
+	}
+
+	q := 2 * p.switchP * (1 - p.switchP)
+	lambda := 1 - 2*q
+	amplitude := p.diverge * p.diverge / 4
+
+	// fit log Cov(l) = log(A) + l*log(lambda).
+	s := regression.NewSimple()
+	for l := 1; l < maxl; l++ {
+		s.Add(float64(l), math.Log(cc.GetResult(l)))
+	}
+	if got := math.Exp(s.Slope()); math.Abs(got-lambda) > 0.01 {
+		t.Errorf("decay rate, Expect %f, got %f\n", lambda, got)
+	}
+	if got := math.Exp(s.Intercept()); math.Abs(got-amplitude) > 0.2*amplitude {
+		t.Errorf("amplitude, Expect %f, got %f\n", amplitude, got)
+	}
+}
@@ -0,0 +1,64 @@
+package fit
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Bootstrap estimates the 95% percentile intervals of the parameters
+// returned by the fitting function f, by resampling the (t, y) points
+// with replacement n times and refitting.
+// Resampled points are sorted by t, as the fitting functions start
+// from the first points; refits with a NaN parameter are discarded.
+func Bootstrap(t, y []float64, f func(t, y []float64) []float64, n int, rng *rand.Rand) (lo, hi []float64) {
+	if len(t) == 0 {
+		return
+	}
+
+	var samples [][]float64
+	for b := 0; b < n; b++ {
+		indices := make([]int, len(t))
+		for i := range indices {
+			indices[i] = rng.Intn(len(t))
+		}
+		sort.Ints(indices)
+
+		bt := make([]float64, len(t))
+		by := make([]float64, len(y))
+		for i, j := range indices {
+			bt[i] = t[j]
+			by[i] = y[j]
+		}
+
+		par := f(bt, by)
+		if !hasNaN(par) {
+			samples = append(samples, par)
+		}
+	}
+	if len(samples) == 0 {
+		return
+	}
+
+	lo = make([]float64, len(samples[0]))
+	hi = make([]float64, len(samples[0]))
+	values := make([]float64, len(samples))
+	for k := range lo {
+		for i, par := range samples {
+			values[i] = par[k]
+		}
+		sort.Float64s(values)
+		lo[k] = values[int(math.Floor(0.025*float64(len(values)-1)))]
+		hi[k] = values[int(math.Ceil(0.975*float64(len(values)-1)))]
+	}
+	return
+}
+
+func hasNaN(par []float64) bool {
+	for _, v := range par {
+		if math.IsNaN(v) {
+			return true
+		}
+	}
+	return false
+}
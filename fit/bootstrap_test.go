@@ -0,0 +1,36 @@
+package fit
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestBootstrap(t *testing.T) {
+	par := []float64{60, 150, 40}
+	x := []float64{}
+	y := []float64{}
+	for i := 1; i <= 100; i++ {
+		x = append(x, float64(i))
+		y = append(y, Exp(float64(i), par)*(1+0.01*math.Sin(float64(i))))
+	}
+	estimate := FitExp(x, y)
+
+	lo, hi := Bootstrap(x, y, FitExp, 200, rand.New(rand.NewSource(1)))
+	if len(lo) != len(estimate) || len(hi) != len(estimate) {
+		t.Fatalf("Expect %d intervals, got %d and %d\n", len(estimate), len(lo), len(hi))
+	}
+	for i := range estimate {
+		if lo[i] > estimate[i] || hi[i] < estimate[i] {
+			t.Errorf("%d, Expect [%f, %f] to bracket %f\n", i, lo[i], hi[i], estimate[i])
+		}
+	}
+
+	// the same seed gives the same intervals.
+	lo2, hi2 := Bootstrap(x, y, FitExp, 200, rand.New(rand.NewSource(1)))
+	for i := range lo {
+		if lo[i] != lo2[i] || hi[i] != hi2[i] {
+			t.Errorf("%d, Expect reproducible intervals, got [%f, %f] and [%f, %f]\n", i, lo[i], hi[i], lo2[i], hi2[i])
+		}
+	}
+}
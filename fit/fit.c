@@ -9,6 +9,10 @@ double expModel(double t, const double *p) {
 	return 1.0/(p[0] + p[1]*(1 - exp(-t/p[2])));
 }
 
+double powerModel(double t, const double *p) {
+	return p[0] * pow(t, -p[1]);
+}
+
 /*
  * Fit HyperModel.
  * m: number of data point;
@@ -34,3 +38,13 @@ int fitExp(int n, double *par, int m, double *t, double *y) {
 
 	return 0;
 }
+
+int fitPower(int n, double *par, int m, double *t, double *y) {
+	lm_control_struct control = lm_control_double;
+	lm_status_struct status;
+	control.verbosity = 0;
+
+	lmcurve(n, par, m, t, y, powerModel, &control, &status);
+
+	return 0;
+}
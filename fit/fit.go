@@ -6,6 +6,7 @@ import "C"
 import (
 	"github.com/mingzhi/gomath/stat/regression"
 
+	"math"
 	"unsafe"
 )
 
@@ -41,3 +42,73 @@ func FitExp(t, y []float64) []float64 {
 
 	return par
 }
+
+// FitPower fits the power-law model y = p[0] * t^(-p[1]),
+// starting from a linear regression of log(y) on log(t).
+// Points with a non-positive t or y are only used in the final fit.
+func FitPower(t, y []float64) []float64 {
+	s := regression.NewSimple()
+	for i := range t {
+		if t[i] > 0 && y[i] > 0 {
+			s.Add(math.Log(t[i]), math.Log(y[i]))
+		}
+	}
+
+	n := 2
+	m := len(t)
+	par := []float64{math.Exp(s.Intercept()), -s.Slope()}
+	C.fitPower(C.int(n), (*C.double)(unsafe.Pointer(&par[0])), C.int(m), (*C.double)(unsafe.Pointer(&t[0])), (*C.double)(unsafe.Pointer(&y[0])))
+
+	return par
+}
+
+// FitLinear fits the linear model y = p[0] + p[1] * t by least squares.
+func FitLinear(t, y []float64) []float64 {
+	s := regression.NewSimple()
+	for i := range t {
+		s.Add(t[i], y[i])
+	}
+
+	return []float64{s.Intercept(), s.Slope()}
+}
+
+// Hyper evaluates the model fitted by FitHyper at t.
+func Hyper(t float64, par []float64) float64 {
+	return 1.0 / (par[0] + par[1]*t)
+}
+
+// Exp evaluates the model fitted by FitExp at t.
+func Exp(t float64, par []float64) float64 {
+	return 1.0 / (par[0] + par[1]*(1-math.Exp(-t/par[2])))
+}
+
+// Power evaluates the model fitted by FitPower at t.
+func Power(t float64, par []float64) float64 {
+	return par[0] * math.Pow(t, -par[1])
+}
+
+// Linear evaluates the model fitted by FitLinear at t.
+func Linear(t float64, par []float64) float64 {
+	return par[0] + par[1]*t
+}
+
+// GoodnessOfFit returns the residual sum of squares of a model
+// with the parameters par over the data,
+// and its coefficient of determination R2,
+// relative to the mean of the observed y.
+func GoodnessOfFit(t, y []float64, model func(t float64, par []float64) float64, par []float64) (rss, r2 float64) {
+	mean := 0.0
+	for _, v := range y {
+		mean += v
+	}
+	mean /= float64(len(y))
+
+	tss := 0.0
+	for i := range t {
+		d := y[i] - model(t[i], par)
+		rss += d * d
+		tss += (y[i] - mean) * (y[i] - mean)
+	}
+	r2 = 1 - rss/tss
+	return
+}
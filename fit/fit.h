@@ -2,3 +2,4 @@
 #include "lmcurve.h"
 int fitHyper(int n, double *par, int m, double *t, double *y);
 int fitExp(int n, double *par, int m, double *t, double *y);
+int fitPower(int n, double *par, int m, double *t, double *y);
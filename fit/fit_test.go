@@ -31,3 +31,78 @@ func TestFitExp(t *testing.T) {
 		}
 	}
 }
+
+func TestFitPower(t *testing.T) {
+	expected := []float64{0.02, 0.35}
+	x := []float64{}
+	y := []float64{}
+	for i := 1; i <= 100; i++ {
+		x = append(x, float64(i))
+		// add a small deterministic noise.
+		noise := 1 + 0.01*math.Sin(float64(i))
+		y = append(y, expected[0]*math.Pow(float64(i), -expected[1])*noise)
+	}
+	par := FitPower(x, y)
+	for i := 0; i < len(expected); i++ {
+		if math.Abs(par[i]-expected[i]) > 0.01*expected[i] {
+			t.Errorf("%d, Expect %f, got %f\n", i, expected[i], par[i])
+		}
+	}
+}
+
+func TestFitLinear(t *testing.T) {
+	expected := []float64{0.015, -0.0001}
+	x := []float64{}
+	y := []float64{}
+	for i := 1; i <= 100; i++ {
+		x = append(x, float64(i))
+		noise := 1e-6 * math.Sin(float64(i))
+		y = append(y, expected[0]+expected[1]*float64(i)+noise)
+	}
+	par := FitLinear(x, y)
+	for i := 0; i < len(expected); i++ {
+		if math.Abs(par[i]-expected[i]) > 0.01*math.Abs(expected[i]) {
+			t.Errorf("%d, Expect %f, got %f\n", i, expected[i], par[i])
+		}
+	}
+}
+
+func TestFitExpSynthetic(t *testing.T) {
+	expected := []float64{60, 150, 40}
+	x := []float64{}
+	y := []float64{}
+	for i := 1; i <= 100; i++ {
+		x = append(x, float64(i))
+		noise := 1 + 0.001*math.Sin(float64(i))
+		y = append(y, noise/(expected[0]+expected[1]*(1-math.Exp(-float64(i)/expected[2]))))
+	}
+	par := FitExp(x, y)
+	for i := 0; i < len(expected); i++ {
+		if math.Abs(par[i]-expected[i]) > 0.05*expected[i] {
+			t.Errorf("%d, Expect %f, got %f\n", i, expected[i], par[i])
+		}
+	}
+}
+
+func TestGoodnessOfFit(t *testing.T) {
+	par := []float64{60, 150, 40}
+	x := []float64{}
+	y := []float64{}
+	noisy := []float64{}
+	for i := 1; i <= 100; i++ {
+		x = append(x, float64(i))
+		v := Exp(float64(i), par)
+		y = append(y, v)
+		noisy = append(noisy, v*(1+0.1*math.Sin(float64(i))))
+	}
+
+	rss, r2 := GoodnessOfFit(x, y, Exp, FitExp(x, y))
+	if rss > 1e-12 || math.Abs(r2-1) > 1e-6 {
+		t.Errorf("perfect fit, Expect RSS 0 and R2 1, got %g and %f\n", rss, r2)
+	}
+
+	_, noisyR2 := GoodnessOfFit(x, noisy, Exp, FitExp(x, noisy))
+	if noisyR2 >= r2 || noisyR2 <= 0 {
+		t.Errorf("noisy fit, Expect R2 in (0, %f), got %f\n", r2, noisyR2)
+	}
+}
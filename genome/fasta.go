@@ -0,0 +1,154 @@
+package genome
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mingzhi/biogo/seq"
+)
+
+// FastaFile gives access to the records of a FASTA file by name.
+// If a FASTA index (.fai) is found next to the file,
+// a sequence is read from disk only when it is requested;
+// otherwise all records are loaded into memory when opening.
+type FastaFile struct {
+	f     *os.File
+	names []string
+	index map[string]faiRecord
+	seqs  map[string][]byte
+}
+
+// faiRecord is a line of a samtools FASTA index.
+type faiRecord struct {
+	length    int   // length of the sequence.
+	offset    int64 // offset of the first base in the file.
+	lineBases int   // number of bases on each line.
+	lineWidth int   // number of bytes on each line, including the newline.
+}
+
+// OpenFasta opens a FASTA file,
+// using its index file (fileName + ".fai") when available.
+func OpenFasta(fileName string) (*FastaFile, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	ff := &FastaFile{}
+	idx, err := os.Open(fileName + ".fai")
+	if err == nil {
+		defer idx.Close()
+		ff.names, ff.index, err = readFai(idx)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s.fai: %v", fileName, err)
+		}
+		ff.f = f
+		return ff, nil
+	}
+
+	// No index, load all records.
+	defer f.Close()
+	records, err := seq.NewFastaReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	ff.seqs = make(map[string][]byte)
+	for _, r := range records {
+		ff.names = append(ff.names, r.Id)
+		ff.seqs[r.Id] = r.Seq
+	}
+
+	return ff, nil
+}
+
+// Names returns the sequence names in the order of the file.
+func (ff *FastaFile) Names() []string {
+	return ff.names
+}
+
+// Seq returns the sequence of the record with the name.
+func (ff *FastaFile) Seq(name string) ([]byte, error) {
+	if ff.index == nil {
+		s, found := ff.seqs[name]
+		if !found {
+			return nil, fmt.Errorf("can not find sequence %s", name)
+		}
+		return s, nil
+	}
+
+	r, found := ff.index[name]
+	if !found {
+		return nil, fmt.Errorf("can not find sequence %s", name)
+	}
+	if r.lineBases <= 0 {
+		return []byte{}, nil
+	}
+
+	size := int64(r.length/r.lineBases*r.lineWidth + r.length%r.lineBases)
+	data, err := ioutil.ReadAll(io.NewSectionReader(ff.f, r.offset, size))
+	if err != nil {
+		return nil, err
+	}
+
+	s := make([]byte, 0, r.length)
+	for _, b := range data {
+		if b != '\n' && b != '\r' {
+			s = append(s, b)
+		}
+	}
+	if len(s) != r.length {
+		return nil, fmt.Errorf("sequence %s has %d bases, but %d in the index", name, len(s), r.length)
+	}
+
+	return s, nil
+}
+
+// Close closes the underlying file.
+func (ff *FastaFile) Close() error {
+	if ff.f != nil {
+		return ff.f.Close()
+	}
+	return nil
+}
+
+// readFai reads a FASTA index.
+func readFai(r io.Reader) (names []string, index map[string]faiRecord, err error) {
+	index = make(map[string]faiRecord)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		terms := strings.Split(string(line), "\t")
+		if len(terms) < 5 {
+			return nil, nil, fmt.Errorf("bad index line: %s", line)
+		}
+
+		var values [4]int64
+		for i := range values {
+			values[i], err = strconv.ParseInt(terms[i+1], 10, 64)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		name := terms[0]
+		names = append(names, name)
+		index[name] = faiRecord{
+			length:    int(values[0]),
+			offset:    values[1],
+			lineBases: int(values[2]),
+			lineWidth: int(values[3]),
+		}
+	}
+
+	return names, index, scanner.Err()
+}
@@ -0,0 +1,96 @@
+package genome
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFastaFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "genome.fna")
+	fasta := ">chr1 first\nACGTA\nCGTAC\nGT\n>chr2\nTTTTT\nGG\n"
+	if err := ioutil.WriteFile(fileName, []byte(fasta), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"chr1": "ACGTACGTACGT", "chr2": "TTTTTGG"}
+
+	check := func() {
+		ff, err := OpenFasta(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ff.Close()
+
+		if len(ff.Names()) != 2 || ff.Names()[0] != "chr1" || ff.Names()[1] != "chr2" {
+			t.Errorf("Expect names [chr1 chr2], got %v\n", ff.Names())
+		}
+		for name, s := range expected {
+			got, err := ff.Seq(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != s {
+				t.Errorf("%s, Expect %s, got %s\n", name, s, got)
+			}
+		}
+		if _, err := ff.Seq("chr3"); err == nil {
+			t.Errorf("Expect error for a missing sequence\n")
+		}
+	}
+
+	// load all records.
+	check()
+
+	// read records through the index.
+	fai := "chr1\t12\t12\t5\t6\nchr2\t7\t33\t5\t6\n"
+	if err := ioutil.WriteFile(fileName+".fai", []byte(fai), 0644); err != nil {
+		t.Fatal(err)
+	}
+	check()
+}
+
+func TestReadFasta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "genome.fna")
+	if err := ioutil.WriteFile(fileName, []byte(">chr1\nACGT\n>chr2\nTTGG\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	seqs, err := ReadFastaAll(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seqs) != 2 || string(seqs[1].Seq) != "TTGG" {
+		t.Errorf("Expect 2 sequences, got %d\n", len(seqs))
+	}
+	s, err := ReadFasta(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Id != "chr1" || string(s.Seq) != "ACGT" {
+		t.Errorf("Expect chr1 ACGT, got %s %s\n", s.Id, s.Seq)
+	}
+
+	// an empty file is an error, not a panic.
+	emptyFile := filepath.Join(dir, "empty.fna")
+	if err := ioutil.WriteFile(emptyFile, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFasta(emptyFile); err == nil {
+		t.Errorf("Expect error for an empty file\n")
+	}
+	if _, err := ReadFasta(filepath.Join(dir, "missing.fna")); err == nil {
+		t.Errorf("Expect error for a missing file\n")
+	}
+}
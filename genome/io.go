@@ -1,6 +1,8 @@
 package genome
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/mingzhi/biogo/seq"
 	"io/ioutil"
 	"os"
@@ -23,20 +25,41 @@ func readProfile(fileName string) Profile {
 }
 
 func readFasta(fileName string) []byte {
-	f, err := os.Open(fileName)
+	s, err := ReadFasta(fileName)
 	if err != nil {
 		panic(err)
 	}
-	defer f.Close()
 
-	rd := seq.NewFastaReader(f)
+	return s.Seq
+}
 
-	seqs, err := rd.ReadAll()
+// ReadFastaAll reads all sequences in a FASTA file.
+func ReadFastaAll(fileName string) ([]*seq.Sequence, error) {
+	data, err := ioutil.ReadFile(fileName)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	// the FASTA reader panics if there is no record.
+	if bytes.IndexByte(data, '>') < 0 {
+		return nil, nil
+	}
+
+	rd := seq.NewFastaReader(bytes.NewReader(data))
+	return rd.ReadAll()
+}
+
+// ReadFasta reads the first sequence in a FASTA file,
+// and returns an error if there is none.
+func ReadFasta(fileName string) (*seq.Sequence, error) {
+	seqs, err := ReadFastaAll(fileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(seqs) == 0 {
+		return nil, fmt.Errorf("no sequence in %s", fileName)
 	}
 
-	return seqs[0].Seq
+	return seqs[0], nil
 }
 
 // Load position profile to the genome,
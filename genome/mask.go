@@ -0,0 +1,32 @@
+package genome
+
+import "github.com/mingzhi/ncbiftp/genomes/profiling"
+
+// UpperCase converts the bases of s to upper case in place,
+// and returns the 0-based, half-open intervals [start, end)
+// of the bases that were in lower case (soft-masked).
+func UpperCase(s []byte) (masked [][2]int) {
+	for i, b := range s {
+		if b < 'a' || b > 'z' {
+			continue
+		}
+		s[i] = b - 'a' + 'A'
+		if n := len(masked); n > 0 && masked[n-1][1] == i {
+			masked[n-1][1] = i + 1
+		} else {
+			masked = append(masked, [2]int{i, i + 1})
+		}
+	}
+	return
+}
+
+// MaskProfile sets the positions of a profile in the masked intervals
+// (see UpperCase) as Undefined, so that they are not used
+// as any type of position.
+func MaskProfile(profile []profiling.Pos, masked [][2]int) {
+	for _, m := range masked {
+		for i := m[0]; i < m[1] && i < len(profile); i++ {
+			profile[i].Type = profiling.Undefined
+		}
+	}
+}
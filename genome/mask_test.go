@@ -0,0 +1,32 @@
+package genome
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+func TestUpperCase(t *testing.T) {
+	s := []byte("acGTAcgTNn")
+	masked := UpperCase(s)
+	if string(s) != "ACGTACGTNN" {
+		t.Errorf("Expect ACGTACGTNN, got %s\n", s)
+	}
+	expected := [][2]int{{0, 2}, {5, 7}, {9, 10}}
+	if !reflect.DeepEqual(masked, expected) {
+		t.Errorf("Expect %v, got %v\n", expected, masked)
+	}
+
+	profile := make([]profiling.Pos, len(s))
+	for i := range profile {
+		profile[i].Type = profiling.FourFold
+	}
+	MaskProfile(profile, masked)
+	for i, p := range profile {
+		inMask := i < 2 || (i >= 5 && i < 7) || i == 9
+		if got := p.Type == profiling.Undefined; got != inMask {
+			t.Errorf("position %d, Expect masked %v, got %v\n", i, inMask, got)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package genome
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mingzhi/biogo/seq"
+)
+
+// twoBitSignature is the first word of a UCSC 2bit file,
+// in the byte order of the file.
+const twoBitSignature = 0x1A412743
+
+// twoBitBases are the bases of the 2-bit codes.
+var twoBitBases = [4]byte{'T', 'C', 'A', 'G'}
+
+// ReadTwoBit reads all sequences in a UCSC 2bit file.
+// The bases of the N blocks are N, and those of the mask blocks are in lower case,
+// as in the FASTA file of the same sequences.
+func ReadTwoBit(r io.ReaderAt) ([]*seq.Sequence, error) {
+	var order binary.ByteOrder = binary.LittleEndian
+	header := make([]byte, 16)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("2bit header: %v", err)
+	}
+	switch {
+	case binary.LittleEndian.Uint32(header) == twoBitSignature:
+	case binary.BigEndian.Uint32(header) == twoBitSignature:
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a 2bit file")
+	}
+	if version := order.Uint32(header[4:]); version != 0 {
+		return nil, fmt.Errorf("unsupported 2bit version %d", version)
+	}
+	count := int(order.Uint32(header[8:]))
+
+	// the index of the names and offsets of the sequences.
+	index := io.NewSectionReader(r, 16, 1<<62)
+	var seqs []*seq.Sequence
+	for i := 0; i < count; i++ {
+		var nameSize uint8
+		if err := binary.Read(index, order, &nameSize); err != nil {
+			return nil, fmt.Errorf("2bit index: %v", err)
+		}
+		name := make([]byte, nameSize)
+		if _, err := io.ReadFull(index, name); err != nil {
+			return nil, fmt.Errorf("2bit index: %v", err)
+		}
+		var offset uint32
+		if err := binary.Read(index, order, &offset); err != nil {
+			return nil, fmt.Errorf("2bit index: %v", err)
+		}
+
+		s, err := readTwoBitSeq(io.NewSectionReader(r, int64(offset), 1<<62), order)
+		if err != nil {
+			return nil, fmt.Errorf("2bit sequence %s: %v", name, err)
+		}
+		seqs = append(seqs, &seq.Sequence{Id: string(name), Name: string(name), Seq: s})
+	}
+	return seqs, nil
+}
+
+// readTwoBitSeq decodes the bases of a sequence record of a 2bit file.
+func readTwoBitSeq(r io.Reader, order binary.ByteOrder) ([]byte, error) {
+	readWords := func(n uint32) ([]uint32, error) {
+		words := make([]uint32, n)
+		err := binary.Read(r, order, words)
+		return words, err
+	}
+
+	var dnaSize, nBlockCount, maskBlockCount, reserved uint32
+	if err := binary.Read(r, order, &dnaSize); err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, order, &nBlockCount); err != nil {
+		return nil, err
+	}
+	nStarts, err := readWords(nBlockCount)
+	if err != nil {
+		return nil, err
+	}
+	nSizes, err := readWords(nBlockCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, order, &maskBlockCount); err != nil {
+		return nil, err
+	}
+	maskStarts, err := readWords(maskBlockCount)
+	if err != nil {
+		return nil, err
+	}
+	maskSizes, err := readWords(maskBlockCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := binary.Read(r, order, &reserved); err != nil {
+		return nil, err
+	}
+
+	packed := make([]byte, (dnaSize+3)/4)
+	if _, err := io.ReadFull(r, packed); err != nil {
+		return nil, err
+	}
+	s := make([]byte, dnaSize)
+	for i := range s {
+		// four bases in a byte, the first in the most significant bits.
+		s[i] = twoBitBases[(packed[i/4]>>uint(6-2*(i%4)))&3]
+	}
+
+	blocks := func(starts, sizes []uint32, f func(b byte) byte) error {
+		for k := range starts {
+			start, end := int(starts[k]), int(starts[k])+int(sizes[k])
+			if end > len(s) {
+				return fmt.Errorf("block [%d, %d) out of the sequence of length %d", start, end, len(s))
+			}
+			for j := start; j < end; j++ {
+				s[j] = f(s[j])
+			}
+		}
+		return nil
+	}
+	if err := blocks(nStarts, nSizes, func(b byte) byte { return 'N' }); err != nil {
+		return nil, err
+	}
+	if err := blocks(maskStarts, maskSizes, func(b byte) byte { return b - 'A' + 'a' }); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ReadTwoBitAll reads all sequences in a UCSC 2bit file.
+func ReadTwoBitAll(fileName string) ([]*seq.Sequence, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadTwoBit(f)
+}
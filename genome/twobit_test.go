@@ -0,0 +1,111 @@
+package genome
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// twoBitData encodes sequences in the 2bit format, in the byte order,
+// with N blocks for runs of N and mask blocks for runs of lower case bases.
+func twoBitData(names, seqs []string, order binary.ByteOrder) []byte {
+	codes := map[byte]byte{'T': 0, 'C': 1, 'A': 2, 'G': 3}
+	blocks := func(s string, in func(b byte) bool) (starts, sizes []uint32) {
+		for i := 0; i < len(s); i++ {
+			if in(s[i]) && (i == 0 || !in(s[i-1])) {
+				starts = append(starts, uint32(i))
+				sizes = append(sizes, 0)
+			}
+			if in(s[i]) {
+				sizes[len(sizes)-1]++
+			}
+		}
+		return
+	}
+
+	var records [][]byte
+	for _, s := range seqs {
+		var buf bytes.Buffer
+		nStarts, nSizes := blocks(s, func(b byte) bool { return b == 'N' || b == 'n' })
+		maskStarts, maskSizes := blocks(s, func(b byte) bool { return b >= 'a' && b <= 'z' })
+		binary.Write(&buf, order, uint32(len(s)))
+		binary.Write(&buf, order, uint32(len(nStarts)))
+		binary.Write(&buf, order, nStarts)
+		binary.Write(&buf, order, nSizes)
+		binary.Write(&buf, order, uint32(len(maskStarts)))
+		binary.Write(&buf, order, maskStarts)
+		binary.Write(&buf, order, maskSizes)
+		binary.Write(&buf, order, uint32(0))
+		packed := make([]byte, (len(s)+3)/4)
+		upper := strings.ToUpper(s)
+		for i := 0; i < len(upper); i++ {
+			packed[i/4] |= codes[upper[i]] << uint(6-2*(i%4))
+		}
+		buf.Write(packed)
+		records = append(records, buf.Bytes())
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, order, []uint32{twoBitSignature, 0, uint32(len(seqs)), 0})
+	offset := 16
+	for _, name := range names {
+		offset += 1 + len(name) + 4
+	}
+	for i, name := range names {
+		buf.WriteByte(byte(len(name)))
+		buf.WriteString(name)
+		binary.Write(&buf, order, uint32(offset))
+		offset += len(records[i])
+	}
+	for _, rec := range records {
+		buf.Write(rec)
+	}
+	return buf.Bytes()
+}
+
+func TestReadTwoBit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "twobit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	names := []string{"chr1", "chr2"}
+	seqs := []string{"ACGTACGTNNNNacgtaCG", "TTGGCCA"}
+	fasta := ">chr1\n" + seqs[0] + "\n>chr2\n" + seqs[1] + "\n"
+	fastaFile := filepath.Join(dir, "genome.fna")
+	if err := ioutil.WriteFile(fastaFile, []byte(fasta), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ReadFastaAll(fastaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		fileName := filepath.Join(dir, "genome.2bit")
+		if err := ioutil.WriteFile(fileName, twoBitData(names, seqs, order), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadTwoBitAll(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(expected) {
+			t.Fatalf("%v, Expect %d sequences, got %d\n", order, len(expected), len(got))
+		}
+		for i := range got {
+			if got[i].Id != expected[i].Id || string(got[i].Seq) != string(expected[i].Seq) {
+				t.Errorf("%v, Expect %s %s, got %s %s\n", order, expected[i].Id, expected[i].Seq, got[i].Id, got[i].Seq)
+			}
+		}
+	}
+
+	if _, err := ReadTwoBit(bytes.NewReader([]byte(fasta))); err == nil {
+		t.Errorf("Expect an error for a FASTA file\n")
+	}
+}
@@ -0,0 +1,102 @@
+// Package meta has the logging shared by the meta commands and packages.
+//
+// DEBUG, INFO, WARN and ERROR write messages of their level
+// if it is at least the level set by SetLevel (Info by default):
+//
+//	meta.SetLevel(meta.Warn)
+//	meta.INFO.Println("not written")
+//	meta.WARN.Println("written")
+package meta
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Level is the level of a log message.
+type Level int
+
+// Log levels, in increasing order.
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level of a name: debug, info, warn or error.
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.ToLower(name) == n {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("unknown log level %s, should be debug, info, warn or error", name)
+}
+
+// Loggers of each level.
+var (
+	DEBUG = newLogger(Debug, "DEBUG: ")
+	INFO  = newLogger(Info, "INFO: ")
+	WARN  = newLogger(Warn, "WARN: ")
+	ERROR = newLogger(Error, "ERROR: ")
+)
+
+var (
+	mu       sync.Mutex
+	minLevel           = Info
+	output   io.Writer = os.Stderr
+)
+
+// SetLevel sets the min level of the written messages.
+func SetLevel(l Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	minLevel = l
+}
+
+// SetQuiet only writes errors if quiet, as SetLevel(Error).
+func SetQuiet(quiet bool) {
+	if quiet {
+		SetLevel(Error)
+	}
+}
+
+// SetOutput sets the destination of the messages, os.Stderr by default.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	output = w
+}
+
+// levelWriter writes messages of a level to the output,
+// if the level is not lower than minLevel.
+type levelWriter struct {
+	level Level
+}
+
+func (w levelWriter) Write(p []byte) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if w.level < minLevel {
+		return len(p), nil
+	}
+	return output.Write(p)
+}
+
+func newLogger(l Level, prefix string) *log.Logger {
+	return log.New(levelWriter{level: l}, prefix, log.Ldate|log.Ltime|log.Lshortfile)
+}
@@ -0,0 +1,53 @@
+package meta
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSetLevel(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	defer SetLevel(Info)
+
+	SetLevel(Warn)
+	DEBUG.Println("debug message")
+	INFO.Println("info message")
+	WARN.Println("warn message")
+	ERROR.Println("error message")
+	out := buf.String()
+	for _, msg := range []string{"debug message", "info message"} {
+		if strings.Contains(out, msg) {
+			t.Errorf("Expect %q suppressed at warn level, got %q\n", msg, out)
+		}
+	}
+	for _, msg := range []string{"WARN: ", "warn message", "ERROR: ", "error message"} {
+		if !strings.Contains(out, msg) {
+			t.Errorf("Expect %q at warn level, got %q\n", msg, out)
+		}
+	}
+
+	buf.Reset()
+	SetLevel(Debug)
+	SetQuiet(true)
+	WARN.Println("warn message")
+	ERROR.Println("error message")
+	if out := buf.String(); strings.Contains(out, "warn message") || !strings.Contains(out, "error message") {
+		t.Errorf("Expect only errors when quiet, got %q\n", out)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, l := range []Level{Debug, Info, Warn, Error} {
+		got, err := ParseLevel(strings.ToUpper(l.String()))
+		if err != nil || got != l {
+			t.Errorf("%s, Expect %v, got %v (%v)\n", l, l, got, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("Expect an error for an unknown level\n")
+	}
+}
@@ -0,0 +1,112 @@
+package p2
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Interval is a 0-based, half-open interval [Start, End) of a reference.
+type Interval struct {
+	Start, End int
+}
+
+// Regions are sorted, non-overlapping intervals of each reference.
+type Regions map[string][]Interval
+
+// ReadBed reads the intervals of a BED file,
+// whose first three columns are the reference name,
+// the 0-based start and the end (exclusive) of an interval.
+// Header ("track", "browser"), comment and empty lines are skipped,
+// and overlapping intervals are merged.
+func ReadBed(r io.Reader) (Regions, error) {
+	regions := make(Regions)
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+			continue
+		}
+		terms := strings.Fields(line)
+		if len(terms) < 3 {
+			return nil, fmt.Errorf("line %d: expect at least 3 columns, got %d", lineNum, len(terms))
+		}
+		start, err := strconv.Atoi(terms[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		end, err := strconv.Atoi(terms[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		if start < 0 || end < start {
+			return nil, fmt.Errorf("line %d: bad interval [%d, %d)", lineNum, start, end)
+		}
+		regions[terms[0]] = append(regions[terms[0]], Interval{Start: start, End: end})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for ref, intervals := range regions {
+		regions[ref] = mergeIntervals(intervals)
+	}
+	return regions, nil
+}
+
+// ReadBedFile reads the intervals of a BED file, see ReadBed.
+func ReadBedFile(fileName string) (Regions, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadBed(f)
+}
+
+// mergeIntervals sorts intervals and merges the overlapping ones.
+func mergeIntervals(intervals []Interval) []Interval {
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].Start < intervals[j].Start })
+	var merged []Interval
+	for _, in := range intervals {
+		if in.Start == in.End {
+			continue
+		}
+		if n := len(merged); n > 0 && in.Start <= merged[n-1].End {
+			if in.End > merged[n-1].End {
+				merged[n-1].End = in.End
+			}
+			continue
+		}
+		merged = append(merged, in)
+	}
+	return merged
+}
+
+// Contains returns true if the 0-based position pos of the reference ref
+// is inside an interval.
+func (regions Regions) Contains(ref string, pos int) bool {
+	intervals := regions[ref]
+	// the first interval ending after pos.
+	i := sort.Search(len(intervals), func(i int) bool { return intervals[i].End > pos })
+	return i < len(intervals) && intervals[i].Start <= pos
+}
+
+// mask sets the positions of a substitution profile inside the regions to NaN.
+func (regions Regions) mask(subProfile SubProfile) {
+	if len(regions[subProfile.Ref]) == 0 {
+		return
+	}
+	for j := range subProfile.Profile {
+		if regions.Contains(subProfile.Ref, subProfile.Pos+j) {
+			subProfile.Profile[j] = math.NaN()
+		}
+	}
+}
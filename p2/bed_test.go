@@ -0,0 +1,80 @@
+package p2
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+func TestReadBed(t *testing.T) {
+	bed := "track name=repeats\n# comment\nchr1\t10\t20\trep1\nchr2\t0\t5\nchr1\t15\t30\n\nchr1\t40\t40\n"
+	regions, err := ReadBed(strings.NewReader(bed))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		ref      string
+		pos      int
+		expected bool
+	}{
+		{"chr1", 9, false},
+		{"chr1", 10, true}, // 0-based start.
+		{"chr1", 25, true}, // merged with the overlapping interval.
+		{"chr1", 29, true},
+		{"chr1", 30, false}, // exclusive end.
+		{"chr1", 40, false}, // empty interval.
+		{"chr2", 0, true},
+		{"chr2", 5, false},
+		{"chr3", 0, false},
+	}
+	for _, tc := range testCases {
+		if got := regions.Contains(tc.ref, tc.pos); got != tc.expected {
+			t.Errorf("%s:%d, Expect %v, got %v\n", tc.ref, tc.pos, tc.expected, got)
+		}
+	}
+
+	for _, bad := range []string{"chr1\t10\n", "chr1\tx\t20\n", "chr1\t20\t10\n"} {
+		if _, err := ReadBed(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q, Expect an error\n", bad)
+		}
+	}
+}
+
+func TestExcludeRegions(t *testing.T) {
+	profile := make([]profiling.Pos, 10)
+	for i := range profile {
+		profile[i].Type = profiling.FourFold
+	}
+	profileOf := func(ref string) []profiling.Pos { return profile }
+	regions := Regions{"chr1": {{Start: 3, End: 5}}}
+
+	testCases := []struct {
+		ref       string
+		expectedN []int // number of position pairs at each lag.
+	}{
+		// positions 3 and 4 are in no pair, while 2 and 5 still are.
+		{"chr1", []int{8, 6, 4, 3}},
+		// the regions of other references do not matter.
+		{"chr2", []int{10, 9, 8, 7}},
+	}
+	for _, tc := range testCases {
+		subProfile := SubProfile{Type: All, Ref: tc.ref, Pos: 0, Profile: make([]float64, len(profile))}
+		regions.mask(subProfile)
+
+		subProfileChan := make(chan SubProfile, 1)
+		subProfileChan <- subProfile
+		close(subProfileChan)
+		covsChan := calc(context.Background(), subProfileChan, profileOf, ConvertPosType(4), len(tc.expectedN), nil, 1, false, false)
+		for covsMap := range covsChan {
+			covs := covsMap[resultKey{class: All}]
+			for l, n := range tc.expectedN {
+				if covs[l].GetN() != n {
+					t.Errorf("%s, lag %d, Expect %d pairs, got %d\n", tc.ref, l, n, covs[l].GetN())
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package p2
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/mingzhi/gomath/stat/correlation"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+// benchProfile returns a genome profile of n positions in genes of 300 bases,
+// and n substitution profiles of 150 positions (as read pairs of 150 bp)
+// at random positions, with substitutions at 1% and missing values at 5%.
+func benchProfile(n int) ([]profiling.Pos, []SubProfile) {
+	rng := rand.New(rand.NewSource(1))
+	types := []byte{profiling.FirstPos, profiling.SecondPos, profiling.FourFold}
+	profile := make([]profiling.Pos, n)
+	for i := range profile {
+		profile[i].Type = types[i%3]
+		profile[i].Gene = fmt.Sprintf("gene%d", i/300)
+	}
+
+	var subProfiles []SubProfile
+	for k := 0; k < n; k++ {
+		sp := SubProfile{Type: All, Ref: "chr", Pos: rng.Intn(n - 150), Profile: make([]float64, 150)}
+		for i := range sp.Profile {
+			switch r := rng.Float64(); {
+			case r < 0.05:
+				sp.Profile[i] = math.NaN()
+			case r < 0.06:
+				sp.Profile[i] = 1
+			}
+		}
+		subProfiles = append(subProfiles, sp)
+	}
+	return profile, subProfiles
+}
+
+// runCalc runs calc over the substitution profiles in a single sample.
+func runCalc(profile []profiling.Pos, subProfiles []SubProfile, posType byte, maxl int, byCodon bool) []*correlation.BivariateCovariance {
+	subProfileChan := make(chan SubProfile)
+	go func() {
+		defer close(subProfileChan)
+		for _, sp := range subProfiles {
+			subProfileChan <- sp
+		}
+	}()
+	profileOf := func(ref string) []profiling.Pos { return profile }
+	var covs []*correlation.BivariateCovariance
+	for covsMap := range calc(context.Background(), subProfileChan, profileOf, posType, maxl, nil, 1, false, byCodon) {
+		covs = covsMap[resultKey{class: All}]
+	}
+	return covs
+}
+
+// naiveCalc is the straightforward double loop of calc,
+// checking both positions of each pair.
+func naiveCalc(profile []profiling.Pos, subProfiles []SubProfile, posType byte, maxl int, byCodon bool) []*correlation.BivariateCovariance {
+	var covs []*correlation.BivariateCovariance
+	for i := 0; i < maxl; i++ {
+		covs = append(covs, correlation.NewBivariateCovariance(false))
+	}
+	for _, sp := range subProfiles {
+		for i := range sp.Profile {
+			for j := i; j < len(sp.Profile); j++ {
+				pos1, pos2 := sp.Pos+i, sp.Pos+j
+				l := j - i
+				if byCodon {
+					if l%3 != 0 || profile[pos1].Gene != profile[pos2].Gene {
+						continue
+					}
+					l /= 3
+				}
+				x, y := sp.Profile[i], sp.Profile[j]
+				if l < maxl && checkPosType(posType, profile[pos1].Type) && checkPosType(posType, profile[pos2].Type) && !math.IsNaN(x) && !math.IsNaN(y) {
+					covs[l].Increment(x, y)
+				}
+			}
+		}
+	}
+	return covs
+}
+
+func TestCalcIdentical(t *testing.T) {
+	profile, subProfiles := benchProfile(3000)
+	testCases := []struct {
+		posType byte
+		maxl    int
+		byCodon bool
+	}{
+		{profiling.FourFold, 100, false},
+		{profiling.Coding, 30, false},
+		{profiling.FirstPos, 20, true},
+	}
+	for _, tc := range testCases {
+		expected := naiveCalc(profile, subProfiles, tc.posType, tc.maxl, tc.byCodon)
+		got := runCalc(profile, subProfiles, tc.posType, tc.maxl, tc.byCodon)
+		if len(got) != len(expected) {
+			t.Fatalf("%c, Expect %d lags, got %d\n", tc.posType, len(expected), len(got))
+		}
+		for l := range expected {
+			e, g := expected[l], got[l]
+			if e.GetN() != g.GetN() || !sameFloat(e.GetResult(), g.GetResult()) || !sameFloat(e.MeanX(), g.MeanX()) || !sameFloat(e.MeanY(), g.MeanY()) {
+				t.Errorf("%c, lag %d, Expect n %d cov %v, got n %d cov %v\n", tc.posType, l, e.GetN(), e.GetResult(), g.GetN(), g.GetResult())
+			}
+		}
+	}
+}
+
+// sameFloat returns true if a and b are identical, or both NaN.
+func sameFloat(a, b float64) bool {
+	return a == b || (math.IsNaN(a) && math.IsNaN(b))
+}
+
+// BenchmarkCalc runs calc over 10000 read pairs.
+// Checking each position once, rather than in every pair,
+// and comparing genes by name only across gene runs:
+//
+//	before: byCodon=false  ~97 ms/op  8032 B/op  118 allocs/op
+//	        byCodon=true  ~147 ms/op  8032 B/op  118 allocs/op
+//	after:  byCodon=false  ~59 ms/op  8192 B/op  119 allocs/op
+//	        byCodon=true   ~81 ms/op  9472 B/op  120 allocs/op
+//
+// The few allocations are the covariances of each sample,
+// and the buffers reused across substitution profiles.
+func BenchmarkCalc(b *testing.B) {
+	profile, subProfiles := benchProfile(10000)
+	for _, byCodon := range []bool{false, true} {
+		b.Run(fmt.Sprintf("byCodon=%v", byCodon), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				runCalc(profile, subProfiles, profiling.FourFold, 100, byCodon)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package p2
+
+import (
+	"math"
+
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+	"github.com/mingzhi/ncbiftp/taxonomy"
+)
+
+// Substitution classes, used as keys of the results of Calc.
+const (
+	All    = "all"    // all substitutions.
+	Syn    = "syn"    // synonymous substitutions.
+	NonSyn = "nonsyn" // non-synonymous substitutions.
+)
+
+// AminoAcid is the Level of amino acid substitutions.
+const AminoAcid = "aa"
+
+// classes returns the substitution classes calculated with the options.
+// Amino acid substitutions are not classified,
+// and TsTv takes precedence over Classify.
+func classes(opts Options) []string {
+	cs := substitutionClasses(opts)
+	if opts.Stranded {
+		var stranded []string
+		for _, c := range cs {
+			stranded = append(stranded, StrandClass(c, Forward), StrandClass(c, Reverse))
+		}
+		cs = stranded
+	}
+	if len(opts.InsertBins) > 0 {
+		var binned []string
+		for _, c := range cs {
+			for _, b := range opts.InsertBins {
+				binned = append(binned, InsertClass(c, b.Label()))
+			}
+		}
+		cs = binned
+	}
+	return cs
+}
+
+// substitutionClasses returns the classes of the substitutions,
+// without the strands.
+func substitutionClasses(opts Options) []string {
+	if opts.Level == AminoAcid {
+		return []string{All}
+	}
+	if opts.TsTv {
+		return []string{Ts, Tv}
+	}
+	switch opts.Classify {
+	case "", All:
+		return []string{All}
+	case "both":
+		return []string{Syn, NonSyn}
+	}
+	return []string{opts.Classify}
+}
+
+// compareCodons compares two MappedReads in their overlapped part
+// with compare, like CompareMappedReads, and splits the substitution profile
+// into a synonymous and a non-synonymous one.
+// A substitution is synonymous if the reference codon with either base
+// encodes the same amino acid; an identical base is 0 in both profiles.
+// Positions not in a complete codon, and those with a non-ATGC
+// or low quality base in either read, are NaN in both profiles.
+// All positions of a codon with a deletion ('*', see Map2Ref) in either read
+// are NaN, as the read codon is not aligned to the reference codon.
+func compareCodons(a, b MappedRead, minBQ, qualOffset int, compare Comparator, profile []profiling.Pos, gc *taxonomy.GeneticCode) (syn, nonsyn SubProfile) {
+	subs := CompareMappedReads(a, b, minBQ, qualOffset, compare, gc)
+	syn = SubProfile{Type: Syn, Ref: subs.Ref, Pos: subs.Pos, Profile: make([]float64, len(subs.Profile))}
+	nonsyn = SubProfile{Type: NonSyn, Ref: subs.Ref, Pos: subs.Pos, Profile: make([]float64, len(subs.Profile))}
+	lag := b.Pos - a.Pos
+	for j, d := range subs.Profile {
+		x, y := math.NaN(), math.NaN()
+		if !math.IsNaN(d) && !deletedCodon(a, b, profile, b.Pos+j) {
+			if isSyn, ok := classifySub(profile, gc, b.Pos+j, a.Seq[j+lag], b.Seq[j]); ok {
+				if d == 0 {
+					x, y = 0, 0
+				} else if isSyn {
+					x = 1
+				} else {
+					y = 1
+				}
+			}
+		}
+		syn.Profile[j] = x
+		nonsyn.Profile[j] = y
+	}
+	return
+}
+
+// compareAminoAcids compares the amino acids encoded by two MappedReads
+// in their overlapped part.
+// The amino acid of a codon is at the position of its first base on the coding strand
+// (profiling.FirstPos): 1 if the two reads encode different amino acids, 0 if not,
+// and NaN if the codon is incomplete, or has a non-ATGC or low quality base
+// in either read. Other positions are NaN.
+func compareAminoAcids(a, b MappedRead, minBQ, qualOffset int, profile []profiling.Pos, gc *taxonomy.GeneticCode) SubProfile {
+	subs := CompareMappedReads(a, b, minBQ, qualOffset, CompareBases, gc)
+	aa := SubProfile{Type: subs.Type, Ref: subs.Ref, Pos: subs.Pos, Profile: make([]float64, len(subs.Profile))}
+	for j := range aa.Profile {
+		aa.Profile[j] = math.NaN()
+		pos := b.Pos + j
+		_, index, reverse, ok := codonAt(profile, pos)
+		if !ok || index != 0 {
+			continue
+		}
+		aa1, ok1 := readAminoAcid(a, pos, reverse, minBQ, qualOffset, gc)
+		aa2, ok2 := readAminoAcid(b, pos, reverse, minBQ, qualOffset, gc)
+		if ok1 && ok2 {
+			if aa1 != aa2 {
+				aa.Profile[j] = 1
+			} else {
+				aa.Profile[j] = 0
+			}
+		}
+	}
+	return aa
+}
+
+// readAminoAcid translates the codon of a read starting at the genomic position pos,
+// extending towards lower positions if reverse.
+// ok is false if the codon is not complete in the read,
+// or has a non-ATGC or low quality base.
+func readAminoAcid(r MappedRead, pos int, reverse bool, minBQ, qualOffset int, gc *taxonomy.GeneticCode) (aa byte, ok bool) {
+	step := 1
+	if reverse {
+		step = -1
+	}
+	codon := make([]byte, 3)
+	for k := range codon {
+		i := pos + step*k - r.Pos
+		if i < 0 || i >= r.Len() || !IsATGC(r.Seq[i]) || Phred(r.Qual[i], qualOffset) <= minBQ {
+			return 0, false
+		}
+		codon[k] = r.Seq[i]
+		if reverse {
+			codon[k] = complement(codon[k])
+		}
+	}
+	aa, ok = gc.Table[string(codon)]
+	return
+}
+
+// deletedCodon returns true if either read has a deletion ('*')
+// in the codon containing the genomic position pos.
+func deletedCodon(a, b MappedRead, profile []profiling.Pos, pos int) bool {
+	_, index, reverse, ok := codonAt(profile, pos)
+	if !ok {
+		return false
+	}
+	step := 1
+	if reverse {
+		step = -1
+	}
+	start := pos - step*index
+	for k := 0; k < 3; k++ {
+		for _, r := range []MappedRead{a, b} {
+			i := start + step*k - r.Pos
+			if i >= 0 && i < r.Len() && r.Seq[i] == '*' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// classifySub returns whether the substitution between bases a and b
+// at the genomic position pos is synonymous.
+// ok is false if it can not be determined.
+func classifySub(profile []profiling.Pos, gc *taxonomy.GeneticCode, pos int, a, b byte) (syn, ok bool) {
+	codon, index, reverse, ok := codonAt(profile, pos)
+	if !ok {
+		return false, false
+	}
+	if reverse {
+		a, b = complement(a), complement(b)
+	}
+
+	ca := []byte(codon)
+	cb := []byte(codon)
+	ca[index] = a
+	cb[index] = b
+	aa1, found1 := gc.Table[string(ca)]
+	aa2, found2 := gc.Table[string(cb)]
+	if !found1 || !found2 {
+		return false, false
+	}
+	return aa1 == aa2, true
+}
+
+// codonAt returns the reference codon containing the position pos
+// on the coding strand, the index of pos in the codon,
+// and whether the gene is on the reverse strand.
+// ok is false if pos is not in a complete codon of a single gene.
+func codonAt(profile []profiling.Pos, pos int) (codon string, index int, reverse, ok bool) {
+	if pos < 0 || pos >= len(profile) {
+		return
+	}
+	switch profile[pos].Type {
+	case profiling.FirstPos:
+		index = 0
+	case profiling.SecondPos:
+		index = 1
+	case profiling.ThirdPos, profiling.FourFold:
+		index = 2
+	default:
+		return
+	}
+
+	for _, reverse = range []bool{false, true} {
+		step := 1
+		if reverse {
+			step = -1
+		}
+		start := pos - step*index
+		var c []byte
+		for k := 0; k < 3; k++ {
+			j := start + step*k
+			if j < 0 || j >= len(profile) || !isCodonPos(k, profile[j].Type) || profile[j].Gene != profile[pos].Gene {
+				break
+			}
+			base := upper(profile[j].Base)
+			if reverse {
+				base = complement(base)
+			}
+			if !IsATGC(base) {
+				break
+			}
+			c = append(c, base)
+		}
+		if len(c) == 3 {
+			return string(c), index, reverse, true
+		}
+	}
+	return "", 0, false, false
+}
+
+// isCodonPos returns true if the position type t is the k-th position of a codon.
+func isCodonPos(k int, t byte) bool {
+	switch k {
+	case 0:
+		return t == profiling.FirstPos
+	case 1:
+		return t == profiling.SecondPos
+	}
+	return t == profiling.ThirdPos || t == profiling.FourFold
+}
+
+func upper(b byte) byte {
+	if b >= 'a' && b <= 'z' {
+		return b - 'a' + 'A'
+	}
+	return b
+}
+
+func complement(b byte) byte {
+	switch b {
+	case 'A':
+		return 'T'
+	case 'T':
+		return 'A'
+	case 'G':
+		return 'C'
+	case 'C':
+		return 'G'
+	}
+	return b
+}
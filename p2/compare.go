@@ -0,0 +1,72 @@
+package p2
+
+import "github.com/mingzhi/ncbiftp/taxonomy"
+
+// Transition and transversion classes, the keys of the results with Options.TsTv.
+const (
+	Ts = "ts" // transitions.
+	Tv = "tv" // transversions.
+)
+
+// Comparator compares the base i of the read a with the base j of the read b,
+// at the same position of the reference, both ATGC with a good quality.
+// It returns the value of the substitution profile at the position,
+// and ok is false to leave it out (NaN).
+type Comparator func(a, b MappedRead, i, j int, gc *taxonomy.GeneticCode) (value float64, ok bool)
+
+// Comparators are the provided comparators, by name.
+var Comparators = map[string]Comparator{
+	"bases":       CompareBases,
+	"transitions": CompareTransitions,
+}
+
+// CompareBases returns 1 if the bases differ, 0 if not.
+func CompareBases(a, b MappedRead, i, j int, gc *taxonomy.GeneticCode) (value float64, ok bool) {
+	if a.Seq[i] != b.Seq[j] {
+		return 1, true
+	}
+	return 0, true
+}
+
+// CompareTransitions returns 1 for a transition (A <-> G, or C <-> T),
+// and 0 if the bases are identical; transversions are left out.
+func CompareTransitions(a, b MappedRead, i, j int, gc *taxonomy.GeneticCode) (value float64, ok bool) {
+	x, y := a.Seq[i], b.Seq[j]
+	if x == y {
+		return 0, true
+	}
+	if isPurine(x) == isPurine(y) {
+		return 1, true
+	}
+	return 0, false
+}
+
+func isPurine(b byte) bool {
+	return b == 'A' || b == 'G'
+}
+
+// compareTsTv compares two MappedReads in their overlapped part,
+// like CompareMappedReads with CompareBases, and splits the substitution profile
+// into a transition (Ts) and a transversion (Tv) one:
+// a substitution is 1 in the profile of its class and 0 in the other,
+// and an identical base is 0 in both.
+// The positions left out (NaN) are the same in both profiles.
+func compareTsTv(a, b MappedRead, minBQ, qualOffset int, gc *taxonomy.GeneticCode) (ts, tv SubProfile) {
+	subs := CompareMappedReads(a, b, minBQ, qualOffset, CompareBases, gc)
+	ts = SubProfile{Type: Ts, Ref: subs.Ref, Pos: subs.Pos, Profile: make([]float64, len(subs.Profile))}
+	tv = SubProfile{Type: Tv, Ref: subs.Ref, Pos: subs.Pos, Profile: make([]float64, len(subs.Profile))}
+	lag := b.Pos - a.Pos
+	for j, d := range subs.Profile {
+		x, y := d, d
+		if d == 1 {
+			if isPurine(a.Seq[j+lag]) == isPurine(b.Seq[j]) {
+				y = 0
+			} else {
+				x = 0
+			}
+		}
+		ts.Profile[j] = x
+		tv.Profile[j] = y
+	}
+	return
+}
@@ -0,0 +1,82 @@
+package p2
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestComparators(t *testing.T) {
+	// A is compared with A, G (transition), C and T (transversions).
+	a := MappedRead{Pos: 0, Seq: []byte("AAAA"), Qual: bytes.Repeat([]byte{30}, 4)}
+	b := MappedRead{Pos: 0, Seq: []byte("AGCT"), Qual: bytes.Repeat([]byte{30}, 4)}
+	// C is compared with T (transition) and G (transversion).
+	c := MappedRead{Pos: 0, Seq: []byte("CC"), Qual: bytes.Repeat([]byte{30}, 2)}
+	d := MappedRead{Pos: 0, Seq: []byte("TG"), Qual: bytes.Repeat([]byte{30}, 2)}
+
+	nan := math.NaN()
+	testCases := []struct {
+		name     string
+		a, b     MappedRead
+		expected []float64
+	}{
+		{"bases", a, b, []float64{0, 1, 1, 1}},
+		{"bases", c, d, []float64{1, 1}},
+		{"transitions", a, b, []float64{0, 1, nan, nan}},
+		{"transitions", c, d, []float64{1, nan}},
+	}
+	for _, tc := range testCases {
+		subs := CompareMappedReads(tc.a, tc.b, 13, 0, Comparators[tc.name], nil)
+		for i, e := range tc.expected {
+			if v := subs.Profile[i]; v != e && !(math.IsNaN(v) && math.IsNaN(e)) {
+				t.Errorf("%s, %c vs %c, Expect %g, got %g\n", tc.name, tc.a.Seq[i], tc.b.Seq[i], e, v)
+			}
+		}
+	}
+
+	// the default is CompareBases.
+	subs := CompareMappedReads(a, b, 13, 0, nil, nil)
+	if subs.Profile[1] != 1 || subs.Profile[2] != 1 {
+		t.Errorf("default, Expect CompareBases, got %v\n", subs.Profile)
+	}
+}
+
+func TestCompareTsTv(t *testing.T) {
+	// b starts one base after a: A-A (identical), A-G (transition),
+	// C-A (transversion), T-C (transition, low quality in b), G-N and G-T (transversion).
+	a := MappedRead{Pos: 10, Seq: []byte("GAACTGG"), Qual: bytes.Repeat([]byte{30}, 7)}
+	b := MappedRead{Pos: 11, Seq: []byte("AGACNT"), Qual: []byte{30, 30, 30, 5, 30, 30}}
+
+	nan := math.NaN()
+	expectedTs := []float64{0, 1, 0, nan, nan, 0}
+	expectedTv := []float64{0, 0, 1, nan, nan, 1}
+	ts, tv := compareTsTv(a, b, 13, 0, nil)
+	if ts.Type != Ts || tv.Type != Tv {
+		t.Errorf("types, Expect %s and %s, got %s and %s\n", Ts, Tv, ts.Type, tv.Type)
+	}
+	if ts.Pos != b.Pos || tv.Pos != b.Pos {
+		t.Errorf("positions, Expect %d, got %d and %d\n", b.Pos, ts.Pos, tv.Pos)
+	}
+	if len(ts.Profile) != len(expectedTs) || len(tv.Profile) != len(expectedTv) {
+		t.Fatalf("lengths, Expect %d, got %d and %d\n", len(expectedTs), len(ts.Profile), len(tv.Profile))
+	}
+	isEqual := func(v, e float64) bool {
+		return v == e || (math.IsNaN(v) && math.IsNaN(e))
+	}
+	all := CompareMappedReads(a, b, 13, 0, CompareBases, nil)
+	for i := range expectedTs {
+		if v := ts.Profile[i]; !isEqual(v, expectedTs[i]) {
+			t.Errorf("ts at %d, Expect %g, got %g\n", i, expectedTs[i], v)
+		}
+		if v := tv.Profile[i]; !isEqual(v, expectedTv[i]) {
+			t.Errorf("tv at %d, Expect %g, got %g\n", i, expectedTv[i], v)
+		}
+		// the same positions are left out, and ts + tv are all the substitutions.
+		if math.IsNaN(ts.Profile[i]) != math.IsNaN(tv.Profile[i]) {
+			t.Errorf("NaN at %d, Expect the same in ts and tv, got %g and %g\n", i, ts.Profile[i], tv.Profile[i])
+		}
+		if v := ts.Profile[i] + tv.Profile[i]; !isEqual(v, all.Profile[i]) {
+			t.Errorf("ts + tv at %d, Expect %g, got %g\n", i, all.Profile[i], v)
+		}
+	}
+}
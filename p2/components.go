@@ -0,0 +1,87 @@
+package p2
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+
+	"github.com/mingzhi/gomath/stat/correlation"
+	"github.com/mingzhi/meta"
+)
+
+// Components are the components of the covariance at a lag,
+// of a substitution class on a reference (empty when pooled) in a sample:
+// the number of position pairs N, the means of x and y,
+// and the co-moment, the sum of (x - MeanX) * (y - MeanY).
+// Components of different samples or references can be recombined by Merge,
+// and the covariance is CoMoment / N.
+type Components struct {
+	Ref      string  `json:"ref,omitempty"`
+	Class    string  `json:"class"`
+	Sample   int     `json:"sample"`
+	Lag      int     `json:"lag"`
+	N        int     `json:"n"`
+	MeanX    float64 `json:"mean_x"`
+	MeanY    float64 `json:"mean_y"`
+	CoMoment float64 `json:"co_moment"`
+}
+
+// CovComponents returns the components of a (not bias corrected) covariance.
+func CovComponents(cov *correlation.BivariateCovariance) (n int, meanX, meanY, coMoment float64) {
+	n = cov.GetN()
+	if n > 0 {
+		coMoment = cov.GetResult() * float64(n)
+	}
+	return n, cov.MeanX(), cov.MeanY(), coMoment
+}
+
+// Covariance returns the covariance, NaN if N is 0.
+func (c Components) Covariance() float64 {
+	if c.N == 0 {
+		return math.NaN()
+	}
+	return c.CoMoment / float64(c.N)
+}
+
+// Merge returns the components of the pooled pairs of a and b.
+func Merge(a, b Components) Components {
+	n := a.N + b.N
+	if n == 0 {
+		return a
+	}
+	dx := b.MeanX - a.MeanX
+	dy := b.MeanY - a.MeanY
+	c := a
+	c.N = n
+	c.MeanX += dx * float64(b.N) / float64(n)
+	c.MeanY += dy * float64(b.N) / float64(n)
+	c.CoMoment += b.CoMoment + float64(a.N)*float64(b.N)/float64(n)*dx*dy
+	return c
+}
+
+// componentWriter writes Components to a file, one JSON object per line.
+type componentWriter struct {
+	enc *json.Encoder
+}
+
+func newComponentWriter(w io.Writer) *componentWriter {
+	if w == nil {
+		return nil
+	}
+	return &componentWriter{enc: json.NewEncoder(w)}
+}
+
+// Write writes the components of the covariances of each lag in a sample.
+func (cw *componentWriter) Write(key resultKey, sample int, covs []*correlation.BivariateCovariance) {
+	if cw == nil {
+		return
+	}
+	for l, cov := range covs {
+		c := Components{Ref: key.ref, Class: key.class, Sample: sample, Lag: l}
+		c.N, c.MeanX, c.MeanY, c.CoMoment = CovComponents(cov)
+		if err := cw.enc.Encode(c); err != nil {
+			meta.ERROR.Println(err)
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package p2
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/mingzhi/gomath/stat/correlation"
+)
+
+func TestComponents(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	newCovs := func(n int) []*correlation.BivariateCovariance {
+		var covs []*correlation.BivariateCovariance
+		for l := 0; l < 3; l++ {
+			cov := correlation.NewBivariateCovariance(false)
+			for i := 0; i < n; i++ {
+				x := float64(rng.Intn(2))
+				cov.Increment(x, x*rng.Float64())
+			}
+			covs = append(covs, cov)
+		}
+		return covs
+	}
+	key := resultKey{ref: "NC_000001", class: All}
+	// the second sample has no pairs.
+	samples := [][]*correlation.BivariateCovariance{newCovs(50), newCovs(0), newCovs(30)}
+
+	covsChan := make(chan map[resultKey][]*correlation.BivariateCovariance)
+	go func() {
+		defer close(covsChan)
+		for _, covs := range samples {
+			covsChan <- map[resultKey][]*correlation.BivariateCovariance{key: covs}
+		}
+	}()
+	var buf bytes.Buffer
+	collect(covsChan, 3, nil, newComponentWriter(&buf))
+
+	// the components of each sample and lag reproduce the covariance.
+	dec := json.NewDecoder(&buf)
+	pooled := make([]Components, 3)
+	count := 0
+	for {
+		var c Components
+		if err := dec.Decode(&c); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		count++
+		if c.Ref != key.ref || c.Class != key.class {
+			t.Errorf("Expect %v, got %s %s\n", key, c.Ref, c.Class)
+		}
+		expected := samples[c.Sample][c.Lag].GetResult()
+		if got := c.Covariance(); got != expected && !(math.IsNaN(got) && math.IsNaN(expected)) {
+			t.Errorf("sample %d, lag %d, Expect %g, got %g\n", c.Sample, c.Lag, expected, got)
+		}
+		pooled[c.Lag] = Merge(pooled[c.Lag], c)
+	}
+	if count != 9 {
+		t.Errorf("Expect 9 components, got %d\n", count)
+	}
+
+	// merged components reproduce the covariance of pooled pairs.
+	for l, c := range pooled {
+		cov := correlation.NewBivariateCovariance(false)
+		for _, covs := range samples {
+			cov.Append(covs[l])
+		}
+		if got, expected := c.Covariance(), cov.GetResult(); math.Abs(got-expected) > 1e-12 {
+			t.Errorf("pooled lag %d, Expect %g, got %g\n", l, expected, got)
+		}
+		if c.N != cov.GetN() {
+			t.Errorf("pooled lag %d, Expect %d pairs, got %d\n", l, cov.GetN(), c.N)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package p2
+
+import (
+	"encoding/json"
+	"math"
+)
+
+// Covariance is a (not bias corrected) covariance,
+// updated as correlation.BivariateCovariance, whose state
+// (the number of pairs, the means and the co-moment) can be saved
+// and restored as JSON, e.g. to checkpoint a long run and resume it.
+type Covariance struct {
+	n            int
+	meanX, meanY float64
+	coMoment     float64
+}
+
+// NewCovariance returns a new Covariance without pairs.
+func NewCovariance() *Covariance {
+	return &Covariance{}
+}
+
+// Increment adds a pair (x, y).
+func (c *Covariance) Increment(x, y float64) {
+	c.n++
+	dx := x - c.meanX
+	dy := y - c.meanY
+	c.meanX += dx / float64(c.n)
+	c.meanY += dy / float64(c.n)
+	c.coMoment += (float64(c.n) - 1) / float64(c.n) * dx * dy
+}
+
+// Append adds the pairs of c2.
+func (c *Covariance) Append(c2 *Covariance) {
+	m := Merge(c.Components(), c2.Components())
+	c.n, c.meanX, c.meanY, c.coMoment = m.N, m.MeanX, m.MeanY, m.CoMoment
+}
+
+// GetN returns the number of pairs.
+func (c *Covariance) GetN() int {
+	return c.n
+}
+
+// GetResult returns the covariance, NaN without pairs.
+func (c *Covariance) GetResult() float64 {
+	if c.n == 0 {
+		return math.NaN()
+	}
+	return c.coMoment / float64(c.n)
+}
+
+// MeanX returns the mean of x.
+func (c *Covariance) MeanX() float64 {
+	return c.meanX
+}
+
+// MeanY returns the mean of y.
+func (c *Covariance) MeanY() float64 {
+	return c.meanY
+}
+
+// Components returns the components of the covariance.
+func (c *Covariance) Components() Components {
+	return Components{N: c.n, MeanX: c.meanX, MeanY: c.meanY, CoMoment: c.coMoment}
+}
+
+// covarianceJSON is the JSON form of a Covariance.
+type covarianceJSON struct {
+	N        int     `json:"n"`
+	MeanX    float64 `json:"mean_x"`
+	MeanY    float64 `json:"mean_y"`
+	CoMoment float64 `json:"co_moment"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (c *Covariance) MarshalJSON() ([]byte, error) {
+	return json.Marshal(covarianceJSON{N: c.n, MeanX: c.meanX, MeanY: c.meanY, CoMoment: c.coMoment})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (c *Covariance) UnmarshalJSON(data []byte) error {
+	var v covarianceJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	c.n, c.meanX, c.meanY, c.coMoment = v.N, v.MeanX, v.MeanY, v.CoMoment
+	return nil
+}
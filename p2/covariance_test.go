@@ -0,0 +1,69 @@
+package p2
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/mingzhi/gomath/stat/correlation"
+)
+
+// TestCovarianceCheckpoint checks that a Covariance saved as JSON
+// in the middle of a stream, and restored, ends with the result
+// of an uninterrupted correlation.BivariateCovariance.
+func TestCovarianceCheckpoint(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	xs := make([]float64, 1000)
+	ys := make([]float64, len(xs))
+	for i := range xs {
+		xs[i] = rng.Float64()
+		ys[i] = xs[i]*rng.Float64() + 1e6
+	}
+
+	expected := correlation.NewBivariateCovariance(false)
+	for i := range xs {
+		expected.Increment(xs[i], ys[i])
+	}
+
+	for _, stop := range []int{0, 1, 500, len(xs)} {
+		cov := NewCovariance()
+		for i := 0; i < stop; i++ {
+			cov.Increment(xs[i], ys[i])
+		}
+		data, err := json.Marshal(cov)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored := NewCovariance()
+		if err := json.Unmarshal(data, restored); err != nil {
+			t.Fatal(err)
+		}
+		for i := stop; i < len(xs); i++ {
+			restored.Increment(xs[i], ys[i])
+		}
+		if restored.GetResult() != expected.GetResult() || restored.GetN() != expected.GetN() {
+			t.Errorf("checkpoint at %d, Expect %g (n %d), got %g (n %d)\n", stop, expected.GetResult(), expected.GetN(), restored.GetResult(), restored.GetN())
+		}
+		if restored.MeanX() != expected.MeanX() || restored.MeanY() != expected.MeanY() {
+			t.Errorf("checkpoint at %d, Expect means %g and %g, got %g and %g\n", stop, expected.MeanX(), expected.MeanY(), restored.MeanX(), restored.MeanY())
+		}
+	}
+
+	// appended halves give the covariance of all pairs.
+	a, b := NewCovariance(), NewCovariance()
+	for i := range xs {
+		if i < 300 {
+			a.Increment(xs[i], ys[i])
+		} else {
+			b.Increment(xs[i], ys[i])
+		}
+	}
+	a.Append(b)
+	if got := a.GetResult(); math.Abs(got-expected.GetResult()) > 1e-9*expected.GetResult() || a.GetN() != expected.GetN() {
+		t.Errorf("append, Expect %g (n %d), got %g (n %d)\n", expected.GetResult(), expected.GetN(), got, a.GetN())
+	}
+	if got := NewCovariance().GetResult(); !math.IsNaN(got) {
+		t.Errorf("no pairs, Expect NaN, got %g\n", got)
+	}
+}
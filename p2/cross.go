@@ -0,0 +1,133 @@
+package p2
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/gomath/stat/correlation"
+	"github.com/mingzhi/gomath/stat/desc/meanvar"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+// CalcCross calculates the correlation of substitutions between two samples
+// whose reads (from readChanA and readChanB) are mapped to the same references,
+// using the genome profile of each reference in profiles, keyed by its name.
+// At each position, the substitution of a sample is the fraction of its reads
+// differing from the reference base (NaN without reads),
+// and the covariance at lag l is that of the substitutions of sample A and B
+// at positions l apart, in both orders, pooled over references.
+// The positions are split into opts.Samples samples, in blocks of maxl positions.
+// Lags are binned by opts.LagStep or opts.LogLags, as in CalcP2.
+// Read pairs are not compared, so Paired, Compare, Classify, Level and MaxPairs are not used.
+// It returns the mean and variance of the covariance over samples at each lag,
+// keyed by All. If ctx is cancelled, the results are incomplete.
+func CalcCross(ctx context.Context, readChanA, readChanB chan *sam.Record, profiles map[string][]profiling.Pos, posType byte, maxl int, opts Options) map[string][]*meanvar.MeanVar {
+	if opts.Samples < 1 {
+		opts.Samples = 1
+	}
+
+	// pile up the two samples concurrently.
+	subsChanB := make(chan map[string][]float64, 1)
+	go func() {
+		subsChanB <- pileupSubs(ctx, readChanB, profiles, opts)
+	}()
+	subsA := pileupSubs(ctx, readChanA, profiles, opts)
+	subsB := <-subsChanB
+
+	sampleCovs := make([][]*correlation.BivariateCovariance, opts.Samples)
+	for s := range sampleCovs {
+		for l := 0; l < maxl; l++ {
+			sampleCovs[s] = append(sampleCovs[s], correlation.NewBivariateCovariance(false))
+		}
+	}
+	bins := lagBins(maxl, opts)
+	var refs []string
+	for ref := range subsA {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		a, b, profile := subsA[ref], subsB[ref], profiles[ref]
+		if b == nil {
+			continue
+		}
+		for p := range a {
+			if ctx.Err() != nil {
+				break
+			}
+			if !checkPosType(posType, profile[p].Type) {
+				continue
+			}
+			covs := sampleCovs[(p/maxl)%opts.Samples]
+			for l := 0; l < maxl && p+l < len(a); l++ {
+				q := p + l
+				bin := lagBin(bins, l)
+				if bin < 0 || !checkPosType(posType, profile[q].Type) {
+					continue
+				}
+				if !math.IsNaN(a[p]) && !math.IsNaN(b[q]) {
+					covs[bin].Increment(a[p], b[q])
+				}
+				if l > 0 && !math.IsNaN(a[q]) && !math.IsNaN(b[p]) {
+					covs[bin].Increment(a[q], b[p])
+				}
+			}
+		}
+	}
+
+	covsChan := make(chan map[resultKey][]*correlation.BivariateCovariance)
+	go func() {
+		defer close(covsChan)
+		for _, covs := range sampleCovs {
+			covsChan <- map[resultKey][]*correlation.BivariateCovariance{{class: All}: covs}
+		}
+	}()
+	results := make(map[string][]*meanvar.MeanVar)
+	for key, meanVars := range collect(covsChan, maxl, []resultKey{{class: All}}, newComponentWriter(opts.Components)) {
+		results[key.class] = meanVars
+	}
+	return results
+}
+
+// pileupSubs piles up reads on the references with a profile,
+// and returns the fraction of bases differing from the reference base
+// at each position of each reference, NaN without (good quality) bases.
+// The bases are those used by pileup, and the positions in opts.Exclude are NaN.
+func pileupSubs(ctx context.Context, readChan chan *sam.Record, profiles map[string][]profiling.Pos, opts Options) map[string][]float64 {
+	depths := make(map[string][]int)
+	diffs := make(map[string][]int)
+	pileup(ctx, readChan, opts, func(ref string, pos int, base byte) {
+		profile, found := profiles[ref]
+		if !found || pos >= len(profile) {
+			return
+		}
+		refBase := upper(profile[pos].Base)
+		if !IsATGC(refBase) {
+			return
+		}
+		if depths[ref] == nil {
+			depths[ref] = make([]int, len(profile))
+			diffs[ref] = make([]int, len(profile))
+		}
+		depths[ref][pos]++
+		if base != refBase {
+			diffs[ref][pos]++
+		}
+	})
+
+	subs := make(map[string][]float64)
+	for ref, depth := range depths {
+		x := make([]float64, len(depth))
+		for pos, n := range depth {
+			if n == 0 || opts.Exclude.Contains(ref, pos) {
+				x[pos] = math.NaN()
+			} else {
+				x[pos] = float64(diffs[ref][pos]) / float64(n)
+			}
+		}
+		subs[ref] = x
+	}
+	return subs
+}
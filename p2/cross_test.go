@@ -0,0 +1,70 @@
+package p2
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+func TestCalcCross(t *testing.T) {
+	const genomeLen = 20
+	ref, err := sam.NewReference("NC_000001", "", "", genomeLen, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	profile := make([]profiling.Pos, genomeLen)
+	for i := range profile {
+		profile[i] = profiling.Pos{Base: 'A', Type: profiling.FourFold}
+	}
+	profiles := map[string][]profiling.Pos{ref.Name(): profile}
+
+	// both samples have the substitutions at 2 and 5,
+	// in two reads covering the genome.
+	s := bytes.Repeat([]byte("A"), genomeLen)
+	s[2], s[5] = 'C', 'C'
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, genomeLen)}
+	readChan := func() chan *sam.Record {
+		c := make(chan *sam.Record)
+		go func() {
+			defer close(c)
+			for i := 0; i < 2; i++ {
+				r, err := sam.NewRecord("read", ref, nil, 0, -1, 0, 40, cigar, s, bytes.Repeat([]byte{30}, genomeLen), nil)
+				if err != nil {
+					panic(err)
+				}
+				c <- r
+			}
+		}()
+		return c
+	}
+
+	opts := Options{MinBQ: 13, MapQ255: "exclude", Samples: 1}
+	results := CalcCross(context.Background(), readChan(), readChan(), profiles, ConvertPosType(4), 4, opts)
+	meanVars := results[All]
+	if len(meanVars) != 4 {
+		t.Fatalf("Expect 4 lags, got %d\n", len(meanVars))
+	}
+
+	// the covariance of x and y over the pairs (A at p, B at p+l)
+	// and (A at p+l, B at p): with n pairs, k of which have x = 1,
+	// k of which have y = 1, and m of which have both,
+	// it is m/n - (k/n)^2.
+	expected := []float64{
+		2.0/20 - (2.0/20)*(2.0/20), // lag 0: the 20 positions.
+		0 - (4.0/38)*(4.0/38),      // lag 1: no shared pair.
+		0 - (4.0/36)*(4.0/36),      // lag 2: idem.
+		2.0/34 - (3.0/34)*(3.0/34), // lag 3: the substitutions at 2 and 5.
+	}
+	for l, e := range expected {
+		if got := meanVars[l].Mean.GetResult(); math.Abs(got-e) > 1e-10 {
+			t.Errorf("lag %d, Expect %g, got %g\n", l, e, got)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package p2_test
+
+import (
+	"fmt"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/meta/p2"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+func ExampleCalcP2() {
+	ref, _ := sam.NewReference("NC_000001", "", "", 20, nil, nil)
+	sam.NewHeader(nil, []*sam.Reference{ref})
+
+	// three overlapping reads, each of which has a substitution.
+	var records []*sam.Record
+	for i, s := range []string{"ACGTACGTAC", "GTTCGTACGT", "ACGAACGTAC"} {
+		pos := i * 2
+		cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, len(s))}
+		qual := make([]byte, len(s))
+		for j := range qual {
+			qual[j] = 30
+		}
+		r, _ := sam.NewRecord(fmt.Sprintf("read%d", i), ref, nil, pos, -1, 0, 40, cigar, []byte(s), qual, nil)
+		records = append(records, r)
+	}
+
+	// all positions are four-fold degenerate sites.
+	profile := make([]profiling.Pos, 20)
+	for i := range profile {
+		profile[i].Type = profiling.FourFold
+	}
+
+	opts := p2.Options{MinBQ: 13, MapQ255: "exclude", Samples: 1}
+	results := p2.CalcP2(records, profile, p2.ConvertPosType(4), 3, opts)
+	for l, mv := range results[p2.All] {
+		fmt.Printf("%d\t%.4f\n", l, mv.Mean.GetResult())
+	}
+	// Output:
+	// 0	0.1488
+	// 1	-0.0332
+	// 2	-0.0469
+}
@@ -0,0 +1,32 @@
+package p2
+
+import "github.com/biogo/hts/sam"
+
+// Categories of the reads excluded by their SAM flags.
+const (
+	Unmapped      = "unmapped"
+	Secondary     = "secondary"
+	Supplementary = "supplementary"
+	Duplicate     = "duplicate"
+)
+
+// flagCategories are the categories, in the order they are checked and reported.
+var flagCategories = []string{Unmapped, Secondary, Supplementary, Duplicate}
+
+// excludedByFlags returns the category of a read excluded by its flags,
+// or "" if it is used: unmapped reads are always excluded,
+// secondary and supplementary alignments, which would count a read again,
+// unless opts.KeepSecondary, and duplicates unless opts.KeepDuplicates.
+func excludedByFlags(r *sam.Record, opts Options) string {
+	switch {
+	case r.Flags&sam.Unmapped != 0:
+		return Unmapped
+	case r.Flags&sam.Secondary != 0 && !opts.KeepSecondary:
+		return Secondary
+	case r.Flags&sam.Supplementary != 0 && !opts.KeepSecondary:
+		return Supplementary
+	case r.Flags&sam.Duplicate != 0 && !opts.KeepDuplicates:
+		return Duplicate
+	}
+	return ""
+}
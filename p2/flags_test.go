@@ -0,0 +1,87 @@
+package p2
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+func TestExcludedByFlags(t *testing.T) {
+	ref, err := sam.NewReference("NC_000001", "", "", 100, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	profile := make([]profiling.Pos, 100)
+	for i := range profile {
+		profile[i].Type = profiling.FourFold
+	}
+
+	// overlapping reads, each with a flag, of which the unmapped one is placed.
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 10)}
+	qual := bytes.Repeat([]byte{30}, 10)
+	var records []*sam.Record
+	for i, r := range []struct {
+		name  string
+		flags sam.Flags
+	}{{"primary", 0}, {"unmapped", sam.Unmapped}, {"secondary", sam.Secondary},
+		{"supplementary", sam.Supplementary}, {"duplicate", sam.Duplicate}, {"paired", sam.Paired | sam.ProperPair | sam.Read1}} {
+		rec, err := sam.NewRecord(r.name, ref, nil, i, -1, 0, 40, cigar, []byte("ACGTACGTAC"), qual, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec.Flags = r.flags
+		records = append(records, rec)
+	}
+
+	testCases := []struct {
+		keepSecondary, keepDuplicates bool
+		expected                      string
+	}{
+		{false, false, "paired primary"},
+		{true, false, "paired primary secondary supplementary"},
+		{false, true, "duplicate paired primary"},
+		{true, true, "duplicate paired primary secondary supplementary"},
+	}
+	for _, tc := range testCases {
+		opts := Options{MinBQ: 13, MapQ255: "exclude", Samples: 1, KeepSecondary: tc.keepSecondary, KeepDuplicates: tc.keepDuplicates}
+		var buf bytes.Buffer
+		opts.Overlaps = &buf
+		CalcP2(records, profile, ConvertPosType(4), 10, opts)
+		var used []string
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if fields := strings.Split(line, "\t"); fields[0] == "R" {
+				used = append(used, fields[2])
+			}
+		}
+		sort.Strings(used)
+		if got := strings.Join(used, " "); got != tc.expected {
+			t.Errorf("keep-secondary %v, keep-duplicates %v, Expect reads %s, got %s\n", tc.keepSecondary, tc.keepDuplicates, tc.expected, got)
+		}
+
+		// the depths count the same reads.
+		readChan := make(chan *sam.Record)
+		go func() {
+			defer close(readChan)
+			for _, r := range records {
+				readChan <- r
+			}
+		}()
+		depth := 0
+		for _, bin := range DepthHistogram(context.Background(), readChan, []*sam.Reference{ref}, opts) {
+			if bin.Depth > depth && bin.Positions > 0 {
+				depth = bin.Depth
+			}
+		}
+		if expected := len(strings.Fields(tc.expected)); depth != expected {
+			t.Errorf("keep-secondary %v, keep-duplicates %v, Expect a max depth of %d, got %d\n", tc.keepSecondary, tc.keepDuplicates, expected, depth)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package p2
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/hts/sam"
+)
+
+// InsertBin is a bin of the insert sizes of fragments, from Min to Max (excluded).
+// The insert size of a read is the absolute TLEN of its record,
+// the same for both mates.
+type InsertBin struct {
+	Min, Max int
+}
+
+// Label returns the label of the bin, e.g. 0-200.
+func (b InsertBin) Label() string {
+	return fmt.Sprintf("%d-%d", b.Min, b.Max)
+}
+
+// ParseInsertBins parses comma-separated bins of insert sizes,
+// such as "0-200,200-400", which should be increasing and not overlap.
+func ParseInsertBins(s string) (bins []InsertBin, err error) {
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		bounds := strings.Split(field, "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("insert bin should be min-max, got %s", field)
+		}
+		var b InsertBin
+		if b.Min, err = strconv.Atoi(bounds[0]); err != nil {
+			return nil, fmt.Errorf("insert bin %s: %v", field, err)
+		}
+		if b.Max, err = strconv.Atoi(bounds[1]); err != nil {
+			return nil, fmt.Errorf("insert bin %s: %v", field, err)
+		}
+		if b.Min < 0 || b.Max <= b.Min {
+			return nil, fmt.Errorf("insert bin %s should be 0 <= min < max", field)
+		}
+		if len(bins) > 0 && b.Min < bins[len(bins)-1].Max {
+			return nil, fmt.Errorf("insert bin %s overlaps or comes before %s", field, bins[len(bins)-1].Label())
+		}
+		bins = append(bins, b)
+	}
+	return
+}
+
+// insertBin returns the label of the bin of the insert size of a record,
+// and false if it is in none of the bins. Records with TLEN 0,
+// such as unpaired reads and mates on other references, are in none.
+func insertBin(r *sam.Record, bins []InsertBin) (label string, ok bool) {
+	size := r.TempLen
+	if size < 0 {
+		size = -size
+	}
+	if size == 0 {
+		return "", false
+	}
+	for _, b := range bins {
+		if size >= b.Min && size < b.Max {
+			return b.Label(), true
+		}
+	}
+	return "", false
+}
+
+// InsertClass returns the class of the substitutions between reads
+// of fragments in an insert bin, with Options.InsertBins, e.g. all_0-200.
+func InsertClass(class, label string) string {
+	return class + "_" + label
+}
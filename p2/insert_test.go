@@ -0,0 +1,70 @@
+package p2
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+// TestInsertBins checks that with InsertBins, the reads of fragments
+// of two size classes are compared within their class only.
+func TestInsertBins(t *testing.T) {
+	ref, err := sam.NewReference("NC_000001", "", "", 100, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	profile := make([]profiling.Pos, 100)
+	for i := range profile {
+		profile[i].Type = profiling.FourFold
+	}
+
+	// overlapping reads of short (s) and long (l) fragments,
+	// and an unpaired read (u), with TLEN 0.
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 10)}
+	qual := bytes.Repeat([]byte{30}, 10)
+	var records []*sam.Record
+	for i, r := range []struct {
+		name string
+		tlen int
+	}{{"s1", 150}, {"l1", 350}, {"u", 0}, {"s2", -150}, {"l2", -350}, {"s3", 199}} {
+		rec, err := sam.NewRecord(r.name, ref, nil, i, -1, r.tlen, 40, cigar, []byte("ACGTACGTAC"), qual, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+
+	bins, err := ParseInsertBins("0-200,200-400")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	opts := Options{MinBQ: 13, MapQ255: "exclude", Samples: 1, InsertBins: bins, Overlaps: &buf}
+	results := CalcP2(records, profile, ConvertPosType(4), 10, opts)
+	var pairs []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if fields := strings.Split(line, "\t"); fields[0] == "P" {
+			pairs = append(pairs, fields[2]+"-"+fields[4])
+		}
+	}
+	sort.Strings(pairs)
+	if got, expected := strings.Join(pairs, ","), "l1-l2,s1-s2,s1-s3,s2-s3"; got != expected {
+		t.Errorf("Expect pairs %s, got %s\n", expected, got)
+	}
+	if len(results) != 2 || results[InsertClass(All, "0-200")] == nil || results[InsertClass(All, "200-400")] == nil {
+		t.Errorf("Expect the results of the bins all_0-200 and all_200-400, got %v\n", results)
+	}
+
+	for _, s := range []string{"0-200,100-300", "200-100", "0-200-300", "a-200"} {
+		if _, err := ParseInsertBins(s); err == nil {
+			t.Errorf("Expect an error for insert bins %s\n", s)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package p2
+
+import (
+	"context"
+	"math"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+// CalcKs calculates the average pairwise divergence of overlapping reads,
+// from records sorted by reference and position, at the positions of posType
+// in the genome profile: the fraction of the compared base pairs that differ,
+// pooled over all pairs of reads. With posType four-fold, this is the synonymous
+// divergence (Ks), the lag 0 of the calculation, without the lags and samples.
+// The reads and bases are chosen, and compared, as in CalcP2, with opts;
+// the substitutions are those of opts.Classify (All, Syn or NonSyn),
+// "both" meaning Syn, and opts.TsTv and opts.Level are not used.
+// It returns the divergence, NaN without pairs, and the number n of compared base pairs.
+func CalcKs(records []*sam.Record, profile []profiling.Pos, posType byte, opts Options) (ks float64, n int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	readChan := make(chan *sam.Record)
+	go func() {
+		defer close(readChan)
+		for _, r := range records {
+			select {
+			case readChan <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if opts.Classify == "both" {
+		opts.Classify = Syn
+	}
+	opts.TsTv = false
+	opts.Level = ""
+	var sum float64
+	profileOf := func(ref string) []profiling.Pos { return profile }
+	for subProfile := range slideReads(ctx, readChan, profileOf, opts, nil) {
+		for i, x := range subProfile.Profile {
+			if !math.IsNaN(x) && checkPosType(posType, profile[subProfile.Pos+i].Type) {
+				sum += x
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return math.NaN(), 0
+	}
+	return sum / float64(n), n
+}
@@ -0,0 +1,69 @@
+package p2
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+func TestCalcKs(t *testing.T) {
+	ref, err := sam.NewReference("NC_000001", "", "", 20, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	// four-fold sites at even positions.
+	profile := make([]profiling.Pos, 20)
+	for i := range profile {
+		profile[i].Type = profiling.FourFold
+		if i%2 == 1 {
+			profile[i].Type = profiling.FirstPos
+		}
+	}
+
+	// b differs from a at 1 and 4, and c, from 5, is identical to both,
+	// with a low quality base at 7.
+	var records []*sam.Record
+	for _, r := range []struct {
+		name string
+		pos  int
+		seq  string
+	}{{"a", 0, "ACGTACGTAC"}, {"b", 0, "AGGTTCGTAC"}, {"c", 5, "CGTACGTACG"}} {
+		cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, len(r.seq))}
+		qual := bytes.Repeat([]byte{30}, len(r.seq))
+		if r.name == "c" {
+			qual[2] = 10
+		}
+		rec, err := sam.NewRecord(r.name, ref, nil, r.pos, -1, 0, 40, cigar, []byte(r.seq), qual, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+
+	testCases := []struct {
+		posType byte
+		minMQ   int
+		ks      float64
+		n       int
+	}{
+		// a-b at 0, 2, 4, 6 and 8, a-c and b-c at 6 and 8.
+		{ConvertPosType(4), 30, 1.0 / 9.0, 9},
+		// a-b at 10 positions, a-c and b-c at 4.
+		{ConvertPosType(5), 30, 2.0 / 18.0, 18},
+		// no reads above the mapping quality.
+		{ConvertPosType(4), 40, math.NaN(), 0},
+	}
+	for _, tc := range testCases {
+		opts := Options{MinBQ: 13, MinMQ: tc.minMQ, MapQ255: "exclude"}
+		ks, n := CalcKs(records, profile, tc.posType, opts)
+		if n != tc.n || (ks != tc.ks && !(math.IsNaN(ks) && math.IsNaN(tc.ks))) {
+			t.Errorf("pos type %d, min-mq %d, Expect Ks %g of %d base pairs, got %g of %d\n", tc.posType, tc.minMQ, tc.ks, tc.n, ks, n)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package p2
+
+import "github.com/mingzhi/gomath/stat/desc/meanvar"
+
+// lagBins returns the bin of each lag in [0, maxl): the first lag of the bin,
+// at which the covariances of all the lags of the bin are pooled,
+// or -1 for a lag not used. It returns nil when every lag is its own bin.
+// With opts.LogLags, the bins are 0, 1, [2, 4), [4, 8), ...;
+// otherwise, with opts.LagStep > 1, only the lags 0, LagStep, 2*LagStep, ... are used.
+func lagBins(maxl int, opts Options) []int {
+	if !opts.LogLags && opts.LagStep <= 1 {
+		return nil
+	}
+	bins := make([]int, maxl)
+	for l := range bins {
+		switch {
+		case opts.LogLags:
+			// the largest power of 2 not above l.
+			bin := l
+			for bin&(bin-1) != 0 {
+				bin &= bin - 1
+			}
+			bins[l] = bin
+		case l%opts.LagStep == 0:
+			bins[l] = l
+		default:
+			bins[l] = -1
+		}
+	}
+	return bins
+}
+
+// lagBin returns the bin of the lag l in bins, l if bins is nil.
+func lagBin(bins []int, l int) int {
+	if bins == nil {
+		return l
+	}
+	return bins[l]
+}
+
+// Lags returns the lags, in [0, maxl), of the results of a calculation with opts:
+// the first lag of each bin, with opts.LagStep or opts.LogLags, or else every lag.
+// The results at other lags are empty.
+func Lags(maxl int, opts Options) []int {
+	bins := lagBins(maxl, opts)
+	var lags []int
+	for l := 0; l < maxl; l++ {
+		if bins == nil || bins[l] == l {
+			lags = append(lags, l)
+		}
+	}
+	return lags
+}
+
+// PopulatedLags returns the number of lags, from 0, up to the last lag with data
+// in any of the results (keyed by the substitution class).
+// A pair of reads gives lags shorter than their overlap, so at most the read length:
+// reads do not populate longer lags, whatever maxl, and nearby reads
+// which do not overlap give no lags at all, even if kept in a window by Options.Window.
+func PopulatedLags(results map[string][]*meanvar.MeanVar) int {
+	n := 0
+	for _, meanVars := range results {
+		for l := len(meanVars) - 1; l >= n; l-- {
+			if meanVars[l].Mean.GetN() > 0 {
+				n = l + 1
+				break
+			}
+		}
+	}
+	return n
+}
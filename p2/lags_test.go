@@ -0,0 +1,112 @@
+package p2
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+func TestLagBins(t *testing.T) {
+	testCases := []struct {
+		opts Options
+		bins []int
+		lags []int
+	}{
+		{Options{}, nil, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{Options{LagStep: 1}, nil, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{Options{LagStep: 3}, []int{0, -1, -1, 3, -1, -1, 6, -1, -1, 9}, []int{0, 3, 6, 9}},
+		{Options{LogLags: true}, []int{0, 1, 2, 2, 4, 4, 4, 4, 8, 8}, []int{0, 1, 2, 4, 8}},
+	}
+	profile, subProfiles := benchProfile(3000)
+	profileOf := func(ref string) []profiling.Pos { return profile }
+	expected := naiveCalc(profile, subProfiles, profiling.Coding, 10, false)
+	for _, tc := range testCases {
+		bins := lagBins(10, tc.opts)
+		if fmt.Sprint(bins) != fmt.Sprint(tc.bins) {
+			t.Errorf("%+v, Expect bins %v, got %v\n", tc.opts, tc.bins, bins)
+		}
+		if lags := Lags(10, tc.opts); fmt.Sprint(lags) != fmt.Sprint(tc.lags) {
+			t.Errorf("%+v, Expect lags %v, got %v\n", tc.opts, tc.lags, lags)
+		}
+
+		// the pairs at the distances of a bin are counted at its first lag.
+		expectedN := make([]int, 10)
+		for l := range expected {
+			if bin := lagBin(tc.bins, l); bin >= 0 {
+				expectedN[bin] += expected[l].GetN()
+			}
+		}
+		subProfileChan := make(chan SubProfile)
+		go func() {
+			defer close(subProfileChan)
+			for _, sp := range subProfiles {
+				subProfileChan <- sp
+			}
+		}()
+		for covsMap := range calc(context.Background(), subProfileChan, profileOf, profiling.Coding, 10, bins, 1, false, false) {
+			covs := covsMap[resultKey{class: All}]
+			for l := range covs {
+				if covs[l].GetN() != expectedN[l] {
+					t.Errorf("%+v, lag %d, Expect n %d, got %d\n", tc.opts, l, expectedN[l], covs[l].GetN())
+				}
+			}
+		}
+	}
+}
+
+func TestPopulatedLags(t *testing.T) {
+	ref, err := sam.NewReference("NC_000001", "", "", 100, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	profile := make([]profiling.Pos, 100)
+	for i := range profile {
+		profile[i].Type = profiling.FourFold
+	}
+
+	// reads of 10 bases: a and b overlapping by 10, c adjacent to them,
+	// and d overlapping c by 5.
+	var records []*sam.Record
+	for _, r := range []struct {
+		name string
+		pos  int
+	}{{"a", 0}, {"b", 0}, {"c", 10}, {"d", 15}} {
+		seq := []byte("ACGTACGTAC")
+		cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, len(seq))}
+		rec, err := sam.NewRecord(r.name, ref, nil, r.pos, -1, 0, 40, cigar, seq, bytes.Repeat([]byte{30}, len(seq)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+
+	// reads kept by Window, beyond the end of a read, are not compared with it.
+	for _, window := range []int{0, 30} {
+		for _, maxl := range []int{5, 10, 30} {
+			opts := Options{MinBQ: 13, MapQ255: "exclude", Samples: 1, Window: window}
+			results := CalcP2(records, profile, ConvertPosType(4), maxl, opts)
+			expected := maxl
+			if expected > 10 {
+				expected = 10
+			}
+			if n := PopulatedLags(results); n != expected {
+				t.Errorf("window %d, maxl %d, Expect %d populated lags, got %d\n", window, maxl, expected, n)
+			}
+			// a-b gives lags [0, 10), and c-d [0, 5).
+			meanVars := results[All]
+			for l := 0; l < maxl; l++ {
+				n := meanVars[l].Mean.GetN()
+				if (l < 10) != (n > 0) {
+					t.Errorf("window %d, maxl %d, lag %d, Expect data %v, got n %d\n", window, maxl, l, l < 10, n)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package p2
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/meta"
+)
+
+// MappedRead contains the section of a read mapped to a reference genome.
+type MappedRead struct {
+	Name string
+	Ref  string
+	Pos  int
+	Seq  []byte
+	Qual []byte
+
+	Strand    string // Forward or Reverse.
+	InsertBin string // label of the insert bin, with Options.InsertBins.
+}
+
+// Len returns the length of the mapped sequence.
+func (m MappedRead) Len() int {
+	return len(m.Seq)
+}
+
+// checkCigar returns an error if the CIGAR of a record has an operation
+// not handled by Map2Ref, or does not match the lengths
+// of its sequence and qualities.
+func checkCigar(r *sam.Record) error {
+	length := 0 // bases of the read consumed by the CIGAR.
+	for _, c := range r.Cigar {
+		switch c.Type() {
+		case sam.CigarMatch, sam.CigarMismatch, sam.CigarEqual, sam.CigarSoftClipped, sam.CigarInsertion:
+			length += c.Len()
+		case sam.CigarDeletion, sam.CigarSkipped, sam.CigarHardClipped, sam.CigarPadded:
+		case sam.CigarBack:
+			return fmt.Errorf("CIGAR %v: back operations (B) are not supported", r.Cigar)
+		default:
+			return fmt.Errorf("CIGAR %v: unknown operation %v", r.Cigar, c.Type())
+		}
+	}
+	if length != r.Seq.Length || length != len(r.Qual) {
+		return fmt.Errorf("CIGAR %v: %d bases, but a sequence of %d and %d qualities", r.Cigar, length, r.Seq.Length, len(r.Qual))
+	}
+	return nil
+}
+
+// Phred returns the Phred score of a base quality encoded with the offset,
+// e.g. 33 for qualities kept as ASCII characters; 0 for raw scores.
+func Phred(q byte, offset int) int {
+	return int(q) - offset
+}
+
+// Map2Ref Obtains a read mapping to the reference genome.
+// It also annotates which mapped bases are mismatches to the reference,
+// according to the MD tag; mismatches is nil if the read has no valid MD tag,
+// and counts the soft-clipped bases.
+// Each CIGAR operation is handled by what it consumes: bases of the read
+// (I, S) are skipped, positions of the reference (D, N) are gaps ('*'),
+// and hard clips (H) and paddings (P) consume neither.
+// Reads whose CIGAR does not map them linearly on the reference (B),
+// with an unknown operation, or not matching the sequence length,
+// are logged and have no mapped sequence.
+func Map2Ref(r *sam.Record) (s []byte, q []byte, mismatches []bool, softClipped int) {
+	if err := checkCigar(r); err != nil {
+		meta.ERROR.Printf("read %s: %v\n", r.Name, err)
+		return nil, nil, nil, 0
+	}
+	p := 0                 // position in the read sequence.
+	read := r.Seq.Expand() // read sequence.
+	qual := r.Qual
+	md := readMD(r)
+	k := 0 // position in the MD annotation.
+	for _, c := range r.Cigar {
+		switch c.Type() {
+		case sam.CigarMatch, sam.CigarMismatch, sam.CigarEqual:
+			s = append(s, read[p:p+c.Len()]...)
+			q = append(q, qual[p:p+c.Len()]...)
+			if md != nil {
+				mismatches = append(mismatches, md[k:k+c.Len()]...)
+				k += c.Len()
+			}
+			p += c.Len()
+		case sam.CigarSoftClipped:
+			softClipped += c.Len()
+			p += c.Len()
+		case sam.CigarInsertion:
+			p += c.Len()
+		case sam.CigarHardClipped, sam.CigarPadded:
+			// hard-clipped bases are not in the sequence,
+			// and paddings are not in the reference.
+		case sam.CigarDeletion, sam.CigarSkipped:
+			for i := 0; i < c.Len(); i++ {
+				s = append(s, '*')
+				q = append(q, 0)
+			}
+			if md != nil {
+				mismatches = append(mismatches, make([]bool, c.Len())...)
+				// skipped regions are not in the MD tag.
+				if c.Type() == sam.CigarDeletion {
+					k += c.Len()
+				}
+			}
+		}
+	}
+
+	s = bytes.ToUpper(s)
+
+	return
+}
+
+// readMD returns the mismatch annotation of the MD tag,
+// one flag for each reference base aligned to the read or deleted from it.
+// It returns nil if the tag is absent or does not agree with the CIGAR.
+func readMD(r *sam.Record) []bool {
+	aux, found := r.Tag([]byte("MD"))
+	if !found {
+		return nil
+	}
+	md, ok := aux.Value().(string)
+	if !ok {
+		return nil
+	}
+	flags, err := parseMD(md)
+	if err != nil {
+		return nil
+	}
+
+	refLen := 0
+	for _, c := range r.Cigar {
+		switch c.Type() {
+		case sam.CigarMatch, sam.CigarMismatch, sam.CigarEqual, sam.CigarDeletion:
+			refLen += c.Len()
+		}
+	}
+	if len(flags) != refLen {
+		return nil
+	}
+
+	return flags
+}
+
+// parseMD parses a MD tag, such as "10A5^AC6",
+// and flags the mismatched reference bases.
+// Deleted bases are not flagged.
+func parseMD(md string) (flags []bool, err error) {
+	n := 0
+	for i := 0; i < len(md); i++ {
+		c := md[i]
+		switch {
+		case c >= '0' && c <= '9':
+			n = n*10 + int(c-'0')
+		case c == '^':
+			flags = append(flags, make([]bool, n)...)
+			n = 0
+			if i+1 == len(md) || !isLetter(md[i+1]) {
+				return nil, fmt.Errorf("bad MD tag: %s", md)
+			}
+			for i+1 < len(md) && isLetter(md[i+1]) {
+				flags = append(flags, false)
+				i++
+			}
+		case isLetter(c):
+			flags = append(flags, make([]bool, n)...)
+			n = 0
+			flags = append(flags, true)
+		default:
+			return nil, fmt.Errorf("bad MD tag: %s", md)
+		}
+	}
+	flags = append(flags, make([]bool, n)...)
+
+	return
+}
+
+func isLetter(b byte) bool {
+	return (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z')
+}
+
+// maskMismatchClusters masks mismatched bases
+// having another mismatch within window bases,
+// by setting the base to '*' and the quality to 0.
+func maskMismatchClusters(s, q []byte, mismatches []bool, window int) {
+	indices := []int{}
+	for i, m := range mismatches {
+		if m {
+			indices = append(indices, i)
+		}
+	}
+
+	for k, i := range indices {
+		clustered := (k > 0 && i-indices[k-1] <= window) ||
+			(k+1 < len(indices) && indices[k+1]-i <= window)
+		if clustered {
+			s[i] = '*'
+			q[i] = 0
+		}
+	}
+}
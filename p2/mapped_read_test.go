@@ -0,0 +1,39 @@
+package p2
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMD(t *testing.T) {
+	testCases := []struct {
+		md    string
+		flags []bool
+		valid bool
+	}{
+		{"", nil, true},
+		{"0", nil, true},
+		{"4", []bool{false, false, false, false}, true},
+		{"12", make([]bool, 12), true},
+		{"2A1", []bool{false, false, true, false}, true},
+		{"A0C", []bool{true, true}, true},
+		{"1^AC2", []bool{false, false, false, false, false}, true},
+		{"1^AC0T1", []bool{false, false, false, true, false}, true},
+		{"2G", []bool{false, false, true}, true},
+		{"1^A", []bool{false, false}, true},
+		{"1^", nil, false},
+		{"1^2", nil, false},
+		{"2*1", nil, false},
+		{"2A-1", nil, false},
+	}
+	for _, tc := range testCases {
+		flags, err := parseMD(tc.md)
+		if (err == nil) != tc.valid {
+			t.Errorf("parseMD(%q), Expect valid %v, got error %v\n", tc.md, tc.valid, err)
+			continue
+		}
+		if len(flags) != len(tc.flags) || (len(flags) > 0 && !reflect.DeepEqual(flags, tc.flags)) {
+			t.Errorf("parseMD(%q), Expect %v, got %v\n", tc.md, tc.flags, flags)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package p2
+
+import (
+	"github.com/mingzhi/gomath/stat/desc/meanvar"
+)
+
+// MergeMeanVars pools the results of two runs (for example, on two shards of reads) at each lag,
+// using the parallel algorithm of Chan et al. for the variances.
+// Lags present in only one of them are copied. a and b are not modified.
+func MergeMeanVars(a, b []*meanvar.MeanVar) []*meanvar.MeanVar {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+
+	merged := make([]*meanvar.MeanVar, n)
+	for i := range merged {
+		merged[i] = meanvar.New()
+		if i < len(a) {
+			merged[i].Append(a[i])
+		}
+		if i < len(b) {
+			merged[i].Append(b[i])
+		}
+	}
+	return merged
+}
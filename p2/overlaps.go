@@ -0,0 +1,56 @@
+package p2
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/mingzhi/meta"
+)
+
+// overlapWriter streams mapped reads and compared read pairs
+// to a tab-separated file, for debugging the read windows:
+//
+//	R	ref	name	pos	length
+//	P	ref	name1	pos1	name2	pos2
+type overlapWriter struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func newOverlapWriter(w io.Writer) *overlapWriter {
+	return &overlapWriter{w: bufio.NewWriter(w)}
+}
+
+// Read writes a mapped read.
+func (o *overlapWriter) Read(r MappedRead) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	fmt.Fprintf(o.w, "R\t%s\t%s\t%d\t%d\n", r.Ref, r.Name, r.Pos, r.Len())
+}
+
+// Pair writes a pair of compared reads.
+func (o *overlapWriter) Pair(a, b MappedRead) {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	fmt.Fprintf(o.w, "P\t%s\t%s\t%d\t%s\t%d\n", a.Ref, a.Name, a.Pos, b.Name, b.Pos)
+}
+
+// Flush flushes buffered lines to the file.
+func (o *overlapWriter) Flush() {
+	if o == nil {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := o.w.Flush(); err != nil {
+		meta.ERROR.Println(err)
+	}
+}
@@ -0,0 +1,765 @@
+// Package p2 calculates the correlation of substitutions (P2)
+// between overlapping reads mapped to a reference genome.
+package p2
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/gomath/stat/correlation"
+	"github.com/mingzhi/gomath/stat/desc/meanvar"
+	"github.com/mingzhi/meta"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+	"github.com/mingzhi/ncbiftp/taxonomy"
+)
+
+// Options controls which reads and bases are used in the calculation.
+type Options struct {
+	MinBQ int // min base quality; bases with lower or equal quality are ignored.
+	// QualOffset is subtracted from the base qualities before comparing with MinBQ,
+	// for inputs which keep the ASCII offset (usually 33); 0 for raw Phred scores.
+	QualOffset int
+	// DefaultQual is the quality (a Phred score) given to every base of the reads
+	// without base qualities (QUAL "*"); those reads are skipped if it is 0.
+	DefaultQual int
+	// Unmapped reads are not used, nor, by their SAM flags,
+	// secondary and supplementary alignments unless KeepSecondary,
+	// and duplicates unless KeepDuplicates.
+	KeepSecondary  bool
+	KeepDuplicates bool
+
+	// Reads are used if MinMQ < MapQ <= MaxMQ;
+	// MaxMQ 0 means no upper bound.
+	MinMQ int
+	MaxMQ int
+
+	// MapQ255 decides how to handle reads with MapQ 255,
+	// which means "mapping quality is not available" in SAM:
+	// "exclude" them, "include" them regardless of the MapQ bounds,
+	// or otherwise treat them as having MapQ MapQ255As,
+	// which is then checked against MinMQ and MaxMQ.
+	// STAR and TopHat report 255 for uniquely mapped reads,
+	// while BWA and Bowtie2 never report it.
+	MapQ255   string
+	MapQ255As int
+
+	// Reads are used if their mapped length, without deletions,
+	// is at least MinReadLen, and they have at most MaxSoftClip soft-clipped bases;
+	// MaxSoftClip -1 means no limit, and 0 rejects any soft-clipped read.
+	MinReadLen  int
+	MaxSoftClip int
+
+	// MDWindow masks mismatches (according to the MD tag)
+	// lying within MDWindow bases of another mismatch,
+	// which are likely misalignment; 0 disables it.
+	MDWindow int
+
+	// Paired merges the overlapping mates of a read pair into one read,
+	// so that the overlap is not counted twice.
+	Paired bool
+	// DedupeMates skips comparing two reads with the same name,
+	// mates of a fragment, which are not independent;
+	// unlike Paired, their overlap is not used.
+	DedupeMates bool
+	// MinOverlap is the min number of reference positions covered by both
+	// reads of a compared pair; pairs overlapping by fewer positions,
+	// which give few (edge) observations, are not compared.
+	MinOverlap int
+
+	// Classify splits substitutions into synonymous (Syn)
+	// and non-synonymous (NonSyn) ones, according to the reference codon
+	// in the genome profile and GeneticCode: "syn", "nonsyn", or "both".
+	// The default ("" or All) uses all substitutions.
+	Classify    string
+	GeneticCode *taxonomy.GeneticCode
+
+	// Compare compares the bases of two reads at a position;
+	// nil for CompareBases. It is not used at the amino acid Level.
+	Compare Comparator
+
+	// TsTv splits substitutions into transitions (Ts) and transversions (Tv),
+	// in place of Classify and Compare; the positions left out are the same in both.
+	TsTv bool
+
+	// Level is the level of the substitutions: "nuc" (or "") for bases,
+	// or "aa" for amino acids, encoded by the reference codons in the genome profile
+	// and GeneticCode; lags are then in codons, and posType is not used.
+	Level string
+
+	// Exclude are regions, such as repeats, whose positions are not used.
+	Exclude Regions
+
+	// ReadGroups, if not empty, are the read groups (IDs in the RG tag)
+	// whose reads are used, e.g. those of a sample in a merged file;
+	// reads without an RG tag are then not used.
+	ReadGroups map[string]bool
+
+	// MaxPileup, if > 0, caps the reads held in a window of overlapping reads,
+	// in regions of extreme coverage, bounding both the memory and the reads compared with a read:
+	// beyond it, the reads are reservoir sampled and the others dropped,
+	// with a fixed seed so that runs are reproducible. It should be at least 2.
+	MaxPileup int
+
+	// Window, if longer than a read, keeps in its window the reads starting
+	// within Window bases of it, rather than only the reads overlapping it.
+	// Pairs of reads without a common position have no substitution profile,
+	// and are skipped: longer windows do not populate lags from the overlap on
+	// (see PopulatedLags), but hold more reads.
+	Window int
+
+	// Stranded keeps the reads on the forward and reverse strands apart,
+	// for stranded protocols: only reads on the same strand are compared,
+	// and their substitutions are of the class StrandClass(class, strand).
+	// Mates of a pair, mapped on opposite strands, are then not compared.
+	Stranded bool
+
+	// InsertBins, if not empty, keep the reads of fragments of each bin
+	// of insert sizes apart: only reads in the same bin are compared,
+	// and their substitutions are of the class InsertClass(class, bin label).
+	// Reads in none of the bins are not used.
+	InsertBins []InsertBin
+
+	// LagStep, if > 1, uses only the lags 0, LagStep, 2*LagStep, ...;
+	// LogLags, in place of LagStep, pools the lags in geometric bins,
+	// 0, 1, [2, 4), [4, 8), ..., at the first lag of each bin.
+	// The results at the lags not used are empty; see Lags.
+	LagStep int
+	LogLags bool
+
+	Samples  int       // number of samples the compared pairs are split into.
+	MaxPairs int64     // stop after comparing MaxPairs read pairs; 0 for no limit.
+	Overlaps io.Writer // if not nil, reads and compared read pairs are dumped to it.
+	// Components, if not nil, is written the Components of the covariance
+	// at each lag of each sample, one JSON object per line.
+	Components io.Writer
+}
+
+// SubProfile is the substitution profile of two reads from the position Pos
+// of the reference Ref.
+// Type is the class of the substitutions (All, Syn or NonSyn).
+type SubProfile struct {
+	Type    string
+	Ref     string
+	Pos     int
+	Profile []float64
+}
+
+// resultKey identifies the results of a substitution class on a reference;
+// ref is empty when the references are pooled.
+type resultKey struct {
+	ref, class string
+}
+
+// CalcP2 calculates the correlation of substitutions at lags [0, maxl)
+// from records sorted by reference and position,
+// using the positions of posType in the genome profile.
+// Only overlapping reads are compared, and a pair gives lags shorter than its overlap,
+// so lags from the read length on are empty (see PopulatedLags).
+// It returns, for each substitution class (All, or Syn and NonSyn with opts.Classify),
+// the mean and variance of the covariance over samples at each lag.
+func CalcP2(records []*sam.Record, profile []profiling.Pos, posType byte, maxl int, opts Options) map[string][]*meanvar.MeanVar {
+	// stop sending records once Calc returns, e.g. at opts.MaxPairs.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	readChan := make(chan *sam.Record)
+	go func() {
+		defer close(readChan)
+		for _, r := range records {
+			select {
+			case readChan <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return Calc(ctx, readChan, profile, posType, maxl, opts)
+}
+
+// Calc is like CalcP2, but reads the records from a channel.
+// Once opts.MaxPairs is reached, it stops receiving from the channel.
+// If ctx is cancelled, it stops receiving, all its go routines return,
+// and the results are incomplete; callers should check ctx.Err().
+func Calc(ctx context.Context, readChan chan *sam.Record, profile []profiling.Pos, posType byte, maxl int, opts Options) map[string][]*meanvar.MeanVar {
+	profileOf := func(ref string) []profiling.Pos { return profile }
+	results := make(map[string][]*meanvar.MeanVar)
+	for key, meanVars := range calcResults(ctx, readChan, profileOf, posType, maxl, opts, false) {
+		results[key.class] = meanVars
+	}
+	return results
+}
+
+// CalcByRef is like Calc, but keeps the results of each reference separate,
+// using its genome profile in profiles, keyed by the reference name.
+// Reads on references without a profile are ignored.
+// It returns the results of each reference with read pairs,
+// keyed by the reference name and then by the substitution class.
+func CalcByRef(ctx context.Context, readChan chan *sam.Record, profiles map[string][]profiling.Pos, posType byte, maxl int, opts Options) map[string]map[string][]*meanvar.MeanVar {
+	profileOf := func(ref string) []profiling.Pos { return profiles[ref] }
+	results := make(map[string]map[string][]*meanvar.MeanVar)
+	for key, meanVars := range calcResults(ctx, readChan, profileOf, posType, maxl, opts, true) {
+		if results[key.ref] == nil {
+			results[key.ref] = make(map[string][]*meanvar.MeanVar)
+		}
+		results[key.ref][key.class] = meanVars
+	}
+	return results
+}
+
+// calcResults runs the calculation, with the genome profile of each reference
+// given by profileOf, keeping the references separate if byRef.
+func calcResults(ctx context.Context, readChan chan *sam.Record, profileOf func(ref string) []profiling.Pos, posType byte, maxl int, opts Options, byRef bool) map[resultKey][]*meanvar.MeanVar {
+	if opts.Samples < 1 {
+		opts.Samples = 1
+	}
+
+	var overlaps *overlapWriter
+	if opts.Overlaps != nil {
+		overlaps = newOverlapWriter(opts.Overlaps)
+		defer overlaps.Flush()
+	}
+
+	// pooled results are returned for every class, even without data.
+	var keys []resultKey
+	if !byRef {
+		for _, c := range classes(opts) {
+			keys = append(keys, resultKey{class: c})
+		}
+	}
+
+	byCodon := opts.Level == AminoAcid
+	if byCodon {
+		// amino acid substitutions are at the first positions of codons.
+		posType = profiling.FirstPos
+	}
+	subProfileChan := slideReads(ctx, readChan, profileOf, opts, overlaps)
+	covsChan := calc(ctx, subProfileChan, profileOf, posType, maxl, lagBins(maxl, opts), opts.Samples, byRef, byCodon)
+	return collect(covsChan, maxl, keys, newComponentWriter(opts.Components))
+}
+
+// slideReads compares overlapping reads.
+// If opts.MaxPairs > 0, it stops reading once MaxPairs read pairs have been compared.
+// With opts.Classify, the substitutions are classified using the genome profile
+// of the reference.
+// It stops reading and comparing when ctx is done.
+func slideReads(ctx context.Context, readChan chan *sam.Record, profileOf func(ref string) []profiling.Pos, opts Options, overlaps *overlapWriter) chan SubProfile {
+	subProfileChan := make(chan SubProfile)
+
+	// stop is closed when MaxPairs is reached.
+	stop := make(chan bool)
+	var stopOnce sync.Once
+	var numPairs int64
+
+	mappedReadArrChan := make(chan []MappedRead)
+	go func() {
+		defer close(mappedReadArrChan)
+
+		totalDiscards := 0
+		totalUsed := 0
+		// discards by mapping quality or read group, base qualities, and read length.
+		var discardsMapQ, discardsQual, discardsLen int
+		missingQuals := 0 // reads without base qualities.
+		discardsFlags := make(map[string]int)
+		discardsInsert := 0 // reads in none of the insert bins.
+		// the reads of each strand, with Stranded, and of each insert bin,
+		// are in their own window.
+		windows := make(map[string]*readWindow)
+		var windowKeys []string
+	readLoop:
+		for {
+			var r *sam.Record
+			select {
+			case <-ctx.Done():
+				meta.WARN.Printf("Cancelled: %v\n", ctx.Err())
+				break readLoop
+			case <-stop:
+				meta.WARN.Printf("Reached max pairs (%d), the run was truncated\n", opts.MaxPairs)
+				break readLoop
+			case rec, ok := <-readChan:
+				if !ok {
+					break readLoop
+				}
+				r = rec
+			}
+
+			if category := excludedByFlags(r, opts); category != "" {
+				totalDiscards++
+				discardsFlags[category]++
+				continue
+			}
+			if !checkMapQ(int(r.MapQ), opts) || !checkReadGroup(r, opts) {
+				totalDiscards++
+				discardsMapQ++
+				continue
+			}
+			if missingQual(r) {
+				missingQuals++
+			}
+			r, ok := checkQual(r, opts)
+			if !ok {
+				totalDiscards++
+				discardsQual++
+				continue
+			}
+			current := MappedRead{}
+			current.Name = r.Name
+			current.Ref = r.Ref.Name()
+			current.Pos = r.Pos
+			current.Strand = readStrand(r)
+			if len(opts.InsertBins) > 0 {
+				label, ok := insertBin(r, opts.InsertBins)
+				if !ok {
+					totalDiscards++
+					discardsInsert++
+					continue
+				}
+				current.InsertBin = label
+			}
+			var mismatches []bool
+			var softClipped int
+			current.Seq, current.Qual, mismatches, softClipped = Map2Ref(r)
+			if !checkReadLen(current.Seq, softClipped, opts) {
+				totalDiscards++
+				discardsLen++
+				continue
+			}
+			if opts.MDWindow > 0 {
+				maskMismatchClusters(current.Seq, current.Qual, mismatches, opts.MDWindow)
+			}
+			overlaps.Read(current)
+			key := current.InsertBin
+			if opts.Stranded {
+				key = current.Strand + "\t" + key
+			}
+			window, found := windows[key]
+			if !found {
+				window = newReadWindow(opts)
+				windows[key] = window
+				windowKeys = append(windowKeys, key)
+			}
+			for _, mappedReadArr := range window.Add(current) {
+				select {
+				case mappedReadArrChan <- mappedReadArr:
+				case <-ctx.Done():
+					return
+				}
+			}
+			totalUsed++
+		}
+		subsampled, dropped := 0, 0
+		for _, key := range windowKeys {
+			for _, mappedReadArr := range windows[key].Flush() {
+				select {
+				case mappedReadArrChan <- mappedReadArr:
+				case <-ctx.Done():
+					return
+				}
+			}
+			subsampled += windows[key].subsampled
+			dropped += windows[key].dropped
+		}
+		meta.INFO.Printf("Total discard reads: %d (mapping quality or read group: %d, missing base qualities: %d, read length: %d)\n",
+			totalDiscards, discardsMapQ, discardsQual, discardsLen)
+		for _, category := range flagCategories {
+			if n := discardsFlags[category]; n > 0 {
+				meta.INFO.Printf("Discarded %s reads: %d\n", category, n)
+			}
+		}
+		// reads dropped at the pileup cap are not used.
+		meta.INFO.Printf("Total used reads: %d\n", totalUsed-dropped)
+		if missingQuals > 0 && opts.DefaultQual > 0 {
+			meta.WARN.Printf("%d reads without base qualities, given quality %d\n", missingQuals, opts.DefaultQual)
+		} else if missingQuals > 0 {
+			meta.WARN.Printf("%d reads without base qualities were discarded\n", missingQuals)
+		}
+		if discardsInsert > 0 {
+			meta.INFO.Printf("Discarded reads in none of the insert bins: %d\n", discardsInsert)
+		}
+		if subsampled > 0 {
+			meta.WARN.Printf("Subsampled %d windows of more than %d reads, dropping %d reads\n", subsampled, opts.MaxPileup, dropped)
+		}
+	}()
+
+	// send sends a substitution profile of the read a (and another read),
+	// and returns false when ctx is done.
+	// With Stranded, the class is that of the strand of the reads,
+	// and with InsertBins, that of their insert bin.
+	send := func(subProfile SubProfile, a MappedRead) bool {
+		opts.Exclude.mask(subProfile)
+		if opts.Stranded {
+			subProfile.Type = StrandClass(subProfile.Type, a.Strand)
+		}
+		if len(opts.InsertBins) > 0 {
+			subProfile.Type = InsertClass(subProfile.Type, a.InsertBin)
+		}
+		select {
+		case subProfileChan <- subProfile:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	ncpu := runtime.GOMAXPROCS(0)
+	done := make(chan bool)
+	for i := 0; i < ncpu; i++ {
+		go func() {
+			defer func() { done <- true }()
+			for mappedReadArr := range mappedReadArrChan {
+				a := mappedReadArr[0]
+				mappedReadArr = mappedReadArr[1:]
+				for _, b := range mappedReadArr {
+					// the following reads do not overlap a,
+					// and are skipped even if kept by opts.Window.
+					if b.Pos > a.Len()+a.Pos {
+						break
+					}
+					if opts.DedupeMates && a.Name == b.Name {
+						continue
+					}
+					if overlapLen(a, b) < opts.MinOverlap {
+						continue
+					}
+					if opts.MaxPairs > 0 {
+						n := atomic.AddInt64(&numPairs, 1)
+						if n > opts.MaxPairs {
+							break
+						}
+						if n == opts.MaxPairs {
+							stopOnce.Do(func() { close(stop) })
+						}
+					}
+					overlaps.Pair(a, b)
+					if opts.Level == AminoAcid {
+						if !send(compareAminoAcids(a, b, opts.MinBQ, opts.QualOffset, profileOf(a.Ref), opts.GeneticCode), a) {
+							return
+						}
+						continue
+					}
+					if opts.TsTv {
+						ts, tv := compareTsTv(a, b, opts.MinBQ, opts.QualOffset, opts.GeneticCode)
+						if !send(ts, a) || !send(tv, a) {
+							return
+						}
+						continue
+					}
+					switch opts.Classify {
+					case "", All:
+						if !send(CompareMappedReads(a, b, opts.MinBQ, opts.QualOffset, opts.Compare, opts.GeneticCode), a) {
+							return
+						}
+					default:
+						syn, nonsyn := compareCodons(a, b, opts.MinBQ, opts.QualOffset, opts.Compare, profileOf(a.Ref), opts.GeneticCode)
+						if opts.Classify != NonSyn && !send(syn, a) {
+							return
+						}
+						if opts.Classify != Syn && !send(nonsyn, a) {
+							return
+						}
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(subProfileChan)
+		for i := 0; i < ncpu; i++ {
+			<-done
+		}
+	}()
+
+	return subProfileChan
+}
+
+// overlapLen returns the number of reference positions covered by the reads a and b,
+// b not starting before a.
+func overlapLen(a, b MappedRead) int {
+	end := a.Pos + a.Len()
+	if b.Pos+b.Len() < end {
+		end = b.Pos + b.Len()
+	}
+	if end < b.Pos {
+		return 0
+	}
+	return end - b.Pos
+}
+
+// checkMapQ return true if a read with the mapping quality is used.
+func checkMapQ(mapQ int, opts Options) bool {
+	if mapQ == 255 {
+		switch opts.MapQ255 {
+		case "exclude":
+			return false
+		case "include":
+			return true
+		default:
+			mapQ = opts.MapQ255As
+		}
+	}
+	if opts.MaxMQ > 0 && mapQ > opts.MaxMQ {
+		return false
+	}
+	return mapQ > opts.MinMQ
+}
+
+// CompareMappedReads compares two MappedReads in their overlapped part,
+// and return a subsitution profile.
+// The read b should not start before a; the profile starts at b.Pos,
+// on the reference of b, and has a value for each overlapped position.
+// Bases are compared by compare (CompareBases if nil),
+// if both are ATGC with a quality (encoded with qualOffset) above minBQ;
+// other positions are NaN.
+// gc is only used by the comparators of codons, and may be nil otherwise.
+func CompareMappedReads(a, b MappedRead, minBQ, qualOffset int, compare Comparator, gc *taxonomy.GeneticCode) SubProfile {
+	if compare == nil {
+		compare = CompareBases
+	}
+	var subs []float64
+	lag := b.Pos - a.Pos
+	for j := 0; j < a.Len()-lag && j < b.Len(); j++ {
+		i := j + lag
+		d := math.NaN()
+		if IsATGC(a.Seq[i]) && IsATGC(b.Seq[j]) {
+			if Phred(a.Qual[i], qualOffset) > minBQ && Phred(b.Qual[j], qualOffset) > minBQ {
+				if v, ok := compare(a, b, i, j, gc); ok {
+					d = v
+				}
+			}
+		}
+		subs = append(subs, d)
+	}
+	return SubProfile{Type: All, Ref: b.Ref, Pos: b.Pos, Profile: subs}
+}
+
+// IsATGC returns true if b is an upper case base A, T, G or C.
+func IsATGC(b byte) bool {
+	if b == 'A' {
+		return true
+	} else if b == 'T' {
+		return true
+	} else if b == 'C' {
+		return true
+	} else if b == 'G' {
+		return true
+	}
+
+	return false
+}
+
+// calc calculates the covariances of each substitution class in each sample,
+// and of each reference if byRef.
+// If byCodon, lags are in codons, between positions of the same gene.
+// The covariance at a lag is pooled at its bin in bins, unless bins is nil (see lagBins).
+// Substitutions on references without a genome profile are ignored.
+// It stops receiving when ctx is done, and sends no covariances.
+func calc(ctx context.Context, subProfileChan chan SubProfile, profileOf func(ref string) []profiling.Pos, posType byte, maxl int, bins []int, samples int, byRef, byCodon bool) (covsChan chan map[resultKey][]*correlation.BivariateCovariance) {
+	covsChan = make(chan map[resultKey][]*correlation.BivariateCovariance)
+	done := make(chan bool)
+	for i := 0; i < samples; i++ {
+		go func() {
+			defer func() { done <- true }()
+			covsMap := make(map[resultKey][]*correlation.BivariateCovariance)
+			var valid []bool // valid positions of a substitution profile, reused.
+			var runs []int   // start of the gene run of each position, reused.
+
+			for {
+				var subProfile SubProfile
+				select {
+				case <-ctx.Done():
+					return
+				case sp, ok := <-subProfileChan:
+					if !ok {
+						select {
+						case covsChan <- covsMap:
+						case <-ctx.Done():
+						}
+						return
+					}
+					subProfile = sp
+				}
+
+				profile := profileOf(subProfile.Ref)
+				if profile == nil {
+					continue
+				}
+				key := resultKey{class: subProfile.Type}
+				if byRef {
+					key.ref = subProfile.Ref
+				}
+				covs, found := covsMap[key]
+				if !found {
+					for i := 0; i < maxl; i++ {
+						covs = append(covs, correlation.NewBivariateCovariance(false))
+					}
+					covsMap[key] = covs
+				}
+				// check each position once, rather than in every pair.
+				xs := subProfile.Profile
+				n := len(xs)
+				if cap(valid) < n {
+					valid = make([]bool, n)
+				}
+				valid = valid[:n]
+				for i, x := range xs {
+					valid[i] = checkPosType(posType, profile[subProfile.Pos+i].Type) && !math.IsNaN(x)
+				}
+				if byCodon {
+					// runs of positions of the same gene,
+					// so that genes are compared by name only across runs.
+					if cap(runs) < n {
+						runs = make([]int, n)
+					}
+					runs = runs[:n]
+					for i := range runs {
+						runs[i] = i
+						if i > 0 && profile[subProfile.Pos+i].Gene == profile[subProfile.Pos+i-1].Gene {
+							runs[i] = runs[i-1]
+						}
+					}
+				}
+
+				for i := 0; i < n; i++ {
+					if !valid[i] {
+						continue
+					}
+					x := xs[i]
+					if byCodon {
+						gene := profile[subProfile.Pos+i].Gene
+						for j, l := i, 0; j < n && l < maxl; j, l = j+3, l+1 {
+							if valid[j] && (runs[j] == runs[i] || profile[subProfile.Pos+j].Gene == gene) {
+								if bin := lagBin(bins, l); bin >= 0 {
+									covs[bin].Increment(x, xs[j])
+								}
+							}
+						}
+					} else {
+						end := i + maxl
+						if end > n {
+							end = n
+						}
+						for j := i; j < end; j++ {
+							if valid[j] {
+								if bin := lagBin(bins, j-i); bin >= 0 {
+									covs[bin].Increment(x, xs[j])
+								}
+							}
+						}
+					}
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(covsChan)
+		for i := 0; i < samples; i++ {
+			<-done
+		}
+	}()
+
+	return
+}
+
+// collect pools the covariances of samples for each key,
+// returning results for the keys even if they have no covariances.
+// The components of the covariances are written to components, if not nil.
+func collect(covsChan chan map[resultKey][]*correlation.BivariateCovariance, maxl int, keys []resultKey, components *componentWriter) (meanVarsMap map[resultKey][]*meanvar.MeanVar) {
+	meanVarsMap = make(map[resultKey][]*meanvar.MeanVar)
+	newMeanVars := func() []*meanvar.MeanVar {
+		meanVars := []*meanvar.MeanVar{}
+		for i := 0; i < maxl; i++ {
+			meanVars = append(meanVars, meanvar.New())
+		}
+		return meanVars
+	}
+	for _, key := range keys {
+		meanVarsMap[key] = newMeanVars()
+	}
+
+	sample := 0
+	for covsMap := range covsChan {
+		for key, covs := range covsMap {
+			components.Write(key, sample, covs)
+			meanVars, found := meanVarsMap[key]
+			if !found {
+				meanVars = newMeanVars()
+				meanVarsMap[key] = meanVars
+			}
+			for i := range covs {
+				v := covs[i].GetResult()
+				if !math.IsNaN(v) {
+					meanVars[i].Increment(v)
+				}
+			}
+		}
+		sample++
+	}
+
+	return
+}
+
+// checkReadLen returns true if a read with the mapped sequence s
+// and softClipped soft-clipped bases passes the length filters.
+func checkReadLen(s []byte, softClipped int, opts Options) bool {
+	if opts.MaxSoftClip >= 0 && softClipped > opts.MaxSoftClip {
+		return false
+	}
+	return len(s)-bytes.Count(s, []byte{'*'}) >= opts.MinReadLen
+}
+
+func checkPosType(posType, t1 byte) bool {
+	isFirstPos := t1 == profiling.FirstPos
+	isSecondPos := t1 == profiling.SecondPos
+	isThirdPos := t1 == profiling.ThirdPos
+	isFourFold := t1 == profiling.FourFold
+
+	if posType == profiling.Coding {
+		if isFirstPos || isSecondPos || isThirdPos || isFourFold {
+			return true
+		}
+		return false
+	}
+
+	if posType == profiling.ThirdPos {
+		if isThirdPos || isFourFold {
+			return true
+		}
+		return false
+	}
+
+	return posType == t1
+}
+
+// ConvertPosType converts a position (0: non-coding, 1-3: codon positions,
+// 4: four-fold degenerate sites, others: coding) to the position type in a profile.
+func ConvertPosType(pos int) byte {
+	var p byte
+	switch pos {
+	case 0:
+		p = profiling.NonCoding
+	case 1:
+		p = profiling.FirstPos
+		break
+	case 2:
+		p = profiling.SecondPos
+		break
+	case 3:
+		p = profiling.ThirdPos
+		break
+	case 4:
+		p = profiling.FourFold
+		break
+	default:
+		p = profiling.Coding
+	}
+
+	return p
+}
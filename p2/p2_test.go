@@ -0,0 +1,569 @@
+package p2
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/gomath/stat/desc/meanvar"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+	"github.com/mingzhi/ncbiftp/taxonomy"
+)
+
+func TestCheckMapQ(t *testing.T) {
+	testCases := []struct {
+		opts     Options
+		expected map[int]bool // MapQ => used.
+	}{
+		{Options{MinMQ: 0, MaxMQ: 60, MapQ255: "exclude"}, map[int]bool{30: true, 51: true, 60: true, 255: false}},
+		{Options{MinMQ: 0, MaxMQ: 50, MapQ255: "exclude"}, map[int]bool{30: true, 51: false, 60: false, 255: false}},
+		{Options{MinMQ: 30, MaxMQ: 60, MapQ255: "exclude"}, map[int]bool{30: false, 51: true, 60: true, 255: false}},
+		{Options{MinMQ: 0, MaxMQ: 0, MapQ255: "exclude"}, map[int]bool{30: true, 51: true, 60: true, 255: false}},
+		{Options{MinMQ: 0, MaxMQ: 50, MapQ255: "include"}, map[int]bool{30: true, 51: false, 60: false, 255: true}},
+		{Options{MinMQ: 0, MaxMQ: 60, MapQ255: "as", MapQ255As: 60}, map[int]bool{30: true, 51: true, 60: true, 255: true}},
+		{Options{MinMQ: 30, MaxMQ: 60, MapQ255: "as", MapQ255As: 20}, map[int]bool{30: false, 51: true, 60: true, 255: false}},
+	}
+
+	for _, tc := range testCases {
+		for mapQ, expected := range tc.expected {
+			if got := checkMapQ(mapQ, tc.opts); got != expected {
+				t.Errorf("%+v, MapQ %d, Expect %v, got %v\n", tc.opts, mapQ, expected, got)
+			}
+		}
+	}
+}
+
+func TestCompareCodons(t *testing.T) {
+	// a forward gene (GCT AAA), a reverse gene (TTT, coding AAA),
+	// and a non-coding position.
+	bases := "GCTAAATTTA"
+	types := []byte{
+		profiling.FirstPos, profiling.SecondPos, profiling.FourFold,
+		profiling.FirstPos, profiling.SecondPos, profiling.ThirdPos,
+		profiling.ThirdPos, profiling.SecondPos, profiling.FirstPos,
+		profiling.NonCoding,
+	}
+	genes := []string{"g1", "g1", "g1", "g1", "g1", "g1", "g2", "g2", "g2", ""}
+	profile := make([]profiling.Pos, len(bases))
+	for i := range profile {
+		profile[i] = profiling.Pos{Base: bases[i], Type: types[i], Gene: genes[i]}
+	}
+
+	qual := bytes.Repeat([]byte{30}, len(bases))
+	a := MappedRead{Pos: 0, Seq: []byte("GCTNAATTTA"), Qual: qual}
+	b := MappedRead{Pos: 0, Seq: []byte("GCCAACCTGC"), Qual: append([]byte{}, qual...)}
+	b.Qual[1] = 5
+
+	nan := math.NaN()
+	expectedSyn := []float64{0, nan, 1, nan, 0, nan, 1, 0, nan, nan}
+	expectedNonSyn := []float64{0, nan, nan, nan, 0, 1, nan, 0, 1, nan}
+
+	syn, nonsyn := compareCodons(a, b, 13, 0, nil, profile, taxonomy.GeneticCodes()["11"])
+	for _, tc := range []struct {
+		subs     SubProfile
+		t        string
+		expected []float64
+	}{
+		{syn, Syn, expectedSyn},
+		{nonsyn, NonSyn, expectedNonSyn},
+	} {
+		if tc.subs.Type != tc.t {
+			t.Errorf("Expect type %s, got %s\n", tc.t, tc.subs.Type)
+		}
+		if len(tc.subs.Profile) != len(tc.expected) {
+			t.Fatalf("%s, Expect %d positions, got %d\n", tc.t, len(tc.expected), len(tc.subs.Profile))
+		}
+		for i, d := range tc.subs.Profile {
+			e := tc.expected[i]
+			if d != e && !(math.IsNaN(d) && math.IsNaN(e)) {
+				t.Errorf("%s at %d, Expect %g, got %g\n", tc.t, i, e, d)
+			}
+		}
+	}
+}
+
+func TestMergeMeanVars(t *testing.T) {
+	values := [][]float64{
+		{0.1, 0.5, 0.2, 0.9, 0.4},
+		{1.5, -0.5, 2.0},
+		{3.0},
+	}
+	all := []*meanvar.MeanVar{}
+	a := []*meanvar.MeanVar{}
+	b := []*meanvar.MeanVar{}
+	for l, vs := range values {
+		all = append(all, meanvar.New())
+		a = append(a, meanvar.New())
+		if l < 2 {
+			b = append(b, meanvar.New())
+		}
+		for i, v := range vs {
+			all[l].Increment(v)
+			// lag 2 is only in a.
+			if i%2 == 0 || l >= len(b) {
+				a[l].Increment(v)
+			} else {
+				b[l].Increment(v)
+			}
+		}
+	}
+
+	merged := MergeMeanVars(a, b)
+	if len(merged) != len(all) {
+		t.Fatalf("Expect %d lags, got %d\n", len(all), len(merged))
+	}
+	for l := range all {
+		if merged[l].Mean.GetN() != all[l].Mean.GetN() {
+			t.Errorf("lag %d, Expect n %d, got %d\n", l, all[l].Mean.GetN(), merged[l].Mean.GetN())
+		}
+		for _, c := range []struct {
+			name          string
+			expected, got float64
+		}{
+			{"mean", all[l].Mean.GetResult(), merged[l].Mean.GetResult()},
+			{"variance", all[l].Var.GetResult(), merged[l].Var.GetResult()},
+		} {
+			if math.Abs(c.expected-c.got) > 1e-12 {
+				t.Errorf("lag %d, Expect %s %g, got %g\n", l, c.name, c.expected, c.got)
+			}
+		}
+	}
+
+	// the inputs are not modified.
+	if a[0].Mean.GetN() != 3 || b[0].Mean.GetN() != 2 {
+		t.Errorf("Expect the inputs not modified, got n %d and %d\n", a[0].Mean.GetN(), b[0].Mean.GetN())
+	}
+}
+
+// TestCalcCancel cancels Calc in the middle of an endless stream of reads,
+// and checks that all its go routines return.
+func TestCalcCancel(t *testing.T) {
+	const genomeLen = 1 << 20
+	ref, err := sam.NewReference("NC_000001", "", "", genomeLen, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	profile := make([]profiling.Pos, genomeLen)
+	for i := range profile {
+		profile[i].Type = profiling.FourFold
+	}
+
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	readChan := make(chan *sam.Record)
+	go func() {
+		defer close(readChan)
+		cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 10)}
+		qual := bytes.Repeat([]byte{30}, 10)
+		for pos := 0; pos+10 < genomeLen; pos++ {
+			r, err := sam.NewRecord("read", ref, nil, pos, -1, 0, 40, cigar, []byte("ACGTACGTAC"), qual, nil)
+			if err != nil {
+				panic(err)
+			}
+			if pos == 1000 {
+				cancel()
+			}
+			select {
+			case readChan <- r:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	opts := Options{MinBQ: 13, MapQ255: "exclude", Samples: 4}
+	Calc(ctx, readChan, profile, ConvertPosType(4), 10, opts)
+	if ctx.Err() == nil {
+		t.Fatal("Expect a cancelled context")
+	}
+
+	// let the go routines return.
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Errorf("Expect %d go routines, got %d\n", before, n)
+	}
+}
+
+func TestCompareMappedReadsQualOffset(t *testing.T) {
+	testCases := []struct {
+		offset int
+		qual   []byte // qualities of both reads.
+	}{
+		// raw Phred scores 30, 10, 30 and 14.
+		{0, []byte{30, 10, 30, 14}},
+		// the same scores with the ASCII offset.
+		{33, []byte{63, 43, 63, 47}},
+	}
+	for _, tc := range testCases {
+		a := MappedRead{Pos: 0, Seq: []byte("ACGT"), Qual: tc.qual}
+		b := MappedRead{Pos: 0, Seq: []byte("ACTT"), Qual: tc.qual}
+		subs := CompareMappedReads(a, b, 13, tc.offset, nil, nil).Profile
+		// bases with quality 10 are ignored.
+		expected := []float64{0, math.NaN(), 1, 0}
+		for i := range expected {
+			if subs[i] != expected[i] && !(math.IsNaN(subs[i]) && math.IsNaN(expected[i])) {
+				t.Errorf("offset %d, %d, Expect %g, got %g\n", tc.offset, i, expected[i], subs[i])
+			}
+		}
+	}
+}
+
+func TestCompareMappedReads(t *testing.T) {
+	nan := math.NaN()
+	read := func(pos int, seq string) MappedRead {
+		return MappedRead{Ref: "NC_000001", Pos: pos, Seq: []byte(seq), Qual: bytes.Repeat([]byte{30}, len(seq))}
+	}
+	lowQual := read(0, "ACGT")
+	lowQual.Qual[2] = 10
+	testCases := []struct {
+		name     string
+		a, b     MappedRead
+		pos      int
+		expected []float64
+	}{
+		{"identical", read(0, "ACGT"), read(0, "ACGT"), 0, []float64{0, 0, 0, 0}},
+		{"substitutions", read(0, "ACGT"), read(0, "TCGA"), 0, []float64{1, 0, 0, 1}},
+		// b starts 2 bases after a, and ends after it.
+		{"lag", read(10, "ACGTAC"), read(12, "GAACGT"), 12, []float64{0, 1, 0, 0}},
+		// b ends before a.
+		{"contained", read(0, "ACGTAC"), read(1, "CTA"), 1, []float64{0, 1, 1}},
+		{"deletion", read(0, "AC*T"), read(0, "ACGT"), 0, []float64{0, 0, nan, 0}},
+		{"N", read(0, "ACGT"), read(0, "ANGT"), 0, []float64{0, nan, 0, 0}},
+		{"low quality", lowQual, read(0, "ACTT"), 0, []float64{0, 0, nan, 0}},
+		{"not overlapped", read(0, "ACGT"), read(4, "ACGT"), 4, nil},
+	}
+	for _, tc := range testCases {
+		subs := CompareMappedReads(tc.a, tc.b, 13, 0, nil, nil)
+		if subs.Type != All || subs.Ref != tc.b.Ref || subs.Pos != tc.pos {
+			t.Errorf("%s, Expect %s on %s at %d, got %s on %s at %d\n", tc.name, All, tc.b.Ref, tc.pos, subs.Type, subs.Ref, subs.Pos)
+		}
+		if len(subs.Profile) != len(tc.expected) {
+			t.Errorf("%s, Expect %d positions, got %d\n", tc.name, len(tc.expected), len(subs.Profile))
+			continue
+		}
+		for i, e := range tc.expected {
+			if v := subs.Profile[i]; v != e && !(math.IsNaN(v) && math.IsNaN(e)) {
+				t.Errorf("%s, %d, Expect %g, got %g\n", tc.name, i, e, v)
+			}
+		}
+	}
+}
+
+func TestCompareCodonsDeletion(t *testing.T) {
+	// two forward codons, GCT AAA.
+	bases := "GCTAAA"
+	types := []byte{
+		profiling.FirstPos, profiling.SecondPos, profiling.FourFold,
+		profiling.FirstPos, profiling.SecondPos, profiling.ThirdPos,
+	}
+	profile := make([]profiling.Pos, len(bases))
+	for i := range profile {
+		profile[i] = profiling.Pos{Base: bases[i], Type: types[i], Gene: "g1"}
+	}
+
+	// a deletion in the middle of the second codon,
+	// whose other bases are compared (a synonymous and an identical base).
+	qual := bytes.Repeat([]byte{30}, len(bases))
+	deleted := MappedRead{Pos: 0, Seq: []byte("GCTA*A"), Qual: append([]byte{}, qual...)}
+	deleted.Qual[4] = 0
+	other := MappedRead{Pos: 0, Seq: []byte("GCCAAG"), Qual: qual}
+
+	nan := math.NaN()
+	expected := []float64{0, 0, 1, nan, nan, nan}
+	// the codon is skipped whichever read has the deletion.
+	for _, reads := range [][2]MappedRead{{deleted, other}, {other, deleted}} {
+		syn, nonsyn := compareCodons(reads[0], reads[1], 13, 0, nil, profile, taxonomy.GeneticCodes()["11"])
+		for i, e := range expected {
+			if d := syn.Profile[i]; d != e && !(math.IsNaN(d) && math.IsNaN(e)) {
+				t.Errorf("syn at %d, Expect %g, got %g\n", i, e, d)
+			}
+			if i >= 3 && !math.IsNaN(nonsyn.Profile[i]) {
+				t.Errorf("nonsyn at %d, Expect NaN, got %g\n", i, nonsyn.Profile[i])
+			}
+		}
+	}
+}
+
+func TestCompareAminoAcids(t *testing.T) {
+	// three forward codons, GCT AAA GAT (Ala Lys Asp).
+	bases := "GCTAAAGAT"
+	types := []byte{
+		profiling.FirstPos, profiling.SecondPos, profiling.FourFold,
+		profiling.FirstPos, profiling.SecondPos, profiling.ThirdPos,
+		profiling.FirstPos, profiling.SecondPos, profiling.ThirdPos,
+	}
+	profile := make([]profiling.Pos, len(bases))
+	for i := range profile {
+		profile[i] = profiling.Pos{Base: bases[i], Type: types[i], Gene: "g1"}
+	}
+	gc := taxonomy.GeneticCodes()["11"]
+
+	// a missense change AAA (Lys) -> AGA (Arg),
+	// and a synonymous change GCT -> GCC (Ala).
+	qual := bytes.Repeat([]byte{30}, len(bases))
+	a := MappedRead{Pos: 0, Seq: []byte("GCTAAAGAT"), Qual: qual}
+	b := MappedRead{Pos: 0, Seq: []byte("GCCAGAGAT"), Qual: qual}
+
+	nan := math.NaN()
+	expected := []float64{0, nan, nan, 1, nan, nan, 0, nan, nan}
+	aa := compareAminoAcids(a, b, 13, 0, profile, gc)
+	for i, e := range expected {
+		if d := aa.Profile[i]; d != e && !(math.IsNaN(d) && math.IsNaN(e)) {
+			t.Errorf("at %d, Expect %g, got %g\n", i, e, d)
+		}
+	}
+
+	// a low quality base makes its codon NaN.
+	low := MappedRead{Pos: 0, Seq: b.Seq, Qual: append([]byte{}, qual...)}
+	low.Qual[7] = 0
+	if d := compareAminoAcids(a, low, 13, 0, profile, gc).Profile[6]; !math.IsNaN(d) {
+		t.Errorf("low quality codon, Expect NaN, got %g\n", d)
+	}
+
+	// lags are in codons: the three codons give lags 0, 1 and 2.
+	subProfileChan := make(chan SubProfile, 1)
+	subProfileChan <- aa
+	close(subProfileChan)
+	profileOf := func(ref string) []profiling.Pos { return profile }
+	maxl := 4
+	covsChan := calc(context.Background(), subProfileChan, profileOf, profiling.FirstPos, maxl, nil, 1, false, true)
+	expectedN := []int{3, 2, 1, 0}
+	for covsMap := range covsChan {
+		covs := covsMap[resultKey{class: All}]
+		for l, n := range expectedN {
+			if covs[l].GetN() != n {
+				t.Errorf("lag %d, Expect %d pairs, got %d\n", l, n, covs[l].GetN())
+			}
+		}
+	}
+}
+
+func TestCheckReadLen(t *testing.T) {
+	ref, err := sam.NewReference("NC_000001", "", "", 100, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	newRecord := func(seq string, cigar ...sam.CigarOp) *sam.Record {
+		r, err := sam.NewRecord("read", ref, nil, 0, -1, 0, 40, cigar, []byte(seq), bytes.Repeat([]byte{30}, len(seq)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	m10 := newRecord("ACGTACGTAC", sam.NewCigarOp(sam.CigarMatch, 10))
+	clipped := newRecord("ACGTACGTAC", sam.NewCigarOp(sam.CigarSoftClipped, 2), sam.NewCigarOp(sam.CigarMatch, 8))
+	deleted := newRecord("ACGTACGT", sam.NewCigarOp(sam.CigarMatch, 4), sam.NewCigarOp(sam.CigarDeletion, 2), sam.NewCigarOp(sam.CigarMatch, 4))
+
+	testCases := []struct {
+		r           *sam.Record
+		minReadLen  int
+		maxSoftClip int
+		expected    bool
+	}{
+		{m10, 10, 0, true},
+		{m10, 11, 0, false},
+		{clipped, 8, 2, true},
+		{clipped, 9, 2, false},
+		{clipped, 8, 1, false},
+		{clipped, 8, 0, false},
+		{clipped, 8, -1, true}, // no limit of soft-clipped bases.
+		// deletions do not count in the mapped length.
+		{deleted, 8, -1, true},
+		{deleted, 9, -1, false},
+	}
+	for _, tc := range testCases {
+		s, _, _, softClipped := Map2Ref(tc.r)
+		opts := Options{MinReadLen: tc.minReadLen, MaxSoftClip: tc.maxSoftClip}
+		if got := checkReadLen(s, softClipped, opts); got != tc.expected {
+			t.Errorf("%v, min-readlen %d, max-softclip %d, Expect %v, got %v\n", tc.r.Cigar, tc.minReadLen, tc.maxSoftClip, tc.expected, got)
+		}
+	}
+}
+
+func TestDedupeMates(t *testing.T) {
+	ref, err := sam.NewReference("NC_000001", "", "", 100, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	profile := make([]profiling.Pos, 100)
+	for i := range profile {
+		profile[i].Type = profiling.FourFold
+	}
+
+	// the mates of frag overlap each other,
+	// and the second one overlaps an unrelated read.
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 10)}
+	qual := bytes.Repeat([]byte{30}, 10)
+	var records []*sam.Record
+	for _, r := range []struct {
+		name string
+		pos  int
+	}{{"frag", 0}, {"frag", 4}, {"other", 12}} {
+		rec, err := sam.NewRecord(r.name, ref, nil, r.pos, -1, 0, 40, cigar, []byte("ACGTACGTAC"), qual, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+
+	testCases := []struct {
+		dedupe   bool
+		expected string
+	}{
+		{false, "P\tNC_000001\tfrag\t0\tfrag\t4\nP\tNC_000001\tfrag\t4\tother\t12\n"},
+		{true, "P\tNC_000001\tfrag\t4\tother\t12\n"},
+	}
+	for _, tc := range testCases {
+		var buf bytes.Buffer
+		opts := Options{MinBQ: 13, MapQ255: "exclude", Samples: 1, DedupeMates: tc.dedupe, Overlaps: &buf}
+		CalcP2(records, profile, ConvertPosType(4), 10, opts)
+		var pairs string
+		for _, line := range strings.SplitAfter(buf.String(), "\n") {
+			if strings.HasPrefix(line, "P\t") {
+				pairs += line
+			}
+		}
+		if pairs != tc.expected {
+			t.Errorf("dedupe-mates %v, Expect pairs %q, got %q\n", tc.dedupe, tc.expected, pairs)
+		}
+	}
+}
+
+func TestMinOverlap(t *testing.T) {
+	ref, err := sam.NewReference("NC_000001", "", "", 100, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	profile := make([]profiling.Pos, 100)
+	for i := range profile {
+		profile[i].Type = profiling.FourFold
+	}
+
+	// a, b and c of 10 bases, and d of 4 bases, inside b,
+	// overlapping by: a-b 7, a-d 4, a-c 2, b-d 4, b-c 5 and d-c 0 (adjacent).
+	var records []*sam.Record
+	for _, r := range []struct {
+		name string
+		pos  int
+		seq  string
+	}{{"a", 0, "ACGTACGTAC"}, {"b", 3, "TACGTACGTA"}, {"d", 4, "ACGT"}, {"c", 8, "ACGTACGTAC"}} {
+		cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, len(r.seq))}
+		qual := bytes.Repeat([]byte{30}, len(r.seq))
+		rec, err := sam.NewRecord(r.name, ref, nil, r.pos, -1, 0, 40, cigar, []byte(r.seq), qual, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+
+	testCases := []struct {
+		minOverlap int
+		expected   []string
+	}{
+		{0, []string{"a-b", "a-c", "a-d", "b-c", "b-d", "d-c"}},
+		{1, []string{"a-b", "a-c", "a-d", "b-c", "b-d"}},
+		{4, []string{"a-b", "a-d", "b-c", "b-d"}},
+		{5, []string{"a-b", "b-c"}},
+		{8, nil},
+	}
+	for _, tc := range testCases {
+		var buf bytes.Buffer
+		opts := Options{MinBQ: 13, MapQ255: "exclude", Samples: 1, MinOverlap: tc.minOverlap, Overlaps: &buf}
+		CalcP2(records, profile, ConvertPosType(4), 10, opts)
+		var pairs []string
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if fields := strings.Split(line, "\t"); fields[0] == "P" {
+				pairs = append(pairs, fields[2]+"-"+fields[4])
+			}
+		}
+		sort.Strings(pairs)
+		if strings.Join(pairs, ",") != strings.Join(tc.expected, ",") {
+			t.Errorf("min-overlap %d, Expect pairs %v, got %v\n", tc.minOverlap, tc.expected, pairs)
+		}
+	}
+}
+
+// TestMap2RefCigar checks that the mapped sequence of each CIGAR
+// has a base or gap at each position of the reference it spans.
+func TestMap2RefCigar(t *testing.T) {
+	ref, err := sam.NewReference("NC_000001", "", "", 100, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	op := sam.NewCigarOp
+	testCases := []struct {
+		seq         string
+		cigar       []sam.CigarOp
+		expected    string
+		softClipped int
+	}{
+		{"ACGTACGT", []sam.CigarOp{op(sam.CigarHardClipped, 2), op(sam.CigarMatch, 8), op(sam.CigarHardClipped, 3)}, "ACGTACGT", 0},
+		{"ACGTTCGT", []sam.CigarOp{op(sam.CigarMatch, 3), op(sam.CigarPadded, 2), op(sam.CigarInsertion, 2), op(sam.CigarMatch, 3)}, "ACGCGT", 0},
+		{"ACGTACGTAC", []sam.CigarOp{op(sam.CigarMatch, 2), op(sam.CigarInsertion, 1), op(sam.CigarMatch, 2), op(sam.CigarDeletion, 2),
+			op(sam.CigarEqual, 3), op(sam.CigarSkipped, 3), op(sam.CigarMismatch, 2)}, "ACTA**CGT***AC", 0},
+		{"ACGTAC", []sam.CigarOp{op(sam.CigarHardClipped, 1), op(sam.CigarSoftClipped, 2), op(sam.CigarMatch, 4), op(sam.CigarHardClipped, 1)}, "GTAC", 2},
+		{"ACGTAC", []sam.CigarOp{op(sam.CigarMatch, 2), op(sam.CigarPadded, 1), op(sam.CigarDeletion, 1), op(sam.CigarPadded, 1), op(sam.CigarMatch, 4)}, "AC*GTAC", 0},
+		// not mapped linearly, and not matching the sequence.
+		{"ACGTAC", []sam.CigarOp{op(sam.CigarMatch, 3), op(sam.CigarBack, 2), op(sam.CigarMatch, 3)}, "", 0},
+		{"ACGTAC", []sam.CigarOp{op(sam.CigarMatch, 3), op(sam.CigarHardClipped, 3)}, "", 0},
+	}
+	for _, tc := range testCases {
+		qual := make([]byte, len(tc.seq))
+		for i := range qual {
+			qual[i] = byte(10 + i)
+		}
+		r, err := sam.NewRecord("read", ref, nil, 10, -1, 0, 40, nil, []byte(tc.seq), qual, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Cigar = tc.cigar
+		s, q, _, softClipped := Map2Ref(r)
+		if string(s) != tc.expected || softClipped != tc.softClipped {
+			t.Errorf("%v, Expect %s (%d soft-clipped), got %s (%d)\n", r.Cigar, tc.expected, tc.softClipped, s, softClipped)
+			continue
+		}
+		if s == nil {
+			continue
+		}
+		if len(s) != r.Len() || len(q) != len(s) {
+			t.Errorf("%v, Expect %d positions of the reference, got %d bases and %d qualities\n", r.Cigar, r.Len(), len(s), len(q))
+		}
+		// the quality of each base is that of its read base.
+		for i := range s {
+			if s[i] == '*' {
+				if q[i] != 0 {
+					t.Errorf("%v, Expect no quality at the gap %d, got %d\n", r.Cigar, i, q[i])
+				}
+				continue
+			}
+			if p := int(q[i]) - 10; tc.seq[p] != s[i] {
+				t.Errorf("%v, Expect the base %c of the read at %d, got %c\n", r.Cigar, tc.seq[p], i, s[i])
+			}
+		}
+	}
+}
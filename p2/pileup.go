@@ -0,0 +1,87 @@
+package p2
+
+import (
+	"context"
+	"sort"
+
+	"github.com/biogo/hts/sam"
+)
+
+// pileup receives reads from readChan until it is closed or ctx is done,
+// and calls add for each base used by the calculation at a position of a reference:
+// reads are filtered as in slideReads (SAM flags, MapQ, read group, base qualities, length and soft clipping,
+// with mismatch clusters masked), and bases are ATGC with a quality above opts.MinBQ.
+func pileup(ctx context.Context, readChan chan *sam.Record, opts Options, add func(ref string, pos int, base byte)) {
+	for {
+		var r *sam.Record
+		select {
+		case <-ctx.Done():
+			return
+		case rec, ok := <-readChan:
+			if !ok {
+				return
+			}
+			r = rec
+		}
+
+		if excludedByFlags(r, opts) != "" || !checkMapQ(int(r.MapQ), opts) || !checkReadGroup(r, opts) {
+			continue
+		}
+		r, ok := checkQual(r, opts)
+		if !ok {
+			continue
+		}
+		s, q, mismatches, softClipped := Map2Ref(r)
+		if !checkReadLen(s, softClipped, opts) {
+			continue
+		}
+		if opts.MDWindow > 0 {
+			maskMismatchClusters(s, q, mismatches, opts.MDWindow)
+		}
+		ref := r.Ref.Name()
+		for i := range s {
+			if r.Pos+i >= 0 && IsATGC(s[i]) && Phred(q[i], opts.QualOffset) > opts.MinBQ {
+				add(ref, r.Pos+i, s[i])
+			}
+		}
+	}
+}
+
+// DepthBin is a bin of a depth histogram.
+type DepthBin struct {
+	Depth     int // number of bases at a position.
+	Positions int // number of positions with the depth.
+}
+
+// DepthHistogram piles up reads from readChan on the references,
+// and returns the number of positions at each depth, sorted by depth,
+// including uncovered positions at depth 0.
+// The depth counts the bases used by the calculation with opts
+// (see Calc), and positions in opts.Exclude are not counted.
+// If ctx is cancelled, the results are incomplete.
+func DepthHistogram(ctx context.Context, readChan chan *sam.Record, refs []*sam.Reference, opts Options) []DepthBin {
+	depths := make(map[string][]int)
+	for _, ref := range refs {
+		depths[ref.Name()] = make([]int, ref.Len())
+	}
+	pileup(ctx, readChan, opts, func(ref string, pos int, base byte) {
+		if depth := depths[ref]; pos < len(depth) {
+			depth[pos]++
+		}
+	})
+
+	counts := make(map[int]int)
+	for ref, depth := range depths {
+		for pos, n := range depth {
+			if !opts.Exclude.Contains(ref, pos) {
+				counts[n]++
+			}
+		}
+	}
+	var bins []DepthBin
+	for depth, n := range counts {
+		bins = append(bins, DepthBin{Depth: depth, Positions: n})
+	}
+	sort.Slice(bins, func(i, j int) bool { return bins[i].Depth < bins[j].Depth })
+	return bins
+}
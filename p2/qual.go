@@ -0,0 +1,37 @@
+package p2
+
+import (
+	"bytes"
+
+	"github.com/biogo/hts/sam"
+)
+
+// missingQual returns true if a record has no base qualities:
+// QUAL is "*" in SAM (0xFF in BAM), or does not match the sequence length.
+func missingQual(r *sam.Record) bool {
+	if len(r.Qual) != r.Seq.Length {
+		return true
+	}
+	for _, q := range r.Qual {
+		if q != 0xff {
+			return false
+		}
+	}
+	return r.Seq.Length > 0
+}
+
+// checkQual returns the record to use, and false if it is to be skipped,
+// for records without base qualities: with opts.DefaultQual > 0,
+// a copy of the record with that quality at every base is returned,
+// otherwise the record is skipped. Other records are returned as they are.
+func checkQual(r *sam.Record, opts Options) (*sam.Record, bool) {
+	if !missingQual(r) {
+		return r, true
+	}
+	if opts.DefaultQual <= 0 {
+		return r, false
+	}
+	c := *r
+	c.Qual = bytes.Repeat([]byte{byte(opts.DefaultQual + opts.QualOffset)}, r.Seq.Length)
+	return &c, true
+}
@@ -0,0 +1,85 @@
+package p2
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+func TestMissingQual(t *testing.T) {
+	ref, err := sam.NewReference("NC_000001", "", "", 100, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	profile := make([]profiling.Pos, 100)
+	for i := range profile {
+		profile[i].Type = profiling.FourFold
+	}
+
+	// overlapping reads with a substitution, without base qualities:
+	// 0xFF as read from BAM, and none.
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 10)}
+	var records []*sam.Record
+	for i, qual := range [][]byte{bytes.Repeat([]byte{0xff}, 10), nil, bytes.Repeat([]byte{30}, 10)} {
+		r, err := sam.NewRecord("read", ref, nil, i*2, -1, 0, 40, cigar, []byte("ACGTACGTAC"), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Qual = qual
+		records = append(records, r)
+	}
+	records[1].Seq = sam.NewSeq([]byte("GTTCGTACGT"))
+
+	testCases := []struct {
+		name        string
+		defaultQual int
+		qualOffset  int
+		used        int // reads used.
+	}{
+		{"skip", 0, 0, 1},
+		{"default", 30, 0, 3},
+		{"default with offset", 30, 33, 3},
+		{"default below min-bq", 10, 0, 3},
+	}
+	for _, tc := range testCases {
+		opts := Options{MinBQ: 13, MapQ255: "exclude", DefaultQual: tc.defaultQual, QualOffset: tc.qualOffset}
+		used := 0
+		for i, r := range records {
+			if missing := i < 2; missing != missingQual(r) {
+				t.Errorf("%s, read %d, Expect missing qualities %v\n", tc.name, i, missing)
+			}
+			c, ok := checkQual(r, opts)
+			if !ok {
+				continue
+			}
+			used++
+			if len(c.Qual) != c.Seq.Length {
+				t.Errorf("%s, read %d, Expect %d qualities, got %d\n", tc.name, i, c.Seq.Length, len(c.Qual))
+			}
+			if i < 2 && Phred(c.Qual[0], tc.qualOffset) != tc.defaultQual {
+				t.Errorf("%s, read %d, Expect quality %d, got %d\n", tc.name, i, tc.defaultQual, Phred(c.Qual[0], tc.qualOffset))
+			}
+		}
+		if used != tc.used {
+			t.Errorf("%s, Expect %d reads used, got %d\n", tc.name, tc.used, used)
+		}
+
+		// the qualities of the records are not changed.
+		if records[0].Qual[0] != 0xff || records[1].Qual != nil {
+			t.Fatalf("%s, Expect the records unchanged\n", tc.name)
+		}
+
+		// the substitution is seen only with qualities above MinBQ.
+		if tc.qualOffset == 0 {
+			ks := CalcP2(records, profile, ConvertPosType(4), 1, opts)[All][0].Mean.GetResult()
+			if e := tc.defaultQual > opts.MinBQ; (ks > 0) != e {
+				t.Errorf("%s, Expect substitutions %v, got P2 %g at lag 0\n", tc.name, e, ks)
+			}
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package p2
+
+import "github.com/biogo/hts/sam"
+
+var rgTag = []byte("RG")
+
+// checkReadGroup returns true if opts.ReadGroups is empty,
+// or if the read group of the record (its RG tag) is in it.
+// Records without an RG tag are excluded when ReadGroups is set.
+func checkReadGroup(r *sam.Record, opts Options) bool {
+	if len(opts.ReadGroups) == 0 {
+		return true
+	}
+	aux, found := r.Tag(rgTag)
+	if !found {
+		return false
+	}
+	id, ok := aux.Value().(string)
+	return ok && opts.ReadGroups[id]
+}
+
+// SampleReadGroups returns the IDs of the read groups of a sample (SM)
+// in the header.
+func SampleReadGroups(h *sam.Header, sample string) []string {
+	var ids []string
+	for _, rg := range h.RGs() {
+		if rg.Get(sam.NewTag("SM")) == sample {
+			ids = append(ids, rg.Name())
+		}
+	}
+	return ids
+}
@@ -0,0 +1,84 @@
+package p2
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+func TestReadGroups(t *testing.T) {
+	ref, err := sam.NewReference("NC_000001", "", "", 100, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := sam.NewHeader(nil, []*sam.Reference{ref})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rg := range []struct{ id, sample string }{{"rg1", "s1"}, {"rg2", "s2"}, {"rg3", "s1"}} {
+		g, err := sam.NewReadGroup(rg.id, "", "", "", "", "", "", rg.sample, "", "", time.Time{}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.AddReadGroup(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ids := SampleReadGroups(h, "s1"); len(ids) != 2 || ids[0] != "rg1" || ids[1] != "rg3" {
+		t.Errorf("Expect read groups [rg1 rg3] of s1, got %v\n", ids)
+	}
+
+	profile := make([]profiling.Pos, 100)
+	for i := range profile {
+		profile[i].Type = profiling.FourFold
+	}
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 10)}
+	qual := bytes.Repeat([]byte{30}, 10)
+	var records []*sam.Record
+	for i, rg := range []string{"rg1", "rg2", "rg1", ""} {
+		var aux []sam.Aux
+		if rg != "" {
+			a, err := sam.NewAux(sam.NewTag("RG"), rg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			aux = append(aux, a)
+		}
+		name := rg
+		if name == "" {
+			name = "none"
+		}
+		rec, err := sam.NewRecord(name, ref, nil, 2*i, -1, 0, 40, cigar, []byte("ACGTACGTAC"), qual, aux)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+
+	testCases := []struct {
+		readGroups map[string]bool
+		expected   []string // names of the used reads.
+	}{
+		{nil, []string{"rg1", "rg2", "rg1", "none"}},
+		{map[string]bool{"rg1": true}, []string{"rg1", "rg1"}},
+		{map[string]bool{"rg2": true, "rg3": true}, []string{"rg2"}},
+	}
+	for _, tc := range testCases {
+		var buf bytes.Buffer
+		opts := Options{MinBQ: 13, MapQ255: "exclude", Samples: 1, ReadGroups: tc.readGroups, Overlaps: &buf}
+		CalcP2(records, profile, ConvertPosType(4), 10, opts)
+		var used []string
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if strings.HasPrefix(line, "R\t") {
+				used = append(used, strings.Split(line, "\t")[2])
+			}
+		}
+		if strings.Join(used, ",") != strings.Join(tc.expected, ",") {
+			t.Errorf("%v, Expect reads %v, got %v\n", tc.readGroups, tc.expected, used)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package p2
+
+import "github.com/biogo/hts/sam"
+
+// Strands of the reads, from the reverse flag of their records.
+const (
+	Forward = "forward"
+	Reverse = "reverse"
+)
+
+// readStrand returns the strand a record is mapped on.
+func readStrand(r *sam.Record) string {
+	if r.Flags&sam.Reverse != 0 {
+		return Reverse
+	}
+	return Forward
+}
+
+// StrandClass returns the class of the substitutions between reads
+// of a strand, with Options.Stranded, e.g. all_forward.
+func StrandClass(class, strand string) string {
+	return class + "_" + strand
+}
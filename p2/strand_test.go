@@ -0,0 +1,103 @@
+package p2
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+// TestStranded checks that with Stranded, the profile of each strand
+// is that of its reads alone, without pairs across strands.
+func TestStranded(t *testing.T) {
+	ref, err := sam.NewReference("NC_000001", "", "", 1000, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	profile := make([]profiling.Pos, 1000)
+	for i := range profile {
+		profile[i].Type = profiling.FourFold
+	}
+
+	// forward reads of a single sequence, and reverse reads of two variants,
+	// differing from it at 5% of positions.
+	rng := rand.New(rand.NewSource(1))
+	genomes := make([][]byte, 3)
+	genomes[0] = make([]byte, 1000)
+	for i := range genomes[0] {
+		genomes[0][i] = "ACGT"[rng.Intn(4)]
+	}
+	for k := 1; k < 3; k++ {
+		genomes[k] = append([]byte{}, genomes[0]...)
+		for i := range genomes[k] {
+			if rng.Float64() < 0.05 {
+				genomes[k][i] = "ACGT"[(rng.Intn(3)+1+int(genomes[k][i]))%4]
+			}
+		}
+	}
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 100)}
+	var records, forward, reverse []*sam.Record
+	for pos := 0; pos+100 <= 1000; pos += 10 {
+		for k := 0; k < 4; k++ {
+			g := genomes[0]
+			if k > 0 {
+				g = genomes[1+k%2]
+			}
+			qual := make([]byte, 100)
+			for i := range qual {
+				qual[i] = 40
+			}
+			r, err := sam.NewRecord("read", ref, nil, pos, -1, 0, 40, cigar, g[pos:pos+100], qual, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Name = fmt.Sprintf("read%d_%d", pos, k)
+			records = append(records, r)
+			if k == 0 {
+				forward = append(forward, r)
+			} else {
+				r.Flags |= sam.Reverse
+				reverse = append(reverse, r)
+			}
+		}
+	}
+
+	maxl := 20
+	opts := Options{MinBQ: 13, MapQ255: "exclude"}
+	posType := ConvertPosType(4)
+	alone := map[string][]*sam.Record{Forward: forward, Reverse: reverse}
+	stranded := opts
+	stranded.Stranded = true
+	results := CalcP2(records, profile, posType, maxl, stranded)
+	if len(results) != 2 {
+		t.Fatalf("Expect the classes of two strands, got %d\n", len(results))
+	}
+	for _, strand := range []string{Forward, Reverse} {
+		got := results[StrandClass(All, strand)]
+		expected := CalcP2(alone[strand], profile, posType, maxl, opts)[All]
+		for l := 0; l < maxl; l++ {
+			g, e := got[l].Mean.GetResult(), expected[l].Mean.GetResult()
+			if got[l].Mean.GetN() != expected[l].Mean.GetN() || math.Abs(g-e) > 1e-12 {
+				t.Errorf("%s, lag %d, Expect %g (n %d), got %g (n %d)\n", strand, l, e, expected[l].Mean.GetN(), g, got[l].Mean.GetN())
+			}
+		}
+	}
+	if ks := results[StrandClass(All, Forward)][0].Mean.GetResult(); ks != 0 {
+		t.Errorf("Expect no substitutions between forward reads, got %g\n", ks)
+	}
+	if ks := results[StrandClass(All, Reverse)][0].Mean.GetResult(); !(ks > 0) {
+		t.Errorf("Expect substitutions between reverse reads, got %g\n", ks)
+	}
+
+	// without Stranded, forward reads are compared with reverse reads.
+	pooled := CalcP2(records, profile, posType, maxl, opts)[All][0].Mean.GetResult()
+	if reverseKs := results[StrandClass(All, Reverse)][0].Mean.GetResult(); pooled == reverseKs || !(pooled > 0) {
+		t.Errorf("Expect pooled substitutions other than those of the reverse strand (%g), got %g\n", reverseKs, pooled)
+	}
+}
@@ -0,0 +1,77 @@
+package p2
+
+import (
+	"context"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/gomath/stat/desc/meanvar"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+// CalcByRefStream is like CalcByRef, but rather than returning the results of all references,
+// it calls write with the results of each reference (keyed by the substitution class)
+// as soon as its reads are done, and then drops them,
+// so that only the results of one reference are held at a time.
+// The records must be sorted by reference: the reads of a reference end
+// at the first read of another one.
+// The write calls are made one at a time, in the order of the references in readChan,
+// and only for references with read pairs.
+// opts.MaxPairs limits the read pairs of each reference.
+// If ctx is cancelled, it stops receiving, and the reference being calculated is not written.
+func CalcByRefStream(ctx context.Context, readChan chan *sam.Record, profiles map[string][]profiling.Pos, posType byte, maxl int, opts Options, write func(ref string, results map[string][]*meanvar.MeanVar)) {
+	profileOf := func(ref string) []profiling.Pos { return profiles[ref] }
+
+	var ref string
+	var refChan chan *sam.Record                          // reads of the current reference.
+	var resultsChan chan map[resultKey][]*meanvar.MeanVar // results of the current reference.
+	var finished chan bool                                // closed once the current reference is calculated.
+	finish := func() {
+		if refChan == nil {
+			return
+		}
+		close(refChan)
+		meanVarsMap := <-resultsChan
+		refChan = nil
+		if ctx.Err() != nil || len(meanVarsMap) == 0 {
+			return
+		}
+		results := make(map[string][]*meanvar.MeanVar)
+		for key, meanVars := range meanVarsMap {
+			results[key.class] = meanVars
+		}
+		write(ref, results)
+	}
+	defer finish()
+
+	for {
+		var r *sam.Record
+		select {
+		case <-ctx.Done():
+			return
+		case rec, ok := <-readChan:
+			if !ok {
+				return
+			}
+			r = rec
+		}
+
+		if refChan == nil || r.Ref.Name() != ref {
+			finish()
+			ref = r.Ref.Name()
+			refChan = make(chan *sam.Record)
+			resultsChan = make(chan map[resultKey][]*meanvar.MeanVar, 1)
+			finished = make(chan bool)
+			go func(c chan *sam.Record, results chan map[resultKey][]*meanvar.MeanVar, finished chan bool) {
+				defer close(finished)
+				results <- calcResults(ctx, c, profileOf, posType, maxl, opts, true)
+			}(refChan, resultsChan, finished)
+		}
+		select {
+		case refChan <- r:
+		case <-finished:
+			// e.g. at opts.MaxPairs, the other reads of the reference are dropped.
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package p2
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/mingzhi/gomath/stat/desc/meanvar"
+	"github.com/mingzhi/ncbiftp/genomes/profiling"
+)
+
+func TestCalcByRefStream(t *testing.T) {
+	// three references with random substitutions in overlapping reads,
+	// and one without reads.
+	rng := rand.New(rand.NewSource(1))
+	const refLen = 200
+	profiles := make(map[string][]profiling.Pos)
+	var records []*sam.Record
+	refs := testRefs(t, 4, refLen)
+	for k, ref := range refs {
+		profile := make([]profiling.Pos, refLen)
+		for i := range profile {
+			profile[i].Type = profiling.FourFold
+		}
+		profiles[ref.Name()] = profile
+		if k == 3 {
+			continue
+		}
+
+		cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 20)}
+		qual := bytes.Repeat([]byte{30}, 20)
+		for pos := 0; pos+20 <= refLen; pos += 5 {
+			s := []byte("ACGTACGTACGTACGTACGT")
+			for i := range s {
+				if rng.Float64() < 0.1 {
+					s[i] = "ACGT"[rng.Intn(4)]
+				}
+			}
+			r, err := sam.NewRecord(fmt.Sprintf("read%d", pos), ref, nil, pos, -1, 0, 40, cigar, s, qual, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, r)
+		}
+	}
+	readChan := func() chan *sam.Record {
+		c := make(chan *sam.Record)
+		go func() {
+			defer close(c)
+			for _, r := range records {
+				c <- r
+			}
+		}()
+		return c
+	}
+
+	opts := Options{MinBQ: 13, MapQ255: "exclude", Samples: 1}
+	posType := ConvertPosType(4)
+	batched := CalcByRef(context.Background(), readChan(), profiles, posType, 10, opts)
+	var written []string
+	streamed := make(map[string]map[string][]*meanvar.MeanVar)
+	CalcByRefStream(context.Background(), readChan(), profiles, posType, 10, opts, func(ref string, results map[string][]*meanvar.MeanVar) {
+		if _, found := streamed[ref]; found {
+			t.Errorf("Expect %s written once\n", ref)
+		}
+		written = append(written, ref)
+		streamed[ref] = results
+	})
+
+	// the references are written in the order of the reads.
+	if fmt.Sprint(written) != "[NC_000000 NC_000001 NC_000002]" {
+		t.Errorf("Expect the references with reads in order, got %v\n", written)
+	}
+	if len(batched) != len(streamed) {
+		t.Errorf("Expect %d references, got %d\n", len(batched), len(streamed))
+	}
+	for ref, results := range batched {
+		for _, class := range []string{All} {
+			a, b := results[class], streamed[ref][class]
+			if len(a) != len(b) {
+				t.Errorf("%s %s, Expect %d lags, got %d\n", ref, class, len(a), len(b))
+				continue
+			}
+			for l := range a {
+				// the read pairs are compared in parallel,
+				// so the sums may differ in the last bits.
+				m1, m2 := a[l].Mean.GetResult(), b[l].Mean.GetResult()
+				if a[l].Mean.GetN() != b[l].Mean.GetN() || math.Abs(m1-m2) > 1e-12 {
+					t.Errorf("%s %s at lag %d, Expect %g (n %d), got %g (n %d)\n", ref, class, l, m1, a[l].Mean.GetN(), m2, b[l].Mean.GetN())
+				}
+			}
+		}
+	}
+}
+
+func TestCalcByRefStreamMaxPairs(t *testing.T) {
+	// the reads of a reference left after MaxPairs do not block the next references.
+	var records []*sam.Record
+	profiles := make(map[string][]profiling.Pos)
+	for _, ref := range testRefs(t, 2, 100) {
+		profile := make([]profiling.Pos, 100)
+		for i := range profile {
+			profile[i].Type = profiling.FourFold
+		}
+		profiles[ref.Name()] = profile
+		cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 10)}
+		qual := bytes.Repeat([]byte{30}, 10)
+		for pos := 0; pos+10 <= 100; pos++ {
+			r, err := sam.NewRecord("read", ref, nil, pos, -1, 0, 40, cigar, []byte("ACGTACGTAC"), qual, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, r)
+		}
+	}
+	readChan := make(chan *sam.Record)
+	go func() {
+		defer close(readChan)
+		for _, r := range records {
+			readChan <- r
+		}
+	}()
+
+	opts := Options{MinBQ: 13, MapQ255: "exclude", Samples: 1, MaxPairs: 5}
+	var refs []string
+	CalcByRefStream(context.Background(), readChan, profiles, ConvertPosType(4), 3, opts, func(ref string, results map[string][]*meanvar.MeanVar) {
+		refs = append(refs, ref)
+	})
+	if len(refs) != 2 {
+		t.Errorf("Expect 2 references, got %v\n", refs)
+	}
+}
+
+// testRefs returns n references of length refLen, in a header.
+func testRefs(t *testing.T, n, refLen int) []*sam.Reference {
+	var refs []*sam.Reference
+	for k := 0; k < n; k++ {
+		ref, err := sam.NewReference(fmt.Sprintf("NC_00000%d", k), "", "", refLen, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, ref)
+	}
+	if _, err := sam.NewHeader(nil, refs); err != nil {
+		t.Fatal(err)
+	}
+	return refs
+}
@@ -0,0 +1,131 @@
+package p2
+
+import "math/rand"
+
+// readWindow keeps the reads that may overlap the coming reads,
+// which come sorted by reference and position.
+type readWindow struct {
+	reads  []MappedRead
+	paired bool // merge mates.
+	span   int  // min span from the anchor of the kept reads, see Options.Window.
+
+	// maxPileup, if > 0, caps the reads held in the window:
+	// once it is full, the coming reads are reservoir sampled
+	// (by rng, seeded for reproducible runs) into its maxPileup slots,
+	// and the reads not sampled, or replaced, are dropped.
+	maxPileup  int
+	rng        *rand.Rand
+	offered    int // reads offered to the window since it is full.
+	subsampled int // number of windows returned while full and dropping reads.
+	dropped    int // number of dropped reads.
+}
+
+// newReadWindow returns a readWindow with the merging of mates and the pileup cap of opts.
+func newReadWindow(opts Options) *readWindow {
+	w := &readWindow{paired: opts.Paired, span: opts.Window, maxPileup: opts.MaxPileup}
+	if w.maxPileup > 0 {
+		w.rng = rand.New(rand.NewSource(1))
+	}
+	return w
+}
+
+// Add adds a read, and returns the windows whose anchor (the first read)
+// does not overlap the read, and so no later reads;
+// with a span longer than the anchor, the windows whose anchor starts
+// more than span bases before the read.
+// Each window contains the anchor and the following reads.
+// If paired, the read is merged into its overlapping mate instead of being added.
+// With a pileup cap, the window never holds more than maxPileup reads.
+func (w *readWindow) Add(r MappedRead) (windows [][]MappedRead) {
+	for len(w.reads) > 0 {
+		a := w.reads[0]
+		end := a.Pos + a.Len()
+		if a.Pos+w.span > end {
+			end = a.Pos + w.span
+		}
+		if a.Ref == r.Ref && end >= r.Pos {
+			break
+		}
+		windows = append(windows, w.shift())
+	}
+
+	if w.paired && mergeMate(w.reads, r) {
+		return
+	}
+	if w.maxPileup > 0 && len(w.reads) >= w.maxPileup {
+		// r is the offered-th read beyond the cap, and is sampled
+		// with a probability maxPileup/(maxPileup+offered).
+		w.offered++
+		w.dropped++
+		j := w.rng.Intn(w.maxPileup + w.offered)
+		if j < w.maxPileup {
+			// r replaces the read j; as r comes last, the reads stay sorted.
+			copy(w.reads[j:], w.reads[j+1:])
+			w.reads[len(w.reads)-1] = r
+		}
+		return
+	}
+	w.reads = append(w.reads, r)
+
+	return
+}
+
+// Flush returns the windows of all the remaining reads.
+func (w *readWindow) Flush() (windows [][]MappedRead) {
+	for len(w.reads) > 0 {
+		windows = append(windows, w.shift())
+	}
+	return
+}
+
+// shift returns a copy of the window of the anchor read, and removes the anchor.
+// A copy is returned, for mates may be merged into the reads later.
+func (w *readWindow) shift() []MappedRead {
+	if w.offered > 0 {
+		w.subsampled++
+	}
+	window := append([]MappedRead{}, w.reads...)
+	w.reads = w.reads[1:]
+	if len(w.reads) < w.maxPileup {
+		// the window is no longer full: a new sample starts when it is again.
+		w.offered = 0
+	}
+	return window
+}
+
+// mergeMate merges a read into its mate in the window,
+// if they overlap, and returns true if merged.
+func mergeMate(window []MappedRead, r MappedRead) bool {
+	for i, m := range window {
+		if m.Name == r.Name && m.Pos <= r.Pos && r.Pos <= m.Pos+m.Len() {
+			window[i] = mergeMappedReads(m, r)
+			return true
+		}
+	}
+	return false
+}
+
+// mergeMappedReads merges two overlapping reads, a.Pos <= b.Pos,
+// preferring the base with the higher quality in the overlap.
+func mergeMappedReads(a, b MappedRead) MappedRead {
+	end := a.Pos + a.Len()
+	if b.Pos+b.Len() > end {
+		end = b.Pos + b.Len()
+	}
+
+	m := MappedRead{Name: a.Name, Ref: a.Ref, Pos: a.Pos, Strand: a.Strand, InsertBin: a.InsertBin}
+	m.Seq = make([]byte, end-a.Pos)
+	m.Qual = make([]byte, end-a.Pos)
+	copy(m.Seq, a.Seq)
+	copy(m.Qual, a.Qual)
+	lag := b.Pos - a.Pos
+	for j := 0; j < b.Len(); j++ {
+		i := j + lag
+		if i >= a.Len() || b.Qual[j] > m.Qual[i] {
+			m.Seq[i] = b.Seq[j]
+			m.Qual[i] = b.Qual[j]
+		}
+	}
+
+	return m
+}
@@ -0,0 +1,180 @@
+package p2
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestMergeMate(t *testing.T) {
+	a := MappedRead{Name: "pair", Pos: 10, Seq: []byte("ACGTAC"), Qual: []byte{30, 30, 30, 30, 10, 30}}
+	b := MappedRead{Name: "pair", Pos: 13, Seq: []byte("TTCGGA"), Qual: []byte{20, 30, 20, 30, 30, 30}}
+	other := MappedRead{Name: "other", Pos: 8, Seq: []byte("AAACGTACCGGA"), Qual: bytes.Repeat([]byte{40}, 12)}
+
+	window := []MappedRead{other, a}
+	if !mergeMate(window, b) {
+		t.Fatal("Expect the mates to be merged")
+	}
+	m := window[1]
+	if m.Pos != 10 || string(m.Seq) != "ACGTTCGGA" {
+		t.Errorf("Expect ACGTTCGGA at 10, got %s at %d\n", m.Seq, m.Pos)
+	}
+	if !bytes.Equal(m.Qual, []byte{30, 30, 30, 30, 30, 30, 30, 30, 30}) {
+		t.Errorf("Expect the higher qualities, got %v\n", m.Qual)
+	}
+
+	// the overlap of the mates is compared only once.
+	subs := CompareMappedReads(other, m, 13, 0, nil, nil).Profile
+	if len(subs) != m.Len() {
+		t.Errorf("Expect %d observations, got %d\n", m.Len(), len(subs))
+	}
+
+	if mergeMate(window, MappedRead{Name: "pair", Pos: 30, Seq: []byte("A"), Qual: []byte{30}}) {
+		t.Errorf("Expect mates without overlap not to be merged\n")
+	}
+}
+
+func TestReadWindow(t *testing.T) {
+	read := func(name, ref string, pos, length int) MappedRead {
+		return MappedRead{Name: name, Ref: ref, Pos: pos, Seq: bytes.Repeat([]byte{'A'}, length), Qual: bytes.Repeat([]byte{30}, length)}
+	}
+	testCases := []struct {
+		name    string
+		span    int
+		reads   []MappedRead
+		windows [][]string
+	}{
+		{
+			name:    "single read",
+			reads:   []MappedRead{read("a", "r1", 0, 10)},
+			windows: [][]string{{"a"}},
+		},
+		{
+			name:    "overlapping reads",
+			reads:   []MappedRead{read("a", "r1", 0, 10), read("b", "r1", 5, 10), read("c", "r1", 8, 10)},
+			windows: [][]string{{"a", "b", "c"}, {"b", "c"}, {"c"}},
+		},
+		{
+			name:    "several anchors shifted at once",
+			reads:   []MappedRead{read("a", "r1", 0, 10), read("b", "r1", 2, 10), read("c", "r1", 30, 10), read("d", "r1", 35, 10)},
+			windows: [][]string{{"a", "b"}, {"b"}, {"c", "d"}, {"d"}},
+		},
+		{
+			name:    "new reference",
+			reads:   []MappedRead{read("a", "r1", 0, 10), read("b", "r2", 2, 10)},
+			windows: [][]string{{"a"}, {"b"}},
+		},
+		{
+			// c and d start within 40 bases of a, but beyond its end.
+			name:    "span",
+			span:    40,
+			reads:   []MappedRead{read("a", "r1", 0, 10), read("b", "r1", 2, 10), read("c", "r1", 30, 10), read("d", "r1", 35, 10), read("e", "r1", 80, 10)},
+			windows: [][]string{{"a", "b", "c", "d"}, {"b", "c", "d"}, {"c", "d"}, {"d"}, {"e"}},
+		},
+		{
+			name:    "span shorter than the reads",
+			span:    5,
+			reads:   []MappedRead{read("a", "r1", 0, 10), read("b", "r1", 8, 10), read("c", "r1", 12, 10)},
+			windows: [][]string{{"a", "b"}, {"b", "c"}, {"c"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		w := &readWindow{span: tc.span}
+		var windows [][]MappedRead
+		for _, r := range tc.reads {
+			windows = append(windows, w.Add(r)...)
+		}
+		windows = append(windows, w.Flush()...)
+
+		var names [][]string
+		for _, window := range windows {
+			var n []string
+			for _, r := range window {
+				n = append(n, r.Name)
+			}
+			names = append(names, n)
+		}
+		if fmt.Sprint(names) != fmt.Sprint(tc.windows) {
+			t.Errorf("%s, Expect windows %v, got %v\n", tc.name, tc.windows, names)
+		}
+	}
+}
+
+func TestReadWindowMaxPileup(t *testing.T) {
+	// 1000 overlapping reads, capped to 10.
+	var reads []MappedRead
+	for i := 0; i < 1000; i++ {
+		reads = append(reads, MappedRead{Name: fmt.Sprintf("read%d", i), Ref: "r1", Pos: i / 100, Seq: bytes.Repeat([]byte{'A'}, 100), Qual: bytes.Repeat([]byte{30}, 100)})
+	}
+	run := func() (windows [][]MappedRead, w *readWindow) {
+		w = newReadWindow(Options{MaxPileup: 10})
+		for _, r := range reads {
+			windows = append(windows, w.Add(r)...)
+			if len(w.reads) > 10 {
+				t.Fatalf("Expect at most 10 reads in the window, got %d\n", len(w.reads))
+			}
+		}
+		windows = append(windows, w.Flush()...)
+		return windows, w
+	}
+
+	windows, w := run()
+	// a window for each read not dropped.
+	if w.dropped != len(reads)-10 {
+		t.Errorf("Expect %d dropped reads, got %d\n", len(reads)-10, w.dropped)
+	}
+	if len(windows) != len(reads)-w.dropped {
+		t.Fatalf("Expect %d windows, got %d\n", len(reads)-w.dropped, len(windows))
+	}
+	// the windows of the sampled reads, all overlapping, are those of a full window.
+	if w.subsampled != 1 {
+		t.Errorf("Expect 1 subsampled window, got %d\n", w.subsampled)
+	}
+	anchors := make(map[string]bool)
+	for i, window := range windows {
+		if len(window) != 10-i {
+			t.Errorf("window %d, Expect %d reads, got %d\n", i, 10-i, len(window))
+		}
+		if anchors[window[0].Name] {
+			t.Errorf("window %d, Expect a new anchor, got %s again\n", i, window[0].Name)
+		}
+		anchors[window[0].Name] = true
+		for k := 1; k < len(window); k++ {
+			if window[k].Pos < window[k-1].Pos {
+				t.Errorf("window %d, Expect reads sorted by position\n", i)
+			}
+		}
+	}
+	// reads of the whole pileup are sampled, not only the first ones.
+	late := 0
+	for name := range anchors {
+		var i int
+		fmt.Sscanf(name, "read%d", &i)
+		if i >= 500 {
+			late++
+		}
+	}
+	if late == 0 {
+		t.Errorf("Expect reads of the second half of the pileup sampled, got %v\n", anchors)
+	}
+
+	// the subsampling is reproducible.
+	again, _ := run()
+	for i := range windows {
+		for k := range windows[i] {
+			if windows[i][k].Name != again[i][k].Name {
+				t.Fatalf("window %d, Expect the same reads in both runs, got %s and %s\n", i, windows[i][k].Name, again[i][k].Name)
+			}
+		}
+	}
+
+	// a full window is sampled again once it is no longer full.
+	w = newReadWindow(Options{MaxPileup: 2})
+	for i, pos := range []int{0, 1, 2, 200, 201} {
+		w.Add(MappedRead{Name: fmt.Sprintf("read%d", i), Ref: "r1", Pos: pos, Seq: bytes.Repeat([]byte{'A'}, 100)})
+	}
+	if w.offered != 0 || len(w.reads) != 2 {
+		t.Errorf("Expect 2 reads in a new sample, got %d reads with %d offered\n", len(w.reads), w.offered)
+	}
+}
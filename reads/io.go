@@ -1,7 +1,7 @@
 package reads
 
 import (
-	"github.com/biogo/hts/bam"
+	"context"
 	"github.com/biogo/hts/sam"
 	"io"
 	"log"
@@ -11,64 +11,101 @@ import (
 // Read SAM file and return its header and records.
 // NOT explicitly sorted.
 func ReadSamFile(fileName string) (header *sam.Header, records []*sam.Record) {
-	// Open sam file.
-	f, err := os.Open(fileName)
+	header, records, err := readRecordFile(fileName, "sam")
 	if err != nil {
 		log.Panic(err)
 	}
-	defer f.Close()
+	return
+}
+
+// Read BAM file and return its header and records.
+// NOT explicitly sorted.
+// All records are held in memory, which is fine for small files;
+// use ReadBamFileStream for large ones.
+func ReadBamFile(fileName string) (header *sam.Header, records []*sam.Record, err error) {
+	return readRecordFile(fileName, "bam")
+}
+
+// ReadBamFileStream reads BAM file and returns its header,
+// and a channel of its records, in the file order, so that
+// only the records not yet received are held in memory.
+// The records channel is closed at the end of the file or at the first error;
+// the error, if any, is then sent on the error channel, which is closed after.
+// If the file can not be opened, the header is nil and no record is sent.
+// The records channel must be drained, or ctx cancelled to stop early,
+// in which case ctx.Err() is sent on the error channel;
+// otherwise the reading go routine blocks, holding the file open.
+func ReadBamFileStream(ctx context.Context, fileName string) (*sam.Header, <-chan *sam.Record, <-chan error) {
+	records := make(chan *sam.Record)
+	errc := make(chan error, 1)
 
-	// Create sam reader,
-	// and read the reference genomes.
-	reader, err := sam.NewReader(f)
+	f, err := os.Open(fileName)
 	if err != nil {
-		log.Panic(err)
+		close(records)
+		errc <- err
+		close(errc)
+		return nil, records, errc
 	}
-	header = reader.Header()
+	reader, err := NewRecordReader(f, "bam")
+	if err != nil {
+		f.Close()
+		close(records)
+		errc <- err
+		close(errc)
+		return nil, records, errc
+	}
+	header := reader.Header()
 
-	for {
-		r, err := reader.Read()
-		if err != nil {
-			if err != io.EOF {
-				log.Panic(err)
-			} else {
-				break
+	go func() {
+		defer close(errc)
+		defer f.Close()
+		defer reader.Close()
+		defer close(records)
+		for {
+			r, err := reader.Read()
+			if err != nil {
+				if err != io.EOF {
+					errc <- err
+				}
+				return
+			}
+			select {
+			case records <- r:
+			case <-ctx.Done():
+				errc <- ctx.Err()
+				return
 			}
 		}
-		records = append(records, r)
-	}
-	return
+	}()
+
+	return header, records, errc
 }
 
-// Read BAM file and return its header and records.
-// NOT explicitly sorted.
-func ReadBamFile(fileName string) (header *sam.Header, records []*sam.Record) {
-	// Open bam file.
+// readRecordFile reads all records of a file in the format (sam or bam).
+func readRecordFile(fileName, format string) (header *sam.Header, records []*sam.Record, err error) {
 	f, err := os.Open(fileName)
 	if err != nil {
-		log.Panic(err)
+		return nil, nil, err
 	}
 	defer f.Close()
 
-	// Create bam reader,
-	// and read the reference genomes.
-	rd := 0 // ignore this now.
-	reader, err := bam.NewReader(f, rd)
+	reader, err := NewRecordReader(f, format)
 	if err != nil {
-		log.Panic(err)
+		return nil, nil, err
 	}
+	defer reader.Close()
 	header = reader.Header()
 
 	for {
 		r, err := reader.Read()
 		if err != nil {
 			if err != io.EOF {
-				log.Panic(err)
+				return nil, nil, err
 			}
 			break
 		}
 		records = append(records, r)
 	}
 
-	return
+	return header, records, nil
 }
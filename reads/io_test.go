@@ -0,0 +1,236 @@
+package reads
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+)
+
+// bamData returns a BAM file with n records.
+func bamData(t *testing.T, n int) []byte {
+	ref, err := sam.NewReference("NC_000001", "", "", 10000, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := sam.NewHeader(nil, []*sam.Reference{ref})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	w, err := bam.NewWriter(&buf, h, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq := bytes.Repeat([]byte("ACGT"), 25)
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, len(seq))}
+	for i := 0; i < n; i++ {
+		r, err := sam.NewRecord("read", ref, nil, i, -1, 0, 60, cigar, seq, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestReadBamFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := bamData(t, 100)
+
+	// well-formed file.
+	fileName := filepath.Join(dir, "reads.bam")
+	if err := ioutil.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	header, records, err := ReadBamFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(header.Refs()) != 1 {
+		t.Errorf("Expect 1 reference, got %d\n", len(header.Refs()))
+	}
+	if len(records) != 100 {
+		t.Errorf("Expect 100 records, got %d\n", len(records))
+	}
+
+	// missing file.
+	if _, _, err := ReadBamFile(filepath.Join(dir, "missing.bam")); err == nil {
+		t.Errorf("Expect an error for a missing file\n")
+	}
+
+	// truncated file, cut before the end-of-file block.
+	truncated := filepath.Join(dir, "truncated.bam")
+	if err := ioutil.WriteFile(truncated, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := ReadBamFile(truncated); err == nil {
+		t.Errorf("Expect an error for a truncated file\n")
+	}
+}
+
+func TestReadBamFileStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := bamData(t, 100)
+
+	// the streamed records are the batch-loaded records.
+	fileName := filepath.Join(dir, "reads.bam")
+	if err := ioutil.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, expected, err := ReadBamFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, records, errc := ReadBamFileStream(context.Background(), fileName)
+	if header == nil || len(header.Refs()) != 1 {
+		t.Errorf("Expect a header of 1 reference, got %v\n", header)
+	}
+	var got []*sam.Record
+	for r := range records {
+		got = append(got, r)
+	}
+	if err := <-errc; err != nil {
+		t.Error(err)
+	}
+	if len(got) != len(expected) {
+		t.Errorf("Expect %d records, got %d\n", len(expected), len(got))
+	} else {
+		for i := range got {
+			if got[i].String() != expected[i].String() {
+				t.Errorf("record %d, Expect %s, got %s\n", i, expected[i], got[i])
+			}
+		}
+	}
+
+	// missing file.
+	header, records, errc = ReadBamFileStream(context.Background(), filepath.Join(dir, "missing.bam"))
+	if _, ok := <-records; ok || header != nil {
+		t.Errorf("Expect no header and no record for a missing file\n")
+	}
+	if err := <-errc; err == nil {
+		t.Errorf("Expect an error for a missing file\n")
+	}
+
+	// truncated file, cut before the end-of-file block.
+	truncated := filepath.Join(dir, "truncated.bam")
+	if err := ioutil.WriteFile(truncated, data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, records, errc = ReadBamFileStream(context.Background(), truncated)
+	for range records {
+	}
+	if err := <-errc; err == nil {
+		t.Errorf("Expect an error for a truncated file\n")
+	}
+
+	// stopped early: the reading go routine returns, and closes the channels.
+	ctx, cancel := context.WithCancel(context.Background())
+	_, records, errc = ReadBamFileStream(ctx, fileName)
+	if _, ok := <-records; !ok {
+		t.Errorf("Expect a first record\n")
+	}
+	cancel()
+	n := 0
+	for range records {
+		n++
+	}
+	if n >= len(expected)-1 {
+		t.Errorf("Expect the records to stop after the cancel, got %d more\n", n)
+	}
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("Expect %v after the cancel, got %v\n", context.Canceled, err)
+	}
+	if _, ok := <-errc; ok {
+		t.Errorf("Expect the error channel closed\n")
+	}
+}
+
+// samText is a SAM file with 2 records.
+const samText = "@HD\tVN:1.0\tSO:coordinate\n" +
+	"@SQ\tSN:NC_000001\tLN:10000\n" +
+	"read1\t0\tNC_000001\t1\t60\t8M\t*\t0\t0\tACGTACGT\t*\n" +
+	"read2\t0\tNC_000001\t5\t60\t8M\t*\t0\t0\tACGTACGT\t*\n"
+
+func TestNewRecordReader(t *testing.T) {
+	var gzBuf bytes.Buffer
+	gz := gzip.NewWriter(&gzBuf)
+	if _, err := gz.Write([]byte(samText)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name   string
+		data   []byte
+		format string
+		n      int
+	}{
+		{"sam", []byte(samText), "sam", 2},
+		{"gzip sam", gzBuf.Bytes(), "sam", 2},
+		{"bam", bamData(t, 10), "bam", 10},
+	}
+	for _, tc := range testCases {
+		reader, err := NewRecordReader(bytes.NewReader(tc.data), tc.format)
+		if err != nil {
+			t.Errorf("%s, %v\n", tc.name, err)
+			continue
+		}
+		if refs := reader.Header().Refs(); len(refs) != 1 || refs[0].Name() != "NC_000001" {
+			t.Errorf("%s, Expect reference NC_000001, got %v\n", tc.name, refs)
+		}
+		n := 0
+		for {
+			r, err := reader.Read()
+			if err != nil {
+				if err != io.EOF {
+					t.Errorf("%s, %v\n", tc.name, err)
+				}
+				break
+			}
+			if r.Ref.Name() != "NC_000001" {
+				t.Errorf("%s, Expect reference NC_000001, got %s\n", tc.name, r.Ref.Name())
+			}
+			n++
+		}
+		if n != tc.n {
+			t.Errorf("%s, Expect %d records, got %d\n", tc.name, tc.n, n)
+		}
+		if err := reader.Close(); err != nil {
+			t.Errorf("%s, %v\n", tc.name, err)
+		}
+	}
+
+	if _, err := NewRecordReader(bytes.NewReader([]byte(samText)), "cram"); err == nil {
+		t.Errorf("Expect an error for an unknown format\n")
+	}
+	if _, err := NewRecordReader(bytes.NewReader([]byte(samText)), "bam"); err == nil {
+		t.Errorf("Expect an error for a sam input read as bam\n")
+	}
+}
@@ -0,0 +1,69 @@
+package reads
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+)
+
+// SamReader reads the header and the records of a SAM or BAM input.
+type SamReader interface {
+	Header() *sam.Header
+	Read() (*sam.Record, error)
+	// Close releases the reader; it does not close the input.
+	Close() error
+}
+
+// NewRecordReader returns a reader of the records in r,
+// whose format is "sam" or "bam".
+// A SAM input compressed by gzip is decompressed.
+// The header is read before returning.
+func NewRecordReader(r io.Reader, format string) (SamReader, error) {
+	switch format {
+	case "bam":
+		br, err := bam.NewReader(r, 0)
+		if err != nil {
+			return nil, err
+		}
+		return br, nil
+	case "sam":
+		in := bufio.NewReader(r)
+		var gz *gzip.Reader
+		if magic, err := in.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+			gz, err = gzip.NewReader(in)
+			if err != nil {
+				return nil, err
+			}
+		}
+		var sr *sam.Reader
+		var err error
+		if gz != nil {
+			sr, err = sam.NewReader(gz)
+		} else {
+			sr, err = sam.NewReader(in)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return samReader{Reader: sr, gz: gz}, nil
+	}
+	return nil, fmt.Errorf("unknown format %s, should be sam or bam", format)
+}
+
+// samReader is a sam.Reader with a Close method,
+// closing its gzip decompression, if any.
+type samReader struct {
+	*sam.Reader
+	gz *gzip.Reader
+}
+
+func (r samReader) Close() error {
+	if r.gz != nil {
+		return r.gz.Close()
+	}
+	return nil
+}
@@ -18,10 +18,26 @@ func (sr SamRecords) Search(pos int) (index int) {
 }
 
 // A wrapper for sorting SAM records by left cordinate.
+// Records at the same position are ordered by reference ID,
+// read name, flags and mate position, so that the order
+// does not depend on the input order.
 type ByLeftCoordinate struct{ SamRecords }
 
 func (b ByLeftCoordinate) Less(i, j int) bool {
-	return b.SamRecords[i].Pos < b.SamRecords[j].Pos
+	ri, rj := b.SamRecords[i], b.SamRecords[j]
+	if ri.Pos != rj.Pos {
+		return ri.Pos < rj.Pos
+	}
+	if ri.Ref.ID() != rj.Ref.ID() {
+		return ri.Ref.ID() < rj.Ref.ID()
+	}
+	if ri.Name != rj.Name {
+		return ri.Name < rj.Name
+	}
+	if ri.Flags != rj.Flags {
+		return ri.Flags < rj.Flags
+	}
+	return ri.MatePos < rj.MatePos
 }
 
 // A wrapper for sorting SAM records by read name.
@@ -0,0 +1,68 @@
+package reads
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestByLeftCoordinate(t *testing.T) {
+	var refs []*sam.Reference
+	for i := 0; i < 2; i++ {
+		ref, err := sam.NewReference(fmt.Sprintf("NC_%06d", i), "", "", 10000, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, ref)
+	}
+	if _, err := sam.NewHeader(nil, refs); err != nil {
+		t.Fatal(err)
+	}
+
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}
+	// records at position 5, but one at 3, in the expected order.
+	var expected SamRecords
+	for _, r := range []struct {
+		ref     int
+		pos     int
+		name    string
+		flags   sam.Flags
+		matePos int
+	}{
+		{1, 3, "d", 0, -1},
+		{0, 5, "a", 0, -1},
+		{0, 5, "b", sam.Paired, 20},
+		{0, 5, "b", sam.Paired, 40},
+		{0, 5, "b", sam.Paired | sam.Reverse, 10},
+		{0, 5, "c", 0, -1},
+		{1, 5, "a", 0, -1},
+	} {
+		var mateRef *sam.Reference
+		if r.matePos >= 0 {
+			mateRef = refs[r.ref]
+		}
+		rec, err := sam.NewRecord(r.name, refs[r.ref], mateRef, r.pos, r.matePos, 0, 60, cigar, []byte("ACGT"), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec.Flags = r.flags
+		expected = append(expected, rec)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	for n := 0; n < 20; n++ {
+		records := make(SamRecords, len(expected))
+		for i, j := range rng.Perm(len(expected)) {
+			records[i] = expected[j]
+		}
+		sort.Sort(ByLeftCoordinate{records})
+		for i := range records {
+			if records[i] != expected[i] {
+				t.Errorf("shuffle %d, record %d, Expect %s, got %s\n", n, i, expected[i], records[i])
+			}
+		}
+	}
+}
@@ -6,11 +6,22 @@ import (
 )
 
 // Separate SAM records for different reference genomes.
-// Return a map of genome reference name to records.
+// Return a map of genome reference name to records,
+// sorted by left coordinate; every reference has an entry, even without records.
+// Records of other references are dropped.
 func SeparateSamRecords(refs []*sam.Reference, records SamRecords) map[string]SamRecords {
-	m := make(map[string]SamRecords)
+	m := make(map[string]SamRecords, len(refs))
 	for _, ref := range refs {
-		m[ref.Name()] = FindSorted(ref.Name(), records)
+		m[ref.Name()] = SamRecords{}
+	}
+	for _, r := range records {
+		name := r.Ref.Name()
+		if founds, found := m[name]; found {
+			m[name] = append(founds, r)
+		}
+	}
+	for _, founds := range m {
+		sort.Stable(ByLeftCoordinate{founds})
 	}
 	return m
 }
@@ -24,7 +35,7 @@ func FindSorted(ref string, records SamRecords) SamRecords {
 		}
 	}
 
-	sort.Sort(ByLeftCoordinate{founds})
+	sort.Stable(ByLeftCoordinate{founds})
 
 	return founds
 }
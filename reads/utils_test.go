@@ -0,0 +1,101 @@
+package reads
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+// samRecords returns n records at random positions of random references
+// among numRef, and the references.
+func samRecords(tb testing.TB, numRef, n int) ([]*sam.Reference, SamRecords) {
+	var refs []*sam.Reference
+	for i := 0; i < numRef; i++ {
+		ref, err := sam.NewReference(fmt.Sprintf("NC_%06d", i), "", "", 10000, nil, nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		refs = append(refs, ref)
+	}
+	if _, err := sam.NewHeader(nil, refs); err != nil {
+		tb.Fatal(err)
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	cigar := []sam.CigarOp{sam.NewCigarOp(sam.CigarMatch, 4)}
+	var records SamRecords
+	for i := 0; i < n; i++ {
+		ref := refs[rng.Intn(numRef)]
+		r, err := sam.NewRecord(fmt.Sprintf("read%d", i), ref, nil, rng.Intn(9996), -1, 0, 60, cigar, []byte("ACGT"), nil, nil)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	return refs, records
+}
+
+// separateByFind separates records by calling FindSorted for each reference,
+// as SeparateSamRecords used to do.
+func separateByFind(refs []*sam.Reference, records SamRecords) map[string]SamRecords {
+	m := make(map[string]SamRecords)
+	for _, ref := range refs {
+		m[ref.Name()] = FindSorted(ref.Name(), records)
+	}
+	return m
+}
+
+func TestSeparateSamRecords(t *testing.T) {
+	refs, records := samRecords(t, 20, 1000)
+	// a reference without records.
+	empty, err := sam.NewReference("NC_empty", "", "", 10000, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs = append(refs, empty)
+
+	expected := separateByFind(refs, records)
+	got := SeparateSamRecords(refs, records)
+	if len(got) != len(expected) {
+		t.Fatalf("Expect %d references, got %d\n", len(expected), len(got))
+	}
+	for name, founds := range expected {
+		gotFounds, found := got[name]
+		if !found || gotFounds == nil {
+			t.Errorf("%s, Expect a (non-nil) entry\n", name)
+			continue
+		}
+		if len(gotFounds) != len(founds) {
+			t.Errorf("%s, Expect %d records, got %d\n", name, len(founds), len(gotFounds))
+			continue
+		}
+		for i, r := range gotFounds {
+			if r.Ref.Name() != name || r.Pos != founds[i].Pos {
+				t.Errorf("%s, record %d, Expect %s:%d, got %s:%d\n", name, i, name, founds[i].Pos, r.Ref.Name(), r.Pos)
+			}
+			if i > 0 && r.Pos < gotFounds[i-1].Pos {
+				t.Errorf("%s, Expect records sorted by coordinate, got %d after %d\n", name, r.Pos, gotFounds[i-1].Pos)
+			}
+		}
+	}
+}
+
+func BenchmarkSeparateSamRecords(b *testing.B) {
+	refs, records := samRecords(b, 1000, 100000)
+	impls := []struct {
+		name     string
+		separate func([]*sam.Reference, SamRecords) map[string]SamRecords
+	}{
+		{"find", separateByFind},
+		{"bucket", SeparateSamRecords},
+	}
+	for _, impl := range impls {
+		b.Run(impl.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				impl.separate(refs, records)
+			}
+		})
+	}
+}